@@ -0,0 +1,125 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package options
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/spf13/pflag"
+
+	"sigs.k8s.io/apiserver-network-proxy/pkg/agent"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/config"
+)
+
+// AgentConfigurationKind is the kind of the proxy-agent configuration file.
+const AgentConfigurationKind = "ProxyAgentConfiguration"
+
+// configFlags are the flags which cannot be set from the configuration file.
+var configFlags = []string{"config", "print-config"}
+
+// AgentConfiguration is the configuration file of the proxy-agent, e.g.
+//
+//	apiVersion: konnectivity.k8s.io/v1alpha1
+//	kind: ProxyAgentConfiguration
+//	options:
+//	  proxy-server-host: konnectivity.example.com
+//	  alpn-proto: [konnectivity]
+//	agentIdentifiers:
+//	  hosts: [node1.example.com]
+//	  cidrs: [10.0.0.0/16]
+type AgentConfiguration struct {
+	config.TypeMeta `json:",inline"`
+
+	// Options sets the command-line options, by flag name.
+	Options config.Options `json:"options,omitempty"`
+
+	// AgentIdentifiers are the identifiers of the agent used by the server
+	// when choosing an agent. It is mutually exclusive with the
+	// "agent-identifiers" option, which takes them URL encoded.
+	AgentIdentifiers *AgentIdentifiersConfiguration `json:"agentIdentifiers,omitempty"`
+}
+
+// AgentIdentifiersConfiguration lists the identifiers of the agent.
+type AgentIdentifiersConfiguration struct {
+	Hosts        []string `json:"hosts,omitempty"`
+	CIDRs        []string `json:"cidrs,omitempty"`
+	IPv4         []string `json:"ipv4,omitempty"`
+	IPv6         []string `json:"ipv6,omitempty"`
+	DefaultRoute bool     `json:"defaultRoute,omitempty"`
+}
+
+// encode returns the identifiers in the format of the "agent-identifiers"
+// option.
+func (c *AgentIdentifiersConfiguration) encode() string {
+	values := url.Values{}
+	values[string(agent.Host)] = c.Hosts
+	values[string(agent.CIDR)] = c.CIDRs
+	values[string(agent.IPv4)] = c.IPv4
+	values[string(agent.IPv6)] = c.IPv6
+	if c.DefaultRoute {
+		values.Set(string(agent.DefaultRoute), "true")
+	}
+	return values.Encode()
+}
+
+// LoadConfigFile applies the configuration file, if set, to the options.
+// fs must be the flag set the options were parsed from; flags set on the
+// command line take precedence over the file.
+func (o *GrpcProxyAgentOptions) LoadConfigFile(fs *pflag.FlagSet) error {
+	if o.ConfigFile == "" {
+		return nil
+	}
+	cfg := &AgentConfiguration{}
+	if err := config.ReadFile(o.ConfigFile, AgentConfigurationKind, cfg); err != nil {
+		return err
+	}
+	if err := cfg.Options.Apply(fs, configFlags...); err != nil {
+		return fmt.Errorf("config file %s: %v", o.ConfigFile, err)
+	}
+	if cfg.AgentIdentifiers != nil {
+		if _, ok := cfg.Options["agent-identifiers"]; ok {
+			return fmt.Errorf("config file %s: agentIdentifiers and the agent-identifiers option are mutually exclusive", o.ConfigFile)
+		}
+		if !fs.Changed("agent-identifiers") {
+			o.AgentIdentifiers = cfg.AgentIdentifiers.encode()
+		}
+	}
+	return nil
+}
+
+// EffectiveConfig returns the configuration file equivalent to the options.
+func (o *GrpcProxyAgentOptions) EffectiveConfig() *AgentConfiguration {
+	cfg := &AgentConfiguration{
+		TypeMeta: config.TypeMeta{APIVersion: config.APIVersion, Kind: AgentConfigurationKind},
+		Options:  config.EffectiveOptions(o.Flags(), configFlags...),
+	}
+	if o.AgentIdentifiers != "" {
+		// Identifiers which fail validation are kept in the option as is.
+		if ids, err := agent.GenAgentIdentifiers(o.AgentIdentifiers); err == nil {
+			delete(cfg.Options, "agent-identifiers")
+			cfg.AgentIdentifiers = &AgentIdentifiersConfiguration{
+				Hosts:        ids.Host,
+				CIDRs:        ids.CIDR,
+				IPv4:         ids.IPv4,
+				IPv6:         ids.IPv6,
+				DefaultRoute: ids.DefaultRoute,
+			}
+		}
+	}
+	return cfg
+}
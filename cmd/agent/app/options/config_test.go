@@ -0,0 +1,102 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package options
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"sigs.k8s.io/apiserver-network-proxy/pkg/agent"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/config"
+)
+
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := writeConfig(t, `
+apiVersion: konnectivity.k8s.io/v1alpha1
+kind: ProxyAgentConfiguration
+options:
+  proxy-server-host: konnectivity.example.com
+  proxy-server-port: 8132
+  alpn-proto: [konnectivity]
+  sync-forever: true
+agentIdentifiers:
+  hosts: [node1.example.com, node1]
+  cidrs: [10.0.0.0/16]
+  defaultRoute: true
+`)
+	o := NewGrpcProxyAgentOptions()
+	fs := o.Flags()
+	if err := fs.Parse([]string{"--config=" + path, "--proxy-server-port=8133"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.LoadConfigFile(fs); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "konnectivity.example.com", o.ProxyServerHost)
+	assert.Equal(t, 8133, o.ProxyServerPort, "flags take precedence over the config file")
+	assert.Equal(t, []string{"konnectivity"}, o.AlpnProtos)
+	assert.True(t, o.SyncForever)
+	assert.NoError(t, o.Validate())
+	ids, err := agent.GenAgentIdentifiers(o.AgentIdentifiers)
+	assert.NoError(t, err)
+	assert.Equal(t, agent.Identifiers{
+		Host:         []string{"node1.example.com", "node1"},
+		CIDR:         []string{"10.0.0.0/16"},
+		DefaultRoute: true,
+	}, ids)
+
+	// The effective config loads back to the same options.
+	data, err := config.Marshal(o.EffectiveConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	o2 := NewGrpcProxyAgentOptions()
+	o2.ConfigFile = writeConfig(t, string(data))
+	if err := o2.LoadConfigFile(o2.Flags()); err != nil {
+		t.Fatalf("failed to load the effective config %s: %v", data, err)
+	}
+	o2.ConfigFile = o.ConfigFile
+	assert.Equal(t, o, o2)
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	for desc, data := range map[string]string{
+		"wrong kind":                    "kind: ProxyServerConfiguration\n",
+		"unknown option":                "kind: ProxyAgentConfiguration\noptions:\n  unknown: value\n",
+		"invalid list":                  "kind: ProxyAgentConfiguration\noptions:\n  sync-forever: [true]\n",
+		"unknown identifier":            "kind: ProxyAgentConfiguration\nagentIdentifiers:\n  uids: [a]\n",
+		"conflicting agent identifiers": "kind: ProxyAgentConfiguration\noptions:\n  agent-identifiers: host=node1\nagentIdentifiers:\n  hosts: [node2]\n",
+	} {
+		t.Run(desc, func(t *testing.T) {
+			o := NewGrpcProxyAgentOptions()
+			o.ConfigFile = writeConfig(t, "apiVersion: konnectivity.k8s.io/v1alpha1\n"+data)
+			assert.Error(t, o.LoadConfigFile(o.Flags()))
+		})
+	}
+}
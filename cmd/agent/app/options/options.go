@@ -27,7 +27,10 @@ import (
 	"google.golang.org/grpc"
 	"k8s.io/klog/v2"
 
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/pkg/common/tracing"
 	"sigs.k8s.io/apiserver-network-proxy/pkg/agent"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/agent/discovery"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/agent/dns"
 	"sigs.k8s.io/apiserver-network-proxy/pkg/util"
 )
 
@@ -58,9 +61,13 @@ type GrpcProxyAgentOptions struct {
 
 	AgentID          string
 	AgentIdentifiers string
-	SyncInterval     time.Duration
-	ProbeInterval    time.Duration
-	SyncIntervalCap  time.Duration
+	// AgentIdentifiersFile, if set, holds the identifiers of the agent
+	// instead of AgentIdentifiers. It is reread when it changes, and the
+	// proxy servers are sent the new identifiers.
+	AgentIdentifiersFile string
+	SyncInterval         time.Duration
+	ProbeInterval        time.Duration
+	SyncIntervalCap      time.Duration
 	// After a duration of this time if the agent doesn't see any activity it
 	// pings the server to see if the transport is still alive.
 	KeepaliveTime time.Duration
@@ -76,6 +83,81 @@ type GrpcProxyAgentOptions struct {
 	WarnOnChannelLimit bool
 
 	SyncForever bool
+
+	// If positive, the time after which a connection to a destination
+	// with no data in either direction is closed.
+	ConnectionIdleTimeout time.Duration
+	// If positive, the time after which a connection to a destination is
+	// closed.
+	ConnectionMaxLifetime time.Duration
+
+	// Time the destinations are dialed for, unless the client requests a
+	// timeout, which is bounded by MinDialTimeout and MaxDialTimeout, if
+	// positive.
+	DialTimeout    time.Duration
+	MinDialTimeout time.Duration
+	MaxDialTimeout time.Duration
+	// Number of times the dials failing with a transient error are retried
+	// within their timeout, after DialRetryBackoff, doubled for each retry.
+	DialRetries      int
+	DialRetryBackoff time.Duration
+
+	// Path of the file with the rules allowing and denying the
+	// destinations the agent dials. All are allowed if empty.
+	DestinationPolicyFile string
+
+	// DNS servers resolving the destination host names, as host[:port], or
+	// <domain>=host[:port] for the names in domain. The system resolver is
+	// used if empty.
+	DNSServers []string
+	// Search domains tried, in order, for the destination host names
+	// without dots.
+	DNSSearch []string
+	// File in the /etc/hosts format overriding the resolution of the names
+	// it lists.
+	DNSHostsFile string
+	// If positive, the answers of DNSServers are cached for their TTL, but
+	// at most DNSCacheMaxTTL.
+	DNSCacheMaxTTL time.Duration
+
+	// Path of the file with the upstream proxies and the routes of the
+	// destinations through them. All are dialed directly if empty.
+	EgressConfigFile string
+
+	// Fraction of the proxy servers the agent must be connected to for it
+	// to be ready; a connection to at least one is always required.
+	ReadinessMinServerFraction float64
+	// If positive, the agent is not ready once its last successful sync
+	// with the proxy servers is older.
+	ReadinessMaxSyncAge time.Duration
+
+	// Discover the addresses of the local network interfaces as
+	// identifiers, filtered by DiscoverInterfaceInclude and
+	// DiscoverInterfaceExclude, each an interface name pattern or a CIDR.
+	DiscoverInterfaceAddresses bool
+	DiscoverInterfaceInclude   []string
+	DiscoverInterfaceExclude   []string
+	// Discover the host name, and its fully qualified domain name, as
+	// identifiers.
+	DiscoverHostname bool
+	DiscoverFQDN     bool
+	// Name of the Kubernetes Node whose addresses and pod CIDRs are
+	// discovered as identifiers, read through the Kubeconfig client, or
+	// the in-cluster one if empty.
+	DiscoverNodeName string
+	Kubeconfig       string
+	// Interval at which the discovered identifiers are refreshed.
+	DiscoveryInterval time.Duration
+
+	// Exporter of the spans recorded for the proxied connections. Only
+	// "log" is supported; spans are not recorded if empty.
+	TracingExporter string
+
+	// Path of the versioned configuration file. Flags set on the command
+	// line take precedence over the file.
+	ConfigFile string
+	// If true, print the effective configuration file and exit.
+	PrintConfig bool
 }
 
 func (o *GrpcProxyAgentOptions) ClientSetConfig(dialOptions ...grpc.DialOption) *agent.ClientSetConfig {
@@ -90,9 +172,47 @@ func (o *GrpcProxyAgentOptions) ClientSetConfig(dialOptions ...grpc.DialOption)
 		ServiceAccountTokenPath: o.ServiceAccountTokenPath,
 		WarnOnChannelLimit:      o.WarnOnChannelLimit,
 		SyncForever:             o.SyncForever,
+		ConnectionIdleTimeout:   o.ConnectionIdleTimeout,
+		ConnectionMaxLifetime:   o.ConnectionMaxLifetime,
+		ReadinessPolicy: agent.ReadinessPolicy{
+			MinServerFraction: o.ReadinessMinServerFraction,
+			MaxSyncAge:        o.ReadinessMaxSyncAge,
+		},
+		DialTimeout:      o.DialTimeout,
+		MinDialTimeout:   o.MinDialTimeout,
+		MaxDialTimeout:   o.MaxDialTimeout,
+		DialRetries:      o.DialRetries,
+		DialRetryBackoff: o.DialRetryBackoff,
+		Tracer:           o.tracer(),
 	}
 }
 
+// DiscoversIdentifiers returns whether any identifier of the agent is
+// discovered.
+func (o *GrpcProxyAgentOptions) DiscoversIdentifiers() bool {
+	return o.DiscoverInterfaceAddresses || o.DiscoverHostname || o.DiscoverFQDN || o.DiscoverNodeName != ""
+}
+
+// DiscoveryConfig returns the configuration of the identifier discovery,
+// without the Kubernetes client.
+func (o *GrpcProxyAgentOptions) DiscoveryConfig() discovery.Config {
+	return discovery.Config{
+		Interfaces:       o.DiscoverInterfaceAddresses,
+		InterfaceInclude: o.DiscoverInterfaceInclude,
+		InterfaceExclude: o.DiscoverInterfaceExclude,
+		Hostname:         o.DiscoverHostname,
+		FQDN:             o.DiscoverFQDN,
+		NodeName:         o.DiscoverNodeName,
+	}
+}
+
+func (o *GrpcProxyAgentOptions) tracer() *tracing.Tracer {
+	if o.TracingExporter == "log" {
+		return tracing.NewTracer("proxy-agent", tracing.LogExporter{})
+	}
+	return nil
+}
+
 func (o *GrpcProxyAgentOptions) Flags() *pflag.FlagSet {
 	flags := pflag.NewFlagSet("proxy-agent", pflag.ContinueOnError)
 	flags.StringVar(&o.AgentCert, "agent-cert", o.AgentCert, "If non-empty secure communication with this cert.")
@@ -114,8 +234,35 @@ func (o *GrpcProxyAgentOptions) Flags() *pflag.FlagSet {
 	flags.DurationVar(&o.KeepaliveTime, "keepalive-time", o.KeepaliveTime, "Time for gRPC agent server keepalive.")
 	flags.StringVar(&o.ServiceAccountTokenPath, "service-account-token-path", o.ServiceAccountTokenPath, "If non-empty proxy agent uses this token to prove its identity to the proxy server.")
 	flags.StringVar(&o.AgentIdentifiers, "agent-identifiers", o.AgentIdentifiers, "Identifiers of the agent that will be used by the server when choosing agent. N.B. the list of identifiers must be in URL encoded format. e.g.,host=localhost&host=node1.mydomain.com&cidr=127.0.0.1/16&ipv4=1.2.3.4&ipv4=5.6.7.8&ipv6=:::::&default-route=true")
+	flags.StringVar(&o.AgentIdentifiersFile, "agent-identifiers-file", o.AgentIdentifiersFile, "File with the identifiers of the agent, in the format of --agent-identifiers, optionally split across lines. The file is reread when it changes, and the proxy servers are sent the new identifiers without the agent reconnecting. Mutually exclusive with --agent-identifiers.")
 	flags.BoolVar(&o.WarnOnChannelLimit, "warn-on-channel-limit", o.WarnOnChannelLimit, "Turns on a warning if the system is going to push to a full channel. The check involves an unsafe read.")
 	flags.BoolVar(&o.SyncForever, "sync-forever", o.SyncForever, "If true, the agent continues syncing, in order to support server count changes.")
+	flags.DurationVar(&o.ConnectionIdleTimeout, "connection-idle-timeout", o.ConnectionIdleTimeout, "If positive, the time after which a connection to a destination with no data in either direction is closed, on both the destination and the proxy server side. Zero disables the idle timeout.")
+	flags.DurationVar(&o.ConnectionMaxLifetime, "connection-max-lifetime", o.ConnectionMaxLifetime, "If positive, the time after which a connection to a destination is closed, on both the destination and the proxy server side. Zero disables the maximum lifetime.")
+	flags.DurationVar(&o.DialTimeout, "dial-timeout", o.DialTimeout, "Time the destinations are dialed for, unless the client requests a timeout, e.g. from the deadline of its dial.")
+	flags.DurationVar(&o.MinDialTimeout, "min-dial-timeout", o.MinDialTimeout, "If positive, the minimum time the destinations are dialed for when the client requests a timeout.")
+	flags.DurationVar(&o.MaxDialTimeout, "max-dial-timeout", o.MaxDialTimeout, "If positive, the maximum time the destinations are dialed for when the client requests a timeout.")
+	flags.IntVar(&o.DialRetries, "dial-retries", o.DialRetries, "Number of times the dials failing with a transient error, e.g. a refused connection or a temporary DNS failure, are retried within their timeout.")
+	flags.DurationVar(&o.DialRetryBackoff, "dial-retry-backoff", o.DialRetryBackoff, "Time to wait before retrying a dial, doubled for each retry.")
+	flags.StringVar(&o.DestinationPolicyFile, "destination-policy-file", o.DestinationPolicyFile, "File with the rules allowing and denying the destinations the agent dials, one \"allow|deny <destination> [<ports>]\" rule per line; the destination is an IP, a CIDR, a host name, \"*.<domain>\" or \"*\". Deny rules take precedence, and if there are allow rules, a destination must match one. The file is reloaded when it changes. All destinations are allowed if empty.")
+	flags.StringSliceVar(&o.DNSServers, "dns-servers", o.DNSServers, "DNS servers resolving the destination host names, as host[:port], or <domain>=host[:port] for the names in the domain, e.g. cluster.local=10.96.0.10. The server of the longest matching domain is used, and the system resolver for the names matching none. The system resolver is used if empty.")
+	flags.StringSliceVar(&o.DNSSearch, "dns-search", o.DNSSearch, "Search domains tried, in order, for the destination host names without dots, before the name itself.")
+	flags.StringVar(&o.DNSHostsFile, "dns-hosts-file", o.DNSHostsFile, "File in the /etc/hosts format overriding the resolution of the destination host names it lists.")
+	flags.DurationVar(&o.DNSCacheMaxTTL, "dns-cache-max-ttl", o.DNSCacheMaxTTL, "If positive, the answers of --dns-servers are cached for their TTL, but at most this long. Zero disables the cache.")
+	flags.StringVar(&o.EgressConfigFile, "egress-config-file", o.EgressConfigFile, "File with the upstream HTTP CONNECT and SOCKS5 proxies the agent dials destinations through, one \"proxy <name> <url> [<credentials file>]\" or \"route <proxy name>|direct <destination> [<ports>]\" per line; the destinations are those of --destination-policy-file. The first matching route is used, and the destinations matching none are dialed directly. The proxies resolve the host names, so the IP and CIDR rules of --destination-policy-file can't check them: host names are refused through a proxy if an IP or CIDR deny rule matches their port, or if only IP or CIDR allow rules could allow them. The credentials files hold \"<user>:<password>\" and are reread on every dial. All destinations are dialed directly if empty.")
+	flags.Float64Var(&o.ReadinessMinServerFraction, "readiness-min-server-fraction", o.ReadinessMinServerFraction, "Fraction, between 0 and 1, of the proxy servers the agent must have a healthy connection to for it to report ready. A connection to at least one proxy server is always required.")
+	flags.DurationVar(&o.ReadinessMaxSyncAge, "readiness-max-sync-age", o.ReadinessMaxSyncAge, "If positive, the agent reports not ready once its last successful sync with the proxy servers, which either reached a proxy server or found them all connected, is older. Zero disables the check.")
+	flags.BoolVar(&o.DiscoverInterfaceAddresses, "discover-interface-addresses", o.DiscoverInterfaceAddresses, "If true, the addresses of the local network interfaces which are up are added to the agent identifiers, except the loopback and link-local ones.")
+	flags.StringSliceVar(&o.DiscoverInterfaceInclude, "discover-interface-include", o.DiscoverInterfaceInclude, "Interface names, which may contain wildcards, e.g. eth*, or CIDRs, of the discovered interface addresses. All are discovered if empty.")
+	flags.StringSliceVar(&o.DiscoverInterfaceExclude, "discover-interface-exclude", o.DiscoverInterfaceExclude, "Interface names, which may contain wildcards, e.g. docker*, or CIDRs, of the interface addresses not discovered.")
+	flags.BoolVar(&o.DiscoverHostname, "discover-hostname", o.DiscoverHostname, "If true, the host name is added to the agent identifiers.")
+	flags.BoolVar(&o.DiscoverFQDN, "discover-fqdn", o.DiscoverFQDN, "If true, the fully qualified domain name of the host is added to the agent identifiers.")
+	flags.StringVar(&o.DiscoverNodeName, "discover-node-name", o.DiscoverNodeName, "If non-empty, the addresses and pod CIDRs of this Kubernetes Node are added to the agent identifiers. The agent must be allowed to get the Node.")
+	flags.StringVar(&o.Kubeconfig, "kubeconfig", o.Kubeconfig, "Path to the kubeconfig file of the client reading the Node of --discover-node-name. The in-cluster client is used if empty.")
+	flags.DurationVar(&o.DiscoveryInterval, "discovery-interval", o.DiscoveryInterval, "Interval at which the discovered agent identifiers are refreshed, and the proxy servers are sent them if they changed.")
+	flags.StringVar(&o.TracingExporter, "tracing-exporter", o.TracingExporter, "Exporter of the spans recorded for the proxied connections. Supported values: \"log\". Spans are not recorded if empty.")
+	flags.StringVar(&o.ConfigFile, "config", o.ConfigFile, "Path of a "+AgentConfigurationKind+" configuration file. Flags set on the command line take precedence over the file.")
+	flags.BoolVar(&o.PrintConfig, "print-config", o.PrintConfig, "Print the effective configuration, in the configuration file format, and exit.")
 	return flags
 }
 
@@ -139,8 +286,34 @@ func (o *GrpcProxyAgentOptions) Print() {
 	klog.V(1).Infof("Keepalive time set to %v.\n", o.KeepaliveTime)
 	klog.V(1).Infof("ServiceAccountTokenPath set to %q.\n", o.ServiceAccountTokenPath)
 	klog.V(1).Infof("AgentIdentifiers set to %s.\n", util.PrettyPrintURL(o.AgentIdentifiers))
+	klog.V(1).Infof("AgentIdentifiersFile set to %q.\n", o.AgentIdentifiersFile)
 	klog.V(1).Infof("WarnOnChannelLimit set to %t.\n", o.WarnOnChannelLimit)
 	klog.V(1).Infof("SyncForever set to %v.\n", o.SyncForever)
+	klog.V(1).Infof("ConnectionIdleTimeout set to %v.\n", o.ConnectionIdleTimeout)
+	klog.V(1).Infof("ConnectionMaxLifetime set to %v.\n", o.ConnectionMaxLifetime)
+	klog.V(1).Infof("DialTimeout set to %v.\n", o.DialTimeout)
+	klog.V(1).Infof("MinDialTimeout set to %v.\n", o.MinDialTimeout)
+	klog.V(1).Infof("MaxDialTimeout set to %v.\n", o.MaxDialTimeout)
+	klog.V(1).Infof("DialRetries set to %d.\n", o.DialRetries)
+	klog.V(1).Infof("DialRetryBackoff set to %v.\n", o.DialRetryBackoff)
+	klog.V(1).Infof("DestinationPolicyFile set to %q.\n", o.DestinationPolicyFile)
+	klog.V(1).Infof("DNSServers set to %v.\n", o.DNSServers)
+	klog.V(1).Infof("DNSSearch set to %v.\n", o.DNSSearch)
+	klog.V(1).Infof("DNSHostsFile set to %q.\n", o.DNSHostsFile)
+	klog.V(1).Infof("DNSCacheMaxTTL set to %v.\n", o.DNSCacheMaxTTL)
+	klog.V(1).Infof("EgressConfigFile set to %q.\n", o.EgressConfigFile)
+	klog.V(1).Infof("ReadinessMinServerFraction set to %v.\n", o.ReadinessMinServerFraction)
+	klog.V(1).Infof("ReadinessMaxSyncAge set to %v.\n", o.ReadinessMaxSyncAge)
+	klog.V(1).Infof("DiscoverInterfaceAddresses set to %v.\n", o.DiscoverInterfaceAddresses)
+	klog.V(1).Infof("DiscoverInterfaceInclude set to %v.\n", o.DiscoverInterfaceInclude)
+	klog.V(1).Infof("DiscoverInterfaceExclude set to %v.\n", o.DiscoverInterfaceExclude)
+	klog.V(1).Infof("DiscoverHostname set to %v.\n", o.DiscoverHostname)
+	klog.V(1).Infof("DiscoverFQDN set to %v.\n", o.DiscoverFQDN)
+	klog.V(1).Infof("DiscoverNodeName set to %q.\n", o.DiscoverNodeName)
+	klog.V(1).Infof("Kubeconfig set to %q.\n", o.Kubeconfig)
+	klog.V(1).Infof("DiscoveryInterval set to %v.\n", o.DiscoveryInterval)
+	klog.V(1).Infof("TracingExporter set to %q.\n", o.TracingExporter)
+	klog.V(1).Infof("ConfigFile set to %q.\n", o.ConfigFile)
 }
 
 func (o *GrpcProxyAgentOptions) Validate() error {
@@ -188,6 +361,88 @@ func (o *GrpcProxyAgentOptions) Validate() error {
 	if err := validateAgentIdentifiers(o.AgentIdentifiers); err != nil {
 		return fmt.Errorf("agent address is invalid: %v", err)
 	}
+	if o.AgentIdentifiersFile != "" {
+		if o.AgentIdentifiers != "" {
+			return fmt.Errorf("--agent-identifiers and --agent-identifiers-file are mutually exclusive")
+		}
+		if _, err := agent.NewIdentifiersFile(o.AgentIdentifiersFile); err != nil {
+			return fmt.Errorf("error checking agent identifiers file %s, got %v", o.AgentIdentifiersFile, err)
+		}
+	}
+	if o.ConnectionIdleTimeout < 0 {
+		return fmt.Errorf("connection idle timeout must not be negative, got %v", o.ConnectionIdleTimeout)
+	}
+	if o.ConnectionMaxLifetime < 0 {
+		return fmt.Errorf("connection max lifetime must not be negative, got %v", o.ConnectionMaxLifetime)
+	}
+	if o.DialTimeout <= 0 {
+		return fmt.Errorf("dial timeout must be positive, got %v", o.DialTimeout)
+	}
+	if o.MinDialTimeout < 0 {
+		return fmt.Errorf("min dial timeout must not be negative, got %v", o.MinDialTimeout)
+	}
+	if o.MaxDialTimeout < 0 {
+		return fmt.Errorf("max dial timeout must not be negative, got %v", o.MaxDialTimeout)
+	}
+	if o.MaxDialTimeout > 0 && o.MaxDialTimeout < o.MinDialTimeout {
+		return fmt.Errorf("max dial timeout %v must not be less than min dial timeout %v", o.MaxDialTimeout, o.MinDialTimeout)
+	}
+	if o.DialRetries < 0 {
+		return fmt.Errorf("dial retries must not be negative, got %d", o.DialRetries)
+	}
+	if o.DialRetries > 0 && o.DialRetryBackoff <= 0 {
+		return fmt.Errorf("dial retry backoff must be positive, got %v", o.DialRetryBackoff)
+	}
+	if o.DestinationPolicyFile != "" {
+		if _, err := os.Stat(o.DestinationPolicyFile); os.IsNotExist(err) {
+			return fmt.Errorf("error checking destination policy file %s, got %v", o.DestinationPolicyFile, err)
+		}
+	}
+	if err := dns.ValidateServers(o.DNSServers); err != nil {
+		return err
+	}
+	if o.DNSHostsFile != "" {
+		if _, err := os.Stat(o.DNSHostsFile); os.IsNotExist(err) {
+			return fmt.Errorf("error checking DNS hosts file %s, got %v", o.DNSHostsFile, err)
+		}
+	}
+	if o.DNSCacheMaxTTL < 0 {
+		return fmt.Errorf("DNS cache max TTL must not be negative, got %v", o.DNSCacheMaxTTL)
+	}
+	if o.DNSCacheMaxTTL > 0 && len(o.DNSServers) == 0 {
+		return fmt.Errorf("the DNS cache requires --dns-servers, the answers of the system resolver have no TTL")
+	}
+	if o.EgressConfigFile != "" {
+		if _, err := os.Stat(o.EgressConfigFile); os.IsNotExist(err) {
+			return fmt.Errorf("error checking egress config file %s, got %v", o.EgressConfigFile, err)
+		}
+	}
+	if o.ReadinessMinServerFraction < 0 || o.ReadinessMinServerFraction > 1 {
+		return fmt.Errorf("readiness min server fraction must be between 0 and 1, got %v", o.ReadinessMinServerFraction)
+	}
+	if o.ReadinessMaxSyncAge < 0 {
+		return fmt.Errorf("readiness max sync age must not be negative, got %v", o.ReadinessMaxSyncAge)
+	}
+	if (len(o.DiscoverInterfaceInclude) > 0 || len(o.DiscoverInterfaceExclude) > 0) && !o.DiscoverInterfaceAddresses {
+		return fmt.Errorf("--discover-interface-include and --discover-interface-exclude require --discover-interface-addresses")
+	}
+	if err := discovery.ValidateInterfaceFilters(o.DiscoverInterfaceInclude); err != nil {
+		return err
+	}
+	if err := discovery.ValidateInterfaceFilters(o.DiscoverInterfaceExclude); err != nil {
+		return err
+	}
+	if o.Kubeconfig != "" {
+		if _, err := os.Stat(o.Kubeconfig); os.IsNotExist(err) {
+			return fmt.Errorf("error checking kubeconfig %s, got %v", o.Kubeconfig, err)
+		}
+	}
+	if o.DiscoversIdentifiers() && o.DiscoveryInterval <= 0 {
+		return fmt.Errorf("discovery interval must be positive, got %v", o.DiscoveryInterval)
+	}
+	if o.TracingExporter != "" && o.TracingExporter != "log" {
+		return fmt.Errorf("tracing exporter %q is not supported, expected \"log\" or empty", o.TracingExporter)
+	}
 	return nil
 }
 
@@ -212,26 +467,53 @@ func validateAgentIdentifiers(agentIdentifiers string) error {
 
 func NewGrpcProxyAgentOptions() *GrpcProxyAgentOptions {
 	o := GrpcProxyAgentOptions{
-		AgentCert:                 "",
-		AgentKey:                  "",
-		CaCert:                    "",
-		ProxyServerHost:           "127.0.0.1",
-		ProxyServerPort:           8091,
-		HealthServerHost:          "",
-		HealthServerPort:          8093,
-		AdminBindAddress:          "127.0.0.1",
-		AdminServerPort:           8094,
-		EnableProfiling:           false,
-		EnableContentionProfiling: false,
-		AgentID:                   defaultAgentID(),
-		AgentIdentifiers:          "",
-		SyncInterval:              1 * time.Second,
-		ProbeInterval:             1 * time.Second,
-		SyncIntervalCap:           10 * time.Second,
-		KeepaliveTime:             1 * time.Hour,
-		ServiceAccountTokenPath:   "",
-		WarnOnChannelLimit:        false,
-		SyncForever:               false,
+		AgentCert:                  "",
+		AgentKey:                   "",
+		CaCert:                     "",
+		ProxyServerHost:            "127.0.0.1",
+		ProxyServerPort:            8091,
+		HealthServerHost:           "",
+		HealthServerPort:           8093,
+		AdminBindAddress:           "127.0.0.1",
+		AdminServerPort:            8094,
+		EnableProfiling:            false,
+		EnableContentionProfiling:  false,
+		AgentID:                    defaultAgentID(),
+		AgentIdentifiers:           "",
+		AgentIdentifiersFile:       "",
+		SyncInterval:               1 * time.Second,
+		ProbeInterval:              1 * time.Second,
+		SyncIntervalCap:            10 * time.Second,
+		KeepaliveTime:              1 * time.Hour,
+		ServiceAccountTokenPath:    "",
+		WarnOnChannelLimit:         false,
+		SyncForever:                false,
+		ConnectionIdleTimeout:      0,
+		ConnectionMaxLifetime:      0,
+		DialTimeout:                5 * time.Second,
+		MinDialTimeout:             0,
+		MaxDialTimeout:             30 * time.Second,
+		DialRetries:                0,
+		DialRetryBackoff:           100 * time.Millisecond,
+		DestinationPolicyFile:      "",
+		DNSServers:                 []string{},
+		DNSSearch:                  []string{},
+		DNSHostsFile:               "",
+		DNSCacheMaxTTL:             0,
+		EgressConfigFile:           "",
+		ReadinessMinServerFraction: 0,
+		ReadinessMaxSyncAge:        0,
+		DiscoverInterfaceAddresses: false,
+		DiscoverInterfaceInclude:   []string{},
+		DiscoverInterfaceExclude:   []string{},
+		DiscoverHostname:           false,
+		DiscoverFQDN:               false,
+		DiscoverNodeName:           "",
+		Kubeconfig:                 "",
+		DiscoveryInterval:          time.Minute,
+		TracingExporter:            "",
+		ConfigFile:                 "",
+		PrintConfig:                false,
 	}
 	return &o
 }
@@ -42,6 +42,7 @@ func TestDefaultServerOptions(t *testing.T) {
 	assertDefaultValue(t, "EnableProfiling", defaultAgentOptions.EnableProfiling, false)
 	assertDefaultValue(t, "EnableContentionProfiling", defaultAgentOptions.EnableContentionProfiling, false)
 	assertDefaultValue(t, "AgentIdentifiers", defaultAgentOptions.AgentIdentifiers, "")
+	assertDefaultValue(t, "AgentIdentifiersFile", defaultAgentOptions.AgentIdentifiersFile, "")
 	assertDefaultValue(t, "SyncInterval", defaultAgentOptions.SyncInterval, 1*time.Second)
 	assertDefaultValue(t, "ProbeInterval", defaultAgentOptions.ProbeInterval, 1*time.Second)
 	assertDefaultValue(t, "SyncIntervalCap", defaultAgentOptions.SyncIntervalCap, 10*time.Second)
@@ -49,6 +50,32 @@ func TestDefaultServerOptions(t *testing.T) {
 	assertDefaultValue(t, "ServiceAccountTokenPath", defaultAgentOptions.ServiceAccountTokenPath, "")
 	assertDefaultValue(t, "WarnOnChannelLimit", defaultAgentOptions.WarnOnChannelLimit, false)
 	assertDefaultValue(t, "SyncForever", defaultAgentOptions.SyncForever, false)
+	assertDefaultValue(t, "ConnectionIdleTimeout", defaultAgentOptions.ConnectionIdleTimeout, time.Duration(0))
+	assertDefaultValue(t, "ConnectionMaxLifetime", defaultAgentOptions.ConnectionMaxLifetime, time.Duration(0))
+	assertDefaultValue(t, "DialTimeout", defaultAgentOptions.DialTimeout, 5*time.Second)
+	assertDefaultValue(t, "MinDialTimeout", defaultAgentOptions.MinDialTimeout, time.Duration(0))
+	assertDefaultValue(t, "MaxDialTimeout", defaultAgentOptions.MaxDialTimeout, 30*time.Second)
+	assertDefaultValue(t, "DialRetries", defaultAgentOptions.DialRetries, 0)
+	assertDefaultValue(t, "DialRetryBackoff", defaultAgentOptions.DialRetryBackoff, 100*time.Millisecond)
+	assertDefaultValue(t, "DestinationPolicyFile", defaultAgentOptions.DestinationPolicyFile, "")
+	assertDefaultValue(t, "DNSServers", defaultAgentOptions.DNSServers, []string{})
+	assertDefaultValue(t, "DNSSearch", defaultAgentOptions.DNSSearch, []string{})
+	assertDefaultValue(t, "DNSHostsFile", defaultAgentOptions.DNSHostsFile, "")
+	assertDefaultValue(t, "DNSCacheMaxTTL", defaultAgentOptions.DNSCacheMaxTTL, time.Duration(0))
+	assertDefaultValue(t, "EgressConfigFile", defaultAgentOptions.EgressConfigFile, "")
+	assertDefaultValue(t, "ReadinessMinServerFraction", defaultAgentOptions.ReadinessMinServerFraction, float64(0))
+	assertDefaultValue(t, "ReadinessMaxSyncAge", defaultAgentOptions.ReadinessMaxSyncAge, time.Duration(0))
+	assertDefaultValue(t, "DiscoverInterfaceAddresses", defaultAgentOptions.DiscoverInterfaceAddresses, false)
+	assertDefaultValue(t, "DiscoverInterfaceInclude", defaultAgentOptions.DiscoverInterfaceInclude, []string{})
+	assertDefaultValue(t, "DiscoverInterfaceExclude", defaultAgentOptions.DiscoverInterfaceExclude, []string{})
+	assertDefaultValue(t, "DiscoverHostname", defaultAgentOptions.DiscoverHostname, false)
+	assertDefaultValue(t, "DiscoverFQDN", defaultAgentOptions.DiscoverFQDN, false)
+	assertDefaultValue(t, "DiscoverNodeName", defaultAgentOptions.DiscoverNodeName, "")
+	assertDefaultValue(t, "Kubeconfig", defaultAgentOptions.Kubeconfig, "")
+	assertDefaultValue(t, "DiscoveryInterval", defaultAgentOptions.DiscoveryInterval, time.Minute)
+	assertDefaultValue(t, "TracingExporter", defaultAgentOptions.TracingExporter, "")
+	assertDefaultValue(t, "ConfigFile", defaultAgentOptions.ConfigFile, "")
+	assertDefaultValue(t, "PrintConfig", defaultAgentOptions.PrintConfig, false)
 }
 
 func assertDefaultValue(t *testing.T, fieldName string, actual, expected interface{}) {
@@ -145,6 +172,122 @@ func TestValidate(t *testing.T) {
 			},
 			expected: fmt.Errorf("if --enable-contention-profiling is set, --enable-profiling must also be set"),
 		},
+		"NegativeConnectionIdleTimeout": {
+			fieldMap: map[string]interface{}{"ConnectionIdleTimeout": -time.Second},
+			expected: fmt.Errorf("connection idle timeout must not be negative, got -1s"),
+		},
+		"NegativeConnectionMaxLifetime": {
+			fieldMap: map[string]interface{}{"ConnectionMaxLifetime": -time.Minute},
+			expected: fmt.Errorf("connection max lifetime must not be negative, got -1m0s"),
+		},
+		"ZeroDialTimeout": {
+			fieldMap: map[string]interface{}{"DialTimeout": time.Duration(0)},
+			expected: fmt.Errorf("dial timeout must be positive, got 0s"),
+		},
+		"NegativeMinDialTimeout": {
+			fieldMap: map[string]interface{}{"MinDialTimeout": -time.Second},
+			expected: fmt.Errorf("min dial timeout must not be negative, got -1s"),
+		},
+		"NegativeMaxDialTimeout": {
+			fieldMap: map[string]interface{}{"MaxDialTimeout": -time.Second},
+			expected: fmt.Errorf("max dial timeout must not be negative, got -1s"),
+		},
+		"MaxDialTimeoutBelowMin": {
+			fieldMap: map[string]interface{}{"MinDialTimeout": time.Minute},
+			expected: fmt.Errorf("max dial timeout 30s must not be less than min dial timeout 1m0s"),
+		},
+		"UnboundedMaxDialTimeout": {
+			fieldMap: map[string]interface{}{"MinDialTimeout": time.Minute, "MaxDialTimeout": time.Duration(0)},
+			expected: nil,
+		},
+		"NegativeDialRetries": {
+			fieldMap: map[string]interface{}{"DialRetries": -1},
+			expected: fmt.Errorf("dial retries must not be negative, got -1"),
+		},
+		"DialRetriesWithoutBackoff": {
+			fieldMap: map[string]interface{}{"DialRetries": 3, "DialRetryBackoff": time.Duration(0)},
+			expected: fmt.Errorf("dial retry backoff must be positive, got 0s"),
+		},
+		"MissingDestinationPolicyFile": {
+			fieldMap: map[string]interface{}{"DestinationPolicyFile": "/tmp/missing-policy"},
+			expected: fmt.Errorf("error checking destination policy file /tmp/missing-policy, got stat /tmp/missing-policy: no such file or directory"),
+		},
+		"DNSServers": {
+			fieldMap: map[string]interface{}{"DNSServers": []string{"10.0.0.10", "cluster.local=10.96.0.10:53"}},
+			expected: nil,
+		},
+		"EmptyDNSServerDomain": {
+			fieldMap: map[string]interface{}{"DNSServers": []string{"=10.96.0.10"}},
+			expected: fmt.Errorf("invalid DNS server \"=10.96.0.10\", expected an empty domain to be omitted"),
+		},
+		"DuplicateDNSServerDomain": {
+			fieldMap: map[string]interface{}{"DNSServers": []string{"cluster.local=10.96.0.10", "Cluster.Local.=10.96.0.11"}},
+			expected: fmt.Errorf("invalid DNS server \"Cluster.Local.=10.96.0.11\", more than one server for the domain \"cluster.local\""),
+		},
+		"MissingDNSHostsFile": {
+			fieldMap: map[string]interface{}{"DNSHostsFile": "/tmp/missing-hosts"},
+			expected: fmt.Errorf("error checking DNS hosts file /tmp/missing-hosts, got stat /tmp/missing-hosts: no such file or directory"),
+		},
+		"NegativeDNSCacheMaxTTL": {
+			fieldMap: map[string]interface{}{"DNSCacheMaxTTL": -time.Second},
+			expected: fmt.Errorf("DNS cache max TTL must not be negative, got -1s"),
+		},
+		"DNSCacheWithoutServers": {
+			fieldMap: map[string]interface{}{"DNSCacheMaxTTL": time.Minute},
+			expected: fmt.Errorf("the DNS cache requires --dns-servers, the answers of the system resolver have no TTL"),
+		},
+		"MissingAgentIdentifiersFile": {
+			fieldMap: map[string]interface{}{"AgentIdentifiersFile": "/tmp/missing-identifiers"},
+			expected: fmt.Errorf("error checking agent identifiers file /tmp/missing-identifiers, got failed to read agent identifiers: stat /tmp/missing-identifiers: no such file or directory"),
+		},
+		"AgentIdentifiersAndFile": {
+			fieldMap: map[string]interface{}{"AgentIdentifiers": "host=node1", "AgentIdentifiersFile": "/tmp/missing-identifiers"},
+			expected: fmt.Errorf("--agent-identifiers and --agent-identifiers-file are mutually exclusive"),
+		},
+		"MissingEgressConfigFile": {
+			fieldMap: map[string]interface{}{"EgressConfigFile": "/tmp/missing-egress"},
+			expected: fmt.Errorf("error checking egress config file /tmp/missing-egress, got stat /tmp/missing-egress: no such file or directory"),
+		},
+		"ReadinessMinServerFraction": {
+			fieldMap: map[string]interface{}{"ReadinessMinServerFraction": 0.5},
+			expected: nil,
+		},
+		"ReadinessMinServerFractionAboveOne": {
+			fieldMap: map[string]interface{}{"ReadinessMinServerFraction": 1.5},
+			expected: fmt.Errorf("readiness min server fraction must be between 0 and 1, got 1.5"),
+		},
+		"NegativeReadinessMaxSyncAge": {
+			fieldMap: map[string]interface{}{"ReadinessMaxSyncAge": -time.Minute},
+			expected: fmt.Errorf("readiness max sync age must not be negative, got -1m0s"),
+		},
+		"DiscoverInterfaceAddresses": {
+			fieldMap: map[string]interface{}{"DiscoverInterfaceAddresses": true, "DiscoverInterfaceExclude": []string{"docker*", "10.0.0.0/8"}},
+			expected: nil,
+		},
+		"DiscoverInterfaceIncludeWithoutAddresses": {
+			fieldMap: map[string]interface{}{"DiscoverInterfaceInclude": []string{"eth*"}},
+			expected: fmt.Errorf("--discover-interface-include and --discover-interface-exclude require --discover-interface-addresses"),
+		},
+		"InvalidDiscoverInterfaceExclude": {
+			fieldMap: map[string]interface{}{"DiscoverInterfaceAddresses": true, "DiscoverInterfaceExclude": []string{"10.0.0.0/33"}},
+			expected: fmt.Errorf("invalid interface filter \"10.0.0.0/33\": invalid CIDR address: 10.0.0.0/33"),
+		},
+		"MissingKubeconfig": {
+			fieldMap: map[string]interface{}{"DiscoverNodeName": "node1", "Kubeconfig": "/tmp/missing-kubeconfig"},
+			expected: fmt.Errorf("error checking kubeconfig /tmp/missing-kubeconfig, got stat /tmp/missing-kubeconfig: no such file or directory"),
+		},
+		"ZeroDiscoveryInterval": {
+			fieldMap: map[string]interface{}{"DiscoverHostname": true, "DiscoveryInterval": time.Duration(0)},
+			expected: fmt.Errorf("discovery interval must be positive, got 0s"),
+		},
+		"LogTracingExporter": {
+			fieldMap: map[string]interface{}{"TracingExporter": "log"},
+			expected: nil,
+		},
+		"UnknownTracingExporter": {
+			fieldMap: map[string]interface{}{"TracingExporter": "otlp"},
+			expected: fmt.Errorf("tracing exporter \"otlp\" is not supported, expected \"log\" or empty"),
+		},
 	} {
 		t.Run(desc, func(t *testing.T) {
 			testAgentOptions := NewGrpcProxyAgentOptions()
@@ -162,6 +305,12 @@ func TestValidate(t *testing.T) {
 				case reflect.Bool:
 					bvalue := value.(bool)
 					fv.SetBool(bvalue)
+				case reflect.Int64:
+					fv.SetInt(reflect.ValueOf(value).Int())
+				case reflect.Float64:
+					fv.SetFloat(reflect.ValueOf(value).Float())
+				case reflect.Slice:
+					fv.Set(reflect.ValueOf(value))
 				}
 			}
 			actual := testAgentOptions.Validate()
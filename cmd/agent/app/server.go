@@ -19,7 +19,9 @@ package app
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/http/pprof"
@@ -33,19 +35,41 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/keepalive"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 
 	"sigs.k8s.io/apiserver-network-proxy/cmd/agent/app/options"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/agent"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/agent/discovery"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/agent/dns"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/agent/egress"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/agent/policy"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/config"
 	"sigs.k8s.io/apiserver-network-proxy/pkg/util"
 )
 
 const ReadHeaderTimeout = 60 * time.Second
 
+// destinationPolicyReloadInterval is how often the destination policy file
+// is checked for changes.
+const destinationPolicyReloadInterval = 10 * time.Second
+
+// agentIdentifiersReloadInterval is how often the agent identifiers file is
+// checked for changes.
+const agentIdentifiersReloadInterval = 10 * time.Second
+
 func NewAgentCommand(a *Agent, o *options.GrpcProxyAgentOptions) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:  "agent",
 		Long: `A gRPC agent, Connects to the proxy and then allows traffic to be forwarded to it.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := o.LoadConfigFile(cmd.Flags()); err != nil {
+				return fmt.Errorf("failed to load agent configuration: %v", err)
+			}
+			if o.PrintConfig {
+				return printConfig(cmd.OutOrStdout(), o.EffectiveConfig())
+			}
 			return a.run(o)
 		},
 	}
@@ -53,7 +77,19 @@ func NewAgentCommand(a *Agent, o *options.GrpcProxyAgentOptions) *cobra.Command
 	return cmd
 }
 
+// printConfig writes cfg to w as YAML.
+func printConfig(w io.Writer, cfg *options.AgentConfiguration) error {
+	data, err := config.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to encode agent configuration: %v", err)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
 type Agent struct {
+	// clientSet connects to the proxy servers, once running.
+	clientSet *agent.ClientSet
 }
 
 func (a *Agent) run(o *options.GrpcProxyAgentOptions) error {
@@ -94,18 +130,124 @@ func (a *Agent) runProxyConnection(o *options.GrpcProxyAgentOptions, stopCh <-ch
 		}),
 	}
 	cc := o.ClientSetConfig(dialOptions...)
+	var identifiersFile *agent.IdentifiersFile
+	if o.AgentIdentifiersFile != "" {
+		f, err := agent.NewIdentifiersFile(o.AgentIdentifiersFile)
+		if err != nil {
+			return err
+		}
+		identifiersFile = f
+		cc.AgentIdentifiers = f.Identifiers()
+	}
+	var discoverer *discovery.Discoverer
+	if o.DiscoversIdentifiers() {
+		d, err := newDiscoverer(o)
+		if err != nil {
+			return err
+		}
+		if err := d.SetStatic(cc.AgentIdentifiers); err != nil {
+			return err
+		}
+		d.Refresh()
+		discoverer = d
+		cc.AgentIdentifiers = d.Identifiers()
+	}
+	if o.DestinationPolicyFile != "" {
+		p, err := policy.NewDestinationPolicy(o.DestinationPolicyFile)
+		if err != nil {
+			return err
+		}
+		go p.Run(destinationPolicyReloadInterval, stopCh)
+		cc.DestinationPolicy = p
+	}
+	if len(o.DNSServers) > 0 || len(o.DNSSearch) > 0 || o.DNSHostsFile != "" {
+		r, err := dns.NewResolver(dns.Config{
+			Servers:     o.DNSServers,
+			Search:      o.DNSSearch,
+			HostsFile:   o.DNSHostsFile,
+			CacheMaxTTL: o.DNSCacheMaxTTL,
+		})
+		if err != nil {
+			return err
+		}
+		cc.Resolver = r
+	}
+	if o.EgressConfigFile != "" {
+		router, err := egress.NewRouter(o.EgressConfigFile, agent.NewDirectDialer(cc.DestinationPolicy, cc.Resolver), cc.DestinationPolicy)
+		if err != nil {
+			return err
+		}
+		cc.Dialer = router
+	}
 	cs := cc.NewAgentClientSet(stopCh)
+	if identifiersFile != nil {
+		onChange := cs.SetAgentIdentifiers
+		if discoverer != nil {
+			// The file has the static identifiers, merged with the
+			// discovered ones.
+			onChange = func(identifiers string) error {
+				if err := discoverer.SetStatic(identifiers); err != nil {
+					return err
+				}
+				return cs.SetAgentIdentifiers(discoverer.Identifiers())
+			}
+		}
+		go identifiersFile.Run(agentIdentifiersReloadInterval, stopCh, onChange)
+	}
+	if discoverer != nil {
+		go discoverer.Run(o.DiscoveryInterval, stopCh, cs.SetAgentIdentifiers)
+	}
 	cs.Serve()
+	a.clientSet = cs
 
 	return nil
 }
 
+// newDiscoverer returns the discoverer of the agent identifiers, with a
+// Kubernetes client if the identifiers of a Node are discovered.
+func newDiscoverer(o *options.GrpcProxyAgentOptions) (*discovery.Discoverer, error) {
+	cfg := o.DiscoveryConfig()
+	if o.DiscoverNodeName != "" {
+		config, err := clientcmd.BuildConfigFromFlags("", o.Kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load kubernetes client config: %v", err)
+		}
+		client, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create kubernetes clientset: %v", err)
+		}
+		cfg.KubernetesClient = client
+	}
+	return discovery.NewDiscoverer(cfg)
+}
+
 func (a *Agent) runHealthServer(o *options.GrpcProxyAgentOptions) error {
 	livenessHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "ok")
 	})
 	readinessHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintf(w, "ok")
+		if _, verbose := r.URL.Query()["verbose"]; verbose {
+			// Detail the result of every check as JSON.
+			status := a.clientSet.ReadinessStatus()
+			w.Header().Set("Content-Type", "application/json")
+			if status.Ready {
+				w.WriteHeader(200)
+			} else {
+				w.WriteHeader(500)
+			}
+			if err := json.NewEncoder(w).Encode(status); err != nil {
+				klog.ErrorS(err, "failed to write the readiness status")
+			}
+			return
+		}
+		ready, msg := a.clientSet.Ready()
+		if ready {
+			w.WriteHeader(200)
+			fmt.Fprintf(w, "ok")
+			return
+		}
+		w.WriteHeader(500)
+		fmt.Fprint(w, msg)
 	})
 
 	muxHandler := http.NewServeMux()
@@ -149,6 +291,13 @@ func (a *Agent) runAdminServer(o *options.GrpcProxyAgentOptions) error {
 		}
 		http.Redirect(w, r, fmt.Sprintf("%s:%d%s", host, o.HealthServerPort, r.URL.Path), http.StatusMovedPermanently)
 	}))
+	// Detail the connection to every proxy server as JSON.
+	muxHandler.HandleFunc("/debug/servers", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(a.clientSet.Status()); err != nil {
+			klog.ErrorS(err, "failed to write the proxy servers status")
+		}
+	})
 	if o.EnableProfiling {
 		muxHandler.HandleFunc("/debug/pprof", util.RedirectTo("/debug/pprof/"))
 		muxHandler.HandleFunc("/debug/pprof/", pprof.Index)
@@ -0,0 +1,154 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package options
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/pflag"
+
+	"sigs.k8s.io/apiserver-network-proxy/pkg/config"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server"
+)
+
+// ServerConfigurationKind is the kind of the proxy-server configuration file.
+const ServerConfigurationKind = "ProxyServerConfiguration"
+
+// configFlags are the flags which cannot be set from the configuration file.
+var configFlags = []string{"config", "print-config"}
+
+// ServerConfiguration is the configuration file of the proxy-server, e.g.
+//
+//	apiVersion: konnectivity.k8s.io/v1alpha1
+//	kind: ProxyServerConfiguration
+//	options:
+//	  mode: http-connect
+//	  cluster-cert: /etc/konnectivity/cluster.crt
+//	  cluster-key: /etc/konnectivity/cluster.key
+//	proxyStrategies:
+//	- name: destHost
+//	  identifierTypes: [host]
+//	- name: default
+//	frontends:
+//	- name: legacy
+//	  mode: grpc
+//	  udsName: /etc/konnectivity/konnectivity.socket
+type ServerConfiguration struct {
+	config.TypeMeta `json:",inline"`
+
+	// Options sets the command-line options, by flag name.
+	Options config.Options `json:"options,omitempty"`
+
+	// ProxyStrategies lists the proxy strategies in the order they are
+	// tried. It is mutually exclusive with the "proxy-strategies" option.
+	ProxyStrategies []ProxyStrategyConfiguration `json:"proxyStrategies,omitempty"`
+
+	// Frontends lists the frontend listeners served along with the one of
+	// the mode, uds-name and server-port options. It is mutually exclusive
+	// with the "frontend" option.
+	Frontends []FrontendListener `json:"frontends,omitempty"`
+}
+
+// ProxyStrategyConfiguration configures one of the proxy strategies.
+type ProxyStrategyConfiguration struct {
+	// Name of the strategy, one of "default", "destHost" or "defaultRoute".
+	Name string `json:"name"`
+	// IdentifierTypes are the types of the agent identifiers which the
+	// destHost strategy matches the destinations against, among "ipv4",
+	// "ipv6" and "host"; all of them if empty. Only destHost supports it.
+	IdentifierTypes []string `json:"identifierTypes,omitempty"`
+}
+
+// LoadConfigFile applies the configuration file, if set, to the options.
+// fs must be the flag set the options were parsed from; flags set on the
+// command line take precedence over the file.
+func (o *ProxyRunOptions) LoadConfigFile(fs *pflag.FlagSet) error {
+	if o.ConfigFile == "" {
+		return nil
+	}
+	cfg := &ServerConfiguration{}
+	if err := config.ReadFile(o.ConfigFile, ServerConfigurationKind, cfg); err != nil {
+		return err
+	}
+	if err := cfg.Options.Apply(fs, configFlags...); err != nil {
+		return fmt.Errorf("config file %s: %v", o.ConfigFile, err)
+	}
+	for _, ps := range cfg.ProxyStrategies {
+		if len(ps.IdentifierTypes) == 0 {
+			continue
+		}
+		if ps.Name != string(server.ProxyStrategyDestHost) {
+			return fmt.Errorf("config file %s: identifierTypes is not supported by the %s proxy strategy", o.ConfigFile, ps.Name)
+		}
+		o.DestHostIdentifierTypes = ps.IdentifierTypes
+	}
+	if len(cfg.ProxyStrategies) > 0 {
+		if _, ok := cfg.Options["proxy-strategies"]; ok {
+			return fmt.Errorf("config file %s: proxyStrategies and the proxy-strategies option are mutually exclusive", o.ConfigFile)
+		}
+		if !fs.Changed("proxy-strategies") {
+			names := make([]string, 0, len(cfg.ProxyStrategies))
+			for _, ps := range cfg.ProxyStrategies {
+				names = append(names, ps.Name)
+			}
+			o.ProxyStrategies = strings.Join(names, ",")
+		}
+	}
+	if len(cfg.Frontends) > 0 {
+		if _, ok := cfg.Options["frontend"]; ok {
+			return fmt.Errorf("config file %s: frontends and the frontend option are mutually exclusive", o.ConfigFile)
+		}
+		if !fs.Changed("frontend") {
+			o.Frontends = nil
+			for _, l := range cfg.Frontends {
+				o.Frontends = append(o.Frontends, l.String())
+			}
+		}
+	}
+	return nil
+}
+
+// EffectiveConfig returns the configuration file equivalent to the options.
+func (o *ProxyRunOptions) EffectiveConfig() *ServerConfiguration {
+	cfg := &ServerConfiguration{
+		TypeMeta: config.TypeMeta{APIVersion: config.APIVersion, Kind: ServerConfigurationKind},
+		Options:  config.EffectiveOptions(o.Flags(), configFlags...),
+	}
+	if o.ProxyStrategies != "" {
+		delete(cfg.Options, "proxy-strategies")
+		for _, name := range strings.Split(o.ProxyStrategies, ",") {
+			ps := ProxyStrategyConfiguration{Name: name}
+			if name == string(server.ProxyStrategyDestHost) {
+				ps.IdentifierTypes = o.DestHostIdentifierTypes
+			}
+			cfg.ProxyStrategies = append(cfg.ProxyStrategies, ps)
+		}
+	}
+	delete(cfg.Options, "frontend")
+	for _, spec := range o.Frontends {
+		l, err := ParseFrontendListener(spec)
+		if err != nil {
+			// Keep the invalid specs as they are, for Validate to report.
+			cfg.Options["frontend"] = o.Frontends
+			cfg.Frontends = nil
+			break
+		}
+		cfg.Frontends = append(cfg.Frontends, l)
+	}
+	return cfg
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package options
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"sigs.k8s.io/apiserver-network-proxy/pkg/config"
+)
+
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := writeConfig(t, `
+apiVersion: konnectivity.k8s.io/v1alpha1
+kind: ProxyServerConfiguration
+options:
+  mode: http-connect
+  server-port: 9000
+  keepalive-time: 30m
+proxyStrategies:
+- name: destHost
+  identifierTypes: [host]
+- name: default
+frontends:
+- name: legacy
+  mode: grpc
+  udsName: /tmp/konnectivity.socket
+`)
+	o := NewProxyRunOptions()
+	fs := o.Flags()
+	if err := fs.Parse([]string{"--config=" + path, "--server-port=9001"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.LoadConfigFile(fs); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "http-connect", o.Mode)
+	assert.Equal(t, 9001, o.ServerPort, "flags take precedence over the config file")
+	assert.Equal(t, 30*time.Minute, o.KeepaliveTime)
+	assert.Equal(t, "destHost,default", o.ProxyStrategies)
+	assert.Equal(t, []string{"host"}, o.DestHostIdentifierTypes)
+	assert.Equal(t, []string{"name=legacy,mode=grpc,uds=/tmp/konnectivity.socket"}, o.Frontends)
+	assert.Equal(t, 8091, o.AgentPort, "options not in the config file keep their default")
+	assert.NoError(t, o.Validate())
+
+	// The effective config loads back to the same options.
+	data, err := config.Marshal(o.EffectiveConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	o2 := NewProxyRunOptions()
+	o2.ConfigFile = writeConfig(t, string(data))
+	if err := o2.LoadConfigFile(o2.Flags()); err != nil {
+		t.Fatalf("failed to load the effective config %s: %v", data, err)
+	}
+	o2.ConfigFile = o.ConfigFile
+	assert.Equal(t, o, o2)
+
+	o.DestHostIdentifierTypes = []string{"uid"}
+	assert.EqualError(t, o.Validate(), "unknown destHost identifier type: uid, available types are: ipv4, ipv6, host")
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	for desc, data := range map[string]string{
+		"unknown option":               "options:\n  unknown: value\n",
+		"reserved option":              "options:\n  config: other.yaml\n",
+		"invalid value":                "options:\n  server-port: eighty\n",
+		"unknown field":                "serverPort: 9000\n",
+		"conflicting proxy strategies": "options:\n  proxy-strategies: default\nproxyStrategies:\n- name: destHost\n",
+		"identifier types of default":  "proxyStrategies:\n- name: default\n  identifierTypes: [host]\n",
+		"conflicting frontends":        "options:\n  frontend: [name=a,mode=grpc,uds=/tmp/a]\nfrontends:\n- name: b\n  mode: grpc\n  udsName: /tmp/b\n",
+	} {
+		t.Run(desc, func(t *testing.T) {
+			o := NewProxyRunOptions()
+			o.ConfigFile = writeConfig(t, "apiVersion: konnectivity.k8s.io/v1alpha1\nkind: ProxyServerConfiguration\n"+data)
+			assert.Error(t, o.LoadConfigFile(o.Flags()))
+		})
+	}
+}
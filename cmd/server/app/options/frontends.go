@@ -0,0 +1,188 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package options
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server/metrics"
+)
+
+// FrontendListener is a listener serving the frontends, e.g. the Kube API
+// Servers, in one of the modes.
+type FrontendListener struct {
+	// Name identifies the listener, e.g. in the listener label of the
+	// metrics.
+	Name string `json:"name"`
+	// Mode is one of "grpc", "http-connect" or "socks5".
+	Mode string `json:"mode"`
+	// UDSName is the path of the unix domain socket of the listener.
+	// Exclusive with Address.
+	UDSName string `json:"udsName,omitempty"`
+	// Address is the host:port of a TCP listener, served with TLS.
+	Address string `json:"address,omitempty"`
+	// CACert, if set, is the CA of the client certificates required by a
+	// TCP listener.
+	CACert string `json:"caCert,omitempty"`
+	// Cert and Key are the serving certificate and key of a TCP listener.
+	Cert string `json:"cert,omitempty"`
+	Key  string `json:"key,omitempty"`
+}
+
+// frontendKeys are the keys of the --frontend specs, by field.
+var frontendKeys = []string{"name", "mode", "uds", "address", "ca-cert", "cert", "key"}
+
+// ParseFrontendListener parses a --frontend spec, a comma-separated list of
+// key=value pairs, e.g.
+// "name=legacy,mode=grpc,uds=/etc/konnectivity/konnectivity.socket".
+func ParseFrontendListener(spec string) (FrontendListener, error) {
+	var l FrontendListener
+	fields := map[string]*string{
+		"name":    &l.Name,
+		"mode":    &l.Mode,
+		"uds":     &l.UDSName,
+		"address": &l.Address,
+		"ca-cert": &l.CACert,
+		"cert":    &l.Cert,
+		"key":     &l.Key,
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		field, known := fields[strings.TrimSpace(key)]
+		if !ok || !known {
+			return l, fmt.Errorf("invalid frontend %q: expected comma-separated %s=value pairs", spec, strings.Join(frontendKeys, "|"))
+		}
+		*field = strings.TrimSpace(value)
+	}
+	return l, nil
+}
+
+// String returns the --frontend spec of the listener.
+func (l FrontendListener) String() string {
+	values := []string{l.Name, l.Mode, l.UDSName, l.Address, l.CACert, l.Cert, l.Key}
+	var pairs []string
+	for i, value := range values {
+		if value != "" {
+			pairs = append(pairs, frontendKeys[i]+"="+value)
+		}
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (l FrontendListener) validate() error {
+	if l.Name == "" {
+		return fmt.Errorf("frontend %q: name cannot be empty", l)
+	}
+	if l.Mode != "grpc" && l.Mode != "http-connect" && l.Mode != "socks5" {
+		return fmt.Errorf("frontend %q: mode must be set to one of 'grpc', 'http-connect' or 'socks5' not %q", l.Name, l.Mode)
+	}
+	if (l.UDSName == "") == (l.Address == "") {
+		return fmt.Errorf("frontend %q: exactly one of uds and address must be set", l.Name)
+	}
+	if l.UDSName != "" {
+		if l.CACert != "" || l.Cert != "" || l.Key != "" {
+			return fmt.Errorf("frontend %q: certificates should not be set for UDS", l.Name)
+		}
+		return nil
+	}
+	_, port, err := net.SplitHostPort(l.Address)
+	if err != nil {
+		return fmt.Errorf("frontend %q: invalid address %q: %v", l.Name, l.Address, err)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1024 || p > 49151 {
+		return fmt.Errorf("frontend %q: please use a port between 1024 and 49151, not %q", l.Name, port)
+	}
+	if l.Cert == "" || l.Key == "" {
+		return fmt.Errorf("frontend %q: cert and key are required for TCP", l.Name)
+	}
+	for _, file := range []string{l.CACert, l.Cert, l.Key} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			return fmt.Errorf("frontend %q: error checking %s, got %v", l.Name, file, err)
+		}
+	}
+	return nil
+}
+
+// FrontendListeners returns the listeners serving the frontends: the one of
+// the --mode, --uds-name and --server-port flags, named "frontend", followed
+// by those of the --frontend flags.
+func (o *ProxyRunOptions) FrontendListeners() ([]FrontendListener, error) {
+	primary := FrontendListener{
+		Name:    metrics.FrontendListener,
+		Mode:    o.Mode,
+		UDSName: o.UdsName,
+	}
+	if o.UdsName == "" {
+		primary.Address = net.JoinHostPort(o.ServerBindAddress, strconv.Itoa(o.ServerPort))
+		primary.CACert, primary.Cert, primary.Key = o.ServerCaCert, o.ServerCert, o.ServerKey
+	}
+	listeners := []FrontendListener{primary}
+	for _, spec := range o.Frontends {
+		l, err := ParseFrontendListener(spec)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// validateFrontends validates the listeners of the --frontend flags; the
+// primary listener is validated with the flags it is made of.
+func (o *ProxyRunOptions) validateFrontends() error {
+	listeners, err := o.FrontendListeners()
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool)
+	addresses := make(map[string]bool)
+	for i, l := range listeners {
+		if i > 0 {
+			if err := l.validate(); err != nil {
+				return err
+			}
+		}
+		if names[l.Name] {
+			return fmt.Errorf("frontend name %q is used more than once", l.Name)
+		}
+		names[l.Name] = true
+		address := l.UDSName + l.Address
+		if addresses[address] {
+			return fmt.Errorf("frontend %q: %s is used by another frontend", l.Name, address)
+		}
+		addresses[address] = true
+	}
+	return nil
+}
+
+// frontendModes returns whether any frontend listener serves each mode.
+func (o *ProxyRunOptions) frontendModes() map[string]bool {
+	modes := map[string]bool{o.Mode: true}
+	for _, spec := range o.Frontends {
+		if l, err := ParseFrontendListener(spec); err == nil {
+			modes[l.Mode] = true
+		}
+	}
+	return modes
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package options
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseFrontendListener(t *testing.T) {
+	for _, tc := range []struct {
+		spec    string
+		want    FrontendListener
+		wantErr bool
+	}{
+		{
+			spec: "name=legacy,mode=grpc,uds=/tmp/konnectivity.socket",
+			want: FrontendListener{Name: "legacy", Mode: "grpc", UDSName: "/tmp/konnectivity.socket"},
+		},
+		{
+			spec: "name=tcp,mode=http-connect,address=:8092,ca-cert=ca.crt,cert=tls.crt,key=tls.key",
+			want: FrontendListener{Name: "tcp", Mode: "http-connect", Address: ":8092", CACert: "ca.crt", Cert: "tls.crt", Key: "tls.key"},
+		},
+		{spec: "name=legacy,port=8092", wantErr: true},
+		{spec: "legacy", wantErr: true},
+	} {
+		t.Run(tc.spec, func(t *testing.T) {
+			got, err := ParseFrontendListener(tc.spec)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if tc.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("expected %+v, got %+v", tc.want, got)
+			}
+			if got.String() != tc.spec {
+				t.Errorf("expected spec %q, got %q", tc.spec, got.String())
+			}
+		})
+	}
+}
+
+func TestValidateFrontends(t *testing.T) {
+	dir := t.TempDir()
+	cert := filepath.Join(dir, "tls.crt")
+	key := filepath.Join(dir, "tls.key")
+	for _, file := range []string{cert, key} {
+		if err := os.WriteFile(file, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tcp := fmt.Sprintf("name=tcp,mode=http-connect,address=:8092,cert=%s,key=%s", cert, key)
+
+	for desc, tc := range map[string]struct {
+		frontends []string
+		expected  error
+	}{
+		"uds": {
+			frontends: []string{"name=legacy,mode=http-connect,uds=/tmp/konnectivity.socket"},
+		},
+		"tcp": {
+			frontends: []string{tcp},
+		},
+		"MissingName": {
+			frontends: []string{"mode=grpc,uds=/tmp/konnectivity.socket"},
+			expected:  fmt.Errorf("frontend \"mode=grpc,uds=/tmp/konnectivity.socket\": name cannot be empty"),
+		},
+		"UnknownMode": {
+			frontends: []string{"name=legacy,mode=socks4,uds=/tmp/konnectivity.socket"},
+			expected:  fmt.Errorf("frontend \"legacy\": mode must be set to one of 'grpc', 'http-connect' or 'socks5' not \"socks4\""),
+		},
+		"UDSAndAddress": {
+			frontends: []string{"name=legacy,mode=grpc,uds=/tmp/konnectivity.socket,address=:8092"},
+			expected:  fmt.Errorf("frontend \"legacy\": exactly one of uds and address must be set"),
+		},
+		"UDSWithCerts": {
+			frontends: []string{"name=legacy,mode=grpc,uds=/tmp/konnectivity.socket,cert=tls.crt"},
+			expected:  fmt.Errorf("frontend \"legacy\": certificates should not be set for UDS"),
+		},
+		"ReservedPort": {
+			frontends: []string{"name=tcp,mode=grpc,address=:443"},
+			expected:  fmt.Errorf("frontend \"tcp\": please use a port between 1024 and 49151, not \"443\""),
+		},
+		"MissingKey": {
+			frontends: []string{"name=tcp,mode=grpc,address=:8092,cert=" + cert},
+			expected:  fmt.Errorf("frontend \"tcp\": cert and key are required for TCP"),
+		},
+		"DuplicateName": {
+			frontends: []string{"name=frontend,mode=grpc,uds=/tmp/konnectivity.socket"},
+			expected:  fmt.Errorf("frontend name \"frontend\" is used more than once"),
+		},
+		"DuplicateAddress": {
+			frontends: []string{tcp, "name=other," + tcp[len("name=tcp,"):]},
+			expected:  fmt.Errorf("frontend \"other\": :8092 is used by another frontend"),
+		},
+		"PrimaryAddress": {
+			frontends: []string{fmt.Sprintf("name=tcp,mode=grpc,address=:8090,cert=%s,key=%s", cert, key)},
+			expected:  fmt.Errorf("frontend \"tcp\": :8090 is used by another frontend"),
+		},
+	} {
+		t.Run(desc, func(t *testing.T) {
+			o := NewProxyRunOptions()
+			o.Frontends = tc.frontends
+			err := o.validateFrontends()
+			if !reflect.DeepEqual(err, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, err)
+			}
+		})
+	}
+}
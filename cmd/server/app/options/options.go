@@ -18,6 +18,7 @@ package options
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"strings"
 	"time"
@@ -26,10 +27,18 @@ import (
 	"github.com/spf13/pflag"
 	"k8s.io/klog/v2"
 
+	pkgagent "sigs.k8s.io/apiserver-network-proxy/pkg/agent"
 	"sigs.k8s.io/apiserver-network-proxy/pkg/server"
 	"sigs.k8s.io/apiserver-network-proxy/pkg/util"
 )
 
+// The supported values of ProxyRunOptions.ServerMembership.
+const (
+	MembershipStatic   = "static"
+	MembershipLease    = "lease"
+	MembershipPeerList = "peer-list"
+)
+
 type ProxyRunOptions struct {
 	// Certificate setup for securing communication to the "client" i.e. the Kube API Server.
 	ServerCert   string
@@ -49,6 +58,9 @@ type ProxyRunOptions struct {
 	ServerPort int
 	// Bind address for the server.
 	ServerBindAddress string
+	// Additional frontend listeners, as FrontendListener specs, served
+	// along with the listener of Mode, UdsName and ServerPort.
+	Frontends []string
 	// Port we listen for agent connections on.
 	AgentPort int
 	// Bind address for the agent.
@@ -95,6 +107,11 @@ type ProxyRunOptions struct {
 	// backend within the destCIDR. if it still can't find any backend,
 	// it will use the default backend manager to choose a random backend.
 	ProxyStrategies string
+	// DestHostIdentifierTypes are the types of the agent identifiers which
+	// the destHost strategy matches the destinations against, among ipv4,
+	// ipv6 and host; all of them if empty. It is only set by the
+	// configuration file.
+	DestHostIdentifierTypes []string
 
 	// Cipher suites used by the server.
 	// If empty, the default suite will be used from tls.CipherSuites(),
@@ -102,6 +119,122 @@ type ProxyRunOptions struct {
 	// NOTE that cipher suites are not configurable for TLS1.3,
 	// see: https://pkg.go.dev/crypto/tls#Config, so in that case, this option won't have any effect.
 	CipherSuites string
+
+	// Path of the file the audit records of tunneled connections are written
+	// to, as JSON lines. Auditing is disabled if empty.
+	AuditLogPath string
+	// Maximum size in megabytes of the audit log before it is rotated.
+	// Zero disables rotation.
+	AuditLogMaxSize int
+	// Maximum number of rotated audit log files to retain.
+	AuditLogMaxBackups int
+	// If true, the audit records are dropped when the buffer of the audit
+	// log is full, instead of holding up the connections until there is
+	// room.
+	AuditLogDropWhenFull bool
+
+	// Maximum number of distinct agents reported by the per-agent metrics.
+	// Zero disables the per-agent metrics.
+	AgentMetricsLimit int
+
+	// Exporter of the spans recorded for the tunneled connections. Only
+	// "log" is supported; spans are not recorded if empty.
+	TracingExporter string
+
+	// Interval at which the TLS certificate, key and CA files of the
+	// frontend and agent servers are checked for changes and reloaded.
+	// Zero disables reloading.
+	TLSReloadInterval time.Duration
+
+	// How the live proxy server instances are discovered: "static" uses
+	// ServerCount, "lease" Kubernetes Leases, and "peer-list" the IDs
+	// listed in ServerPeerListFile.
+	ServerMembership string
+	// Namespace of the Leases of the proxy servers, with the "lease"
+	// membership.
+	ServerLeaseNamespace string
+	// Duration after which the Lease of a proxy server expires if not
+	// renewed, with the "lease" membership.
+	ServerLeaseDuration time.Duration
+	// File listing the IDs of the proxy servers, one per line, with the
+	// "peer-list" membership.
+	ServerPeerListFile string
+	// Interval at which the Lease is renewed, or the peer list checked for
+	// changes.
+	ServerMembershipInterval time.Duration
+
+	// Port we listen for connections from the peer proxy servers on. Zero
+	// disables the peer listener.
+	PeerPort int
+	// Bind address for the peer connections.
+	PeerBindAddress string
+	// Comma-separated host:port addresses of the peer proxy servers, to
+	// which the dials without a matching local agent are forwarded. Every
+	// address the host resolves to is a peer.
+	PeerAddresses string
+	// Certificate, key and CA used for mutual TLS authentication between
+	// peer proxy servers, as server as well as client.
+	PeerCert   string
+	PeerKey    string
+	PeerCaCert string
+	// Interval at which the agents of the peer proxy servers are refreshed.
+	PeerSyncInterval time.Duration
+
+	// Minimum number of connected agents for the server to be ready.
+	ReadinessMinAgents int
+	// Comma-separated proxy strategies which must each have an agent for
+	// the server to be ready.
+	ReadinessStrategies string
+	// If positive, the server is not ready while all the dials of the last
+	// ReadinessDialWindow failed.
+	ReadinessDialWindow time.Duration
+
+	// Path of the htpasswd file of the users allowed to authenticate HTTP
+	// CONNECT requests with Basic Proxy-Authorization credentials, or SOCKS5
+	// connections with their username and password.
+	ProxyAuthHtpasswdFile string
+	// Path of the file of the tokens allowed to authenticate HTTP CONNECT
+	// requests with Bearer Proxy-Authorization credentials.
+	ProxyAuthTokenFile string
+	// Authenticate the Bearer Proxy-Authorization tokens with the
+	// TokenReview API.
+	ProxyAuthTokenReview bool
+	// Comma-separated audiences of the tokens reviewed with the TokenReview
+	// API.
+	ProxyAuthTokenAudiences string
+	// Realm advertised in the Proxy-Authenticate challenges.
+	ProxyAuthRealm string
+
+	// Accept CONNECT requests over HTTP/2 streams in the http-connect mode,
+	// with TLS (ALPN) or, over UDS, without (prior knowledge).
+	HTTPConnectHTTP2 bool
+	// If positive, the time after which a dial of the http-connect or
+	// socks5 modes not answered by the agent is cancelled.
+	HTTPConnectDialTimeout time.Duration
+
+	// If positive, the interval at which PINGs are sent to the agents.
+	AgentPingInterval time.Duration
+	// Time after which an agent which did not answer a PING is removed
+	// from the backend selection.
+	AgentPingTimeout time.Duration
+	// Number of packets of each priority queued to an agent before the
+	// senders wait.
+	AgentSendQueueSize int
+	// If positive, the time a DATA packet waits for room in the send queue
+	// of an agent, after which its connection is closed.
+	AgentSendTimeout time.Duration
+	// If positive, the time after which an established connection with no
+	// DATA in either direction is closed.
+	ConnectionIdleTimeout time.Duration
+	// If positive, the time after which an established connection is
+	// closed.
+	ConnectionMaxLifetime time.Duration
+
+	// Path of the versioned configuration file. Flags set on the command
+	// line take precedence over the file.
+	ConfigFile string
+	// If true, print the effective configuration file and exit.
+	PrintConfig bool
 }
 
 func (o *ProxyRunOptions) Flags() *pflag.FlagSet {
@@ -112,11 +245,12 @@ func (o *ProxyRunOptions) Flags() *pflag.FlagSet {
 	flags.StringVar(&o.ClusterCert, "cluster-cert", o.ClusterCert, "If non-empty secure communication with this cert.")
 	flags.StringVar(&o.ClusterKey, "cluster-key", o.ClusterKey, "If non-empty secure communication with this key.")
 	flags.StringVar(&o.ClusterCaCert, "cluster-ca-cert", o.ClusterCaCert, "If non-empty the CA we use to validate Agent clients.")
-	flags.StringVar(&o.Mode, "mode", o.Mode, "mode can be either 'grpc' or 'http-connect'.")
+	flags.StringVar(&o.Mode, "mode", o.Mode, "mode can be either 'grpc', 'http-connect' or 'socks5'.")
 	flags.StringVar(&o.UdsName, "uds-name", o.UdsName, "uds-name should be empty for TCP traffic. For UDS set to its name.")
 	flags.BoolVar(&o.DeleteUDSFile, "delete-existing-uds-file", o.DeleteUDSFile, "If true and if file UdsName already exists, delete the file before listen on that UDS file")
 	flags.IntVar(&o.ServerPort, "server-port", o.ServerPort, "Port we listen for server connections on. Set to 0 for UDS.")
 	flags.StringVar(&o.ServerBindAddress, "server-bind-address", o.ServerBindAddress, "Bind address for server connections. If empty, we will bind to all interfaces.")
+	flags.StringArrayVar(&o.Frontends, "frontend", o.Frontends, "Additional frontend listener, served along with the one of --mode, --uds-name and --server-port, e.g. to serve the grpc and http-connect modes at the same time. A comma-separated list of name=<name>, mode=<grpc|http-connect|socks5>, either uds=<path> or address=<host:port>, and for TCP the cert=<file>, key=<file> and optional ca-cert=<file> of its mutual TLS. May be repeated.")
 	flags.IntVar(&o.AgentPort, "agent-port", o.AgentPort, "Port we listen for agent connections on.")
 	flags.StringVar(&o.AgentBindAddress, "agent-bind-address", o.AgentBindAddress, "Bind address for agent connections. If empty, we will bind to all interfaces.")
 	flags.IntVar(&o.AdminPort, "admin-port", o.AdminPort, "Port we listen for admin connections on.")
@@ -137,6 +271,43 @@ func (o *ProxyRunOptions) Flags() *pflag.FlagSet {
 	flags.StringVar(&o.AuthenticationAudience, "authentication-audience", o.AuthenticationAudience, "Expected agent's token authentication audience (used with agent-namespace, agent-service-account, kubeconfig).")
 	flags.StringVar(&o.ProxyStrategies, "proxy-strategies", o.ProxyStrategies, "The list of proxy strategies used by the server to pick a backend/tunnel, available strategies are: default, destHost.")
 	flags.StringVar(&o.CipherSuites, "cipher-suites", o.CipherSuites, "The comma separated list of allowed cipher suites. Has no effect on TLS1.3. Empty means allow default list.")
+	flags.StringVar(&o.AuditLogPath, "audit-log-path", o.AuditLogPath, "If non-empty, write a JSON audit record of every tunneled connection to this file. The records are buffered; while the buffer is full, e.g. on a slow disk, the teardown of the connections waits for room, unless --audit-log-drop-when-full is set.")
+	flags.IntVar(&o.AuditLogMaxSize, "audit-log-max-size", o.AuditLogMaxSize, "Maximum size in megabytes of the audit log before it is rotated. Set to 0 to disable rotation.")
+	flags.IntVar(&o.AuditLogMaxBackups, "audit-log-max-backups", o.AuditLogMaxBackups, "Maximum number of rotated audit log files to retain.")
+	flags.BoolVar(&o.AuditLogDropWhenFull, "audit-log-drop-when-full", o.AuditLogDropWhenFull, "If true, drop the audit records when the buffer of the audit log is full, instead of waiting for room. The dropped records are counted by the audit_records_dropped_total metric.")
+	flags.IntVar(&o.AgentMetricsLimit, "agent-metrics-limit", o.AgentMetricsLimit, "Maximum number of distinct agents labelled in the per-agent metrics, further agents are reported as \"other\". Set to 0 to disable the per-agent metrics.")
+	flags.StringVar(&o.TracingExporter, "tracing-exporter", o.TracingExporter, "Exporter of the spans recorded for the tunneled connections. Supported values: \"log\". Spans are not recorded if empty.")
+	flags.DurationVar(&o.TLSReloadInterval, "tls-reload-interval", o.TLSReloadInterval, "Interval at which the TLS certificate, key and CA files of the frontend and agent servers are checked for changes and reloaded, without dropping established connections. Set to 0 to disable reloading.")
+	flags.StringVar(&o.ServerMembership, "server-membership", o.ServerMembership, "How the live proxy server instances are discovered and reported to the agents: \"static\" uses --server-count, \"lease\" Kubernetes Leases in --server-lease-namespace, and \"peer-list\" the server IDs listed in --server-peer-list-file.")
+	flags.StringVar(&o.ServerLeaseNamespace, "server-lease-namespace", o.ServerLeaseNamespace, "Namespace of the Leases of the proxy servers, with --server-membership=lease.")
+	flags.DurationVar(&o.ServerLeaseDuration, "server-lease-duration", o.ServerLeaseDuration, "Duration after which the Lease of a proxy server expires if not renewed, with --server-membership=lease.")
+	flags.StringVar(&o.ServerPeerListFile, "server-peer-list-file", o.ServerPeerListFile, "File listing the IDs of the proxy servers, one per line, with --server-membership=peer-list. The file is reloaded when it changes.")
+	flags.DurationVar(&o.ServerMembershipInterval, "server-membership-interval", o.ServerMembershipInterval, "Interval at which the Lease of the proxy server is renewed, or the peer list file checked for changes.")
+	flags.IntVar(&o.PeerPort, "peer-port", o.PeerPort, "Port we listen for connections from the peer proxy servers on. The peers must present a certificate signed by --peer-ca-cert. If zero, the peer listener is disabled.")
+	flags.StringVar(&o.PeerBindAddress, "peer-bind-address", o.PeerBindAddress, "Bind address for peer connections. If empty, we will bind to all interfaces.")
+	flags.StringVar(&o.PeerAddresses, "peer-addresses", o.PeerAddresses, "Comma-separated host:port addresses of the peer proxy servers, e.g. of a headless Service. Every address a host resolves to is a peer. A dial for which no agent is connected to this server is forwarded to a peer with a matching agent. If empty, dials are never forwarded.")
+	flags.StringVar(&o.PeerCert, "peer-cert", o.PeerCert, "Certificate used for mutual TLS with the peer proxy servers, as server and client. It must be valid for the hosts of --peer-addresses.")
+	flags.StringVar(&o.PeerKey, "peer-key", o.PeerKey, "Private key of --peer-cert.")
+	flags.StringVar(&o.PeerCaCert, "peer-ca-cert", o.PeerCaCert, "CA used to verify the certificates of the peer proxy servers.")
+	flags.DurationVar(&o.PeerSyncInterval, "peer-sync-interval", o.PeerSyncInterval, "Interval at which the peer addresses are resolved and the agents of the peer proxy servers refreshed.")
+	flags.IntVar(&o.ReadinessMinAgents, "readiness-min-agents", o.ReadinessMinAgents, "Minimum number of connected agents for the server to report ready.")
+	flags.StringVar(&o.ReadinessStrategies, "readiness-strategies", o.ReadinessStrategies, "Comma-separated proxy strategies, among --proxy-strategies, which must each have an agent for the server to report ready, e.g. \"defaultRoute\" requires an agent serving the default route.")
+	flags.DurationVar(&o.ReadinessDialWindow, "readiness-dial-window", o.ReadinessDialWindow, "If positive, the server reports not ready while all the dials of the last window failed. Zero disables the check.")
+	flags.StringVar(&o.ProxyAuthHtpasswdFile, "proxy-auth-htpasswd-file", o.ProxyAuthHtpasswdFile, "If non-empty, the htpasswd file (bcrypt or {SHA} hashes) of the users who may authenticate HTTP CONNECT requests with Basic Proxy-Authorization credentials, or SOCKS5 connections with their username and password.")
+	flags.StringVar(&o.ProxyAuthTokenFile, "proxy-auth-token-file", o.ProxyAuthTokenFile, "If non-empty, a file of token,user lines whose tokens may authenticate HTTP CONNECT requests with Bearer Proxy-Authorization credentials.")
+	flags.BoolVar(&o.ProxyAuthTokenReview, "proxy-auth-token-review", o.ProxyAuthTokenReview, "If true, Bearer Proxy-Authorization tokens of HTTP CONNECT requests are authenticated with the TokenReview API (used with kubeconfig).")
+	flags.StringVar(&o.ProxyAuthTokenAudiences, "proxy-auth-token-audiences", o.ProxyAuthTokenAudiences, "Comma-separated audiences of the tokens reviewed with --proxy-auth-token-review. Defaults to the audiences of the API server.")
+	flags.StringVar(&o.ProxyAuthRealm, "proxy-auth-realm", o.ProxyAuthRealm, "Realm advertised in the Proxy-Authenticate challenges of the 407 responses.")
+	flags.BoolVar(&o.HTTPConnectHTTP2, "http-connect-http2", o.HTTPConnectHTTP2, "If true, the http-connect frontend also accepts CONNECT requests over HTTP/2 streams, so that many tunnels can share one client connection. Extended CONNECT (RFC 8441) of the connect-tcp protocol is accepted too, with the destination in the /.well-known/masque/tcp/{host}/{port}/ path.")
+	flags.DurationVar(&o.HTTPConnectDialTimeout, "http-connect-dial-timeout", o.HTTPConnectDialTimeout, "Time to wait for the agent to dial the destination of a CONNECT request of the http-connect or socks5 modes before cancelling the dial and answering 504 Gateway Timeout, or the TTL expired SOCKS5 reply. Zero waits indefinitely.")
+	flags.DurationVar(&o.AgentPingInterval, "agent-ping-interval", o.AgentPingInterval, "If positive, the interval at which PINGs are sent to the agents to measure their round-trip time and detect stalled agents. Requires agents which answer PINGs. Zero disables the PINGs.")
+	flags.DurationVar(&o.AgentPingTimeout, "agent-ping-timeout", o.AgentPingTimeout, "Time after which an agent which did not answer a PING is removed from the backend selection, until it answers again.")
+	flags.DurationVar(&o.ConnectionIdleTimeout, "connection-idle-timeout", o.ConnectionIdleTimeout, "If positive, the time after which an established connection with no data in either direction is closed, on both the frontend and the agent side. Zero disables the idle timeout.")
+	flags.DurationVar(&o.ConnectionMaxLifetime, "connection-max-lifetime", o.ConnectionMaxLifetime, "If positive, the time after which an established connection is closed, on both the frontend and the agent side. Zero disables the maximum lifetime.")
+	flags.IntVar(&o.AgentSendQueueSize, "agent-send-queue-size", o.AgentSendQueueSize, "Number of packets queued to be sent to each agent, for the control packets and for the DATA packets. Control packets, e.g. dial requests, are sent before the queued DATA packets. Senders, and so the frontends they read, wait while the queue is full.")
+	flags.DurationVar(&o.AgentSendTimeout, "agent-send-timeout", o.AgentSendTimeout, "Maximum time the DATA of a connection waits for room in the full send queue of an agent, after which the connection is closed so that a slow agent does not hold up the frontends. Set to 0 to wait indefinitely.")
+	flags.StringVar(&o.ConfigFile, "config", o.ConfigFile, "Path of a "+ServerConfigurationKind+" configuration file. Flags set on the command line take precedence over the file.")
+	flags.BoolVar(&o.PrintConfig, "print-config", o.PrintConfig, "Print the effective configuration, in the configuration file format, and exit.")
 
 	flags.Bool("warn-on-channel-limit", true, "This behavior is now thread safe and always on. This flag will be removed in a future release.")
 	flags.MarkDeprecated("warn-on-channel-limit", "This behavior is now thread safe and always on. This flag will be removed in a future release.")
@@ -156,6 +327,7 @@ func (o *ProxyRunOptions) Print() {
 	klog.V(1).Infof("DeleteUDSFile set to %v.\n", o.DeleteUDSFile)
 	klog.V(1).Infof("Server port set to %d.\n", o.ServerPort)
 	klog.V(1).Infof("Server bind address set to %q.\n", o.ServerBindAddress)
+	klog.V(1).Infof("Frontends set to %q.\n", o.Frontends)
 	klog.V(1).Infof("Agent port set to %d.\n", o.AgentPort)
 	klog.V(1).Infof("Agent bind address set to %q.\n", o.AgentBindAddress)
 	klog.V(1).Infof("Admin port set to %d.\n", o.AdminPort)
@@ -175,7 +347,44 @@ func (o *ProxyRunOptions) Print() {
 	klog.V(1).Infof("KubeconfigQPS set to %f.\n", o.KubeconfigQPS)
 	klog.V(1).Infof("KubeconfigBurst set to %d.\n", o.KubeconfigBurst)
 	klog.V(1).Infof("ProxyStrategies set to %q.\n", o.ProxyStrategies)
+	klog.V(1).Infof("DestHostIdentifierTypes set to %q.\n", o.DestHostIdentifierTypes)
 	klog.V(1).Infof("CipherSuites set to %q.\n", o.CipherSuites)
+	klog.V(1).Infof("AuditLogPath set to %q.\n", o.AuditLogPath)
+	klog.V(1).Infof("AuditLogMaxSize set to %d.\n", o.AuditLogMaxSize)
+	klog.V(1).Infof("AuditLogMaxBackups set to %d.\n", o.AuditLogMaxBackups)
+	klog.V(1).Infof("AuditLogDropWhenFull set to %v.\n", o.AuditLogDropWhenFull)
+	klog.V(1).Infof("AgentMetricsLimit set to %d.\n", o.AgentMetricsLimit)
+	klog.V(1).Infof("TracingExporter set to %q.\n", o.TracingExporter)
+	klog.V(1).Infof("TLSReloadInterval set to %v.\n", o.TLSReloadInterval)
+	klog.V(1).Infof("ServerMembership set to %q.\n", o.ServerMembership)
+	klog.V(1).Infof("ServerLeaseNamespace set to %q.\n", o.ServerLeaseNamespace)
+	klog.V(1).Infof("ServerLeaseDuration set to %v.\n", o.ServerLeaseDuration)
+	klog.V(1).Infof("ServerPeerListFile set to %q.\n", o.ServerPeerListFile)
+	klog.V(1).Infof("ServerMembershipInterval set to %v.\n", o.ServerMembershipInterval)
+	klog.V(1).Infof("Peer port set to %d.\n", o.PeerPort)
+	klog.V(1).Infof("Peer bind address set to %q.\n", o.PeerBindAddress)
+	klog.V(1).Infof("PeerAddresses set to %q.\n", o.PeerAddresses)
+	klog.V(1).Infof("PeerCert set to %q.\n", o.PeerCert)
+	klog.V(1).Infof("PeerKey set to %q.\n", o.PeerKey)
+	klog.V(1).Infof("PeerCACert set to %q.\n", o.PeerCaCert)
+	klog.V(1).Infof("PeerSyncInterval set to %v.\n", o.PeerSyncInterval)
+	klog.V(1).Infof("ReadinessMinAgents set to %d.\n", o.ReadinessMinAgents)
+	klog.V(1).Infof("ReadinessStrategies set to %q.\n", o.ReadinessStrategies)
+	klog.V(1).Infof("ReadinessDialWindow set to %v.\n", o.ReadinessDialWindow)
+	klog.V(1).Infof("ProxyAuthHtpasswdFile set to %q.\n", o.ProxyAuthHtpasswdFile)
+	klog.V(1).Infof("ProxyAuthTokenFile set to %q.\n", o.ProxyAuthTokenFile)
+	klog.V(1).Infof("ProxyAuthTokenReview set to %t.\n", o.ProxyAuthTokenReview)
+	klog.V(1).Infof("ProxyAuthTokenAudiences set to %q.\n", o.ProxyAuthTokenAudiences)
+	klog.V(1).Infof("ProxyAuthRealm set to %q.\n", o.ProxyAuthRealm)
+	klog.V(1).Infof("HTTPConnectHTTP2 set to %t.\n", o.HTTPConnectHTTP2)
+	klog.V(1).Infof("HTTPConnectDialTimeout set to %v.\n", o.HTTPConnectDialTimeout)
+	klog.V(1).Infof("AgentPingInterval set to %v.\n", o.AgentPingInterval)
+	klog.V(1).Infof("AgentPingTimeout set to %v.\n", o.AgentPingTimeout)
+	klog.V(1).Infof("AgentSendQueueSize set to %d.\n", o.AgentSendQueueSize)
+	klog.V(1).Infof("AgentSendTimeout set to %v.\n", o.AgentSendTimeout)
+	klog.V(1).Infof("ConnectionIdleTimeout set to %v.\n", o.ConnectionIdleTimeout)
+	klog.V(1).Infof("ConnectionMaxLifetime set to %v.\n", o.ConnectionMaxLifetime)
+	klog.V(1).Infof("ConfigFile set to %q.\n", o.ConfigFile)
 }
 
 func (o *ProxyRunOptions) Validate() error {
@@ -221,8 +430,8 @@ func (o *ProxyRunOptions) Validate() error {
 			return fmt.Errorf("error checking cluster CA cert %s, got %v", o.ClusterCaCert, err)
 		}
 	}
-	if o.Mode != "grpc" && o.Mode != "http-connect" {
-		return fmt.Errorf("mode must be set to either 'grpc' or 'http-connect' not %q", o.Mode)
+	if o.Mode != "grpc" && o.Mode != "http-connect" && o.Mode != "socks5" {
+		return fmt.Errorf("mode must be set to one of 'grpc', 'http-connect' or 'socks5' not %q", o.Mode)
 	}
 	if o.UdsName != "" {
 		if o.ServerPort != 0 {
@@ -304,6 +513,18 @@ func (o *ProxyRunOptions) Validate() error {
 			}
 		}
 	}
+	if len(o.DestHostIdentifierTypes) > 0 {
+		if !strings.Contains(","+o.ProxyStrategies+",", ","+string(server.ProxyStrategyDestHost)+",") {
+			return fmt.Errorf("destHost identifier types are set, but destHost is not one of the proxy strategies %q", o.ProxyStrategies)
+		}
+		for _, idType := range o.DestHostIdentifierTypes {
+			switch idType {
+			case string(pkgagent.IPv4), string(pkgagent.IPv6), string(pkgagent.Host):
+			default:
+				return fmt.Errorf("unknown destHost identifier type: %s, available types are: ipv4, ipv6, host", idType)
+			}
+		}
+	}
 
 	// validate the cipher suites
 	if o.CipherSuites != "" {
@@ -317,6 +538,184 @@ func (o *ProxyRunOptions) Validate() error {
 		}
 	}
 
+	if o.AuditLogMaxSize < 0 {
+		return fmt.Errorf("audit log max size must not be negative, got %d", o.AuditLogMaxSize)
+	}
+	if o.AuditLogMaxBackups < 0 {
+		return fmt.Errorf("audit log max backups must not be negative, got %d", o.AuditLogMaxBackups)
+	}
+	if o.AgentMetricsLimit < 0 {
+		return fmt.Errorf("agent metrics limit must not be negative, got %d", o.AgentMetricsLimit)
+	}
+	if o.TracingExporter != "" && o.TracingExporter != "log" {
+		return fmt.Errorf("tracing exporter %q is not supported, expected \"log\" or empty", o.TracingExporter)
+	}
+	if o.TLSReloadInterval < 0 {
+		return fmt.Errorf("TLS reload interval must not be negative, got %v", o.TLSReloadInterval)
+	}
+
+	switch o.ServerMembership {
+	case MembershipStatic:
+	case MembershipLease:
+		if o.ServerLeaseNamespace == "" {
+			return fmt.Errorf("server lease namespace cannot be empty with the %q membership", o.ServerMembership)
+		}
+		if o.ServerLeaseDuration <= o.ServerMembershipInterval {
+			return fmt.Errorf("server lease duration %v must be greater than the membership interval %v", o.ServerLeaseDuration, o.ServerMembershipInterval)
+		}
+	case MembershipPeerList:
+		if o.ServerPeerListFile == "" {
+			return fmt.Errorf("server peer list file cannot be empty with the %q membership", o.ServerMembership)
+		}
+		if _, err := os.Stat(o.ServerPeerListFile); os.IsNotExist(err) {
+			return fmt.Errorf("error checking server peer list file %s, got %v", o.ServerPeerListFile, err)
+		}
+	default:
+		return fmt.Errorf("server membership %q is not supported, expected %q, %q or %q", o.ServerMembership, MembershipStatic, MembershipLease, MembershipPeerList)
+	}
+	if o.ServerMembership != MembershipStatic && o.ServerMembershipInterval <= 0 {
+		return fmt.Errorf("server membership interval must be positive, got %v", o.ServerMembershipInterval)
+	}
+	if err := o.validatePeers(); err != nil {
+		return err
+	}
+	if o.ReadinessMinAgents < 0 {
+		return fmt.Errorf("readiness min agents must not be negative, got %d", o.ReadinessMinAgents)
+	}
+	if _, err := o.ReadinessPolicy(); err != nil {
+		return err
+	}
+	if o.ReadinessDialWindow < 0 {
+		return fmt.Errorf("readiness dial window must not be negative, got %v", o.ReadinessDialWindow)
+	}
+	if err := o.validateProxyAuth(); err != nil {
+		return err
+	}
+	if err := o.validateFrontends(); err != nil {
+		return err
+	}
+	if o.HTTPConnectHTTP2 && !o.frontendModes()["http-connect"] {
+		return fmt.Errorf("HTTP/2 CONNECT is only supported in the http-connect mode, not %q", o.Mode)
+	}
+	if o.HTTPConnectDialTimeout < 0 {
+		return fmt.Errorf("http-connect dial timeout must not be negative, got %v", o.HTTPConnectDialTimeout)
+	}
+	if o.AgentPingInterval < 0 {
+		return fmt.Errorf("agent ping interval must not be negative, got %v", o.AgentPingInterval)
+	}
+	if o.AgentPingInterval > 0 && o.AgentPingTimeout <= 0 {
+		return fmt.Errorf("agent ping timeout must be positive, got %v", o.AgentPingTimeout)
+	}
+	if o.AgentSendQueueSize <= 0 {
+		return fmt.Errorf("agent send queue size must be positive, got %d", o.AgentSendQueueSize)
+	}
+	if o.AgentSendTimeout < 0 {
+		return fmt.Errorf("agent send timeout must not be negative, got %v", o.AgentSendTimeout)
+	}
+	if o.ConnectionIdleTimeout < 0 {
+		return fmt.Errorf("connection idle timeout must not be negative, got %v", o.ConnectionIdleTimeout)
+	}
+	if o.ConnectionMaxLifetime < 0 {
+		return fmt.Errorf("connection max lifetime must not be negative, got %v", o.ConnectionMaxLifetime)
+	}
+
+	return nil
+}
+
+// ReadinessPolicy returns the readiness policy of the server.
+func (o *ProxyRunOptions) ReadinessPolicy() (server.ReadinessPolicy, error) {
+	policy := server.ReadinessPolicy{
+		MinAgents:  o.ReadinessMinAgents,
+		DialWindow: o.ReadinessDialWindow,
+	}
+	if o.ReadinessStrategies == "" {
+		return policy, nil
+	}
+	strategies, err := server.GenProxyStrategiesFromStr(o.ReadinessStrategies)
+	if err != nil {
+		return policy, fmt.Errorf("invalid readiness strategies: %v", err)
+	}
+	for _, ps := range strategies {
+		if !strings.Contains(","+o.ProxyStrategies+",", ","+string(ps)+",") {
+			return policy, fmt.Errorf("readiness strategy %q is not one of the proxy strategies %q", ps, o.ProxyStrategies)
+		}
+	}
+	policy.Strategies = strategies
+	return policy, nil
+}
+
+// ProxyAuthEnabled returns whether HTTP CONNECT requests are authenticated
+// by their Proxy-Authorization header.
+func (o *ProxyRunOptions) ProxyAuthEnabled() bool {
+	return o.ProxyAuthHtpasswdFile != "" || o.ProxyAuthTokenFile != "" || o.ProxyAuthTokenReview
+}
+
+func (o *ProxyRunOptions) validateProxyAuth() error {
+	if !o.ProxyAuthEnabled() {
+		if o.ProxyAuthTokenAudiences != "" {
+			return fmt.Errorf("proxy auth token audiences require --proxy-auth-token-review")
+		}
+		return nil
+	}
+	modes := o.frontendModes()
+	if !modes["http-connect"] && !modes["socks5"] {
+		return fmt.Errorf("proxy authorization is only supported in the http-connect and socks5 modes, not %q", o.Mode)
+	}
+	if modes["socks5"] && o.ProxyAuthHtpasswdFile == "" {
+		return fmt.Errorf("proxy authorization in the socks5 mode requires --proxy-auth-htpasswd-file, as it only authenticates usernames and passwords")
+	}
+	if o.ProxyAuthHtpasswdFile != "" {
+		if _, err := os.Stat(o.ProxyAuthHtpasswdFile); os.IsNotExist(err) {
+			return fmt.Errorf("error checking proxy auth htpasswd file %s, got %v", o.ProxyAuthHtpasswdFile, err)
+		}
+	}
+	if o.ProxyAuthTokenFile != "" {
+		if _, err := os.Stat(o.ProxyAuthTokenFile); os.IsNotExist(err) {
+			return fmt.Errorf("error checking proxy auth token file %s, got %v", o.ProxyAuthTokenFile, err)
+		}
+	}
+	if o.ProxyAuthTokenAudiences != "" && !o.ProxyAuthTokenReview {
+		return fmt.Errorf("proxy auth token audiences require --proxy-auth-token-review")
+	}
+	if o.ProxyAuthRealm == "" {
+		return fmt.Errorf("proxy auth realm cannot be empty")
+	}
+	return nil
+}
+
+func (o *ProxyRunOptions) validatePeers() error {
+	if o.PeerPort == 0 && o.PeerAddresses == "" {
+		return nil
+	}
+	if o.PeerPort > 49151 {
+		return fmt.Errorf("please do not try to use ephemeral port %d for the peer port", o.PeerPort)
+	}
+	if o.PeerPort != 0 && o.PeerPort < 1024 {
+		return fmt.Errorf("please do not try to use reserved port %d for the peer port", o.PeerPort)
+	}
+	for _, address := range strings.Split(o.PeerAddresses, ",") {
+		if address == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return fmt.Errorf("invalid peer address %q: %v", address, err)
+		}
+	}
+	for _, f := range []struct{ name, path string }{
+		{"cert", o.PeerCert},
+		{"key", o.PeerKey},
+		{"CA cert", o.PeerCaCert},
+	} {
+		if f.path == "" {
+			return fmt.Errorf("peer %s is required for mutual TLS between peer proxy servers", f.name)
+		}
+		if _, err := os.Stat(f.path); os.IsNotExist(err) {
+			return fmt.Errorf("error checking peer %s %s, got %v", f.name, f.path, err)
+		}
+	}
+	if o.PeerSyncInterval <= 0 {
+		return fmt.Errorf("peer sync interval must be positive, got %v", o.PeerSyncInterval)
+	}
 	return nil
 }
 
@@ -353,6 +752,43 @@ func NewProxyRunOptions() *ProxyRunOptions {
 		AuthenticationAudience:    "",
 		ProxyStrategies:           "default",
 		CipherSuites:              "",
+		AuditLogPath:              "",
+		AuditLogMaxSize:           100,
+		AuditLogMaxBackups:        5,
+		AuditLogDropWhenFull:      false,
+		AgentMetricsLimit:         0,
+		TracingExporter:           "",
+		TLSReloadInterval:         1 * time.Minute,
+		ServerMembership:          MembershipStatic,
+		ServerLeaseNamespace:      "kube-system",
+		ServerLeaseDuration:       30 * time.Second,
+		ServerPeerListFile:        "",
+		ServerMembershipInterval:  10 * time.Second,
+		PeerPort:                  0,
+		PeerBindAddress:           "",
+		PeerAddresses:             "",
+		PeerCert:                  "",
+		PeerKey:                   "",
+		PeerCaCert:                "",
+		PeerSyncInterval:          10 * time.Second,
+		ReadinessMinAgents:        1,
+		ReadinessStrategies:       "",
+		ReadinessDialWindow:       0,
+		ProxyAuthHtpasswdFile:     "",
+		ProxyAuthTokenFile:        "",
+		ProxyAuthTokenReview:      false,
+		ProxyAuthTokenAudiences:   "",
+		ProxyAuthRealm:            "konnectivity",
+		HTTPConnectHTTP2:          false,
+		HTTPConnectDialTimeout:    30 * time.Second,
+		AgentPingInterval:         0,
+		AgentPingTimeout:          30 * time.Second,
+		AgentSendQueueSize:        server.DefaultAgentSendQueueSize,
+		AgentSendTimeout:          server.DefaultAgentSendTimeout,
+		ConnectionIdleTimeout:     0,
+		ConnectionMaxLifetime:     0,
+		ConfigFile:                "",
+		PrintConfig:               false,
 	}
 	return &o
 }
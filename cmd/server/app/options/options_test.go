@@ -60,6 +60,43 @@ func TestDefaultServerOptions(t *testing.T) {
 	assertDefaultValue(t, "AuthenticationAudience", defaultServerOptions.AuthenticationAudience, "")
 	assertDefaultValue(t, "ProxyStrategies", defaultServerOptions.ProxyStrategies, "default")
 	assertDefaultValue(t, "CipherSuites", defaultServerOptions.CipherSuites, "")
+	assertDefaultValue(t, "AuditLogPath", defaultServerOptions.AuditLogPath, "")
+	assertDefaultValue(t, "AuditLogMaxSize", defaultServerOptions.AuditLogMaxSize, 100)
+	assertDefaultValue(t, "AuditLogMaxBackups", defaultServerOptions.AuditLogMaxBackups, 5)
+	assertDefaultValue(t, "AuditLogDropWhenFull", defaultServerOptions.AuditLogDropWhenFull, false)
+	assertDefaultValue(t, "AgentMetricsLimit", defaultServerOptions.AgentMetricsLimit, 0)
+	assertDefaultValue(t, "TracingExporter", defaultServerOptions.TracingExporter, "")
+	assertDefaultValue(t, "TLSReloadInterval", defaultServerOptions.TLSReloadInterval, 1*time.Minute)
+	assertDefaultValue(t, "ServerMembership", defaultServerOptions.ServerMembership, "static")
+	assertDefaultValue(t, "ServerLeaseNamespace", defaultServerOptions.ServerLeaseNamespace, "kube-system")
+	assertDefaultValue(t, "ServerLeaseDuration", defaultServerOptions.ServerLeaseDuration, 30*time.Second)
+	assertDefaultValue(t, "ServerPeerListFile", defaultServerOptions.ServerPeerListFile, "")
+	assertDefaultValue(t, "ServerMembershipInterval", defaultServerOptions.ServerMembershipInterval, 10*time.Second)
+	assertDefaultValue(t, "PeerPort", defaultServerOptions.PeerPort, 0)
+	assertDefaultValue(t, "PeerBindAddress", defaultServerOptions.PeerBindAddress, "")
+	assertDefaultValue(t, "PeerAddresses", defaultServerOptions.PeerAddresses, "")
+	assertDefaultValue(t, "PeerCert", defaultServerOptions.PeerCert, "")
+	assertDefaultValue(t, "PeerKey", defaultServerOptions.PeerKey, "")
+	assertDefaultValue(t, "PeerCaCert", defaultServerOptions.PeerCaCert, "")
+	assertDefaultValue(t, "PeerSyncInterval", defaultServerOptions.PeerSyncInterval, 10*time.Second)
+	assertDefaultValue(t, "ReadinessMinAgents", defaultServerOptions.ReadinessMinAgents, 1)
+	assertDefaultValue(t, "ReadinessStrategies", defaultServerOptions.ReadinessStrategies, "")
+	assertDefaultValue(t, "ReadinessDialWindow", defaultServerOptions.ReadinessDialWindow, time.Duration(0))
+	assertDefaultValue(t, "ProxyAuthHtpasswdFile", defaultServerOptions.ProxyAuthHtpasswdFile, "")
+	assertDefaultValue(t, "ProxyAuthTokenFile", defaultServerOptions.ProxyAuthTokenFile, "")
+	assertDefaultValue(t, "ProxyAuthTokenReview", defaultServerOptions.ProxyAuthTokenReview, false)
+	assertDefaultValue(t, "ProxyAuthTokenAudiences", defaultServerOptions.ProxyAuthTokenAudiences, "")
+	assertDefaultValue(t, "ProxyAuthRealm", defaultServerOptions.ProxyAuthRealm, "konnectivity")
+	assertDefaultValue(t, "HTTPConnectHTTP2", defaultServerOptions.HTTPConnectHTTP2, false)
+	assertDefaultValue(t, "HTTPConnectDialTimeout", defaultServerOptions.HTTPConnectDialTimeout, 30*time.Second)
+	assertDefaultValue(t, "AgentPingInterval", defaultServerOptions.AgentPingInterval, time.Duration(0))
+	assertDefaultValue(t, "AgentPingTimeout", defaultServerOptions.AgentPingTimeout, 30*time.Second)
+	assertDefaultValue(t, "AgentSendQueueSize", defaultServerOptions.AgentSendQueueSize, 128)
+	assertDefaultValue(t, "AgentSendTimeout", defaultServerOptions.AgentSendTimeout, 5*time.Second)
+	assertDefaultValue(t, "ConnectionIdleTimeout", defaultServerOptions.ConnectionIdleTimeout, time.Duration(0))
+	assertDefaultValue(t, "ConnectionMaxLifetime", defaultServerOptions.ConnectionMaxLifetime, time.Duration(0))
+	assertDefaultValue(t, "ConfigFile", defaultServerOptions.ConfigFile, "")
+	assertDefaultValue(t, "PrintConfig", defaultServerOptions.PrintConfig, false)
 }
 
 func assertDefaultValue(t *testing.T, fieldName string, actual, expected interface{}) {
@@ -139,6 +176,96 @@ func TestValidate(t *testing.T) {
 			value:    49152,
 			expected: fmt.Errorf("please do not try to use ephemeral port 49152 for the health port"),
 		},
+		"NegativeAuditLogMaxSize": {
+			field:    "AuditLogMaxSize",
+			value:    -1,
+			expected: fmt.Errorf("audit log max size must not be negative, got -1"),
+		},
+		"UnknownTracingExporter": {
+			field:    "TracingExporter",
+			value:    "otlp",
+			expected: fmt.Errorf("tracing exporter \"otlp\" is not supported, expected \"log\" or empty"),
+		},
+		"LeaseServerMembership": {
+			field:    "ServerMembership",
+			value:    "lease",
+			expected: nil,
+		},
+		"UnknownServerMembership": {
+			field:    "ServerMembership",
+			value:    "dns",
+			expected: fmt.Errorf("server membership \"dns\" is not supported, expected \"static\", \"lease\" or \"peer-list\""),
+		},
+		"PeerListServerMembershipWithoutFile": {
+			field:    "ServerMembership",
+			value:    "peer-list",
+			expected: fmt.Errorf("server peer list file cannot be empty with the \"peer-list\" membership"),
+		},
+		"PeerPortWithoutCert": {
+			field:    "PeerPort",
+			value:    8093,
+			expected: fmt.Errorf("peer cert is required for mutual TLS between peer proxy servers"),
+		},
+		"EphemeralPeerPort": {
+			field:    "PeerPort",
+			value:    49152,
+			expected: fmt.Errorf("please do not try to use ephemeral port 49152 for the peer port"),
+		},
+		"ProxyAuthInGRPCMode": {
+			field:    "ProxyAuthHtpasswdFile",
+			value:    "/tmp/missing.htpasswd",
+			expected: fmt.Errorf("proxy authorization is only supported in the http-connect and socks5 modes, not \"grpc\""),
+		},
+		"ZeroAgentSendQueueSize": {
+			field:    "AgentSendQueueSize",
+			value:    0,
+			expected: fmt.Errorf("agent send queue size must be positive, got 0"),
+		},
+		"DestHostIdentifierTypesWithoutDestHost": {
+			field:    "DestHostIdentifierTypes",
+			value:    []string{"host"},
+			expected: fmt.Errorf("destHost identifier types are set, but destHost is not one of the proxy strategies \"default\""),
+		},
+		"NegativeAgentSendTimeout": {
+			field:    "AgentSendTimeout",
+			value:    -time.Second,
+			expected: fmt.Errorf("agent send timeout must not be negative, got -1s"),
+		},
+		"NegativeConnectionIdleTimeout": {
+			field:    "ConnectionIdleTimeout",
+			value:    -time.Second,
+			expected: fmt.Errorf("connection idle timeout must not be negative, got -1s"),
+		},
+		"NegativeConnectionMaxLifetime": {
+			field:    "ConnectionMaxLifetime",
+			value:    -time.Minute,
+			expected: fmt.Errorf("connection max lifetime must not be negative, got -1m0s"),
+		},
+		"UnknownMode": {
+			field:    "Mode",
+			value:    "socks4",
+			expected: fmt.Errorf("mode must be set to one of 'grpc', 'http-connect' or 'socks5' not \"socks4\""),
+		},
+		"ProxyAuthTokenAudiencesWithoutReview": {
+			field:    "ProxyAuthTokenAudiences",
+			value:    "konnectivity",
+			expected: fmt.Errorf("proxy auth token audiences require --proxy-auth-token-review"),
+		},
+		"NegativeReadinessMinAgents": {
+			field:    "ReadinessMinAgents",
+			value:    -1,
+			expected: fmt.Errorf("readiness min agents must not be negative, got -1"),
+		},
+		"UnusedReadinessStrategy": {
+			field:    "ReadinessStrategies",
+			value:    "defaultRoute",
+			expected: fmt.Errorf("readiness strategy \"defaultRoute\" is not one of the proxy strategies \"default\""),
+		},
+		"InvalidPeerAddress": {
+			field:    "PeerAddresses",
+			value:    "konnectivity-server",
+			expected: fmt.Errorf("invalid peer address \"konnectivity-server\": address konnectivity-server: missing port in address"),
+		},
 	} {
 		t.Run(desc, func(t *testing.T) {
 			testServerOptions := NewProxyRunOptions()
@@ -153,6 +280,10 @@ func TestValidate(t *testing.T) {
 				case reflect.Int:
 					ivalue := tc.value.(int)
 					fv.SetInt(int64(ivalue))
+				case reflect.Int64:
+					fv.SetInt(reflect.ValueOf(tc.value).Int())
+				case reflect.Slice:
+					fv.Set(reflect.ValueOf(tc.value))
 				}
 			}
 			actual := testServerOptions.Validate()
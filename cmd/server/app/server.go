@@ -19,15 +19,15 @@ package app
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net"
 	"net/http"
 	netpprof "net/http/pprof"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"runtime"
 	runpprof "runtime/pprof"
 	"strconv"
@@ -38,6 +38,8 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/keepalive"
@@ -46,10 +48,18 @@ import (
 	"k8s.io/klog/v2"
 
 	"sigs.k8s.io/apiserver-network-proxy/cmd/server/app/options"
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/pkg/common/tracing"
 	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
+	pkgagent "sigs.k8s.io/apiserver-network-proxy/pkg/agent"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/config"
 	"sigs.k8s.io/apiserver-network-proxy/pkg/server"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server/audit"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server/membership"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server/metrics"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server/proxyauth"
 	"sigs.k8s.io/apiserver-network-proxy/pkg/util"
 	"sigs.k8s.io/apiserver-network-proxy/proto/agent"
+	"sigs.k8s.io/apiserver-network-proxy/proto/peer"
 )
 
 var udsListenerLock sync.Mutex
@@ -61,6 +71,12 @@ func NewProxyCommand(p *Proxy, o *options.ProxyRunOptions) *cobra.Command {
 		Use:  "proxy",
 		Long: `A gRPC proxy server, receives requests from the API server and forwards to the agent.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := o.LoadConfigFile(cmd.Flags()); err != nil {
+				return fmt.Errorf("failed to load server configuration: %v", err)
+			}
+			if o.PrintConfig {
+				return printConfig(cmd.OutOrStdout(), o.EffectiveConfig())
+			}
 			return p.run(o)
 		},
 	}
@@ -68,6 +84,16 @@ func NewProxyCommand(p *Proxy, o *options.ProxyRunOptions) *cobra.Command {
 	return cmd
 }
 
+// printConfig writes cfg to w as YAML.
+func printConfig(w io.Writer, cfg *options.ServerConfiguration) error {
+	data, err := config.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to encode server configuration: %v", err)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
 func tlsCipherSuites(cipherNames []string) []uint16 {
 	// return nil, so use default cipher list
 	if len(cipherNames) == 0 {
@@ -83,6 +109,8 @@ func tlsCipherSuites(cipherNames []string) []uint16 {
 }
 
 type Proxy struct {
+	// proxyAuth, if set, authenticates the HTTP CONNECT requests.
+	proxyAuth *proxyauth.Chain
 }
 
 type StopFunc func()
@@ -96,7 +124,7 @@ func (p *Proxy) run(o *options.ProxyRunOptions) error {
 	defer cancel()
 
 	var k8sClient *kubernetes.Clientset
-	if o.AgentNamespace != "" {
+	if o.AgentNamespace != "" || o.ServerMembership == options.MembershipLease || o.ProxyAuthTokenReview {
 		config, err := clientcmd.BuildConfigFromFlags("", o.KubeconfigPath)
 		if err != nil {
 			return fmt.Errorf("failed to load kubernetes client config: %v", err)
@@ -128,7 +156,57 @@ func (p *Proxy) run(o *options.ProxyRunOptions) error {
 	if err != nil {
 		return err
 	}
+	metrics.Metrics.SetAgentLabelLimit(o.AgentMetricsLimit)
 	server := server.NewProxyServer(o.ServerID, ps, int(o.ServerCount), authOpt)
+	readinessPolicy, err := o.ReadinessPolicy()
+	if err != nil {
+		return err
+	}
+	if err := server.SetReadinessPolicy(readinessPolicy); err != nil {
+		return err
+	}
+	if len(o.DestHostIdentifierTypes) > 0 {
+		var idTypes []pkgagent.IdentifierType
+		for _, idType := range o.DestHostIdentifierTypes {
+			idTypes = append(idTypes, pkgagent.IdentifierType(idType))
+		}
+		if err := server.SetDestHostIdentifierTypes(idTypes); err != nil {
+			return err
+		}
+	}
+	server.AgentPingInterval = o.AgentPingInterval
+	server.AgentPingTimeout = o.AgentPingTimeout
+	server.AgentSendQueueSize = o.AgentSendQueueSize
+	server.AgentSendTimeout = o.AgentSendTimeout
+	server.ConnectionIdleTimeout = o.ConnectionIdleTimeout
+	server.ConnectionMaxLifetime = o.ConnectionMaxLifetime
+	if o.AuditLogPath != "" {
+		fileSink, err := audit.NewFileSink(o.AuditLogPath, int64(o.AuditLogMaxSize)<<20, o.AuditLogMaxBackups)
+		if err != nil {
+			return fmt.Errorf("failed to open the audit log: %v", err)
+		}
+		// Write the records off the packet routing goroutines.
+		sink := audit.NewAsyncSink(fileSink, audit.DefaultBufferSize, o.AuditLogDropWhenFull)
+		defer sink.Close()
+		server.AuditSink = sink
+	}
+	if o.TracingExporter == "log" {
+		server.Tracer = tracing.NewTracer("proxy-server", tracing.LogExporter{})
+	}
+	if o.ProxyAuthEnabled() {
+		p.proxyAuth, err = newProxyAuth(o, k8sClient)
+		if err != nil {
+			return fmt.Errorf("failed to set up proxy authorization: %v", err)
+		}
+	}
+	membershipDone, err := runMembership(ctx, o, server, k8sClient)
+	if err != nil {
+		return fmt.Errorf("failed to run the server membership: %v", err)
+	}
+
+	if err := p.runPeers(ctx, o, server); err != nil {
+		return fmt.Errorf("failed to run the peer server: %v", err)
+	}
 
 	frontendStop, err := p.runFrontendServer(ctx, o, server)
 	if err != nil {
@@ -136,7 +214,7 @@ func (p *Proxy) run(o *options.ProxyRunOptions) error {
 	}
 
 	klog.V(1).Infoln("Starting agent server for tunnel connections.")
-	err = p.runAgentServer(o, server)
+	err = p.runAgentServer(ctx, o, server)
 	if err != nil {
 		return fmt.Errorf("failed to run the agent server: %v", err)
 	}
@@ -158,10 +236,42 @@ func (p *Proxy) run(o *options.ProxyRunOptions) error {
 	if frontendStop != nil {
 		frontendStop()
 	}
+	// Give the membership the chance to withdraw this server.
+	cancel()
+	<-membershipDone
 
 	return nil
 }
 
+// runMembership starts tracking the live proxy servers, as configured by
+// o.ServerMembership. The returned channel is closed once the tracking
+// stopped, after ctx is done.
+func runMembership(ctx context.Context, o *options.ProxyRunOptions, s *server.ProxyServer, k8sClient kubernetes.Interface) (<-chan struct{}, error) {
+	done := make(chan struct{})
+	switch o.ServerMembership {
+	case options.MembershipLease:
+		m := membership.NewLeases(k8sClient, o.ServerLeaseNamespace, o.ServerID, o.ServerLeaseDuration)
+		s.SetMembership(m)
+		go func() {
+			defer close(done)
+			m.Run(o.ServerMembershipInterval, ctx.Done())
+		}()
+	case options.MembershipPeerList:
+		m, err := membership.NewPeerList(o.ServerPeerListFile, o.ServerID)
+		if err != nil {
+			return nil, err
+		}
+		s.SetMembership(m)
+		go func() {
+			defer close(done)
+			m.Run(o.ServerMembershipInterval, ctx.Done())
+		}()
+	default:
+		close(done)
+	}
+	return done, nil
+}
+
 var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
 
 func SetupSignalHandler() (stopCh <-chan struct{}) {
@@ -196,43 +306,107 @@ func getUDSListener(ctx context.Context, udsName string) (net.Listener, error) {
 	return lis, nil
 }
 
+// newProxyAuth returns the authenticators of the Proxy-Authorization
+// credentials enabled by o.
+func newProxyAuth(o *options.ProxyRunOptions, k8sClient kubernetes.Interface) (*proxyauth.Chain, error) {
+	chain := &proxyauth.Chain{Realm: o.ProxyAuthRealm}
+	if o.ProxyAuthHtpasswdFile != "" {
+		h, err := proxyauth.NewHtpasswd(o.ProxyAuthHtpasswdFile)
+		if err != nil {
+			return nil, err
+		}
+		chain.Authenticators = append(chain.Authenticators, h)
+	}
+	if o.ProxyAuthTokenFile != "" {
+		t, err := proxyauth.NewStaticTokens(o.ProxyAuthTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		chain.Authenticators = append(chain.Authenticators, t)
+	}
+	if o.ProxyAuthTokenReview {
+		var audiences []string
+		if o.ProxyAuthTokenAudiences != "" {
+			audiences = strings.Split(o.ProxyAuthTokenAudiences, ",")
+		}
+		chain.Authenticators = append(chain.Authenticators, proxyauth.NewTokenReview(k8sClient, audiences))
+	}
+	return chain, nil
+}
+
 func (p *Proxy) runFrontendServer(ctx context.Context, o *options.ProxyRunOptions, server *server.ProxyServer) (StopFunc, error) {
-	if o.UdsName != "" {
-		return p.runUDSFrontendServer(ctx, o, server)
+	listeners, err := o.FrontendListeners()
+	if err != nil {
+		return nil, err
 	}
-	return p.runMTLSFrontendServer(ctx, o, server)
+	var stops []StopFunc
+	stopAll := func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}
+	for _, l := range listeners {
+		klog.V(1).InfoS("Starting frontend server for client connections.", "listener", l.Name, "mode", l.Mode, "udsName", l.UDSName, "address", l.Address)
+		var stop StopFunc
+		if l.UDSName != "" {
+			stop, err = p.runUDSFrontendServer(ctx, o, l, server)
+		} else {
+			stop, err = p.runMTLSFrontendServer(ctx, o, l, server)
+		}
+		if err != nil {
+			stopAll()
+			return nil, fmt.Errorf("frontend %q: %v", l.Name, err)
+		}
+		stops = append(stops, stop)
+	}
+	return stopAll, nil
 }
 
-func (p *Proxy) runUDSFrontendServer(ctx context.Context, o *options.ProxyRunOptions, s *server.ProxyServer) (StopFunc, error) {
+func (p *Proxy) runUDSFrontendServer(ctx context.Context, o *options.ProxyRunOptions, l options.FrontendListener, s *server.ProxyServer) (StopFunc, error) {
 	if o.DeleteUDSFile {
-		if err := os.Remove(o.UdsName); err != nil && !os.IsNotExist(err) {
-			klog.ErrorS(err, "failed to delete file", "file", o.UdsName)
+		if err := os.Remove(l.UDSName); err != nil && !os.IsNotExist(err) {
+			klog.ErrorS(err, "failed to delete file", "file", l.UDSName)
 		}
 	}
+	lis, err := getUDSListener(ctx, l.UDSName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get uds listener: %v", err)
+	}
+	lis = metrics.InstrumentFrontendListener(lis, l.Name, l.Mode)
 	var stop StopFunc
-	if o.Mode == "grpc" {
+	if l.Mode == "grpc" {
 		frontendServerOptions := []grpc.ServerOption{
 			grpc.KeepaliveParams(keepalive.ServerParameters{Time: o.FrontendKeepaliveTime}),
 		}
 		grpcServer := grpc.NewServer(frontendServerOptions...)
 		client.RegisterProxyServiceServer(grpcServer, s)
-		lis, err := getUDSListener(ctx, o.UdsName)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get uds listener: %v", err)
-		}
 		labels := runpprof.Labels(
 			"core", "udsGrpcFrontend",
-			"udsFile", o.UdsName,
+			"udsFile", l.UDSName,
 		)
 		go runpprof.Do(context.Background(), labels, func(context.Context) { grpcServer.Serve(lis) })
 		stop = grpcServer.GracefulStop
+	} else if l.Mode == "socks5" {
+		labels := runpprof.Labels(
+			"core", "udsSocks5Frontend",
+			"udsFile", l.UDSName,
+		)
+		go runpprof.Do(context.Background(), labels, func(context.Context) { p.serveSOCKS5(o, s, lis) })
+		stop = func() { lis.Close() }
 	} else {
 		// http-connect
+		var handler http.Handler = &server.Tunnel{
+			Server:      s,
+			ProxyAuth:   p.proxyAuth,
+			DialTimeout: o.HTTPConnectDialTimeout,
+		}
+		if o.HTTPConnectHTTP2 {
+			// Without TLS, HTTP/2 clients connect with prior knowledge.
+			handler = h2c.NewHandler(handler, &http2.Server{})
+		}
 		server := &http.Server{
 			ReadHeaderTimeout: ReadHeaderTimeout,
-			Handler: &server.Tunnel{
-				Server: s,
-			},
+			Handler:           handler,
 		}
 		stop = func() {
 			err := server.Shutdown(ctx)
@@ -240,18 +414,10 @@ func (p *Proxy) runUDSFrontendServer(ctx context.Context, o *options.ProxyRunOpt
 		}
 		labels := runpprof.Labels(
 			"core", "udsHttpFrontend",
-			"udsFile", o.UdsName,
+			"udsFile", l.UDSName,
 		)
 		go runpprof.Do(context.Background(), labels, func(context.Context) {
-			udsListener, err := getUDSListener(ctx, o.UdsName)
-			if err != nil {
-				klog.ErrorS(err, "failed to get uds listener")
-			}
-			defer func() {
-				err := udsListener.Close()
-				klog.ErrorS(err, "failed to close uds listener")
-			}()
-			err = server.Serve(udsListener)
+			err := server.Serve(lis)
 			if err != nil {
 				klog.ErrorS(err, "failed to serve uds requests")
 			}
@@ -261,77 +427,99 @@ func (p *Proxy) runUDSFrontendServer(ctx context.Context, o *options.ProxyRunOpt
 	return stop, nil
 }
 
-func (p *Proxy) getTLSConfig(caFile, certFile, keyFile, cipherSuites string) (*tls.Config, error) {
-	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+// getTLSConfig returns the TLS config of listener, serving the current
+// contents of the certificate, key and CA files. Unless disabled, the files
+// are checked for changes every o.TLSReloadInterval until ctx is done.
+func (p *Proxy) getTLSConfig(ctx context.Context, o *options.ProxyRunOptions, listener, caFile, certFile, keyFile string, nextProtos ...string) (*tls.Config, error) {
+	cipherSuiteIDs := tlsCipherSuites(strings.Split(o.CipherSuites, ","))
+	reloader, err := util.NewServerTLSReloader(caFile, certFile, keyFile, cipherSuiteIDs, func(err error) {
+		metrics.Metrics.ObserveTLSReload(listener, err)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to load X509 key pair %s and %s: %v", certFile, keyFile, err)
+		return nil, err
 	}
-
-	cipherSuiteIDs := tlsCipherSuites(strings.Split(cipherSuites, ","))
-
-	if caFile == "" {
-		return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12, CipherSuites: cipherSuiteIDs}, nil
+	if o.TLSReloadInterval > 0 {
+		labels := runpprof.Labels(
+			"core", "tlsReloader",
+			"listener", listener,
+		)
+		go runpprof.Do(context.Background(), labels, func(context.Context) { reloader.Run(o.TLSReloadInterval, ctx.Done()) })
 	}
+	return reloader.TLSConfig(nextProtos...), nil
+}
 
-	certPool := x509.NewCertPool()
-	caCert, err := ioutil.ReadFile(filepath.Clean(caFile))
-	if err != nil {
-		return nil, fmt.Errorf("failed to read cluster CA cert %s: %v", caFile, err)
-	}
-	ok := certPool.AppendCertsFromPEM(caCert)
-	if !ok {
-		return nil, fmt.Errorf("failed to append cluster CA cert to the cert pool")
+// serveSOCKS5 serves the socks5 frontend on lis, until it is closed.
+func (p *Proxy) serveSOCKS5(o *options.ProxyRunOptions, s *server.ProxyServer, lis net.Listener) {
+	socks5 := &server.SOCKS5{
+		Server:      s,
+		Auth:        p.proxyAuth,
+		DialTimeout: o.HTTPConnectDialTimeout,
 	}
-
-	tlsConfig := &tls.Config{
-		ClientAuth:   tls.RequireAndVerifyClientCert,
-		Certificates: []tls.Certificate{cert},
-		ClientCAs:    certPool,
-		MinVersion:   tls.VersionTLS12,
-		CipherSuites: cipherSuiteIDs,
+	if err := socks5.Serve(lis); err != nil && !errors.Is(err, net.ErrClosed) {
+		klog.ErrorS(err, "failed to serve socks5 connections")
 	}
-
-	return tlsConfig, nil
 }
 
-func (p *Proxy) runMTLSFrontendServer(ctx context.Context, o *options.ProxyRunOptions, s *server.ProxyServer) (StopFunc, error) {
+func (p *Proxy) runMTLSFrontendServer(ctx context.Context, o *options.ProxyRunOptions, l options.FrontendListener, s *server.ProxyServer) (StopFunc, error) {
 	var stop StopFunc
 
-	var tlsConfig *tls.Config
-	var err error
-	if tlsConfig, err = p.getTLSConfig(o.ServerCaCert, o.ServerCert, o.ServerKey, o.CipherSuites); err != nil {
+	var nextProtos []string
+	switch {
+	case l.Mode == "grpc":
+		nextProtos = []string{"h2"}
+	case l.Mode == "http-connect" && o.HTTPConnectHTTP2:
+		nextProtos = []string{"h2", "http/1.1"}
+	}
+	tlsConfig, err := p.getTLSConfig(ctx, o, l.Name, l.CACert, l.Cert, l.Key, nextProtos...)
+	if err != nil {
 		return nil, err
 	}
+	lis, err := net.Listen("tcp", l.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %v", l.Address, err)
+	}
+	lis = metrics.InstrumentFrontendListener(lis, l.Name, l.Mode)
 
-	addr := net.JoinHostPort(o.ServerBindAddress, strconv.Itoa(o.ServerPort))
-
-	if o.Mode == "grpc" {
+	if l.Mode == "grpc" {
 		frontendServerOptions := []grpc.ServerOption{
 			grpc.Creds(credentials.NewTLS(tlsConfig)),
 			grpc.KeepaliveParams(keepalive.ServerParameters{Time: o.FrontendKeepaliveTime}),
 		}
 		grpcServer := grpc.NewServer(frontendServerOptions...)
 		client.RegisterProxyServiceServer(grpcServer, s)
-		lis, err := net.Listen("tcp", addr)
-		if err != nil {
-			return nil, fmt.Errorf("failed to listen on %s: %v", addr, err)
-		}
 		labels := runpprof.Labels(
 			"core", "mtlsGrpcFrontend",
-			"port", strconv.FormatUint(uint64(o.ServerPort), 10),
+			"address", l.Address,
 		)
 		go runpprof.Do(context.Background(), labels, func(context.Context) { grpcServer.Serve(lis) })
 		stop = grpcServer.GracefulStop
+	} else if l.Mode == "socks5" {
+		lis = tls.NewListener(lis, tlsConfig)
+		labels := runpprof.Labels(
+			"core", "mtlsSocks5Frontend",
+			"address", l.Address,
+		)
+		go runpprof.Do(context.Background(), labels, func(context.Context) { p.serveSOCKS5(o, s, lis) })
+		stop = func() { lis.Close() }
 	} else {
 		// http-connect
 		server := &http.Server{
 			ReadHeaderTimeout: ReadHeaderTimeout,
-			Addr:              addr,
 			TLSConfig:         tlsConfig,
 			Handler: &server.Tunnel{
-				Server: s,
+				Server:      s,
+				ProxyAuth:   p.proxyAuth,
+				DialTimeout: o.HTTPConnectDialTimeout,
 			},
-			TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler)),
+		}
+		if !o.HTTPConnectHTTP2 {
+			// Tunnels over HTTP/1 hijack their connection.
+			server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		} else if err := http2.ConfigureServer(server, &http2.Server{}); err != nil {
+			// The HTTP/2 server of x/net accepts extended CONNECT, unlike
+			// the one of net/http.
+			lis.Close()
+			return nil, fmt.Errorf("failed to configure HTTP/2 on %s: %v", l.Address, err)
 		}
 		stop = func() {
 			err := server.Shutdown(ctx)
@@ -341,10 +529,10 @@ func (p *Proxy) runMTLSFrontendServer(ctx context.Context, o *options.ProxyRunOp
 		}
 		labels := runpprof.Labels(
 			"core", "mtlsHttpFrontend",
-			"port", strconv.FormatUint(uint64(o.ServerPort), 10),
+			"address", l.Address,
 		)
 		go runpprof.Do(context.Background(), labels, func(context.Context) {
-			err := server.ListenAndServeTLS("", "") // empty files defaults to tlsConfig
+			err := server.ServeTLS(lis, "", "") // empty files defaults to tlsConfig
 			if err != nil {
 				klog.ErrorS(err, "failed to listen on frontend port")
 			}
@@ -354,10 +542,9 @@ func (p *Proxy) runMTLSFrontendServer(ctx context.Context, o *options.ProxyRunOp
 	return stop, nil
 }
 
-func (p *Proxy) runAgentServer(o *options.ProxyRunOptions, server *server.ProxyServer) error {
-	var tlsConfig *tls.Config
-	var err error
-	if tlsConfig, err = p.getTLSConfig(o.ClusterCaCert, o.ClusterCert, o.ClusterKey, o.CipherSuites); err != nil {
+func (p *Proxy) runAgentServer(ctx context.Context, o *options.ProxyRunOptions, server *server.ProxyServer) error {
+	tlsConfig, err := p.getTLSConfig(ctx, o, metrics.AgentListener, o.ClusterCaCert, o.ClusterCert, o.ClusterKey, "h2")
+	if err != nil {
 		return err
 	}
 
@@ -385,6 +572,51 @@ func (p *Proxy) runAgentServer(o *options.ProxyRunOptions, server *server.ProxyS
 	return nil
 }
 
+// runPeers serves the peer proxy servers on o.PeerPort, and forwards the
+// dials without a matching local agent to the peers at o.PeerAddresses,
+// unless disabled.
+func (p *Proxy) runPeers(ctx context.Context, o *options.ProxyRunOptions, s *server.ProxyServer) error {
+	if o.PeerPort != 0 {
+		tlsConfig, err := p.getTLSConfig(ctx, o, metrics.PeerListener, o.PeerCaCert, o.PeerCert, o.PeerKey, "h2")
+		if err != nil {
+			return err
+		}
+		addr := net.JoinHostPort(o.PeerBindAddress, strconv.Itoa(o.PeerPort))
+		grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
+		peer.RegisterPeerServiceServer(grpcServer, s)
+		client.RegisterProxyServiceServer(grpcServer, s)
+		lis, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %v", addr, err)
+		}
+		labels := runpprof.Labels(
+			"core", "peerListener",
+			"port", strconv.FormatUint(uint64(o.PeerPort), 10),
+		)
+		go runpprof.Do(context.Background(), labels, func(context.Context) { grpcServer.Serve(lis) })
+	}
+
+	var addresses []string
+	for _, address := range strings.Split(o.PeerAddresses, ",") {
+		if address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	if len(addresses) == 0 {
+		return nil
+	}
+	tlsConfig, err := util.GetClientTLSConfig(o.PeerCaCert, o.PeerCert, o.PeerKey, "", []string{"h2"})
+	if err != nil {
+		return err
+	}
+	s.Peers = server.NewPeers(o.ServerID, addresses, tlsConfig)
+	labels := runpprof.Labels(
+		"core", "peers",
+	)
+	go runpprof.Do(context.Background(), labels, func(context.Context) { s.Peers.Run(o.PeerSyncInterval, ctx.Done()) })
+	return nil
+}
+
 func (p *Proxy) runAdminServer(o *options.ProxyRunOptions, server *server.ProxyServer) error {
 	muxHandler := http.NewServeMux()
 	muxHandler.Handle("/metrics", promhttp.Handler())
@@ -425,6 +657,20 @@ func (p *Proxy) runHealthServer(o *options.ProxyRunOptions, server *server.Proxy
 		fmt.Fprintf(w, "ok")
 	})
 	readinessHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, verbose := r.URL.Query()["verbose"]; verbose {
+			// Detail the result of every check as JSON.
+			status := server.ReadinessStatus()
+			w.Header().Set("Content-Type", "application/json")
+			if status.Ready {
+				w.WriteHeader(200)
+			} else {
+				w.WriteHeader(500)
+			}
+			if err := json.NewEncoder(w).Encode(status); err != nil {
+				klog.ErrorS(err, "failed to write the readiness status")
+			}
+			return
+		}
 		ready, msg := server.Readiness.Ready()
 		if ready {
 			w.WriteHeader(200)
@@ -32,13 +32,20 @@ import (
 
 	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/pkg/client/metrics"
 	commonmetrics "sigs.k8s.io/apiserver-network-proxy/konnectivity-client/pkg/common/metrics"
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/pkg/common/tracing"
 	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
 )
 
+// dialBackstopTimeout is how long the dials without a deadline wait for the
+// DIAL_RSP.
+const dialBackstopTimeout = 30 * time.Second
+
 // Tunnel provides ability to dial a connection through a tunnel.
 type Tunnel interface {
 	// Dial connects to the address on the named network, similar to
-	// what net.Dial does. The only supported protocol is tcp.
+	// what net.Dial does. The only supported protocol is tcp. The deadline
+	// of requestCtx, if any, is passed on to the agent as the time to
+	// dial for; dials without a deadline time out after 30 seconds.
 	DialContext(requestCtx context.Context, protocol, address string) (net.Conn, error)
 	// Done returns a channel that is closed when the tunnel is no longer serving any connections,
 	// and can no longer be used.
@@ -157,6 +164,22 @@ var (
 	Metrics = metrics.Metrics
 )
 
+// tracer holds the *tracing.Tracer used by all tunnels.
+var tracer atomic.Value
+
+// SetTracer sets the tracer recording the spans of the dials and connections
+// made through any tunnel. Pass nil to stop recording spans. The trace context
+// carried by the DialContext request context is propagated to the proxy server
+// regardless of the tracer.
+func SetTracer(t *tracing.Tracer) {
+	tracer.Store(t)
+}
+
+func getTracer() *tracing.Tracer {
+	t, _ := tracer.Load().(*tracing.Tracer)
+	return t
+}
+
 // CreateSingleUseGrpcTunnel creates a Tunnel to dial to a remote server through a
 // gRPC based proxy service.
 // Currently, a single tunnel supports a single connection, and the tunnel is closed when the connection is terminated
@@ -383,15 +406,32 @@ func (t *grpcTunnel) serve(tunnelCtx context.Context) {
 // Dial connects to the address on the named network, similar to
 // what net.Dial does. The only supported protocol is tcp.
 func (t *grpcTunnel) DialContext(requestCtx context.Context, protocol, address string) (net.Conn, error) {
-	conn, err := t.dialContext(requestCtx, protocol, address)
+	dialCtx, span := getTracer().Start(requestCtx, "konnectivity-client.Dial")
+	span.SetAttribute("address", address)
+	c, err := t.dialContext(dialCtx, protocol, address)
 	if err != nil {
 		_, reason := GetDialFailureReason(err)
 		metrics.Metrics.ObserveDialFailure(reason)
+		span.RecordError(err)
+		span.End()
+		return nil, err
+	}
+	span.End()
+
+	// A single-use tunnel serves a single connection, so the connection
+	// lives as long as the tunnel.
+	if _, connSpan := getTracer().Start(requestCtx, "konnectivity-client.Connection"); connSpan != nil {
+		connSpan.SetAttribute("address", address)
+		connSpan.SetAttribute("connectionID", fmt.Sprint(c.connID))
+		go func() {
+			<-t.done
+			connSpan.End()
+		}()
 	}
-	return conn, err
+	return c, nil
 }
 
-func (t *grpcTunnel) dialContext(requestCtx context.Context, protocol, address string) (net.Conn, error) {
+func (t *grpcTunnel) dialContext(requestCtx context.Context, protocol, address string) (*conn, error) {
 	prevStarted := atomic.SwapUint32(&t.started, 1)
 	if prevStarted != 0 {
 		return nil, &dialFailure{"single-use dialer already dialed", metrics.DialFailureAlreadyStarted}
@@ -431,6 +471,15 @@ func (t *grpcTunnel) dialContext(requestCtx context.Context, protocol, address s
 			},
 		},
 	}
+	tracing.Inject(requestCtx, req.GetDialRequest())
+	// Let the agent dial for as long as the client waits, rather than for
+	// its own default.
+	deadline, hasDeadline := requestCtx.Deadline()
+	if hasDeadline {
+		if timeout := time.Until(deadline).Milliseconds(); timeout > 0 {
+			req.GetDialRequest().TimeoutMillis = timeout
+		}
+	}
 	klog.V(5).InfoS("[tracing] send packet", "type", req.Type)
 
 	err := t.Send(req)
@@ -445,6 +494,14 @@ func (t *grpcTunnel) dialContext(requestCtx context.Context, protocol, address s
 		random: random,
 	}
 
+	// The backstop only applies to the dials without a deadline.
+	var backstop <-chan time.Time
+	if !hasDeadline {
+		timer := time.NewTimer(dialBackstopTimeout)
+		defer timer.Stop()
+		backstop = timer.C
+	}
+
 	select {
 	case res := <-resCh:
 		if res.err != nil {
@@ -454,7 +511,7 @@ func (t *grpcTunnel) dialContext(requestCtx context.Context, protocol, address s
 		c.readCh = make(chan []byte, 10)
 		c.closeCh = make(chan string, 1)
 		t.conns.add(res.connid, c)
-	case <-time.After(30 * time.Second):
+	case <-backstop:
 		klog.V(5).InfoS("Timed out waiting for DialResp", "dialID", random)
 		go func() {
 			defer t.closeTunnel()
@@ -523,6 +580,7 @@ func (t *grpcTunnel) Send(pkt *client.Packet) error {
 
 	const segment = commonmetrics.SegmentFromClient
 	metrics.Metrics.ObservePacket(segment, pkt.Type)
+	metrics.Metrics.ObserveDataBytes(segment, pkt)
 	err := t.stream.Send(pkt)
 	if err != nil && err != io.EOF {
 		metrics.Metrics.ObserveStreamError(segment, err, pkt.Type)
@@ -543,6 +601,7 @@ func (t *grpcTunnel) Recv() (*client.Packet, error) {
 		return nil, err
 	}
 	metrics.Metrics.ObservePacket(segment, pkt.Type)
+	metrics.Metrics.ObserveDataBytes(segment, pkt)
 	return pkt, nil
 }
 
@@ -32,6 +32,7 @@ import (
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/pkg/client/metrics"
 	metricstest "sigs.k8s.io/apiserver-network-proxy/konnectivity-client/pkg/common/metrics/testing"
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/pkg/common/tracing"
 	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
 )
 
@@ -76,6 +77,10 @@ func TestDial(t *testing.T) {
 		t.Errorf("expect packet.address %v; got %v", "127.0.0.1:80", ts.packets[0].GetDialRequest().Address)
 	}
 
+	if timeout := ts.packets[0].GetDialRequest().TimeoutMillis; timeout != 0 {
+		t.Errorf("expect no timeout without a deadline; got %dms", timeout)
+	}
+
 	if err := metricstest.ExpectClientConnections(map[metrics.ClientConnectionStatus]int{
 		metrics.ClientConnectionStatusCreated: 0,
 		metrics.ClientConnectionStatusDialing: 0,
@@ -281,6 +286,89 @@ func TestClose(t *testing.T) {
 	metrics.Metrics.Reset() // For clean shutdown.
 }
 
+func TestDialTracing(t *testing.T) {
+	expectCleanShutdown(t)
+
+	exporter := &tracing.InMemoryExporter{}
+	SetTracer(tracing.NewTracer("konnectivity-client", exporter))
+	defer SetTracer(nil)
+
+	parent := tracing.ParseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", "")
+	ctx := tracing.ContextWithSpanContext(context.Background(), parent)
+	s, ps := pipe()
+	ts := testServer(ps, 100)
+
+	defer ps.Close()
+	defer s.Close()
+
+	tunnel := newUnstartedTunnel(s, s.conn())
+
+	go tunnel.serve(ctx)
+	go ts.serve()
+
+	conn, err := tunnel.DialContext(ctx, "tcp", "127.0.0.1:80")
+	if err != nil {
+		t.Fatalf("expect nil; got %v", err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Error(err)
+	}
+	<-tunnel.Done()
+
+	sc := tracing.ParseTraceparent(ts.packets[0].GetDialRequest().GetTraceparent(), "")
+	if sc.TraceID != parent.TraceID {
+		t.Errorf("expect DIAL_REQ trace ID %v; got %v", parent.TraceID, sc.TraceID)
+	}
+
+	// The connection span ends asynchronously once the tunnel is done.
+	spans := exporter.Spans()
+	for deadline := time.Now().Add(5 * time.Second); len(spans) < 2 && time.Now().Before(deadline); spans = exporter.Spans() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(spans) != 2 {
+		t.Fatalf("expect 2 spans; got %d", len(spans))
+	}
+	if dial := spans[0]; dial.Name != "konnectivity-client.Dial" || dial.Parent != parent.SpanID || dial.SpanContext.SpanID != sc.SpanID {
+		t.Errorf("unexpected dial span %+v, DIAL_REQ span ID %v", dial, sc.SpanID)
+	}
+	if c := spans[1]; c.Name != "konnectivity-client.Connection" || c.Parent != parent.SpanID || c.Attributes["connectionID"] != "100" {
+		t.Errorf("unexpected connection span %+v", c)
+	}
+	metrics.Metrics.Reset() // For clean shutdown.
+}
+
+func TestDialDeadline(t *testing.T) {
+	expectCleanShutdown(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s, ps := pipe()
+	ts := testServer(ps, 100)
+
+	defer ps.Close()
+	defer s.Close()
+
+	tunnel := newUnstartedTunnel(s, s.conn())
+
+	go tunnel.serve(ctx)
+	go ts.serve()
+
+	conn, err := tunnel.DialContext(ctx, "tcp", "127.0.0.1:80")
+	if err != nil {
+		t.Fatalf("expect nil; got %v", err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Error(err)
+	}
+	<-tunnel.Done()
+
+	// The deadline of the request context is passed on to the agent.
+	if timeout := ts.packets[0].GetDialRequest().TimeoutMillis; timeout <= 9000 || timeout > 10000 {
+		t.Errorf("expect a timeout of about 10s; got %dms", timeout)
+	}
+	metrics.Metrics.Reset() // For clean shutdown.
+}
+
 func TestCloseTimeout(t *testing.T) {
 	if testing.Short() {
 		t.Skip()
@@ -38,11 +38,12 @@ var (
 
 // ClientMetrics includes all the metrics of the konnectivity-client.
 type ClientMetrics struct {
-	registerOnce  sync.Once
-	streamPackets *prometheus.CounterVec
-	streamErrors  *prometheus.CounterVec
-	dialFailures  *prometheus.CounterVec
-	clientConns   *prometheus.GaugeVec
+	registerOnce    sync.Once
+	streamPackets   *prometheus.CounterVec
+	streamDataBytes *prometheus.CounterVec
+	streamErrors    *prometheus.CounterVec
+	dialFailures    *prometheus.CounterVec
+	clientConns     *prometheus.GaugeVec
 }
 
 type DialFailureReason string
@@ -107,10 +108,11 @@ func newMetrics() *ClientMetrics {
 		},
 	)
 	return &ClientMetrics{
-		streamPackets: commonmetrics.MakeStreamPacketsTotalMetric(Namespace, Subsystem),
-		streamErrors:  commonmetrics.MakeStreamErrorsTotalMetric(Namespace, Subsystem),
-		dialFailures:  dialFailures,
-		clientConns:   clientConns,
+		streamPackets:   commonmetrics.MakeStreamPacketsTotalMetric(Namespace, Subsystem),
+		streamDataBytes: commonmetrics.MakeStreamDataBytesTotalMetric(Namespace, Subsystem),
+		streamErrors:    commonmetrics.MakeStreamErrorsTotalMetric(Namespace, Subsystem),
+		dialFailures:    dialFailures,
+		clientConns:     clientConns,
 	}
 }
 
@@ -118,6 +120,7 @@ func newMetrics() *ClientMetrics {
 func (c *ClientMetrics) RegisterMetrics(r prometheus.Registerer) {
 	c.registerOnce.Do(func() {
 		r.MustRegister(c.streamPackets)
+		r.MustRegister(c.streamDataBytes)
 		r.MustRegister(c.streamErrors)
 		r.MustRegister(c.dialFailures)
 		r.MustRegister(c.clientConns)
@@ -129,6 +132,7 @@ func (c *ClientMetrics) RegisterMetrics(r prometheus.Registerer) {
 func (c *ClientMetrics) LegacyRegisterMetrics(mustRegisterFn func(...prometheus.Collector)) {
 	c.registerOnce.Do(func() {
 		mustRegisterFn(c.streamPackets)
+		mustRegisterFn(c.streamDataBytes)
 		mustRegisterFn(c.streamErrors)
 		mustRegisterFn(c.dialFailures)
 		mustRegisterFn(c.clientConns)
@@ -138,6 +142,7 @@ func (c *ClientMetrics) LegacyRegisterMetrics(mustRegisterFn func(...prometheus.
 // Reset resets the metrics.
 func (c *ClientMetrics) Reset() {
 	c.streamPackets.Reset()
+	c.streamDataBytes.Reset()
 	c.streamErrors.Reset()
 	c.dialFailures.Reset()
 	c.clientConns.Reset()
@@ -155,6 +160,10 @@ func (c *ClientMetrics) ObservePacket(segment commonmetrics.Segment, packetType
 	commonmetrics.ObservePacket(c.streamPackets, segment, packetType)
 }
 
+func (c *ClientMetrics) ObserveDataBytes(segment commonmetrics.Segment, pkt *client.Packet) {
+	commonmetrics.ObserveDataBytes(c.streamDataBytes, segment, pkt)
+}
+
 func (c *ClientMetrics) ObserveStreamErrorNoPacket(segment commonmetrics.Segment, err error) {
 	commonmetrics.ObserveStreamErrorNoPacket(c.streamErrors, segment, err)
 }
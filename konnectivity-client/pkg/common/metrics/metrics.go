@@ -63,10 +63,31 @@ func MakeStreamErrorsTotalMetric(namespace, subsystem string) *prometheus.Counte
 	)
 }
 
+func MakeStreamDataBytesTotalMetric(namespace, subsystem string) *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "stream_data_bytes_total",
+			Help:      "Count of DATA packet payload bytes processed, by segment (example: from_client)",
+		},
+		[]string{"segment"},
+	)
+}
+
 func ObservePacket(m *prometheus.CounterVec, segment Segment, packetType client.PacketType) {
 	m.WithLabelValues(string(segment), packetType.String()).Inc()
 }
 
+// ObserveDataBytes adds the payload size of DATA packets to m. Other packet
+// types are ignored.
+func ObserveDataBytes(m *prometheus.CounterVec, segment Segment, pkt *client.Packet) {
+	if pkt.Type != client.PacketType_DATA {
+		return
+	}
+	m.WithLabelValues(string(segment)).Add(float64(len(pkt.GetData().Data)))
+}
+
 func ObserveStreamErrorNoPacket(m *prometheus.CounterVec, segment Segment, err error) {
 	code := status.Code(err)
 	m.WithLabelValues(string(segment), code.String(), "Unknown").Inc()
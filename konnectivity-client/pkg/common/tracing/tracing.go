@@ -0,0 +1,351 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing provides lightweight distributed tracing shared by the
+// konnectivity client, server, and agent.
+//
+// Trace context is propagated in the W3C Trace Context format
+// (https://www.w3.org/TR/trace-context/), which makes the spans recorded here
+// compatible with OpenTelemetry: a trace started by an OpenTelemetry
+// instrumented caller is continued across the tunnel, and an Exporter can
+// forward the finished spans to any OpenTelemetry backend.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
+)
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+// String returns the lowercase hex encoding of the trace ID.
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// String returns the lowercase hex encoding of the span ID.
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// FlagsSampled is the trace flag indicating the caller may have recorded
+// the trace.
+const FlagsSampled byte = 0x01
+
+// SpanContext is the part of a span propagated across process boundaries.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      byte
+	TraceState string
+}
+
+// IsValid reports whether both the trace ID and the span ID are non-zero.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// IsSampled reports whether the sampled flag is set.
+func (sc SpanContext) IsSampled() bool {
+	return sc.Flags&FlagsSampled != 0
+}
+
+// Traceparent returns the W3C traceparent header value for the span context,
+// or an empty string if it is not valid.
+func (sc SpanContext) Traceparent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// ParseTraceparent parses W3C traceparent and tracestate values. It returns
+// an invalid SpanContext if traceparent is empty or malformed.
+func ParseTraceparent(traceparent, tracestate string) SpanContext {
+	parts := strings.Split(traceparent, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}
+	}
+	// Version 00 has exactly four fields; later versions may append more.
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}
+	}
+	var sc SpanContext
+	var flags [1]byte
+	if !decodeHex(sc.TraceID[:], parts[1]) || !decodeHex(sc.SpanID[:], parts[2]) || !decodeHex(flags[:], parts[3]) {
+		return SpanContext{}
+	}
+	if !sc.IsValid() {
+		return SpanContext{}
+	}
+	sc.Flags = flags[0]
+	sc.TraceState = tracestate
+	return sc
+}
+
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext returns a copy of ctx carrying sc as the current
+// span context. Spans started from the returned context are its children.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext returns the current span context carried by ctx, or
+// an invalid SpanContext if there is none.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc
+}
+
+// Inject sets the trace context fields of req from the span context carried
+// by ctx. They are cleared if ctx carries no span context.
+func Inject(ctx context.Context, req *client.DialRequest) {
+	sc := SpanContextFromContext(ctx)
+	req.Traceparent = sc.Traceparent()
+	req.Tracestate = ""
+	if req.Traceparent != "" {
+		req.Tracestate = sc.TraceState
+	}
+}
+
+// Extract returns a copy of ctx carrying the span context propagated in req.
+// ctx is returned unchanged if req carries no valid trace context.
+func Extract(ctx context.Context, req *client.DialRequest) context.Context {
+	sc := ParseTraceparent(req.GetTraceparent(), req.GetTracestate())
+	if !sc.IsValid() {
+		return ctx
+	}
+	return ContextWithSpanContext(ctx, sc)
+}
+
+// SpanData is the immutable record of a finished span handed to an Exporter.
+type SpanData struct {
+	// Service is the name of the component which recorded the span.
+	Service     string
+	Name        string
+	SpanContext SpanContext
+	// Parent is the span ID of the parent span, zero for root spans.
+	Parent     SpanID
+	StartTime  time.Time
+	EndTime    time.Time
+	Attributes map[string]string
+	// Error is the error message recorded on the span, if any.
+	Error string
+}
+
+// Exporter receives the finished spans. Implementations must be safe for
+// concurrent use and should not block.
+type Exporter interface {
+	ExportSpan(s *SpanData)
+}
+
+// Tracer records spans and hands them to its exporter once they end.
+// A nil *Tracer is valid and records nothing, while still propagating any
+// span context already present in the context.
+type Tracer struct {
+	service  string
+	exporter Exporter
+}
+
+// NewTracer returns a Tracer recording spans on behalf of service.
+func NewTracer(service string, exporter Exporter) *Tracer {
+	return &Tracer{service: service, exporter: exporter}
+}
+
+// Start starts a span named name, as a child of the span context carried by
+// ctx if any, or as the root of a new trace otherwise. The returned context
+// carries the new span's context. The returned span must be ended with End.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	parent := SpanContextFromContext(ctx)
+	s := &Span{
+		tracer: t,
+		data: SpanData{
+			Service:   t.service,
+			Name:      name,
+			StartTime: time.Now(),
+		},
+	}
+	if parent.IsValid() {
+		s.data.SpanContext = SpanContext{TraceID: parent.TraceID, Flags: parent.Flags, TraceState: parent.TraceState}
+		s.data.Parent = parent.SpanID
+	} else {
+		s.data.SpanContext = SpanContext{TraceID: newTraceID(), Flags: FlagsSampled}
+	}
+	s.data.SpanContext.SpanID = newSpanID()
+	return ContextWithSpanContext(ctx, s.data.SpanContext), s
+}
+
+// Span is an in-progress span. A nil *Span is valid and all its methods are
+// no-ops.
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex // protects the following
+	data  SpanData
+	ended bool
+}
+
+// SpanContext returns the span context of s.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+// SetAttribute records a key/value attribute on the span.
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]string)
+	}
+	s.data.Attributes[key] = value
+}
+
+// RecordError marks the span as failed with err. A nil err is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.data.Error = err.Error()
+}
+
+// End finishes the span and exports it if it is sampled. Only the first call
+// has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.EndTime = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if s.tracer.exporter != nil && data.SpanContext.IsSampled() {
+		s.tracer.exporter.ExportSpan(&data)
+	}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for id == (TraceID{}) {
+		rand.Read(id[:]) /* #nosec G104 */
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for id == (SpanID{}) {
+		rand.Read(id[:]) /* #nosec G104 */
+	}
+	return id
+}
+
+var _ Exporter = &InMemoryExporter{}
+
+// InMemoryExporter keeps the exported spans in memory. It is meant for tests.
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// ExportSpan appends s to the recorded spans.
+func (e *InMemoryExporter) ExportSpan(s *SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, *s)
+}
+
+// Spans returns a copy of the recorded spans, in the order they ended.
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+// Reset drops all the recorded spans.
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+var _ Exporter = LogExporter{}
+
+// LogExporter writes the exported spans to the klog structured log at the
+// given verbosity.
+type LogExporter struct {
+	Verbosity klog.Level
+}
+
+// ExportSpan logs s.
+func (e LogExporter) ExportSpan(s *SpanData) {
+	keysAndValues := []interface{}{
+		"service", s.Service,
+		"name", s.Name,
+		"traceID", s.SpanContext.TraceID,
+		"spanID", s.SpanContext.SpanID,
+		"parentSpanID", s.Parent,
+		"startTime", s.StartTime,
+		"duration", s.EndTime.Sub(s.StartTime),
+	}
+	for k, v := range s.Attributes {
+		keysAndValues = append(keysAndValues, k, v)
+	}
+	if s.Error != "" {
+		keysAndValues = append(keysAndValues, "error", s.Error)
+	}
+	klog.V(e.Verbosity).InfoS("Span", keysAndValues...)
+}
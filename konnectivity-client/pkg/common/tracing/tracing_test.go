@@ -0,0 +1,114 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	testcases := []struct {
+		traceparent string
+		valid       bool
+	}{
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", true},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00", true},
+		{"01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra", true},
+		{"", false},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra", false},
+		{"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", false},
+		{"00-00000000000000000000000000000000-b7ad6b7169203331-01", false},
+		{"00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01", false},
+		{"00-0AF7651916CD43DD8448EB211C80319C-b7ad6b7169203331-01", false},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b71692033-01", false},
+	}
+	for _, tc := range testcases {
+		sc := ParseTraceparent(tc.traceparent, "vendor=value")
+		if sc.IsValid() != tc.valid {
+			t.Errorf("ParseTraceparent(%q): expected valid=%v, got %+v", tc.traceparent, tc.valid, sc)
+			continue
+		}
+		if tc.valid && tc.traceparent[:2] == "00" && sc.Traceparent() != tc.traceparent {
+			t.Errorf("ParseTraceparent(%q).Traceparent() = %q", tc.traceparent, sc.Traceparent())
+		}
+	}
+}
+
+func TestStartAndPropagate(t *testing.T) {
+	exporter := &InMemoryExporter{}
+	tracer := NewTracer("test", exporter)
+
+	ctx, root := tracer.Start(context.Background(), "root")
+	_, child := tracer.Start(ctx, "child")
+	child.SetAttribute("key", "value")
+	child.RecordError(errors.New("failed"))
+	child.End()
+	child.End()
+	root.End()
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	if spans[0].Name != "child" || spans[0].Parent != root.SpanContext().SpanID || spans[0].SpanContext.TraceID != root.SpanContext().TraceID {
+		t.Errorf("unexpected child span %+v", spans[0])
+	}
+	if spans[0].Attributes["key"] != "value" || spans[0].Error != "failed" {
+		t.Errorf("unexpected child span attributes %+v", spans[0])
+	}
+	if spans[1].Name != "root" || spans[1].Parent != (SpanID{}) || !spans[1].SpanContext.IsSampled() {
+		t.Errorf("unexpected root span %+v", spans[1])
+	}
+
+	// The trace context round-trips through a DIAL_REQ.
+	req := &client.DialRequest{}
+	Inject(ctx, req)
+	if got := SpanContextFromContext(Extract(context.Background(), req)); got != root.SpanContext() {
+		t.Errorf("expected extracted span context %+v, got %+v", root.SpanContext(), got)
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+	parent := ParseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", "")
+	ctx, span := tracer.Start(ContextWithSpanContext(context.Background(), parent), "span")
+	span.SetAttribute("key", "value")
+	span.End()
+
+	if span != nil {
+		t.Errorf("expected nil span, got %+v", span)
+	}
+	if got := SpanContextFromContext(ctx); got != parent {
+		t.Errorf("expected the parent span context to be propagated, got %+v", got)
+	}
+}
+
+func TestUnsampled(t *testing.T) {
+	exporter := &InMemoryExporter{}
+	tracer := NewTracer("test", exporter)
+	parent := ParseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00", "")
+	_, span := tracer.Start(ContextWithSpanContext(context.Background(), parent), "span")
+	span.End()
+
+	if spans := exporter.Spans(); len(spans) != 0 {
+		t.Errorf("expected unsampled span not to be exported, got %+v", spans)
+	}
+}
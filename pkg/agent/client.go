@@ -18,6 +18,7 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -25,23 +26,29 @@ import (
 	"net/url"
 	runpprof "runtime/pprof"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/metadata"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
 
 	commonmetrics "sigs.k8s.io/apiserver-network-proxy/konnectivity-client/pkg/common/metrics"
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/pkg/common/tracing"
 	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
 	"sigs.k8s.io/apiserver-network-proxy/pkg/agent/metrics"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/agent/policy"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/util"
 	"sigs.k8s.io/apiserver-network-proxy/proto/agent"
 	"sigs.k8s.io/apiserver-network-proxy/proto/header"
 )
 
-const dialTimeout = 5 * time.Second
+const defaultDialTimeout = 5 * time.Second
 const xfrChannelSize = 150
 
 // endpointConn tracks a connection from agent to node network.
@@ -53,12 +60,29 @@ type endpointConn struct {
 	cleanOnce sync.Once
 	warnChLim bool
 	dialDone  chan struct{}
+	span      *tracing.Span // lifetime of the connection, set once dialDone is closed
+	// expiry closes the connection once idle or too old, if the agent
+	// limits them. Set once dialDone is closed.
+	expiry *util.ExpiryTimer
+	// closeErr is the error of the CLOSE_RSP, if the agent closed the
+	// connection.
+	closeErr string
 }
 
 func (e *endpointConn) cleanup() {
 	e.cleanOnce.Do(e.cleanFunc)
 }
 
+// expire closes the connection, idle or too old, and tells the server why.
+func (e *endpointConn) expire(reason util.ExpiryReason) {
+	e.cleanOnce.Do(func() {
+		klog.V(2).InfoS("Closing expired connection", "reason", reason, "connectionID", e.connID)
+		metrics.Metrics.ObserveEndpointConnectionExpired(string(reason))
+		e.closeErr = fmt.Sprintf("connection closed by the agent: %s", reason)
+		e.cleanFunc()
+	})
+}
+
 func (e *endpointConn) send(msg []byte) {
 	// TODO (cheftako@): Get perf test working and compare this solution with a lock based solution.
 	defer func() {
@@ -174,21 +198,36 @@ func GenAgentIdentifiers(addrs string) (Identifiers, error) {
 // a stream connection from which it sends and receives network traffic.
 type Client struct {
 	nextConnID int64
+	// pendingDials is the number of DIAL_REQs being dialed.
+	pendingDials int64
 
 	connManager *connectionManager
 
+	// draining is set once the server sent a DRAIN, and the client is
+	// closed once its connections are.
+	draining  atomic.Bool
+	drainOnce sync.Once
+
 	cs *ClientSet // the clientset that includes this AgentClient.
 
 	stream           agent.AgentService_ConnectClient
 	agentID          string
-	agentIdentifiers string
-	serverID         string // the id of the proxy server this client connects to.
+	agentIdentifiers string // the identifiers sent on connecting.
+	// sentIdentifiers are the identifiers the server last got, protected by
+	// cs.identifiersMu.
+	sentIdentifiers string
+	serverID        string // the id of the proxy server this client connects to.
+	// serverIDs are the IDs of the live proxy servers reported by the
+	// server on connecting, if it knows them.
+	serverIDs []string
 
 	// connect opts
 	address string
 	opts    []grpc.DialOption
 	conn    *grpc.ClientConn
 	stopCh  chan struct{}
+	// connectedSince is when the client connected to the proxy server.
+	connectedSince time.Time
 	// locks
 	sendLock      sync.Mutex
 	recvLock      sync.Mutex
@@ -199,6 +238,11 @@ type Client struct {
 	serviceAccountTokenPath string
 
 	warnOnChannelLimit bool
+
+	// dialer connects to the destinations, directly if nil.
+	dialer Dialer
+
+	tracer *tracing.Tracer
 }
 
 func newAgentClient(address, agentID, agentIdentifiers string, cs *ClientSet, opts ...grpc.DialOption) (*Client, int, error) {
@@ -207,17 +251,21 @@ func newAgentClient(address, agentID, agentIdentifiers string, cs *ClientSet, op
 		address:                 address,
 		agentID:                 agentID,
 		agentIdentifiers:        agentIdentifiers,
+		sentIdentifiers:         agentIdentifiers,
 		opts:                    opts,
 		probeInterval:           cs.probeInterval,
 		stopCh:                  make(chan struct{}),
 		serviceAccountTokenPath: cs.serviceAccountTokenPath,
 		connManager:             newConnectionManager(),
 		warnOnChannelLimit:      cs.warnOnChannelLimit,
+		dialer:                  cs.dialer,
+		tracer:                  cs.tracer,
 	}
 	serverCount, err := a.Connect()
 	if err != nil {
 		return nil, 0, err
 	}
+	a.connectedSince = time.Now()
 	return a, serverCount, nil
 }
 
@@ -255,9 +303,15 @@ func (a *Client) Connect() (int, error) {
 		conn.Close() /* #nosec G104 */
 		return 0, err
 	}
+	serverIDs, err := serverIDs(stream)
+	if err != nil {
+		conn.Close() /* #nosec G104 */
+		return 0, err
+	}
 	a.conn = conn
 	a.stream = stream
 	a.serverID = serverID
+	a.serverIDs = serverIDs
 	klog.V(2).InfoS("Connect to server", "serverID", serverID)
 	return serverCount, nil
 }
@@ -280,6 +334,7 @@ func (a *Client) Send(pkt *client.Packet) error {
 
 	const segment = commonmetrics.SegmentFromAgent
 	metrics.Metrics.ObservePacket(segment, pkt.Type)
+	metrics.Metrics.ObserveDataBytes(segment, pkt)
 	err := a.stream.Send(pkt)
 	if err != nil && err != io.EOF {
 		metrics.Metrics.ObserveServerFailureDeprecated(metrics.DirectionToServer)
@@ -303,6 +358,7 @@ func (a *Client) Recv() (*client.Packet, error) {
 		return nil, err
 	}
 	metrics.Metrics.ObservePacket(segment, pkt.Type)
+	metrics.Metrics.ObserveDataBytes(segment, pkt)
 	return pkt, nil
 }
 
@@ -332,6 +388,23 @@ func serverID(stream agent.AgentService_ConnectClient) (string, error) {
 	return sids[0], nil
 }
 
+// serverIDs returns the IDs of the live proxy servers, or nil if the server
+// does not report them.
+func serverIDs(stream agent.AgentService_ConnectClient) ([]string, error) {
+	md, err := stream.Header()
+	if err != nil {
+		return nil, err
+	}
+	ids := md.Get(header.ServerIDs)
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if len(ids) != 1 {
+		return nil, fmt.Errorf("expected one list of server IDs, got %d", len(ids))
+	}
+	return strings.Split(ids[0], ","), nil
+}
+
 func (a *Client) initializeAuthContext(ctx context.Context) (context.Context, error) {
 	var err error
 	var b []byte
@@ -409,6 +482,7 @@ func (a *Client) Serve() {
 			dataCh := make(chan []byte, xfrChannelSize)
 			dialDone := make(chan struct{})
 			eConn := &endpointConn{
+				connID:    connID,
 				dataCh:    dataCh,
 				dialDone:  dialDone,
 				warnChLim: a.warnOnChannelLimit,
@@ -416,6 +490,7 @@ func (a *Client) Serve() {
 			eConn.cleanFunc = func() {
 				// block on purpose
 				<-dialDone
+				eConn.expiry.Stop()
 				if eConn.conn == nil {
 					// TODO: move this guard lower
 					klog.ErrorS(fmt.Errorf("remote connection is nil"), "could not send CLOSE_RESP to nil connection")
@@ -435,6 +510,7 @@ func (a *Client) Serve() {
 						Payload: &client.Packet_CloseResponse{CloseResponse: &client.CloseResponse{}},
 					}
 					closePkt.GetCloseResponse().ConnectID = connID
+					closePkt.GetCloseResponse().Error = eConn.closeErr
 				}
 				if err := a.Send(closePkt); err != nil {
 					klog.ErrorS(err, "close response failure", "")
@@ -444,6 +520,8 @@ func (a *Client) Serve() {
 				if err := eConn.conn.Close(); err != nil {
 					klog.ErrorS(err, "failed to close connection to remote", "dialID", dialReq.Random, "connectionID", connID)
 				}
+				eConn.span.End()
+				a.closeIfDrained()
 			}
 			labels := runpprof.Labels(
 				"agentID", a.agentID,
@@ -453,19 +531,40 @@ func (a *Client) Serve() {
 				"dialID", strconv.FormatInt(dialReq.Random, 10),
 				"dialAddress", dialReq.Address,
 			)
+			// Continue the trace propagated by the proxy server, if any.
+			traceCtx := tracing.Extract(context.Background(), dialReq)
+			atomic.AddInt64(&a.pendingDials, 1)
 			go runpprof.Do(context.Background(), labels, func(context.Context) {
+				defer func() {
+					atomic.AddInt64(&a.pendingDials, -1)
+					a.closeIfDrained()
+				}()
 				defer close(dialDone)
+				_, dialSpan := a.tracer.Start(traceCtx, "proxy-agent.Dial")
+				dialSpan.SetAttribute("agentID", a.agentID)
+				dialSpan.SetAttribute("address", dialReq.Address)
+				defer dialSpan.End()
 				start := time.Now()
-				conn, err := net.DialTimeout(dialReq.Protocol, dialReq.Address, dialTimeout)
+				conn, err := a.dial(dialReq.Protocol, dialReq.Address, time.Duration(dialReq.TimeoutMillis)*time.Millisecond)
 				if err != nil {
+					dialSpan.RecordError(err)
 					reason := metrics.DialFailureUnknown
-					if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
+					var dnsErr *net.DNSError
+					if errors.Is(err, policy.ErrDenied) {
+						reason = metrics.DialFailureDenied
+					} else if a.cs.resolver != nil && errors.As(err, &dnsErr) {
+						// Recorded by the resolver.
+						reason = ""
+					} else if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
 						reason = metrics.DialFailureTimeout
 					}
-					metrics.Metrics.ObserveDialFailure(reason)
+					if reason != "" {
+						metrics.Metrics.ObserveDialFailure(reason)
+					}
 					// Do not log agent errors for remote unavailable.
 					klog.V(1).InfoS("error dialing backend", "error", err, "dialID", dialReq.Random, "connectionID", connID, "dialAddress", dialReq.Address)
 					dialResp.GetDialResponse().Error = err.Error()
+					dialResp.GetDialResponse().ErrorCode = dialErrorCode(err)
 					if err := a.Send(dialResp); err != nil {
 						klog.ErrorS(err, "could not send DIAL_RSP with error", "dialID", dialReq.Random, "connectionID", connID, "dialAddress", dialReq.Address)
 					}
@@ -475,6 +574,12 @@ func (a *Client) Serve() {
 				metrics.Metrics.ObserveDialLatency(time.Since(start))
 				klog.V(3).InfoS("Endpoint connection established", "dialID", dialReq.Random, "connectionID", connID, "dialAddress", dialReq.Address)
 				eConn.conn = conn
+				eConn.expiry = util.NewExpiryTimer(a.cs.connectionIdleTimeout, a.cs.connectionMaxLifetime, eConn.expire)
+				_, eConn.span = a.tracer.Start(traceCtx, "proxy-agent.Connection")
+				eConn.span.SetAttribute("agentID", a.agentID)
+				eConn.span.SetAttribute("address", dialReq.Address)
+				eConn.span.SetAttribute("connectionID", strconv.FormatInt(connID, 10))
+				dialSpan.SetAttribute("connectionID", strconv.FormatInt(connID, 10))
 				a.connManager.Add(connID, eConn)
 				dialResp.GetDialResponse().ConnectID = connID
 				labels := runpprof.Labels(
@@ -546,12 +651,135 @@ func (a *Client) Serve() {
 				}
 			}
 
+		case client.PacketType_SERVER_INFO:
+			info := pkt.GetServerInfo()
+			klog.V(3).InfoS("Received SERVER_INFO", "serverID", a.serverID, "serverCount", info.ServerCount, "serverIDs", info.ServerIDs)
+			a.cs.updateServers(a.serverID, int(info.ServerCount), info.ServerIDs)
+
+		case client.PacketType_PING:
+			ping := pkt.GetPing()
+			klog.V(5).InfoS("Received PING", "serverID", a.serverID, "pingID", ping.Id)
+			pong := &client.Packet{
+				Type:    client.PacketType_PONG,
+				Payload: &client.Packet_Pong{Pong: &client.Pong{Id: ping.Id}},
+			}
+			if err := a.Send(pong); err != nil {
+				klog.ErrorS(err, "could not send PONG", "serverID", a.serverID)
+			}
+
+		case client.PacketType_DRAIN:
+			klog.V(2).InfoS("Received DRAIN, closing the stream once its connections are closed", "serverID", a.serverID, "agentID", a.agentID, "reason", pkt.GetDrain().Reason)
+			a.drain()
+
 		default:
 			klog.V(5).InfoS("unrecognized packet", "type", pkt)
 		}
 	}
 }
 
+// drain closes the client once its connections are closed, and its dials
+// are done.
+func (a *Client) drain() {
+	a.draining.Store(true)
+	a.closeIfDrained()
+}
+
+// closeIfDrained closes the client if it is draining, and it has
+// no connections left, nor dials in progress.
+func (a *Client) closeIfDrained() {
+	if !a.draining.Load() || atomic.LoadInt64(&a.pendingDials) > 0 || len(a.connManager.List()) > 0 {
+		return
+	}
+	a.drainOnce.Do(func() {
+		klog.V(2).InfoS("Closing drained stream", "serverID", a.serverID, "agentID", a.agentID)
+		a.cs.removeClient(a)
+	})
+}
+
+// dial connects to the destination address with the dialer of the client,
+// if the destination policy allows it.
+func (a *Client) dial(protocol, address string, requestedTimeout time.Duration) (net.Conn, error) {
+	if p := a.cs.destinationPolicy; p != nil {
+		if err := p.CheckAddress(address); err != nil {
+			return nil, err
+		}
+	}
+	dialer := a.dialer
+	if dialer == nil {
+		dialer = NewDirectDialer(a.cs.destinationPolicy, a.cs.resolver)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), a.cs.dialTimeoutFor(requestedTimeout))
+	defer cancel()
+	backoff := wait.Backoff{Duration: a.cs.dialRetryBackoff, Factor: 2, Jitter: 0.1, Steps: a.cs.dialRetries}
+	for retry := 1; ; retry++ {
+		conn, err := dialer.DialContext(ctx, protocol, address)
+		if err == nil || retry > a.cs.dialRetries || !isTransientDialError(err) {
+			return conn, err
+		}
+		delay := backoff.Step()
+		klog.V(3).InfoS("Retrying dial after a transient error", "dialAddress", address, "retry", retry, "delay", delay, "error", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, err
+		}
+		metrics.Metrics.ObserveDialRetry()
+	}
+}
+
+// dialTimeoutFor returns the time to dial for, given the timeout requested
+// by the DIAL_REQ, if any.
+func (cs *ClientSet) dialTimeoutFor(requested time.Duration) time.Duration {
+	if requested <= 0 {
+		if cs.dialTimeout > 0 {
+			return cs.dialTimeout
+		}
+		return defaultDialTimeout
+	}
+	if cs.minDialTimeout > 0 && requested < cs.minDialTimeout {
+		return cs.minDialTimeout
+	}
+	if cs.maxDialTimeout > 0 && requested > cs.maxDialTimeout {
+		return cs.maxDialTimeout
+	}
+	return requested
+}
+
+// isTransientDialError returns whether a dial which failed with err may
+// succeed if retried.
+func isTransientDialError(err error) bool {
+	if errors.Is(err, policy.ErrDenied) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH)
+}
+
+// dialErrorCode classifies the error of a failed dial for the proxy server.
+func dialErrorCode(err error) client.DialErrorCode {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, policy.ErrDenied):
+		return client.DialErrorCode_DIAL_ERROR_DENIED
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		return client.DialErrorCode_DIAL_ERROR_NO_SUCH_HOST
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return client.DialErrorCode_DIAL_ERROR_CONNECTION_REFUSED
+	case errors.Is(err, syscall.EHOSTUNREACH):
+		return client.DialErrorCode_DIAL_ERROR_HOST_UNREACHABLE
+	case errors.Is(err, syscall.ENETUNREACH):
+		return client.DialErrorCode_DIAL_ERROR_NETWORK_UNREACHABLE
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return client.DialErrorCode_DIAL_ERROR_TIMEOUT
+	}
+	return client.DialErrorCode_DIAL_ERROR_UNSPECIFIED
+}
+
 func (a *Client) remoteToProxy(connID int64, eConn *endpointConn) {
 	defer func() {
 		if panicInfo := recover(); panicInfo != nil {
@@ -584,6 +812,7 @@ func (a *Client) remoteToProxy(connID int64, eConn *endpointConn) {
 			}
 			return
 		} else {
+			eConn.expiry.Touch()
 			resp.Payload = &client.Packet_Data{Data: &client.Data{
 				Data:      buf[:n],
 				ConnectID: connID,
@@ -626,6 +855,7 @@ func (a *Client) proxyToRemote(connID int64, eConn *endpointConn) {
 		for {
 			n, err := eConn.conn.Write(d[pos:])
 			if err == nil {
+				eConn.expiry.Touch()
 				klog.V(4).InfoS("write to remote", "connectionID", connID, "lastData", n, "dataSize", len(d))
 				break
 			} else if n > 0 {
@@ -17,11 +17,17 @@ limitations under the License.
 package agent
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -29,7 +35,9 @@ import (
 	"google.golang.org/grpc"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/pkg/common/tracing"
 	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/agent/policy"
 	"sigs.k8s.io/apiserver-network-proxy/proto/agent"
 )
 
@@ -221,6 +229,627 @@ func TestClose_Client(t *testing.T) {
 
 }
 
+func TestConnectionExpiry_Client(t *testing.T) {
+	var stream agent.AgentService_ConnectClient
+	stopCh := make(chan struct{})
+	cs := &ClientSet{
+		clients:               make(map[string]*Client),
+		stopCh:                stopCh,
+		connectionIdleTimeout: 50 * time.Millisecond,
+	}
+	testClient := &Client{
+		connManager: newConnectionManager(),
+		stopCh:      stopCh,
+		cs:          cs,
+	}
+	testClient.stream, stream = pipe()
+
+	// Start agent
+	go testClient.Serve()
+	defer close(stopCh)
+
+	// Start test http server as remote service
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello, world")
+	}))
+	defer ts.Close()
+
+	if err := stream.Send(newDialPacket("tcp", ts.URL[len("http://"):], 111)); err != nil {
+		t.Fatal(err)
+	}
+	pkt, _ := stream.Recv()
+	if pkt == nil {
+		t.Fatal("unexpected nil packet")
+	}
+	if pkt.Type != client.PacketType_DIAL_RSP {
+		t.Fatalf("expect PacketType_DIAL_RSP; got %v", pkt.Type)
+	}
+	connID := pkt.GetDialResponse().ConnectID
+
+	// Without any data, the agent closes the connection once idle.
+	pkt, _ = stream.Recv()
+	if pkt == nil {
+		t.Fatal("unexpected nil packet")
+	}
+	if pkt.Type != client.PacketType_CLOSE_RSP {
+		t.Fatalf("expect PacketType_CLOSE_RSP; got %v", pkt.Type)
+	}
+	if got := pkt.GetCloseResponse().ConnectID; got != connID {
+		t.Errorf("expect connID=%d; got %d", connID, got)
+	}
+	if closeErr := pkt.GetCloseResponse().Error; !strings.Contains(closeErr, "idle_timeout") {
+		t.Errorf("expect idle_timeout closeErr; got %q", closeErr)
+	}
+
+	waitForConnectionDeletion(t, testClient, connID)
+}
+
+func TestDestinationPolicy_Client(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy")
+	if err := os.WriteFile(path, []byte("deny 127.0.0.0/8\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	destinationPolicy, err := policy.NewDestinationPolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stream agent.AgentService_ConnectClient
+	stopCh := make(chan struct{})
+	cs := &ClientSet{
+		clients:           make(map[string]*Client),
+		stopCh:            stopCh,
+		destinationPolicy: destinationPolicy,
+	}
+	testClient := &Client{
+		connManager: newConnectionManager(),
+		stopCh:      stopCh,
+		cs:          cs,
+	}
+	testClient.stream, stream = pipe()
+
+	// Start agent
+	go testClient.Serve()
+	defer close(stopCh)
+
+	// Start test http server as remote service
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello, world")
+	}))
+	defer ts.Close()
+	_, port, err := net.SplitHostPort(ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both the address and a name resolving to it are denied.
+	for _, address := range []string{ts.Listener.Addr().String(), net.JoinHostPort("localhost", port)} {
+		if err := stream.Send(newDialPacket("tcp", address, 111)); err != nil {
+			t.Fatal(err)
+		}
+		pkt, _ := stream.Recv()
+		if pkt == nil {
+			t.Fatal("unexpected nil packet")
+		}
+		if pkt.Type != client.PacketType_DIAL_RSP {
+			t.Fatalf("expect PacketType_DIAL_RSP; got %v", pkt.Type)
+		}
+		if dialErr := pkt.GetDialResponse().Error; !strings.Contains(dialErr, policy.ErrDenied.Error()) {
+			t.Errorf("expect the dial to %s to be denied; got error %q", address, dialErr)
+		}
+		if code := pkt.GetDialResponse().ErrorCode; code != client.DialErrorCode_DIAL_ERROR_DENIED {
+			t.Errorf("expect the dial to %s to fail with %v; got %v", address, client.DialErrorCode_DIAL_ERROR_DENIED, code)
+		}
+	}
+}
+
+// standInDialer connects every destination to the address of a stand-in,
+// e.g. through an upstream proxy, recording the destinations.
+type standInDialer struct {
+	address      string
+	destinations chan string
+}
+
+func (d *standInDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.destinations <- address
+	var nd net.Dialer
+	return nd.DialContext(ctx, network, d.address)
+}
+
+func TestDialer_Client(t *testing.T) {
+	var stream agent.AgentService_ConnectClient
+	stopCh := make(chan struct{})
+	cs := &ClientSet{
+		clients: make(map[string]*Client),
+		stopCh:  stopCh,
+	}
+	testClient := &Client{
+		connManager: newConnectionManager(),
+		stopCh:      stopCh,
+		cs:          cs,
+	}
+	testClient.stream, stream = pipe()
+
+	// Start test http server as remote service
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello, world")
+	}))
+	defer ts.Close()
+	dialer := &standInDialer{address: ts.Listener.Addr().String(), destinations: make(chan string, 1)}
+	testClient.dialer = dialer
+
+	// Start agent
+	go testClient.Serve()
+	defer close(stopCh)
+
+	if err := stream.Send(newDialPacket("tcp", "web.example.com:80", 111)); err != nil {
+		t.Fatal(err)
+	}
+	pkt, _ := stream.Recv()
+	if pkt == nil {
+		t.Fatal("unexpected nil packet")
+	}
+	if pkt.Type != client.PacketType_DIAL_RSP {
+		t.Fatalf("expect PacketType_DIAL_RSP; got %v", pkt.Type)
+	}
+	if dialErr := pkt.GetDialResponse().Error; dialErr != "" {
+		t.Fatalf("expect the dial to succeed; got error %q", dialErr)
+	}
+	if got := <-dialer.destinations; got != "web.example.com:80" {
+		t.Errorf("expect the dialer to connect to web.example.com:80; got %s", got)
+	}
+}
+
+func TestDialTimeoutFor(t *testing.T) {
+	cs := &ClientSet{dialTimeout: 10 * time.Second, minDialTimeout: time.Second, maxDialTimeout: time.Minute}
+	for requested, want := range map[time.Duration]time.Duration{
+		0:                      10 * time.Second,
+		100 * time.Millisecond: time.Second,
+		20 * time.Second:       20 * time.Second,
+		time.Hour:              time.Minute,
+	} {
+		if got := cs.dialTimeoutFor(requested); got != want {
+			t.Errorf("requested %v: expect a dial timeout of %v; got %v", requested, want, got)
+		}
+	}
+	if got := (&ClientSet{}).dialTimeoutFor(0); got != defaultDialTimeout {
+		t.Errorf("expect the default dial timeout %v; got %v", defaultDialTimeout, got)
+	}
+}
+
+// flakyDialer fails the first dials with err.
+type flakyDialer struct {
+	failures int
+	err      error
+
+	mu    sync.Mutex
+	dials int
+}
+
+func (d *flakyDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.mu.Lock()
+	d.dials++
+	fail := d.dials <= d.failures
+	d.mu.Unlock()
+	if fail {
+		return nil, d.err
+	}
+	var nd net.Dialer
+	return nd.DialContext(ctx, network, address)
+}
+
+func TestDialRetry_Client(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+
+	testCases := map[string]struct {
+		dialer    *flakyDialer
+		wantErr   bool
+		wantDials int
+	}{
+		"transient error": {
+			dialer:    &flakyDialer{failures: 2, err: refused},
+			wantDials: 3,
+		},
+		"too many transient errors": {
+			dialer:    &flakyDialer{failures: 4, err: refused},
+			wantErr:   true,
+			wantDials: 3,
+		},
+		"denied": {
+			dialer:    &flakyDialer{failures: 1, err: fmt.Errorf("destination: %w", policy.ErrDenied)},
+			wantErr:   true,
+			wantDials: 1,
+		},
+		"not found": {
+			dialer:    &flakyDialer{failures: 1, err: &net.DNSError{Err: "no such host", Name: "web", IsNotFound: true}},
+			wantErr:   true,
+			wantDials: 1,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			testClient := &Client{
+				cs:     &ClientSet{dialRetries: 2, dialRetryBackoff: time.Millisecond},
+				dialer: tc.dialer,
+			}
+			conn, err := testClient.dial("tcp", ts.Listener.Addr().String(), 0)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expect error %v; got %v", tc.wantErr, err)
+			}
+			if conn != nil {
+				conn.Close()
+			}
+			if tc.dialer.dials != tc.wantDials {
+				t.Errorf("expect %d dials; got %d", tc.wantDials, tc.dialer.dials)
+			}
+		})
+	}
+
+	// The retries stop at the dial timeout.
+	dialer := &flakyDialer{failures: 100, err: refused}
+	testClient := &Client{
+		cs:     &ClientSet{dialRetries: 100, dialRetryBackoff: 20 * time.Millisecond},
+		dialer: dialer,
+	}
+	start := time.Now()
+	if _, err := testClient.dial("tcp", ts.Listener.Addr().String(), 100*time.Millisecond); err == nil {
+		t.Fatal("expect the dial to fail")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expect the retries to stop at the 100ms dial timeout; took %v", elapsed)
+	}
+	if dialer.dials >= 100 {
+		t.Errorf("expect the retries to stop at the dial timeout; got %d dials", dialer.dials)
+	}
+}
+
+func TestDialErrorCode(t *testing.T) {
+	testCases := map[string]struct {
+		err  error
+		want client.DialErrorCode
+	}{
+		"refused": {
+			err:  &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+			want: client.DialErrorCode_DIAL_ERROR_CONNECTION_REFUSED,
+		},
+		"host unreachable": {
+			err:  &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.EHOSTUNREACH)},
+			want: client.DialErrorCode_DIAL_ERROR_HOST_UNREACHABLE,
+		},
+		"network unreachable": {
+			err:  &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ENETUNREACH)},
+			want: client.DialErrorCode_DIAL_ERROR_NETWORK_UNREACHABLE,
+		},
+		"not found": {
+			err:  &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "web", IsNotFound: true}},
+			want: client.DialErrorCode_DIAL_ERROR_NO_SUCH_HOST,
+		},
+		"lookup timeout": {
+			err:  &net.DNSError{Err: "i/o timeout", Name: "web", IsTimeout: true},
+			want: client.DialErrorCode_DIAL_ERROR_TIMEOUT,
+		},
+		"deadline exceeded": {
+			err:  fmt.Errorf("dial tcp: %w", context.DeadlineExceeded),
+			want: client.DialErrorCode_DIAL_ERROR_TIMEOUT,
+		},
+		"denied": {
+			err:  fmt.Errorf("destination: %w", policy.ErrDenied),
+			want: client.DialErrorCode_DIAL_ERROR_DENIED,
+		},
+		"unknown": {
+			err:  errors.New("proxy returned 502 Bad Gateway"),
+			want: client.DialErrorCode_DIAL_ERROR_UNSPECIFIED,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if got := dialErrorCode(tc.err); got != tc.want {
+				t.Errorf("expect %v; got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestTracing_Client(t *testing.T) {
+	var stream agent.AgentService_ConnectClient
+	stopCh := make(chan struct{})
+	cs := &ClientSet{
+		clients: make(map[string]*Client),
+		stopCh:  stopCh,
+	}
+	exporter := &tracing.InMemoryExporter{}
+	testClient := &Client{
+		connManager: newConnectionManager(),
+		stopCh:      stopCh,
+		cs:          cs,
+		agentID:     "agent1",
+		tracer:      tracing.NewTracer("proxy-agent", exporter),
+	}
+	testClient.stream, stream = pipe()
+
+	// Start agent
+	go testClient.Serve()
+	defer close(stopCh)
+
+	// Start test http server as remote service
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello, world")
+	}))
+	defer ts.Close()
+
+	// Simulate sending KAS DIAL_REQ, carrying the trace context of the server, to (Agent) Client
+	parent := tracing.ParseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", "")
+	dialPacket := newDialPacket("tcp", ts.URL[len("http://"):], 111)
+	dialPacket.GetDialRequest().Traceparent = parent.Traceparent()
+	if err := stream.Send(dialPacket); err != nil {
+		t.Fatal(err)
+	}
+
+	pkt, _ := stream.Recv()
+	if pkt == nil || pkt.Type != client.PacketType_DIAL_RSP {
+		t.Fatalf("expect PacketType_DIAL_RSP; got %v", pkt)
+	}
+	connID := pkt.GetDialResponse().ConnectID
+
+	if err := stream.Send(newClosePacket(connID)); err != nil {
+		t.Fatal(err)
+	}
+	pkt, _ = stream.Recv()
+	if pkt == nil || pkt.Type != client.PacketType_CLOSE_RSP {
+		t.Fatalf("expect PacketType_CLOSE_RSP; got %v", pkt)
+	}
+
+	// The connection span ends once the remote connection is closed.
+	var spans []tracing.SpanData
+	err := wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		spans = exporter.Spans()
+		return len(spans) == 2, nil
+	})
+	if err != nil {
+		t.Fatalf("expect 2 spans; got %+v", spans)
+	}
+	for i, name := range []string{"proxy-agent.Dial", "proxy-agent.Connection"} {
+		span := spans[i]
+		if span.Name != name || span.Parent != parent.SpanID || span.SpanContext.TraceID != parent.TraceID {
+			t.Errorf("unexpected span %+v", span)
+		}
+		if span.Attributes["connectionID"] != fmt.Sprint(connID) || span.Attributes["agentID"] != "agent1" {
+			t.Errorf("unexpected attributes of span %s: %v", span.Name, span.Attributes)
+		}
+	}
+}
+
+func TestServerInfo_Client(t *testing.T) {
+	var stream agent.AgentService_ConnectClient
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	cs := &ClientSet{
+		clients:     make(map[string]*Client),
+		serverCount: 2,
+		stopCh:      stopCh,
+	}
+	testClient := &Client{
+		connManager: newConnectionManager(),
+		stopCh:      stopCh,
+		cs:          cs,
+		serverID:    "server-c",
+	}
+	testClient.stream, stream = pipe()
+	// The clients of server-a and server-b are never connected.
+	newIdleClient := func(serverID string) *Client {
+		conn, err := grpc.Dial("127.0.0.1:1", grpc.WithInsecure())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Client{connManager: newConnectionManager(), conn: conn, stopCh: make(chan struct{}), serverID: serverID, cs: cs}
+	}
+	liveClient := newIdleClient("server-a")
+	defer liveClient.Close()
+	staleClient := newIdleClient("server-b")
+	cs.clients["server-a"] = liveClient
+	cs.clients["server-b"] = staleClient
+
+	go testClient.Serve()
+
+	// report sends a SERVER_INFO, and waits for the client to handle it.
+	report := func(serverIDs ...string) {
+		t.Helper()
+		err := stream.Send(&client.Packet{
+			Type: client.PacketType_SERVER_INFO,
+			Payload: &client.Packet_ServerInfo{ServerInfo: &client.ServerInfo{
+				ServerCount: 2,
+				ServerIDs:   serverIDs,
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = stream.Send(&client.Packet{
+			Type:    client.PacketType_PING,
+			Payload: &client.Packet_Ping{Ping: &client.Ping{Id: 1}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pkt, err := stream.Recv(); err != nil || pkt.Type != client.PacketType_PONG {
+			t.Fatalf("expect PacketType_PONG; got %v, %v", pkt, err)
+		}
+	}
+
+	// server-b left, and the reporting server-c joined. A server missing
+	// from a lagging view is kept...
+	for i := 1; i < staleServerReports; i++ {
+		report("server-a", "server-c")
+	}
+	report("server-a", "server-b", "server-c")
+	for i := 1; i < staleServerReports; i++ {
+		report("server-a", "server-c")
+	}
+	if !cs.HasID("server-b") {
+		t.Fatal("expected the client of server-b to be kept until it is missing from consecutive reports")
+	}
+
+	// ...until it is missing from staleServerReports consecutive reports.
+	report("server-a", "server-c")
+	err := wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		return !cs.HasID("server-b"), nil
+	})
+	if err != nil {
+		t.Fatal("expected the client of server-b to be removed")
+	}
+	select {
+	case <-staleClient.stopCh:
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Error("expected the client of server-b to be closed")
+	}
+	if !cs.HasID("server-a") {
+		t.Error("expected the client of server-a to be kept")
+	}
+	if got := cs.ServerCount(); got != 2 {
+		t.Errorf("expected a server count of 2, got %d", got)
+	}
+}
+
+func TestServerInfo_ClientReporters(t *testing.T) {
+	cs := &ClientSet{clients: make(map[string]*Client)}
+	conn, err := grpc.Dial("127.0.0.1:1", grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	staleClient := &Client{connManager: newConnectionManager(), conn: conn, stopCh: make(chan struct{}), serverID: "server-b", cs: cs}
+	cs.clients["server-b"] = staleClient
+
+	cs.updateServers("server-a", 2, []string{"server-a", "server-c"})
+	if !cs.HasID("server-b") {
+		t.Fatal("expected the client of server-b to be kept after a single report")
+	}
+	// A second server agrees that server-b left.
+	cs.updateServers("server-c", 2, []string{"server-a", "server-c"})
+	if cs.HasID("server-b") {
+		t.Error("expected the client of server-b to be removed")
+	}
+	select {
+	case <-staleClient.stopCh:
+	default:
+		t.Error("expected the client of server-b to be closed")
+	}
+}
+
+func TestPing_Client(t *testing.T) {
+	var stream agent.AgentService_ConnectClient
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	cs := &ClientSet{
+		clients: make(map[string]*Client),
+		stopCh:  stopCh,
+	}
+	testClient := &Client{
+		connManager: newConnectionManager(),
+		stopCh:      stopCh,
+		cs:          cs,
+	}
+	testClient.stream, stream = pipe()
+
+	go testClient.Serve()
+
+	err := stream.Send(&client.Packet{
+		Type:    client.PacketType_PING,
+		Payload: &client.Packet_Ping{Ping: &client.Ping{Id: 42}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkt, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkt.Type != client.PacketType_PONG {
+		t.Fatalf("expect PacketType_PONG; got %v", pkt.Type)
+	}
+	if id := pkt.GetPong().Id; id != 42 {
+		t.Errorf("expect pong id 42; got %d", id)
+	}
+}
+
+func TestDrain_Client(t *testing.T) {
+	var stream agent.AgentService_ConnectClient
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	cs := &ClientSet{
+		clients: make(map[string]*Client),
+		stopCh:  stopCh,
+	}
+	conn, err := grpc.Dial("127.0.0.1:1", grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testClient := &Client{
+		connManager: newConnectionManager(),
+		stopCh:      make(chan struct{}),
+		cs:          cs,
+		conn:        conn,
+		serverID:    "server-a",
+		// The connection is never ready, so it is not probed.
+		probeInterval: time.Hour,
+	}
+	testClient.stream, stream = pipe()
+	cs.clients["server-a"] = testClient
+
+	go testClient.Serve()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello, world")
+	}))
+	defer ts.Close()
+
+	if err := stream.Send(newDialPacket("tcp", ts.URL[len("http://"):], 111)); err != nil {
+		t.Fatal(err)
+	}
+	pkt, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkt.Type != client.PacketType_DIAL_RSP {
+		t.Fatalf("expect PacketType_DIAL_RSP; got %v", pkt.Type)
+	}
+	connID := pkt.GetDialResponse().ConnectID
+
+	err = stream.Send(&client.Packet{
+		Type:    client.PacketType_DRAIN,
+		Payload: &client.Packet_Drain{Drain: &client.Drain{Reason: "duplicate stream"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The stream is kept while it has a connection...
+	time.Sleep(100 * time.Millisecond)
+	if !cs.HasID("server-a") {
+		t.Fatal("expected the drained client to be kept until its connection is closed")
+	}
+
+	// ...and closed with it.
+	if err := stream.Send(newClosePacket(connID)); err != nil {
+		t.Fatal(err)
+	}
+	if pkt, _ := stream.Recv(); pkt == nil || pkt.Type != client.PacketType_CLOSE_RSP {
+		t.Fatalf("expect PacketType_CLOSE_RSP; got %v", pkt)
+	}
+	err = wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		return !cs.HasID("server-a"), nil
+	})
+	if err != nil {
+		t.Fatal("expected the drained client to be removed")
+	}
+	select {
+	case <-testClient.stopCh:
+	default:
+		t.Error("expected the drained client to be closed")
+	}
+}
+
 func TestConnectionMismatch(t *testing.T) {
 	var stream agent.AgentService_ConnectClient
 	stopCh := make(chan struct{})
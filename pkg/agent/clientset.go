@@ -27,7 +27,11 @@ import (
 	"google.golang.org/grpc/connectivity"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/pkg/common/tracing"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/agent/dns"
 	"sigs.k8s.io/apiserver-network-proxy/pkg/agent/metrics"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/agent/policy"
 )
 
 // ClientSet consists of clients connected to each instance of an HA proxy server.
@@ -40,7 +44,13 @@ type ClientSet struct {
 	address     string // proxy server address. Assuming HA proxy server
 	serverCount int    // number of proxy server instances, should be 1
 	// unless it is an HA server. Initialized when the ClientSet creates
-	// the first client. When syncForever is set, it will be the most recently seen.
+	// the first client, and updated when a server reports a change. Protected
+	// by mu.
+	missing map[string]*missingServer // the connected servers missing from
+	// the live servers reported by the servers, by ID. Protected by mu.
+	lastSync time.Time // The time of the last successful sync, which
+	// either reached a proxy server or found them all connected. Protected
+	// by mu.
 	syncInterval time.Duration // The interval by which the agent
 	// periodically checks that it has connections to all instances of the
 	// proxy server.
@@ -56,11 +66,44 @@ type ClientSet struct {
 	stopCh <-chan struct{}
 
 	agentIdentifiers string // The identifiers of the agent, which will be used
-	// by the server when choosing agent
+	// by the server when choosing agent. Protected by mu.
+
+	// identifiersMu serializes the identifiers sent to the servers, so that
+	// they all end up with the latest.
+	identifiersMu sync.Mutex
 
 	warnOnChannelLimit bool
 
 	syncForever bool // Continue syncing (support dynamic server count).
+
+	// connectionIdleTimeout and connectionMaxLifetime, if positive, limit
+	// the connections to the destinations.
+	connectionIdleTimeout time.Duration
+	connectionMaxLifetime time.Duration
+
+	// dialTimeout is the time the destinations are dialed for, unless the
+	// DIAL_REQ asks for a timeout, bounded by minDialTimeout and
+	// maxDialTimeout, if positive.
+	dialTimeout    time.Duration
+	minDialTimeout time.Duration
+	maxDialTimeout time.Duration
+	// dialRetries is the number of times the dials failing with a transient
+	// error are retried within their timeout, after dialRetryBackoff,
+	// doubled for each retry.
+	dialRetries      int
+	dialRetryBackoff time.Duration
+
+	// destinationPolicy, if set, restricts the destinations which are dialed.
+	destinationPolicy *policy.DestinationPolicy
+	// resolver, if set, resolves the destination host names instead of the
+	// system resolver.
+	resolver *dns.Resolver
+	// dialer connects the clients to the destinations, directly if nil.
+	dialer Dialer
+
+	tracer *tracing.Tracer // records the spans of the dials and connections, if set.
+
+	readinessPolicy ReadinessPolicy
 }
 
 func (cs *ClientSet) ClientsCount() int {
@@ -82,6 +125,81 @@ func (cs *ClientSet) HealthyClientsCount() int {
 
 }
 
+// ServerCount returns the number of proxy server instances last reported by
+// a server, or zero if no server was connected yet.
+func (cs *ClientSet) ServerCount() int {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.serverCount
+}
+
+// staleServerReports is the number of consecutive reports a proxy server
+// must be missing from before its client is drained, unless more than one
+// server reported it missing. The membership views of the servers lag each
+// other, so a single report is not trusted.
+const staleServerReports = 3
+
+// missingServer tracks the reports a proxy server was missing from.
+type missingServer struct {
+	reports   int             // consecutive reports missing the server.
+	reporters map[string]bool // the servers which reported it missing.
+}
+
+// updateServers records the live proxy servers reported by the server
+// reporterID. If their IDs are known, the clients connected to servers
+// missing from staleServerReports consecutive reports, or from the reports
+// of more than one server, are drained; missing servers are connected to by
+// the sync loop.
+func (cs *ClientSet) updateServers(reporterID string, serverCount int, serverIDs []string) {
+	var stale []*Client
+	cs.mu.Lock()
+	if cs.serverCount != 0 && cs.serverCount != serverCount {
+		klog.V(2).InfoS("Server count change suggestion by server",
+			"current", cs.serverCount, "serverID", reporterID, "actual", serverCount)
+	}
+	cs.serverCount = serverCount
+	if len(serverIDs) > 0 {
+		live := make(map[string]bool, len(serverIDs))
+		for _, id := range serverIDs {
+			live[id] = true
+		}
+		if cs.missing == nil {
+			cs.missing = make(map[string]*missingServer)
+		}
+		for id, c := range cs.clients {
+			if live[id] || id == reporterID {
+				delete(cs.missing, id)
+				continue
+			}
+			if c.draining.Load() {
+				continue
+			}
+			m := cs.missing[id]
+			if m == nil {
+				m = &missingServer{reporters: make(map[string]bool)}
+				cs.missing[id] = m
+			}
+			m.reports++
+			m.reporters[reporterID] = true
+			if m.reports >= staleServerReports || len(m.reporters) > 1 {
+				stale = append(stale, c)
+				delete(cs.missing, id)
+			}
+		}
+		for id := range cs.missing {
+			if _, ok := cs.clients[id]; !ok {
+				delete(cs.missing, id)
+			}
+		}
+	}
+	cs.mu.Unlock()
+
+	for _, c := range stale {
+		klog.V(2).InfoS("Draining client of a proxy server which is no longer live", "serverID", c.serverID, "reportedBy", reporterID)
+		c.drain()
+	}
+}
+
 func (cs *ClientSet) hasIDLocked(serverID string) bool {
 	_, ok := cs.clients[serverID]
 	return ok
@@ -128,6 +246,18 @@ func (cs *ClientSet) RemoveClient(serverID string) {
 	metrics.Metrics.SetServerConnectionsCount(len(cs.clients))
 }
 
+// removeClient removes and closes c, unless it was already removed.
+func (cs *ClientSet) removeClient(c *Client) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.clients[c.serverID] != c {
+		return
+	}
+	c.Close()
+	delete(cs.clients, c.serverID)
+	metrics.Metrics.SetServerConnectionsCount(len(cs.clients))
+}
+
 type ClientSetConfig struct {
 	Address                 string
 	AgentID                 string
@@ -139,6 +269,34 @@ type ClientSetConfig struct {
 	ServiceAccountTokenPath string
 	WarnOnChannelLimit      bool
 	SyncForever             bool
+	// ConnectionIdleTimeout and ConnectionMaxLifetime, if positive, close
+	// the connections to the destinations with no data in either direction
+	// for ConnectionIdleTimeout, or open for ConnectionMaxLifetime.
+	ConnectionIdleTimeout time.Duration
+	ConnectionMaxLifetime time.Duration
+	// DialTimeout is the time the destinations are dialed for, unless the
+	// DIAL_REQ asks for a timeout, bounded by MinDialTimeout and
+	// MaxDialTimeout, if positive. The default is 5 seconds.
+	DialTimeout    time.Duration
+	MinDialTimeout time.Duration
+	MaxDialTimeout time.Duration
+	// DialRetries is the number of times the dials failing with a transient
+	// error, e.g. a refused connection, are retried within their timeout,
+	// after DialRetryBackoff, doubled for each retry.
+	DialRetries      int
+	DialRetryBackoff time.Duration
+	// DestinationPolicy, if set, restricts the destinations which are
+	// dialed; all are allowed otherwise.
+	DestinationPolicy *policy.DestinationPolicy
+	// Resolver, if set, resolves the destination host names instead of the
+	// system resolver.
+	Resolver *dns.Resolver
+	// Dialer, if set, connects to the destinations, e.g. through an
+	// upstream proxy; the destinations are dialed directly otherwise.
+	Dialer Dialer
+	Tracer *tracing.Tracer
+	// ReadinessPolicy configures when the agent is ready.
+	ReadinessPolicy ReadinessPolicy
 }
 
 func (cc *ClientSetConfig) NewAgentClientSet(stopCh <-chan struct{}) *ClientSet {
@@ -154,12 +312,24 @@ func (cc *ClientSetConfig) NewAgentClientSet(stopCh <-chan struct{}) *ClientSet
 		serviceAccountTokenPath: cc.ServiceAccountTokenPath,
 		warnOnChannelLimit:      cc.WarnOnChannelLimit,
 		syncForever:             cc.SyncForever,
+		connectionIdleTimeout:   cc.ConnectionIdleTimeout,
+		connectionMaxLifetime:   cc.ConnectionMaxLifetime,
+		dialTimeout:             cc.DialTimeout,
+		minDialTimeout:          cc.MinDialTimeout,
+		maxDialTimeout:          cc.MaxDialTimeout,
+		dialRetries:             cc.DialRetries,
+		dialRetryBackoff:        cc.DialRetryBackoff,
+		destinationPolicy:       cc.DestinationPolicy,
+		resolver:                cc.Resolver,
+		dialer:                  cc.Dialer,
+		tracer:                  cc.Tracer,
+		readinessPolicy:         cc.ReadinessPolicy,
 		stopCh:                  stopCh,
 	}
 }
 
 func (cs *ClientSet) newAgentClient() (*Client, int, error) {
-	return newAgentClient(cs.address, cs.agentID, cs.agentIdentifiers, cs, cs.dialOptions...)
+	return newAgentClient(cs.address, cs.agentID, cs.AgentIdentifiers(), cs, cs.dialOptions...)
 }
 
 func (cs *ClientSet) resetBackoff() *wait.Backoff {
@@ -180,8 +350,10 @@ func (cs *ClientSet) sync() {
 	for {
 		if err := cs.connectOnce(); err != nil {
 			if dse, ok := err.(*DuplicateServerError); ok {
-				klog.V(4).InfoS("duplicate server", "serverID", dse.ServerID, "serverCount", cs.serverCount, "clientsCount", cs.ClientsCount())
-				if cs.serverCount != 0 && cs.ClientsCount() >= cs.serverCount {
+				cs.observeSync()
+				serverCount := cs.ServerCount()
+				klog.V(4).InfoS("duplicate server", "serverID", dse.ServerID, "serverCount", serverCount, "clientsCount", cs.ClientsCount())
+				if serverCount != 0 && cs.ClientsCount() >= serverCount {
 					duration = backoff.Step()
 				}
 			} else {
@@ -189,6 +361,7 @@ func (cs *ClientSet) sync() {
 				duration = backoff.Step()
 			}
 		} else {
+			cs.observeSync()
 			backoff = cs.resetBackoff()
 			duration = wait.Jitter(backoff.Duration, backoff.Jitter)
 		}
@@ -202,27 +375,23 @@ func (cs *ClientSet) sync() {
 }
 
 func (cs *ClientSet) connectOnce() error {
-	if !cs.syncForever && cs.serverCount != 0 && cs.ClientsCount() >= cs.serverCount {
+	if serverCount := cs.ServerCount(); !cs.syncForever && serverCount != 0 && cs.ClientsCount() >= serverCount {
 		return nil
 	}
 	c, serverCount, err := cs.newAgentClient()
 	if err != nil {
 		return err
 	}
-	if cs.serverCount != 0 && cs.serverCount != serverCount {
-		klog.V(2).InfoS("Server count change suggestion by server",
-			"current", cs.serverCount, "serverID", c.serverID, "actual", serverCount)
-
-	}
-	cs.serverCount = serverCount
+	cs.updateServers(c.serverID, serverCount, c.serverIDs)
 	if err := cs.AddClient(c.serverID, c); err != nil {
 		c.Close()
 		return err
 	}
 	klog.V(2).InfoS("sync added client connecting to proxy server", "serverID", c.serverID)
+	cs.sendIdentifiers(c)
 
 	labels := runpprof.Labels(
-		"agentIdentifiers", cs.agentIdentifiers,
+		"agentIdentifiers", c.agentIdentifiers,
 		"serverAddress", cs.address,
 		"serverID", c.serverID,
 	)
@@ -232,7 +401,7 @@ func (cs *ClientSet) connectOnce() error {
 
 func (cs *ClientSet) Serve() {
 	labels := runpprof.Labels(
-		"agentIdentifiers", cs.agentIdentifiers,
+		"agentIdentifiers", cs.AgentIdentifiers(),
 		"serverAddress", cs.address,
 	)
 	go runpprof.Do(context.Background(), labels, func(context.Context) { cs.sync() })
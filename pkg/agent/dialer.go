@@ -0,0 +1,56 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"context"
+	"net"
+
+	"sigs.k8s.io/apiserver-network-proxy/pkg/agent/dns"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/agent/policy"
+)
+
+// Dialer connects the agent to the destinations of the DIAL_REQs. The
+// destination policy is checked before dialing.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// NewDirectDialer returns the Dialer connecting to the destinations
+// directly. The IPs dialed are checked against the destination policy, if
+// set, and the host names are resolved by resolver, if set, or the system
+// resolver.
+func NewDirectDialer(destinationPolicy *policy.DestinationPolicy, resolver *dns.Resolver) Dialer {
+	return &directDialer{destinationPolicy: destinationPolicy, resolver: resolver}
+}
+
+type directDialer struct {
+	destinationPolicy *policy.DestinationPolicy
+	resolver          *dns.Resolver
+}
+
+func (d *directDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{}
+	if d.destinationPolicy != nil {
+		// The IP a host name resolves to is checked before connecting to it.
+		dialer.Control = d.destinationPolicy.Control(address)
+	}
+	if d.resolver != nil {
+		return d.resolver.Dial(ctx, dialer, network, address)
+	}
+	return dialer.DialContext(ctx, network, address)
+}
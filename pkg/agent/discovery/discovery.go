@@ -0,0 +1,364 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package discovery discovers the identifiers of the agent, e.g. the
+// addresses of its node, instead of them being configured.
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/apiserver-network-proxy/pkg/agent"
+)
+
+// refreshTimeout limits a refresh of the identifiers.
+const refreshTimeout = 30 * time.Second
+
+// Config configures a Discoverer.
+type Config struct {
+	// Interfaces discovers the addresses of the local network interfaces
+	// which are up, as ipv4 and ipv6 identifiers. The loopback and
+	// link-local addresses are never discovered.
+	Interfaces bool
+	// InterfaceInclude and InterfaceExclude filter the interface
+	// addresses. Each entry is an interface name, which may contain
+	// wildcards, e.g. "eth*", or a CIDR. An address is discovered if it
+	// matches an entry of InterfaceInclude, or if it is empty, and no entry
+	// of InterfaceExclude.
+	InterfaceInclude []string
+	InterfaceExclude []string
+	// Hostname discovers the host name, and FQDN its fully qualified domain
+	// name, as host identifiers.
+	Hostname bool
+	FQDN     bool
+	// NodeName, if set, discovers the addresses of the Kubernetes Node,
+	// as ipv4, ipv6 and host identifiers, and its pod CIDRs, as cidr
+	// identifiers, through KubernetesClient.
+	NodeName         string
+	KubernetesClient kubernetes.Interface
+}
+
+// Discoverer discovers the identifiers of the agent from the configured
+// sources, and merges them with the static ones. A source which fails to
+// refresh keeps the identifiers it last discovered.
+type Discoverer struct {
+	sources []source
+
+	// interfaceAddrs, hostname and lookupCNAME query the system;
+	// overridden in tests.
+	interfaceAddrs func() ([]interfaceAddr, error)
+	hostname       func() (string, error)
+	lookupCNAME    func(ctx context.Context, host string) (string, error)
+
+	mu         sync.Mutex // protects the following
+	static     url.Values
+	discovered map[string]url.Values // by source name
+}
+
+type source struct {
+	name     string
+	discover func(ctx context.Context) (url.Values, error)
+}
+
+// interfaceAddr is an address of a network interface.
+type interfaceAddr struct {
+	name string
+	ip   net.IP
+}
+
+// filter is an entry of Config.InterfaceInclude or InterfaceExclude.
+type filter struct {
+	pattern string     // interface name pattern, if ipNet is nil
+	ipNet   *net.IPNet // CIDR
+}
+
+// NewDiscoverer returns a Discoverer, with no static identifiers. It fails
+// if the configuration is invalid.
+func NewDiscoverer(cfg Config) (*Discoverer, error) {
+	include, err := parseFilters(cfg.InterfaceInclude)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := parseFilters(cfg.InterfaceExclude)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.NodeName != "" && cfg.KubernetesClient == nil {
+		return nil, fmt.Errorf("discovering the identifiers of node %s requires a Kubernetes client", cfg.NodeName)
+	}
+	d := &Discoverer{
+		interfaceAddrs: systemInterfaceAddrs,
+		hostname:       os.Hostname,
+		lookupCNAME:    net.DefaultResolver.LookupCNAME,
+		discovered:     make(map[string]url.Values),
+	}
+	if cfg.Interfaces {
+		d.sources = append(d.sources, source{"interfaces", func(context.Context) (url.Values, error) {
+			return d.discoverInterfaces(include, exclude)
+		}})
+	}
+	if cfg.Hostname || cfg.FQDN {
+		d.sources = append(d.sources, source{"hostname", func(ctx context.Context) (url.Values, error) {
+			return d.discoverHostname(ctx, cfg.Hostname, cfg.FQDN)
+		}})
+	}
+	if cfg.NodeName != "" {
+		d.sources = append(d.sources, source{"node", func(ctx context.Context) (url.Values, error) {
+			return discoverNode(ctx, cfg.KubernetesClient, cfg.NodeName)
+		}})
+	}
+	return d, nil
+}
+
+// ValidateInterfaceFilters checks the entries of Config.InterfaceInclude or
+// InterfaceExclude.
+func ValidateInterfaceFilters(entries []string) error {
+	_, err := parseFilters(entries)
+	return err
+}
+
+func parseFilters(entries []string) ([]filter, error) {
+	var filters []filter
+	for _, entry := range entries {
+		if strings.Contains(entry, "/") {
+			_, ipNet, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid interface filter %q: %v", entry, err)
+			}
+			filters = append(filters, filter{ipNet: ipNet})
+			continue
+		}
+		if _, err := filepath.Match(entry, ""); err != nil || entry == "" {
+			return nil, fmt.Errorf("invalid interface filter %q, expected an interface name or a CIDR", entry)
+		}
+		filters = append(filters, filter{pattern: entry})
+	}
+	return filters, nil
+}
+
+func (f filter) matches(a interfaceAddr) bool {
+	if f.ipNet != nil {
+		return f.ipNet.Contains(a.ip)
+	}
+	matched, _ := filepath.Match(f.pattern, a.name)
+	return matched
+}
+
+// SetStatic sets the static identifiers, in the url encoded format of
+// agent.GenAgentIdentifiers, which the discovered ones are merged with.
+func (d *Discoverer) SetStatic(identifiers string) error {
+	if _, err := agent.GenAgentIdentifiers(identifiers); err != nil {
+		return err
+	}
+	static, _ := url.ParseQuery(identifiers)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.static = static
+	return nil
+}
+
+// Refresh discovers the identifiers from every source. The sources which
+// fail keep the identifiers they last discovered.
+func (d *Discoverer) Refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+	defer cancel()
+	for _, s := range d.sources {
+		values, err := s.discover(ctx)
+		if err != nil {
+			klog.ErrorS(err, "Failed to discover the agent identifiers, keeping the previous ones", "source", s.name)
+			continue
+		}
+		d.mu.Lock()
+		d.discovered[s.name] = values
+		d.mu.Unlock()
+	}
+}
+
+// Identifiers returns the static identifiers merged with the discovered
+// ones, in the url encoded format of agent.GenAgentIdentifiers.
+func (d *Discoverer) Identifiers() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	merged := make(url.Values)
+	add := func(values url.Values) {
+		for idType, ids := range values {
+			for _, id := range ids {
+				if !contains(merged[idType], id) {
+					merged.Add(idType, id)
+				}
+			}
+		}
+	}
+	add(d.static)
+	for _, s := range d.sources {
+		add(d.discovered[s.name])
+	}
+	return merged.Encode()
+}
+
+// Run refreshes the identifiers every interval, until stopCh is closed, and
+// calls onChange with the merged identifiers when they change, e.g.
+// ClientSet.SetAgentIdentifiers.
+func (d *Discoverer) Run(interval time.Duration, stopCh <-chan struct{}, onChange func(identifiers string) error) {
+	last := d.Identifiers()
+	wait.Until(func() {
+		d.Refresh()
+		identifiers := d.Identifiers()
+		if identifiers == last {
+			return
+		}
+		if err := onChange(identifiers); err != nil {
+			klog.ErrorS(err, "Failed to update the discovered agent identifiers")
+			return
+		}
+		last = identifiers
+	}, interval, stopCh)
+}
+
+func (d *Discoverer) discoverInterfaces(include, exclude []filter) (url.Values, error) {
+	addrs, err := d.interfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	values := make(url.Values)
+	for _, a := range addrs {
+		if a.ip.IsLoopback() || a.ip.IsLinkLocalUnicast() {
+			continue
+		}
+		if len(include) > 0 && !matchesAny(include, a) {
+			continue
+		}
+		if matchesAny(exclude, a) {
+			continue
+		}
+		addIP(values, a.ip)
+	}
+	return values, nil
+}
+
+func (d *Discoverer) discoverHostname(ctx context.Context, hostname, fqdn bool) (url.Values, error) {
+	name, err := d.hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the host name: %v", err)
+	}
+	values := make(url.Values)
+	if hostname {
+		values.Add(string(agent.Host), name)
+	}
+	if fqdn {
+		cname, err := d.lookupCNAME(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the fully qualified domain name of %s: %v", name, err)
+		}
+		if cname = strings.TrimSuffix(cname, "."); !contains(values[string(agent.Host)], cname) {
+			values.Add(string(agent.Host), cname)
+		}
+	}
+	return values, nil
+}
+
+func discoverNode(ctx context.Context, client kubernetes.Interface, nodeName string) (url.Values, error) {
+	node, err := client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node %s: %v", nodeName, err)
+	}
+	values := make(url.Values)
+	for _, a := range node.Status.Addresses {
+		switch a.Type {
+		case corev1.NodeInternalIP, corev1.NodeExternalIP:
+			if ip := net.ParseIP(a.Address); ip != nil {
+				addIP(values, ip)
+			}
+		case corev1.NodeHostName, corev1.NodeInternalDNS, corev1.NodeExternalDNS:
+			if !contains(values[string(agent.Host)], a.Address) {
+				values.Add(string(agent.Host), a.Address)
+			}
+		}
+	}
+	podCIDRs := node.Spec.PodCIDRs
+	if len(podCIDRs) == 0 && node.Spec.PodCIDR != "" {
+		podCIDRs = []string{node.Spec.PodCIDR}
+	}
+	for _, cidr := range podCIDRs {
+		values.Add(string(agent.CIDR), cidr)
+	}
+	return values, nil
+}
+
+func systemInterfaceAddrs() ([]interfaceAddr, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the network interfaces: %v", err)
+	}
+	var addrs []interfaceAddr
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		ifaceAddrs, err := iface.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the addresses of interface %s: %v", iface.Name, err)
+		}
+		for _, a := range ifaceAddrs {
+			if ipNet, ok := a.(*net.IPNet); ok {
+				addrs = append(addrs, interfaceAddr{name: iface.Name, ip: ipNet.IP})
+			}
+		}
+	}
+	return addrs, nil
+}
+
+func addIP(values url.Values, ip net.IP) {
+	idType, id := agent.IPv6, ip.String()
+	if ip.To4() != nil {
+		idType = agent.IPv4
+	}
+	if !contains(values[string(idType)], id) {
+		values.Add(string(idType), id)
+	}
+}
+
+func matchesAny(filters []filter, a interfaceAddr) bool {
+	for _, f := range filters {
+		if f.matches(a) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
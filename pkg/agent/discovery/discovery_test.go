@@ -0,0 +1,125 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDiscoverer(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Spec:       corev1.NodeSpec{PodCIDRs: []string{"10.244.1.0/24", "fd00:1::/64"}},
+		Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+			{Type: corev1.NodeInternalIP, Address: "10.0.0.1"},
+			{Type: corev1.NodeExternalIP, Address: "203.0.113.1"},
+			{Type: corev1.NodeHostName, Address: "node1"},
+		}},
+	}
+	client := fake.NewSimpleClientset(node)
+	d, err := NewDiscoverer(Config{
+		Interfaces:       true,
+		InterfaceInclude: []string{"eth*", "192.168.0.0/16"},
+		InterfaceExclude: []string{"10.0.1.0/24"},
+		Hostname:         true,
+		FQDN:             true,
+		NodeName:         "node1",
+		KubernetesClient: client,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.interfaceAddrs = func() ([]interfaceAddr, error) {
+		return []interfaceAddr{
+			{name: "eth0", ip: net.ParseIP("10.0.0.1")},
+			{name: "eth0", ip: net.ParseIP("fe80::1")},
+			{name: "eth0", ip: net.ParseIP("2001:db8::1")},
+			{name: "eth1", ip: net.ParseIP("10.0.1.1")},
+			{name: "wlan0", ip: net.ParseIP("192.168.1.10")},
+			{name: "docker0", ip: net.ParseIP("172.17.0.1")},
+		}, nil
+	}
+	d.hostname = func() (string, error) { return "node1", nil }
+	d.lookupCNAME = func(context.Context, string) (string, error) { return "node1.example.com.", nil }
+
+	if err := d.SetStatic("host=static.example.com&default-route=true"); err != nil {
+		t.Fatal(err)
+	}
+	d.Refresh()
+	want := "cidr=10.244.1.0%2F24&cidr=fd00%3A1%3A%3A%2F64&default-route=true" +
+		"&host=static.example.com&host=node1&host=node1.example.com" +
+		"&ipv4=10.0.0.1&ipv4=192.168.1.10&ipv4=203.0.113.1&ipv6=2001%3Adb8%3A%3A1"
+	if got := d.Identifiers(); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	// A failing source keeps its previous identifiers.
+	d.hostname = func() (string, error) { return "", errors.New("no host name") }
+	node.Status.Addresses = []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.2"}}
+	node.Spec.PodCIDRs = nil
+	if _, err := client.CoreV1().Nodes().Update(context.Background(), node, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetStatic(""); err != nil {
+		t.Fatal(err)
+	}
+	changes := make(chan string, 1)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go d.Run(10*time.Millisecond, stopCh, func(identifiers string) error {
+		changes <- identifiers
+		return nil
+	})
+	want = "host=node1&host=node1.example.com&ipv4=10.0.0.1&ipv4=192.168.1.10&ipv4=10.0.0.2&ipv6=2001%3Adb8%3A%3A1"
+	select {
+	case got := <-changes:
+		if got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatal("expected the change of identifiers to be reported")
+	}
+}
+
+func TestNewDiscoverer_InvalidConfig(t *testing.T) {
+	for name, cfg := range map[string]Config{
+		"InvalidCIDR":       {Interfaces: true, InterfaceInclude: []string{"10.0.0.0/33"}},
+		"InvalidPattern":    {Interfaces: true, InterfaceExclude: []string{"eth["}},
+		"EmptyFilter":       {Interfaces: true, InterfaceExclude: []string{""}},
+		"NodeWithoutClient": {NodeName: "node1"},
+	} {
+		if _, err := NewDiscoverer(cfg); err == nil {
+			t.Errorf("%s: expected the config to be invalid", name)
+		}
+	}
+	d, err := NewDiscoverer(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetStatic("zone=a"); err == nil {
+		t.Error("expected invalid static identifiers to be rejected")
+	}
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dns
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// defaultFallbackDelay is the delay before racing the connection to the
+// next address, as recommended by RFC 8305.
+const defaultFallbackDelay = 250 * time.Millisecond
+
+// Dial connects to address with dialer. If the host of address is a name,
+// it is resolved by r, and the connections to its addresses are raced
+// ("happy eyeballs"): they are attempted in turn, alternating the address
+// families, and the next attempt starts when the previous one fails or
+// after a short delay, while the previous ones continue. The first
+// established connection is returned.
+func (r *Resolver) Dial(ctx context.Context, dialer *net.Dialer, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6":
+	default:
+		return dialer.DialContext(ctx, network, address)
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) != nil {
+		return dialer.DialContext(ctx, network, address)
+	}
+	ips, err := r.LookupIP(ctx, network, host)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(ips))
+	for _, ip := range interleave(ips) {
+		addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+	}
+	// Connecting to a UDP address doesn't tell whether it is reachable.
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return dialer.DialContext(ctx, network, addrs[0])
+	}
+	return r.race(ctx, dialer, network, addrs)
+}
+
+// race returns the first connection established to one of addrs.
+func (r *Resolver) race(ctx context.Context, dialer *net.Dialer, network string, addrs []string) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	// Buffered, so that the attempts which lose the race don't block.
+	results := make(chan result, len(addrs))
+	next, pending := 0, 0
+	var fallback <-chan time.Time
+	attempt := func() {
+		addr := addrs[next]
+		next++
+		pending++
+		go func() {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			results <- result{conn, err}
+		}()
+		fallback = nil
+		if next < len(addrs) {
+			fallback = time.After(r.fallbackDelay)
+		}
+	}
+
+	attempt()
+	var firstErr error
+	for pending > 0 {
+		select {
+		case <-fallback:
+			attempt()
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				// Close the connections established by the other attempts,
+				// which are canceled when returning.
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if res := <-results; res.conn != nil {
+							res.conn.Close()
+						}
+					}
+				}(pending)
+				return res.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			if next < len(addrs) {
+				attempt()
+			}
+		}
+	}
+	return nil, firstErr
+}
+
+// interleave orders ips alternating the address families, starting with
+// the family of the first one.
+func interleave(ips []net.IP) []net.IP {
+	if len(ips) == 0 {
+		return ips
+	}
+	var first, second []net.IP
+	for _, ip := range ips {
+		if (ip.To4() != nil) == (ips[0].To4() != nil) {
+			first = append(first, ip)
+		} else {
+			second = append(second, ip)
+		}
+	}
+	ordered := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			ordered = append(ordered, first[i])
+		}
+		if i < len(second) {
+			ordered = append(ordered, second[i])
+		}
+	}
+	return ordered
+}
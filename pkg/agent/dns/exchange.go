@@ -0,0 +1,171 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// maxUDPSize is the largest response read over UDP; truncated responses
+// are retried over TCP.
+const maxUDPSize = 512
+
+// exchange asks server for the addresses of q, returning them with the
+// time they may be cached for, the smallest TTL of the answers.
+func exchange(ctx context.Context, server string, q question) ([]net.IP, time.Duration, error) {
+	id := uint16(rand.Uint32())
+	query, err := newQuery(id, q)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := exchangeUDP(ctx, server, id, query)
+	if err == nil && resp.Truncated {
+		resp, err = exchangeTCP(ctx, server, id, query)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return parseAnswers(resp, q)
+}
+
+func newQuery(id uint16, q question) ([]byte, error) {
+	name, err := dnsmessage.NewName(q.name + ".")
+	if err != nil {
+		return nil, fmt.Errorf("invalid name %q: %v", q.name, err)
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: name, Type: q.qtype, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+func exchangeUDP(ctx context.Context, server string, id uint16, query []byte) (*dnsmessage.Message, error) {
+	conn, err := dialServer(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxUDPSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// Ignore the messages which don't answer the query.
+		var p dnsmessage.Parser
+		h, err := p.Start(buf[:n])
+		if err != nil || h.ID != id || !h.Response {
+			continue
+		}
+		if h.Truncated {
+			return &dnsmessage.Message{Header: h}, nil
+		}
+		resp := &dnsmessage.Message{}
+		if err := resp.Unpack(buf[:n]); err != nil {
+			return nil, fmt.Errorf("invalid response from %s: %v", server, err)
+		}
+		return resp, nil
+	}
+}
+
+func exchangeTCP(ctx context.Context, server string, id uint16, query []byte) (*dnsmessage.Message, error) {
+	conn, err := dialServer(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	resp := &dnsmessage.Message{}
+	if err := resp.Unpack(buf); err != nil {
+		return nil, fmt.Errorf("invalid response from %s: %v", server, err)
+	}
+	if resp.ID != id || !resp.Response {
+		return nil, fmt.Errorf("unexpected response from %s", server)
+	}
+	return resp, nil
+}
+
+func dialServer(ctx context.Context, network, server string) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	return conn, nil
+}
+
+// parseAnswers returns the addresses answering q in resp, and the smallest
+// TTL of the answers.
+func parseAnswers(resp *dnsmessage.Message, q question) ([]net.IP, time.Duration, error) {
+	switch resp.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, 0, errNotFound
+	default:
+		return nil, 0, fmt.Errorf("server failure: %v", resp.RCode)
+	}
+	var ips []net.IP
+	var ttl uint32
+	for i, rr := range resp.Answers {
+		if i == 0 || rr.Header.TTL < ttl {
+			ttl = rr.Header.TTL
+		}
+		switch body := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			if q.qtype == dnsmessage.TypeA {
+				ips = append(ips, net.IP(body.A[:]))
+			}
+		case *dnsmessage.AAAAResource:
+			if q.qtype == dnsmessage.TypeAAAA {
+				ips = append(ips, net.IP(body.AAAA[:]))
+			}
+		}
+	}
+	return ips, time.Duration(ttl) * time.Second, nil
+}
@@ -0,0 +1,348 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package dns resolves the host names of the destinations the agent dials.
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"sigs.k8s.io/apiserver-network-proxy/pkg/agent/metrics"
+)
+
+// defaultTimeout limits the lookups which have no deadline.
+const defaultTimeout = 5 * time.Second
+
+// errNotFound is the error of the names which don't exist, or have no
+// address.
+var errNotFound = errors.New("no such host")
+
+// Config configures a Resolver.
+type Config struct {
+	// Servers are the DNS servers, as host[:port], or <domain>=host[:port]
+	// for the names in domain. The server of the longest matching domain
+	// resolves a name. The system resolver is used if empty.
+	Servers []string
+	// Search are the domains tried, in order, for the names without dots,
+	// before the name itself.
+	Search []string
+	// HostsFile is a file in the /etc/hosts format, overriding the
+	// resolution of the names it lists.
+	HostsFile string
+	// CacheMaxTTL, if positive, caches the answers of Servers for their
+	// TTL, but at most CacheMaxTTL.
+	CacheMaxTTL time.Duration
+}
+
+// Resolver resolves host names with the configured DNS servers and hosts
+// file, falling back to the system resolver.
+type Resolver struct {
+	servers     []server // sorted by decreasing domain length
+	search      []string
+	hosts       map[string][]net.IP
+	cacheMaxTTL time.Duration
+
+	// fallbackDelay is the delay before racing the connection to the next
+	// address, see Dial.
+	fallbackDelay time.Duration
+
+	mu    sync.Mutex // protects cache
+	cache map[question]answer
+}
+
+type server struct {
+	domain  string // "" for any name
+	address string
+}
+
+type question struct {
+	name  string
+	qtype dnsmessage.Type
+}
+
+type answer struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// NewResolver returns the resolver configured by cfg. It fails if the
+// servers are invalid or the hosts file can't be read.
+func NewResolver(cfg Config) (*Resolver, error) {
+	servers, err := parseServers(cfg.Servers)
+	if err != nil {
+		return nil, err
+	}
+	r := &Resolver{
+		servers:       servers,
+		cacheMaxTTL:   cfg.CacheMaxTTL,
+		fallbackDelay: defaultFallbackDelay,
+		cache:         make(map[question]answer),
+	}
+	for _, domain := range cfg.Search {
+		r.search = append(r.search, normalize(domain))
+	}
+	if cfg.HostsFile != "" {
+		if r.hosts, err = readHostsFile(cfg.HostsFile); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// ValidateServers returns an error if one of the DNS servers, as
+// host[:port] or <domain>=host[:port], is invalid.
+func ValidateServers(specs []string) error {
+	_, err := parseServers(specs)
+	return err
+}
+
+// parseServers parses the DNS servers, sorted by decreasing domain length.
+func parseServers(specs []string) ([]server, error) {
+	var servers []server
+	seen := make(map[string]bool)
+	for _, spec := range specs {
+		domain, address, ok := strings.Cut(spec, "=")
+		if !ok {
+			domain, address = "", spec
+		} else if domain = normalize(domain); domain == "" {
+			return nil, fmt.Errorf("invalid DNS server %q, expected an empty domain to be omitted", spec)
+		}
+		if seen[domain] {
+			return nil, fmt.Errorf("invalid DNS server %q, more than one server for the domain %q", spec, domain)
+		}
+		seen[domain] = true
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			address = net.JoinHostPort(address, "53")
+		}
+		host, _, _ := net.SplitHostPort(address)
+		if host == "" {
+			return nil, fmt.Errorf("invalid DNS server %q, expected host[:port] or <domain>=host[:port]", spec)
+		}
+		servers = append(servers, server{domain: domain, address: address})
+	}
+	sort.SliceStable(servers, func(i, j int) bool { return len(servers[i].domain) > len(servers[j].domain) })
+	return servers, nil
+}
+
+// LookupIP returns the addresses of host for network, e.g. only the IPv4
+// addresses for "tcp4". The failures are *net.DNSError.
+func (r *Resolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	start := time.Now()
+	ips, err := r.lookupIP(ctx, network, host)
+	metrics.Metrics.ObserveDNSLookupLatency(time.Since(start))
+	if err != nil {
+		dnsErr := &net.DNSError{Err: err.Error(), Name: host}
+		reason := metrics.DNSLookupFailureUnknown
+		var sysErr *net.DNSError
+		switch {
+		case errors.As(err, &sysErr):
+			dnsErr = sysErr
+			if sysErr.IsNotFound {
+				reason = metrics.DNSLookupFailureNotFound
+			} else if sysErr.IsTimeout {
+				reason = metrics.DNSLookupFailureTimeout
+			}
+		case errors.Is(err, errNotFound):
+			dnsErr.IsNotFound = true
+			reason = metrics.DNSLookupFailureNotFound
+		case errors.Is(err, context.DeadlineExceeded) || isTimeout(err):
+			dnsErr.IsTimeout = true
+			reason = metrics.DNSLookupFailureTimeout
+		}
+		metrics.Metrics.ObserveDNSLookupFailure(reason)
+		return nil, dnsErr
+	}
+	return ips, nil
+}
+
+func (r *Resolver) lookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	name := normalize(host)
+	if ips := filterIPs(network, r.hosts[name]); len(ips) > 0 {
+		return ips, nil
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultTimeout)
+		defer cancel()
+	}
+
+	names := []string{name}
+	if !strings.Contains(name, ".") && len(r.search) > 0 {
+		names = names[:0]
+		for _, domain := range r.search {
+			names = append(names, name+"."+domain)
+		}
+		names = append(names, name)
+	}
+	for _, name := range names {
+		ips, err := r.lookupName(ctx, network, name)
+		if isNotFound(err) {
+			continue
+		}
+		return ips, err
+	}
+	return nil, errNotFound
+}
+
+// lookupName looks up the addresses of the fully qualified name, both IPv4
+// and IPv6 for "tcp" and "udp".
+func (r *Resolver) lookupName(ctx context.Context, network, name string) ([]net.IP, error) {
+	server := r.serverFor(name)
+	if server == "" {
+		return net.DefaultResolver.LookupIP(ctx, ipNetwork(network), name)
+	}
+	var qtypes []dnsmessage.Type
+	switch ipNetwork(network) {
+	case "ip4":
+		qtypes = []dnsmessage.Type{dnsmessage.TypeA}
+	case "ip6":
+		qtypes = []dnsmessage.Type{dnsmessage.TypeAAAA}
+	default:
+		qtypes = []dnsmessage.Type{dnsmessage.TypeAAAA, dnsmessage.TypeA}
+	}
+
+	type result struct {
+		ips []net.IP
+		err error
+	}
+	results := make([]result, len(qtypes))
+	var wg sync.WaitGroup
+	for i, qtype := range qtypes {
+		wg.Add(1)
+		go func(i int, qtype dnsmessage.Type) {
+			defer wg.Done()
+			ips, err := r.query(ctx, server, question{name: name, qtype: qtype})
+			results[i] = result{ips, err}
+		}(i, qtype)
+	}
+	wg.Wait()
+
+	var ips []net.IP
+	var err error
+	for _, res := range results {
+		ips = append(ips, res.ips...)
+		if res.err != nil && (err == nil || isNotFound(err)) {
+			err = res.err
+		}
+	}
+	if len(ips) > 0 {
+		return ips, nil
+	}
+	if err == nil {
+		err = errNotFound
+	}
+	return nil, err
+}
+
+// query returns the cached answer to q, or asks server.
+func (r *Resolver) query(ctx context.Context, server string, q question) ([]net.IP, error) {
+	if r.cacheMaxTTL > 0 {
+		r.mu.Lock()
+		a, ok := r.cache[q]
+		r.mu.Unlock()
+		if ok && time.Now().Before(a.expires) {
+			return a.ips, nil
+		}
+	}
+
+	ips, ttl, err := exchange(ctx, server, q)
+	if err != nil {
+		return nil, err
+	}
+	if r.cacheMaxTTL > 0 && ttl > 0 && len(ips) > 0 {
+		if ttl > r.cacheMaxTTL {
+			ttl = r.cacheMaxTTL
+		}
+		r.mu.Lock()
+		r.removeExpiredLocked()
+		r.cache[q] = answer{ips: ips, expires: time.Now().Add(ttl)}
+		r.mu.Unlock()
+	}
+	return ips, nil
+}
+
+func (r *Resolver) removeExpiredLocked() {
+	now := time.Now()
+	for q, a := range r.cache {
+		if now.After(a.expires) {
+			delete(r.cache, q)
+		}
+	}
+}
+
+// serverFor returns the address of the server resolving name, or "" for
+// the system resolver.
+func (r *Resolver) serverFor(name string) string {
+	for _, s := range r.servers {
+		if s.domain == "" || name == s.domain || strings.HasSuffix(name, "."+s.domain) {
+			return s.address
+		}
+	}
+	return ""
+}
+
+// ipNetwork returns the IP network of the dial network, for LookupIP.
+func ipNetwork(network string) string {
+	switch {
+	case strings.HasSuffix(network, "4"):
+		return "ip4"
+	case strings.HasSuffix(network, "6"):
+		return "ip6"
+	default:
+		return "ip"
+	}
+}
+
+func filterIPs(network string, ips []net.IP) []net.IP {
+	var filtered []net.IP
+	for _, ip := range ips {
+		switch ipNetwork(network) {
+		case "ip4":
+			if ip.To4() == nil {
+				continue
+			}
+		case "ip6":
+			if ip.To4() != nil {
+				continue
+			}
+		}
+		filtered = append(filtered, ip)
+	}
+	return filtered
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.Is(err, errNotFound) || (errors.As(err, &dnsErr) && dnsErr.IsNotFound)
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func normalize(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
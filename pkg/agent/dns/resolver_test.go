@@ -0,0 +1,360 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeServer answers the A and AAAA queries of the names in records, over
+// UDP and TCP. The answers of the names in truncated are truncated over UDP.
+type fakeServer struct {
+	t         *testing.T
+	addr      string
+	records   map[string][]net.IP
+	truncated map[string]bool
+
+	mu      sync.Mutex
+	ttl     uint32
+	queries map[string]int // by network and name, e.g. "udp web.example.com"
+}
+
+func newFakeServer(t *testing.T, records map[string][]net.IP) *fakeServer {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{
+		t:         t,
+		addr:      pc.LocalAddr().String(),
+		ttl:       60,
+		records:   records,
+		truncated: make(map[string]bool),
+		queries:   make(map[string]int),
+	}
+	t.Cleanup(func() {
+		pc.Close()
+		l.Close()
+	})
+	go s.serveUDP(pc)
+	go s.serveTCP(l)
+	return s
+}
+
+func (s *fakeServer) setTTL(ttl uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ttl = ttl
+}
+
+func (s *fakeServer) queryCount(network, name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries[network+" "+name]
+}
+
+func (s *fakeServer) serveUDP(pc net.PacketConn) {
+	buf := make([]byte, maxUDPSize)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp := s.answer("udp", buf[:n]); resp != nil {
+			pc.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (s *fakeServer) serveTCP(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			var length [2]byte
+			if _, err := io.ReadFull(conn, length[:]); err != nil {
+				return
+			}
+			query := make([]byte, binary.BigEndian.Uint16(length[:]))
+			if _, err := io.ReadFull(conn, query); err != nil {
+				return
+			}
+			resp := s.answer("tcp", query)
+			msg := make([]byte, 2+len(resp))
+			binary.BigEndian.PutUint16(msg, uint16(len(resp)))
+			copy(msg[2:], resp)
+			conn.Write(msg)
+		}()
+	}
+}
+
+func (s *fakeServer) answer(network string, query []byte) []byte {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil || len(msg.Questions) != 1 {
+		s.t.Errorf("unexpected query: %v", err)
+		return nil
+	}
+	q := msg.Questions[0]
+	name := strings.TrimSuffix(q.Name.String(), ".")
+	s.mu.Lock()
+	s.queries[network+" "+name]++
+	ttl := s.ttl
+	s.mu.Unlock()
+
+	header := dnsmessage.Header{ID: msg.ID, Response: true, RecursionAvailable: true}
+	ips, ok := s.records[name]
+	if !ok {
+		header.RCode = dnsmessage.RCodeNameError
+	}
+	if network == "udp" && s.truncated[name] {
+		header.Truncated = true
+		ips = nil
+	}
+	b := dnsmessage.NewBuilder(nil, header)
+	b.StartQuestions()
+	b.Question(q)
+	b.StartAnswers()
+	for _, ip := range ips {
+		rh := dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: dnsmessage.ClassINET, TTL: ttl}
+		if ip4 := ip.To4(); ip4 != nil && q.Type == dnsmessage.TypeA {
+			var a [4]byte
+			copy(a[:], ip4)
+			b.AResource(rh, dnsmessage.AResource{A: a})
+		} else if ip4 == nil && q.Type == dnsmessage.TypeAAAA {
+			var aaaa [16]byte
+			copy(aaaa[:], ip)
+			b.AAAAResource(rh, dnsmessage.AAAAResource{AAAA: aaaa})
+		}
+	}
+	resp, err := b.Finish()
+	if err != nil {
+		s.t.Errorf("failed to build the response: %v", err)
+	}
+	return resp
+}
+
+func ips(addrs ...string) []net.IP {
+	var ips []net.IP
+	for _, addr := range addrs {
+		ips = append(ips, net.ParseIP(addr))
+	}
+	return ips
+}
+
+func assertIPs(t *testing.T, host string, got []net.IP, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: expected %v, got %v", host, want, got)
+	}
+	for i := range want {
+		if !got[i].Equal(net.ParseIP(want[i])) {
+			t.Errorf("%s: expected %v, got %v", host, want, got)
+		}
+	}
+}
+
+func TestResolver(t *testing.T) {
+	nodes := newFakeServer(t, map[string][]net.IP{
+		"node1.example.com": ips("192.168.0.1", "fd00::1"),
+		"big.example.com":   ips("192.168.0.2"),
+	})
+	nodes.truncated["big.example.com"] = true
+	cluster := newFakeServer(t, map[string][]net.IP{
+		"web.default.svc.cluster.local": ips("10.96.0.10"),
+	})
+	hostsFile := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(hostsFile, []byte("# Overrides\n10.0.0.1 node1.example.com override # node1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewResolver(Config{
+		Servers:   []string{nodes.addr, "cluster.local=" + cluster.addr},
+		Search:    []string{"default.svc.cluster.local"},
+		HostsFile: hostsFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	got, err := r.LookupIP(ctx, "tcp", "web.default.svc.cluster.local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIPs(t, "web.default.svc.cluster.local", got, "10.96.0.10")
+	if nodes.queryCount("udp", "web.default.svc.cluster.local") != 0 {
+		t.Error("expected the cluster names to be resolved by the cluster server only")
+	}
+
+	got, err = r.LookupIP(ctx, "tcp", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIPs(t, "web", got, "10.96.0.10")
+
+	// The hosts file overrides the servers.
+	got, err = r.LookupIP(ctx, "tcp", "Node1.Example.Com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIPs(t, "node1.example.com", got, "10.0.0.1")
+	got, err = r.LookupIP(ctx, "tcp6", "node1.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIPs(t, "node1.example.com", got, "fd00::1")
+
+	// Truncated answers are retried over TCP.
+	got, err = r.LookupIP(ctx, "tcp4", "big.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIPs(t, "big.example.com", got, "192.168.0.2")
+	if nodes.queryCount("tcp", "big.example.com") != 1 {
+		t.Error("expected the truncated answer to be retried over TCP")
+	}
+
+	_, err = r.LookupIP(ctx, "tcp", "missing.example.com")
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Errorf("expected a not found DNS error, got %#v", err)
+	}
+}
+
+func TestResolver_Cache(t *testing.T) {
+	s := newFakeServer(t, map[string][]net.IP{"node1.example.com": ips("192.168.0.1")})
+	s.setTTL(3600)
+	r, err := NewResolver(Config{Servers: []string{s.addr}, CacheMaxTTL: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		got, err := r.LookupIP(context.Background(), "tcp4", "node1.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertIPs(t, "node1.example.com", got, "192.168.0.1")
+	}
+	if n := s.queryCount("udp", "node1.example.com"); n != 1 {
+		t.Errorf("expected the answer to be cached, got %d queries", n)
+	}
+
+	// The TTL is capped by the maximum.
+	time.Sleep(300 * time.Millisecond)
+	if _, err := r.LookupIP(context.Background(), "tcp4", "node1.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.queryCount("udp", "node1.example.com"); n != 2 {
+		t.Errorf("expected the cached answer to expire, got %d queries", n)
+	}
+
+	// Answers with a zero TTL are not cached.
+	s.setTTL(0)
+	time.Sleep(300 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if _, err := r.LookupIP(context.Background(), "tcp4", "node1.example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := s.queryCount("udp", "node1.example.com"); n != 4 {
+		t.Errorf("expected the answers without TTL not to be cached, got %d queries", n)
+	}
+}
+
+func TestDial_HappyEyeballs(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	hostsFile := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(hostsFile, []byte("127.0.0.2 web\n127.0.0.1 web\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewResolver(Config{HostsFile: hostsFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.fallbackDelay = 50 * time.Millisecond
+
+	// The connection to the first address hangs.
+	dialer := &net.Dialer{Control: func(network, address string, c syscall.RawConn) error {
+		if strings.HasPrefix(address, "127.0.0.2:") {
+			time.Sleep(time.Second)
+			return errors.New("unreachable")
+		}
+		return nil
+	}}
+	start := time.Now()
+	conn, err := r.Dial(context.Background(), dialer, "tcp", net.JoinHostPort("web", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("expected the second address to be raced, took %v", elapsed)
+	}
+	if got := conn.RemoteAddr().String(); got != l.Addr().String() {
+		t.Errorf("expected a connection to %s, got %s", l.Addr(), got)
+	}
+}
+
+func TestInterleave(t *testing.T) {
+	got := interleave(ips("fd00::1", "fd00::2", "fd00::3", "10.0.0.1"))
+	assertIPs(t, "interleaved", got, "fd00::1", "10.0.0.1", "fd00::2", "fd00::3")
+}
+
+func TestValidateServers(t *testing.T) {
+	for _, servers := range [][]string{
+		{"10.0.0.1"},
+		{"10.0.0.1:5353", "cluster.local=[fd00::10]:53"},
+	} {
+		if err := ValidateServers(servers); err != nil {
+			t.Errorf("expected %v to be valid, got %v", servers, err)
+		}
+	}
+	for _, servers := range [][]string{
+		{"=10.0.0.1"},
+		{"10.0.0.1", "10.0.0.2"},
+		{"cluster.local=:53"},
+	} {
+		if err := ValidateServers(servers); err == nil {
+			t.Errorf("expected %v to be invalid", servers)
+		}
+	}
+}
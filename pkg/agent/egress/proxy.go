@@ -0,0 +1,131 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package egress
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	xproxy "golang.org/x/net/proxy"
+)
+
+// proxy is an upstream HTTP CONNECT or SOCKS5 proxy.
+type proxy struct {
+	name            string
+	scheme          string // "http" or "socks5"
+	address         string
+	credentialsFile string // "<user>:<password>", if set
+}
+
+// credentials reads the user and password of the proxy, if any.
+func (p *proxy) credentials() (*xproxy.Auth, error) {
+	if p.credentialsFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(p.credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the credentials of proxy %q: %v", p.name, err)
+	}
+	user, password, ok := strings.Cut(strings.TrimSpace(string(data)), ":")
+	if !ok || user == "" {
+		return nil, fmt.Errorf("invalid credentials of proxy %q in %s, expected \"<user>:<password>\"", p.name, p.credentialsFile)
+	}
+	return &xproxy.Auth{User: user, Password: password}, nil
+}
+
+// DialContext connects to address through the proxy. Only TCP is
+// supported.
+func (p *proxy) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("proxy %q: network %q is not supported through upstream proxies", p.name, network)
+	}
+	auth, err := p.credentials()
+	if err != nil {
+		return nil, err
+	}
+	if p.scheme == "socks5" {
+		d, err := xproxy.SOCKS5("tcp", p.address, auth, &net.Dialer{})
+		if err != nil {
+			return nil, err
+		}
+		conn, err := d.(xproxy.ContextDialer).DialContext(ctx, network, address)
+		if err != nil {
+			return nil, fmt.Errorf("proxy %q: %v", p.name, err)
+		}
+		return conn, nil
+	}
+	return p.connect(ctx, address, auth)
+}
+
+// connect opens an HTTP CONNECT tunnel to address.
+func (p *proxy) connect(ctx context.Context, address string, auth *xproxy.Auth) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", p.address)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %q: %v", p.name, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", address, address)
+	if auth != nil {
+		creds := base64.StdEncoding.EncodeToString([]byte(auth.User + ":" + auth.Password))
+		req += "Proxy-Authorization: Basic " + creds + "\r\n"
+	}
+	req += "\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %q: %v", p.name, err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %q: %v", p.name, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %q: CONNECT to %s failed: %s", p.name, address, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	if br.Buffered() > 0 {
+		// The destination may have sent data along with the response.
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn reads what was buffered from its connection first.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package egress connects the agent to its destinations through upstream
+// proxies.
+package egress
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/apiserver-network-proxy/pkg/agent/policy"
+)
+
+// direct is the route of the destinations which are dialed directly.
+const direct = "direct"
+
+// Dialer connects to destinations.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Router connects to each destination through the upstream proxy of its
+// route, or directly. The proxies and routes are read from a file, with one
+// per line:
+//
+//	proxy <name> <url> [<credentials file>]
+//	route <proxy name>|direct <destination> [<ports>]
+//
+// The proxy URLs are http://host:port for HTTP CONNECT proxies, and
+// socks5://host:port for SOCKS5 proxies. The credentials file, if any,
+// holds "<user>:<password>", and is reread on every dial so that the
+// credentials can be rotated. The destinations and ports of the routes are
+// those of the destination policy, and are matched against the host name
+// or IP of the DIAL_REQ. The proxy resolves the host names, so the IPs they
+// resolve to can't be checked by the destination policy: the host names
+// are refused through a proxy if an IP or CIDR deny rule of the policy
+// matches their port, or if only its IP allow rules could allow them. The
+// first matching route is used, and the destinations matching none are
+// dialed directly. Empty lines and lines starting with '#' are ignored. For
+// example:
+//
+//	proxy corp http://proxy.corp.example.com:3128 /etc/konnectivity/corp-proxy
+//	route direct 10.0.0.0/8
+//	route direct *.cluster.local
+//	route corp *
+type Router struct {
+	direct Dialer
+	policy *policy.DestinationPolicy // nil if any destination is allowed
+	routes []route
+}
+
+type route struct {
+	text  string // the route, as written
+	dest  *policy.Destination
+	proxy *proxy // nil for direct
+}
+
+// NewRouter returns the router configured in the file at path, dialing the
+// direct destinations with direct. The destinations dialed through a proxy
+// are checked against the destination policy p, if not nil.
+func NewRouter(path string, direct Dialer, p *policy.DestinationPolicy) (*Router, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read egress configuration: %v", err)
+	}
+	r := &Router{direct: direct, policy: p}
+	proxies := make(map[string]*proxy)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := r.parseLine(line, proxies); err != nil {
+			return nil, fmt.Errorf("failed to parse egress configuration %s: line %d: %v", path, n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to parse egress configuration %s: %v", path, err)
+	}
+	return r, nil
+}
+
+func (r *Router) parseLine(line string, proxies map[string]*proxy) error {
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case "proxy":
+		if len(fields) != 3 && len(fields) != 4 {
+			return fmt.Errorf("expected \"proxy <name> <url> [<credentials file>]\", got %q", line)
+		}
+		name := fields[1]
+		if name == direct {
+			return fmt.Errorf("proxy name %q is reserved", direct)
+		}
+		if _, ok := proxies[name]; ok {
+			return fmt.Errorf("proxy %q is defined more than once", name)
+		}
+		p, err := newProxy(name, fields[2])
+		if err != nil {
+			return err
+		}
+		if len(fields) == 4 {
+			p.credentialsFile = fields[3]
+			if _, err := p.credentials(); err != nil {
+				return err
+			}
+		}
+		proxies[name] = p
+	case "route":
+		if len(fields) != 3 && len(fields) != 4 {
+			return fmt.Errorf("expected \"route <proxy name>|direct <destination> [<ports>]\", got %q", line)
+		}
+		rt := route{text: line}
+		if fields[1] != direct {
+			p, ok := proxies[fields[1]]
+			if !ok {
+				return fmt.Errorf("proxy %q is not defined before the route", fields[1])
+			}
+			rt.proxy = p
+		}
+		dest, err := policy.ParseDestination(fields[2:]...)
+		if err != nil {
+			return err
+		}
+		rt.dest = dest
+		r.routes = append(r.routes, rt)
+	default:
+		return fmt.Errorf("expected \"proxy\" or \"route\", got %q", fields[0])
+	}
+	return nil
+}
+
+// DialContext connects to address through the proxy of its route, or
+// directly.
+func (r *Router) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if p := r.proxyFor(address); p != nil {
+		if r.policy != nil {
+			if err := r.policy.CheckProxiedAddress(address); err != nil {
+				return nil, err
+			}
+		}
+		klog.V(4).InfoS("Dialing through an upstream proxy", "proxy", p.name, "network", network, "address", address)
+		return p.DialContext(ctx, network, address)
+	}
+	return r.direct.DialContext(ctx, network, address)
+}
+
+// proxyFor returns the proxy of the route of address, or nil to dial it
+// directly.
+func (r *Router) proxyFor(address string) *proxy {
+	host, ip, port, err := policy.SplitAddress(address)
+	if err != nil {
+		return nil
+	}
+	for _, rt := range r.routes {
+		if rt.dest.Matches(host, ip, port) {
+			return rt.proxy
+		}
+	}
+	return nil
+}
+
+// newProxy returns the proxy at rawURL.
+func newProxy(name, rawURL string) (*proxy, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL of proxy %q: %v", name, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "socks5" {
+		return nil, fmt.Errorf("proxy %q scheme %q is not supported, expected \"http\" or \"socks5\"", name, u.Scheme)
+	}
+	if u.User != nil {
+		return nil, fmt.Errorf("proxy %q URL must not include credentials, use a credentials file", name)
+	}
+	if u.Port() == "" || u.Hostname() == "" {
+		return nil, fmt.Errorf("proxy %q URL must include the host and port, got %q", name, rawURL)
+	}
+	return &proxy{name: name, scheme: u.Scheme, address: u.Host}, nil
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/util"
+)
+
+// AgentIdentifiers returns the current identifiers of the agent.
+func (cs *ClientSet) AgentIdentifiers() string {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.agentIdentifiers
+}
+
+// SetAgentIdentifiers replaces the identifiers of the agent, in the url
+// encoded format of GenAgentIdentifiers. They are sent to the connected
+// proxy servers, which update the agent's backends without it reconnecting,
+// and to the other servers once they are connected.
+func (cs *ClientSet) SetAgentIdentifiers(identifiers string) error {
+	if _, err := GenAgentIdentifiers(identifiers); err != nil {
+		return err
+	}
+	cs.identifiersMu.Lock()
+	defer cs.identifiersMu.Unlock()
+	cs.mu.Lock()
+	if cs.agentIdentifiers == identifiers {
+		cs.mu.Unlock()
+		return nil
+	}
+	cs.agentIdentifiers = identifiers
+	clients := make([]*Client, 0, len(cs.clients))
+	for _, c := range cs.clients {
+		clients = append(clients, c)
+	}
+	cs.mu.Unlock()
+
+	klog.V(1).InfoS("Agent identifiers changed", "agentIdentifiers", identifiers)
+	for _, c := range clients {
+		cs.sendIdentifiersLocked(c, identifiers)
+	}
+	return nil
+}
+
+// sendIdentifiers sends the current identifiers of the agent to the server
+// of c, if c connected with others. It catches up on the updates made while
+// c was connecting.
+func (cs *ClientSet) sendIdentifiers(c *Client) {
+	cs.identifiersMu.Lock()
+	defer cs.identifiersMu.Unlock()
+	cs.sendIdentifiersLocked(c, cs.AgentIdentifiers())
+}
+
+// sendIdentifiersLocked sends identifiers to the server of c, unless it
+// already has them. cs.identifiersMu must be held.
+func (cs *ClientSet) sendIdentifiersLocked(c *Client, identifiers string) {
+	if c.sentIdentifiers == identifiers {
+		return
+	}
+	pkt := &client.Packet{
+		Type:    client.PacketType_AGENT_IDENTIFIERS,
+		Payload: &client.Packet_AgentIdentifiers{AgentIdentifiers: &client.AgentIdentifiers{Identifiers: identifiers}},
+	}
+	if err := c.Send(pkt); err != nil {
+		klog.ErrorS(err, "Failed to send the agent identifiers to the proxy server", "serverID", c.serverID)
+		return
+	}
+	c.sentIdentifiers = identifiers
+}
+
+// IdentifiersFile reads the identifiers of the agent from a file, in the url
+// encoded format of GenAgentIdentifiers. The identifiers may be split across
+// lines, which are joined by '&'; empty lines and lines starting with '#'
+// are ignored. For example:
+//
+//	host=node1.mydomain.com
+//	ipv4=10.0.0.1&ipv4=10.0.0.2
+//	default-route=true
+//
+// The file is reread when it changes, so that the identifiers can be
+// updated, e.g. when the node gets a new address.
+type IdentifiersFile struct {
+	path        string
+	watcher     *util.FileWatcher
+	identifiers string
+}
+
+// NewIdentifiersFile returns the identifiers in the file at path. It fails
+// if the file can't be read or the identifiers are invalid.
+func NewIdentifiersFile(path string) (*IdentifiersFile, error) {
+	f := &IdentifiersFile{path: path}
+	w, err := util.NewFileWatcher("agent identifiers", path, f.load)
+	if err != nil {
+		return nil, err
+	}
+	f.watcher = w
+	return f, nil
+}
+
+// Identifiers returns the identifiers read from the file, before it is Run.
+func (f *IdentifiersFile) Identifiers() string {
+	return f.identifiers
+}
+
+// Run rereads the file every interval if it changed, until stopCh is
+// closed, and calls onChange with the identifiers when they change, e.g.
+// ClientSet.SetAgentIdentifiers. If onChange fails, it is called again at
+// the next interval.
+func (f *IdentifiersFile) Run(interval time.Duration, stopCh <-chan struct{}, onChange func(identifiers string) error) {
+	last := f.identifiers
+	wait.Until(func() {
+		f.watcher.ReloadIfChanged()
+		if f.identifiers == last {
+			return
+		}
+		if err := onChange(f.identifiers); err != nil {
+			klog.ErrorS(err, "Failed to update the agent identifiers", "path", f.path)
+			return
+		}
+		last = f.identifiers
+	}, interval, stopCh)
+}
+
+func (f *IdentifiersFile) load(data []byte) error {
+	var parts []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts = append(parts, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	identifiers := strings.Join(parts, "&")
+	if _, err := GenAgentIdentifiers(identifiers); err != nil {
+		return err
+	}
+	f.identifiers = identifiers
+	klog.V(1).InfoS("Loaded the agent identifiers", "path", f.path, "agentIdentifiers", identifiers)
+	return nil
+}
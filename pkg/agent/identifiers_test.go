@@ -0,0 +1,194 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
+	"sigs.k8s.io/apiserver-network-proxy/proto/agent"
+)
+
+func TestSetAgentIdentifiers(t *testing.T) {
+	cs := &ClientSet{
+		clients:          make(map[string]*Client),
+		agentIdentifiers: "host=node1",
+	}
+	servers := make(map[string]agent.AgentService_ConnectClient)
+	for _, serverID := range []string{"server1", "server2"} {
+		c := &Client{cs: cs, serverID: serverID, agentIdentifiers: "host=node1", sentIdentifiers: "host=node1"}
+		c.stream, servers[serverID] = pipe()
+		cs.clients[serverID] = c
+	}
+	assertReceived := func(server agent.AgentService_ConnectClient, want string) {
+		t.Helper()
+		pkt, err := server.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pkt.Type != client.PacketType_AGENT_IDENTIFIERS || pkt.GetAgentIdentifiers().Identifiers != want {
+			t.Errorf("expected the identifiers %q, got %v", want, pkt)
+		}
+	}
+
+	if err := cs.SetAgentIdentifiers("zone=a"); err == nil {
+		t.Error("expected invalid identifiers to be rejected")
+	}
+	if err := cs.SetAgentIdentifiers("host=node2&default-route=true"); err != nil {
+		t.Fatal(err)
+	}
+	for _, server := range servers {
+		assertReceived(server, "host=node2&default-route=true")
+	}
+	if got := cs.AgentIdentifiers(); got != "host=node2&default-route=true" {
+		t.Errorf("expected the updated identifiers, got %q", got)
+	}
+
+	// A client which connected with the previous identifiers catches up.
+	c := &Client{cs: cs, serverID: "server3", agentIdentifiers: "host=node1", sentIdentifiers: "host=node1"}
+	var server agent.AgentService_ConnectClient
+	c.stream, server = pipe()
+	cs.sendIdentifiers(c)
+	assertReceived(server, "host=node2&default-route=true")
+
+	// Nothing is sent to the servers which have the identifiers already.
+	cs.sendIdentifiers(c)
+	if err := cs.SetAgentIdentifiers("host=node2&default-route=true"); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range append([]agent.AgentService_ConnectClient{server}, servers["server1"], servers["server2"]) {
+		if pending := len(s.(*fakeStream).r); pending != 0 {
+			t.Errorf("expected no further packet, got %d", pending)
+		}
+	}
+}
+
+func TestIdentifiersFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identifiers")
+	write := func(data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("# node1\nhost=node1\n\nipv4=10.0.0.1&ipv4=10.0.0.2\n")
+	f, err := NewIdentifiersFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.Identifiers(), "host=node1&ipv4=10.0.0.1&ipv4=10.0.0.2"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	changes := make(chan string, 1)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go f.Run(10*time.Millisecond, stopCh, func(identifiers string) error {
+		changes <- identifiers
+		return nil
+	})
+
+	// Invalid identifiers are ignored.
+	write("zone=a\n")
+	write("host=node2\n")
+	select {
+	case got := <-changes:
+		if got != "host=node2" {
+			t.Errorf("expected the identifiers of node2, got %q", got)
+		}
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatal("expected the change of identifiers to be reported")
+	}
+
+	write("zone=a\n")
+	select {
+	case got := <-changes:
+		t.Errorf("expected the invalid identifiers to be ignored, got %q", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if _, err := NewIdentifiersFile(path); err == nil {
+		t.Error("expected the invalid identifiers file to be rejected")
+	}
+	if _, err := NewIdentifiersFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected the missing identifiers file to be rejected")
+	}
+}
+
+func TestIdentifiersFileRetry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identifiers")
+	if err := os.WriteFile(path, []byte("host=node1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewIdentifiersFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := make(chan string, 10)
+	failed := false
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go f.Run(10*time.Millisecond, stopCh, func(identifiers string) error {
+		calls <- identifiers
+		if !failed {
+			failed = true
+			return errors.New("failed to update")
+		}
+		return nil
+	})
+
+	if err := os.WriteFile(path, []byte("host=node2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// The failed update is retried, and not repeated once it succeeds.
+	for i := 0; i < 2; i++ {
+		select {
+		case got := <-calls:
+			if got != "host=node2" {
+				t.Errorf("expected the identifiers of node2, got %q", got)
+			}
+		case <-time.After(wait.ForeverTestTimeout):
+			t.Fatalf("expected update %d of the identifiers", i+1)
+		}
+	}
+	select {
+	case got := <-calls:
+		t.Errorf("expected no further update, got %q", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestIdentifiersFileLineTooLong(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identifiers")
+	data := "host=" + strings.Repeat("a", bufio.MaxScanTokenSize) + "\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewIdentifiersFile(path); err == nil {
+		t.Error("expected the identifiers file with a too long line to be rejected")
+	}
+}
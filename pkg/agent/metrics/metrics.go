@@ -50,11 +50,16 @@ var (
 // AgentMetrics includes all the metrics of the proxy agent.
 type AgentMetrics struct {
 	dialLatencies       *prometheus.HistogramVec
+	dnsLatencies        *prometheus.HistogramVec
+	dnsFailures         *prometheus.CounterVec
 	serverFailures      *prometheus.CounterVec
 	dialFailures        *prometheus.CounterVec
+	dialRetries         *prometheus.CounterVec
 	serverConnections   *prometheus.GaugeVec
 	endpointConnections *prometheus.GaugeVec
+	expiredConnections  *prometheus.CounterVec
 	streamPackets       *prometheus.CounterVec
+	streamDataBytes     *prometheus.CounterVec
 	streamErrors        *prometheus.CounterVec
 }
 
@@ -70,6 +75,25 @@ func newAgentMetrics() *AgentMetrics {
 		},
 		[]string{},
 	)
+	dnsLatencies := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "dns_lookup_duration_seconds",
+			Help:      "Latency of the resolution of the remote endpoint host names by the agent resolver in seconds",
+			Buckets:   latencyBuckets,
+		},
+		[]string{},
+	)
+	dnsFailures := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "dns_lookup_failure_total",
+			Help:      "Number of failures resolving the remote endpoint host names by the agent resolver, by reason (not_found, timeout or unknown).",
+		},
+		[]string{"reason"},
+	)
 	serverFailures := prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: Namespace,
@@ -88,6 +112,15 @@ func newAgentMetrics() *AgentMetrics {
 		},
 		[]string{"reason"},
 	)
+	dialRetries := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "endpoint_dial_retries_total",
+			Help:      "Number of dials to the remote endpoint retried after a transient error.",
+		},
+		[]string{},
+	)
 	serverConnections := prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Namespace: Namespace,
@@ -106,22 +139,42 @@ func newAgentMetrics() *AgentMetrics {
 		},
 		[]string{},
 	)
+	expiredConnections := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "endpoint_connections_expired_total",
+			Help:      "Number of endpoint connections closed by the agent, by reason (idle_timeout or max_lifetime).",
+		},
+		[]string{"reason"},
+	)
 	streamPackets := commonmetrics.MakeStreamPacketsTotalMetric(Namespace, Subsystem)
+	streamDataBytes := commonmetrics.MakeStreamDataBytesTotalMetric(Namespace, Subsystem)
 	streamErrors := commonmetrics.MakeStreamErrorsTotalMetric(Namespace, Subsystem)
 	prometheus.MustRegister(dialLatencies)
+	prometheus.MustRegister(dnsLatencies)
+	prometheus.MustRegister(dnsFailures)
 	prometheus.MustRegister(serverFailures)
 	prometheus.MustRegister(dialFailures)
+	prometheus.MustRegister(dialRetries)
 	prometheus.MustRegister(serverConnections)
 	prometheus.MustRegister(endpointConnections)
+	prometheus.MustRegister(expiredConnections)
 	prometheus.MustRegister(streamPackets)
+	prometheus.MustRegister(streamDataBytes)
 	prometheus.MustRegister(streamErrors)
 	return &AgentMetrics{
 		dialLatencies:       dialLatencies,
+		dnsLatencies:        dnsLatencies,
+		dnsFailures:         dnsFailures,
 		serverFailures:      serverFailures,
 		dialFailures:        dialFailures,
+		dialRetries:         dialRetries,
 		serverConnections:   serverConnections,
 		endpointConnections: endpointConnections,
+		expiredConnections:  expiredConnections,
 		streamPackets:       streamPackets,
+		streamDataBytes:     streamDataBytes,
 		streamErrors:        streamErrors,
 	}
 
@@ -130,11 +183,16 @@ func newAgentMetrics() *AgentMetrics {
 // Reset resets the metrics.
 func (a *AgentMetrics) Reset() {
 	a.dialLatencies.Reset()
+	a.dnsLatencies.Reset()
+	a.dnsFailures.Reset()
 	a.serverFailures.Reset()
 	a.dialFailures.Reset()
+	a.dialRetries.Reset()
 	a.serverConnections.Reset()
 	a.endpointConnections.Reset()
+	a.expiredConnections.Reset()
 	a.streamPackets.Reset()
+	a.streamDataBytes.Reset()
 	a.streamErrors.Reset()
 }
 
@@ -148,6 +206,7 @@ type DialFailureReason string
 
 const (
 	DialFailureTimeout DialFailureReason = "timeout"
+	DialFailureDenied  DialFailureReason = "denied" // Denied by the destination policy.
 	DialFailureUnknown DialFailureReason = "unknown"
 )
 
@@ -161,6 +220,32 @@ func (a *AgentMetrics) ObserveDialFailure(reason DialFailureReason) {
 	a.dialFailures.WithLabelValues(string(reason)).Inc()
 }
 
+// ObserveDialRetry records a remote endpoint dial retried after a transient
+// error.
+func (a *AgentMetrics) ObserveDialRetry() {
+	a.dialRetries.WithLabelValues().Inc()
+}
+
+type DNSLookupFailureReason string
+
+const (
+	DNSLookupFailureNotFound DNSLookupFailureReason = "not_found"
+	DNSLookupFailureTimeout  DNSLookupFailureReason = "timeout"
+	DNSLookupFailureUnknown  DNSLookupFailureReason = "unknown"
+)
+
+// ObserveDNSLookupLatency records the latency of the resolution of a remote
+// endpoint host name.
+func (a *AgentMetrics) ObserveDNSLookupLatency(elapsed time.Duration) {
+	a.dnsLatencies.WithLabelValues().Observe(elapsed.Seconds())
+}
+
+// ObserveDNSLookupFailure records a remote endpoint host name resolution
+// failure.
+func (a *AgentMetrics) ObserveDNSLookupFailure(reason DNSLookupFailureReason) {
+	a.dnsFailures.WithLabelValues(string(reason)).Inc()
+}
+
 func (a *AgentMetrics) SetServerConnectionsCount(count int) {
 	a.serverConnections.WithLabelValues().Set(float64(count))
 }
@@ -175,10 +260,20 @@ func (a *AgentMetrics) EndpointConnectionDec() {
 	a.endpointConnections.WithLabelValues().Dec()
 }
 
+// ObserveEndpointConnectionExpired records an endpoint connection closed
+// by the agent, idle or too old.
+func (a *AgentMetrics) ObserveEndpointConnectionExpired(reason string) {
+	a.expiredConnections.WithLabelValues(reason).Inc()
+}
+
 func (a *AgentMetrics) ObservePacket(segment commonmetrics.Segment, packetType client.PacketType) {
 	commonmetrics.ObservePacket(a.streamPackets, segment, packetType)
 }
 
+func (a *AgentMetrics) ObserveDataBytes(segment commonmetrics.Segment, pkt *client.Packet) {
+	commonmetrics.ObserveDataBytes(a.streamDataBytes, segment, pkt)
+}
+
 func (a *AgentMetrics) ObserveStreamErrorNoPacket(segment commonmetrics.Segment, err error) {
 	commonmetrics.ObserveStreamErrorNoPacket(a.streamErrors, segment, err)
 }
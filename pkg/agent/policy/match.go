@@ -0,0 +1,164 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Destination matches destinations by IP, CIDR, host name, "*.<domain>"
+// for the host names in the domain, or "*" for any destination, and
+// optionally by port.
+type Destination struct {
+	any    bool
+	ipNet  *net.IPNet
+	host   string // a host name, if not a domain
+	domain string // ".<domain>", for "*.<domain>"
+
+	ports []portRange // any port if empty
+}
+
+type portRange struct {
+	first, last int
+}
+
+// ParseDestination parses a destination followed, optionally, by its
+// ports: a comma-separated list of ports and port ranges, e.g.
+// "443,8000-8999", or "*" for any port.
+func ParseDestination(fields ...string) (*Destination, error) {
+	if len(fields) != 1 && len(fields) != 2 {
+		return nil, fmt.Errorf("expected a destination and, optionally, ports, got %q", strings.Join(fields, " "))
+	}
+	d := &Destination{}
+	dest := strings.ToLower(fields[0])
+	switch {
+	case dest == "*":
+		d.any = true
+	case strings.Contains(dest, "/"):
+		_, ipNet, err := net.ParseCIDR(dest)
+		if err != nil {
+			return nil, err
+		}
+		d.ipNet = ipNet
+	case net.ParseIP(dest) != nil:
+		ip := net.ParseIP(dest)
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		d.ipNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	case strings.HasPrefix(dest, "*."):
+		d.domain = normalizeHost(dest[1:])
+	case strings.Contains(dest, "*"):
+		return nil, fmt.Errorf("invalid destination %q, wildcards are only supported as \"*\" or \"*.<domain>\"", fields[0])
+	default:
+		d.host = normalizeHost(dest)
+	}
+
+	if len(fields) == 2 && fields[1] != "*" {
+		for _, s := range strings.Split(fields[1], ",") {
+			pr, err := parsePortRange(s)
+			if err != nil {
+				return nil, err
+			}
+			d.ports = append(d.ports, pr)
+		}
+	}
+	return d, nil
+}
+
+// Matches returns whether the destination with the host name, if any, the
+// IP, if known, and the port matches d.
+func (d *Destination) Matches(host string, ip net.IP, port int) bool {
+	if !d.matchesPort(port) {
+		return false
+	}
+	switch {
+	case d.any:
+		return true
+	case d.ipNet != nil:
+		return ip != nil && d.ipNet.Contains(ip)
+	case d.host != "":
+		return host == d.host
+	default:
+		return strings.HasSuffix(host, d.domain)
+	}
+}
+
+func (d *Destination) matchesPort(port int) bool {
+	if len(d.ports) == 0 {
+		return true
+	}
+	for _, pr := range d.ports {
+		if port >= pr.first && port <= pr.last {
+			return true
+		}
+	}
+	return false
+}
+
+func parsePortRange(s string) (portRange, error) {
+	first, last, isRange := strings.Cut(s, "-")
+	pr := portRange{}
+	var err error
+	if pr.first, err = parsePort(first); err != nil {
+		return pr, err
+	}
+	pr.last = pr.first
+	if isRange {
+		if pr.last, err = parsePort(last); err != nil {
+			return pr, err
+		}
+		if pr.last < pr.first {
+			return pr, fmt.Errorf("invalid port range %q", s)
+		}
+	}
+	return pr, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 0 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
+
+// SplitAddress returns the host name, or the IP, and the port of the
+// address, in the host:port format. The host name is normalized for
+// Matches.
+func SplitAddress(address string) (string, net.IP, int, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	port, err := parsePort(portStr)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return "", ip, port, nil
+	}
+	return normalizeHost(host), nil, port, nil
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package policy restricts the destinations the agent dials.
+package policy
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/apiserver-network-proxy/pkg/util"
+)
+
+// ErrDenied is wrapped by the errors of the destinations which are denied.
+var ErrDenied = errors.New("denied by the agent destination policy")
+
+// DestinationPolicy decides which destinations the agent may dial, from
+// the rules in a file, with one rule per line:
+//
+//	allow|deny <destination> [<ports>]
+//
+// The destination is an IP, a CIDR, a host name, "*.<domain>" for the host
+// names in the domain, or "*" for any destination. The ports are a
+// comma-separated list of ports and port ranges, e.g. "443,8000-8999"; any
+// port matches if omitted. Empty lines and lines starting with '#' are
+// ignored. For example:
+//
+//	deny 169.254.0.0/16
+//	deny fe80::/10
+//	allow 10.0.0.0/8
+//	allow *.svc.cluster.local 443
+//
+// A destination is denied if it matches any deny rule. Otherwise, it is
+// allowed if it matches an allow rule, or if there are no allow rules.
+// Host names are checked again against the IP rules once resolved, so that
+// a name can't be used to dial a denied IP.
+//
+// The file is reread when it changes, so that the policy can be updated,
+// e.g. through a ConfigMap.
+type DestinationPolicy struct {
+	path    string
+	watcher *util.FileWatcher
+	rules   atomic.Pointer[[]rule]
+}
+
+// NewDestinationPolicy returns the policy in the file at path. It fails if
+// the file can't be read or is invalid.
+func NewDestinationPolicy(path string) (*DestinationPolicy, error) {
+	p := &DestinationPolicy{path: path}
+	w, err := util.NewFileWatcher("destination policy", path, p.load)
+	if err != nil {
+		return nil, err
+	}
+	p.watcher = w
+	return p, nil
+}
+
+// Run rereads the file every interval if it changed, until stopCh is closed.
+func (p *DestinationPolicy) Run(interval time.Duration, stopCh <-chan struct{}) {
+	p.watcher.Run(interval, stopCh)
+}
+
+// CheckAddress returns an error wrapping ErrDenied if the destination
+// address, in the host:port format, is denied. If the host is a name, the
+// address is only denied if it would be whatever the name resolves to; the
+// IP is checked once resolved by the dialer's Control function.
+func (p *DestinationPolicy) CheckAddress(address string) error {
+	host, ip, port, err := SplitAddress(address)
+	if err != nil {
+		return fmt.Errorf("destination %s: %v: %w", address, err, ErrDenied)
+	}
+	return p.check(address, host, ip, port)
+}
+
+// CheckProxiedAddress is CheckAddress for the destinations dialed through an
+// upstream proxy, which resolves the host names itself. As the IP a name
+// resolves to can't be checked, the names are also denied if an IP deny
+// rule matches their port, or if only the IP allow rules could allow them.
+func (p *DestinationPolicy) CheckProxiedAddress(address string) error {
+	host, ip, port, err := SplitAddress(address)
+	if err != nil {
+		return fmt.Errorf("destination %s: %v: %w", address, err, ErrDenied)
+	}
+	if err := p.check(address, host, ip, port); err != nil || ip != nil {
+		return err
+	}
+	rules := *p.rules.Load()
+	hasAllow, allowed := false, false
+	for _, r := range rules {
+		if r.allow {
+			hasAllow = true
+			allowed = allowed || r.dest.Matches(host, nil, port)
+		} else if r.dest.ipNet != nil && r.dest.matchesPort(port) {
+			return fmt.Errorf("destination %s is resolved by the upstream proxy, so it can't be checked against %q: %w", address, r.text, ErrDenied)
+		}
+	}
+	if hasAllow && !allowed {
+		return fmt.Errorf("destination %s is resolved by the upstream proxy, so it can't be allowed by the IP rules: %w", address, ErrDenied)
+	}
+	return nil
+}
+
+// Control returns a net.Dialer Control function, which checks the IP
+// dialed for the destination address once resolved.
+func (p *DestinationPolicy) Control(address string) func(network, resolved string, c syscall.RawConn) error {
+	return func(network, resolved string, c syscall.RawConn) error {
+		host, _, port, err := SplitAddress(address)
+		if err != nil {
+			return fmt.Errorf("destination %s: %v: %w", address, err, ErrDenied)
+		}
+		ipStr, _, err := net.SplitHostPort(resolved)
+		if err != nil {
+			return fmt.Errorf("destination %s: %v: %w", address, err, ErrDenied)
+		}
+		ip := net.ParseIP(ipStr)
+		if ip == nil {
+			return fmt.Errorf("destination %s: invalid resolved IP %q: %w", address, ipStr, ErrDenied)
+		}
+		return p.check(address, host, ip, port)
+	}
+}
+
+// check decides for the host name, if any, the IP, if known, and port of
+// address.
+func (p *DestinationPolicy) check(address, host string, ip net.IP, port int) error {
+	rules := *p.rules.Load()
+	hasAllow, allowed := false, false
+	for _, r := range rules {
+		if !r.allow {
+			if r.dest.Matches(host, ip, port) {
+				return fmt.Errorf("destination %s matches %q: %w", address, r.text, ErrDenied)
+			}
+			continue
+		}
+		hasAllow = true
+		// The IP rules may still allow a name which isn't resolved yet.
+		if r.dest.Matches(host, ip, port) || (ip == nil && r.dest.ipNet != nil && r.dest.matchesPort(port)) {
+			allowed = true
+		}
+	}
+	if hasAllow && !allowed {
+		return fmt.Errorf("destination %s matches no allow rule: %w", address, ErrDenied)
+	}
+	return nil
+}
+
+func (p *DestinationPolicy) load(data []byte) error {
+	rules, err := parseRules(data)
+	if err != nil {
+		return err
+	}
+	p.rules.Store(&rules)
+	klog.V(1).InfoS("Loaded the destination policy", "path", p.path, "rules", len(rules))
+	return nil
+}
+
+// rule allows or denies the destinations it matches.
+type rule struct {
+	text  string // the rule, as written
+	allow bool
+	dest  *Destination
+}
+
+func parseRules(data []byte) ([]rule, error) {
+	rules := []rule{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r, err := parseRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		rules = append(rules, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func parseRule(line string) (rule, error) {
+	r := rule{text: line}
+	fields := strings.Fields(line)
+	if len(fields) != 2 && len(fields) != 3 {
+		return r, fmt.Errorf("expected \"allow|deny <destination> [<ports>]\", got %q", line)
+	}
+	switch fields[0] {
+	case "allow":
+		r.allow = true
+	case "deny":
+	default:
+		return r, fmt.Errorf("expected \"allow\" or \"deny\", got %q", fields[0])
+	}
+	var err error
+	r.dest, err = ParseDestination(fields[1:]...)
+	return r, err
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writePolicy(t *testing.T, path, rules string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDestinationPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy")
+	writePolicy(t, path, `
+# Never reach the metadata service.
+deny 169.254.0.0/16
+deny fe80::/10
+deny * 22
+allow 10.0.0.0/8
+allow 2001:db8::1 443
+allow *.svc.cluster.local 443,8000-8999
+allow kubernetes.default
+`)
+	p, err := NewDestinationPolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		address string
+		// resolved is the IP the dialer resolved the host name to, if any.
+		resolved string
+		denied   bool
+	}{
+		{address: "10.1.2.3:80"},
+		{address: "10.1.2.3:22", denied: true},
+		{address: "11.1.2.3:80", denied: true},
+		{address: "169.254.169.254:80", denied: true},
+		{address: "[fe80::1]:443", denied: true},
+		{address: "[2001:db8::1]:443"},
+		{address: "[2001:db8::1]:80", denied: true},
+		{address: "web.default.svc.cluster.local:443"},
+		{address: "WEB.default.svc.cluster.local.:8080"},
+		{address: "web.default.svc.cluster.local:80", resolved: "172.16.0.10:80", denied: true},
+		{address: "svc.cluster.local:443", resolved: "172.16.0.10:443", denied: true},
+		{address: "kubernetes.default:6443"},
+		{address: "example.com:443", resolved: "93.184.216.34:443", denied: true},
+		{address: "not-an-address", denied: true},
+		// Names may be allowed by the IP rules once resolved.
+		{address: "internal.example.com:80", resolved: "10.0.0.1:80"},
+		{address: "internal.example.com:80", resolved: "192.168.0.1:80", denied: true},
+		// Allowed names are denied if they resolve to a denied IP.
+		{address: "kubernetes.default:80", resolved: "169.254.169.254:80", denied: true},
+	} {
+		err := p.CheckAddress(tc.address)
+		if err == nil && tc.resolved != "" {
+			err = p.Control(tc.address)("tcp", tc.resolved, nil)
+		}
+		if tc.denied && !errors.Is(err, ErrDenied) {
+			t.Errorf("%s (%s): expected ErrDenied, got %v", tc.address, tc.resolved, err)
+		}
+		if !tc.denied && err != nil {
+			t.Errorf("%s (%s): expected to be allowed, got %v", tc.address, tc.resolved, err)
+		}
+	}
+}
+
+func TestDestinationPolicy_DenyOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy")
+	writePolicy(t, path, "deny 169.254.169.254\n")
+	p, err := NewDestinationPolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CheckAddress("example.com:443"); err != nil {
+		t.Errorf("expected example.com to be allowed without allow rules, got %v", err)
+	}
+	if err := p.Control("example.com:443")("tcp", "169.254.169.254:443", nil); !errors.Is(err, ErrDenied) {
+		t.Errorf("expected ErrDenied, got %v", err)
+	}
+}
+
+func TestDestinationPolicy_Proxied(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy")
+	writePolicy(t, path, `
+deny 169.254.0.0/16 80
+deny *.internal.example.com
+allow 10.0.0.0/8
+allow *.svc.cluster.local
+allow example.com
+`)
+	p, err := NewDestinationPolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		address string
+		denied  bool
+	}{
+		{address: "10.1.2.3:80"},
+		{address: "169.254.169.254:80", denied: true},
+		{address: "web.default.svc.cluster.local:443"},
+		{address: "example.com:443"},
+		// The name could resolve to the denied IPs.
+		{address: "example.com:80", denied: true},
+		{address: "db.internal.example.com:443", denied: true},
+		// The name could only be allowed by the IP rules once resolved.
+		{address: "other.example.org:443", denied: true},
+	} {
+		err := p.CheckProxiedAddress(tc.address)
+		if tc.denied && !errors.Is(err, ErrDenied) {
+			t.Errorf("%s: expected ErrDenied, got %v", tc.address, err)
+		}
+		if !tc.denied && err != nil {
+			t.Errorf("%s: expected to be allowed, got %v", tc.address, err)
+		}
+	}
+}
+
+func TestDestinationPolicy_Invalid(t *testing.T) {
+	for _, rules := range []string{
+		"permit 10.0.0.0/8",
+		"allow",
+		"allow 10.0.0.0/8 443 extra",
+		"allow 10.0.0.0/33",
+		"allow foo*.example.com",
+		"allow 10.0.0.0/8 https",
+		"allow 10.0.0.0/8 9000-8000",
+		"allow 10.0.0.0/8 70000",
+	} {
+		path := filepath.Join(t.TempDir(), "policy")
+		writePolicy(t, path, rules)
+		if _, err := NewDestinationPolicy(path); err == nil {
+			t.Errorf("expected %q to be invalid", rules)
+		}
+	}
+	if _, err := NewDestinationPolicy(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected a missing file to fail")
+	}
+}
+
+func TestDestinationPolicy_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy")
+	writePolicy(t, path, "allow 10.0.0.0/8\n")
+	p, err := NewDestinationPolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CheckAddress("192.168.0.1:80"); !errors.Is(err, ErrDenied) {
+		t.Fatalf("expected ErrDenied, got %v", err)
+	}
+
+	writePolicy(t, path, "allow 192.168.0.0/16\n")
+	// The size changed, even if the modification time may be the same.
+	p.watcher.ReloadIfChanged()
+	if err := p.CheckAddress("192.168.0.1:80"); err != nil {
+		t.Errorf("expected the reloaded policy to allow 192.168.0.1, got %v", err)
+	}
+
+	// An invalid policy keeps the previous one.
+	writePolicy(t, path, "allow 192.168.0.0/16 http\n")
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	p.watcher.ReloadIfChanged()
+	if err := p.CheckAddress("192.168.0.1:80"); err != nil {
+		t.Errorf("expected the previous policy to be kept, got %v", err)
+	}
+}
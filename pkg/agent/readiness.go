@@ -0,0 +1,179 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/connectivity"
+)
+
+// ReadinessPolicy configures when the agent is ready.
+type ReadinessPolicy struct {
+	// MinServerFraction is the fraction of the proxy servers, as last
+	// reported by a server, the agent must have a healthy connection to.
+	// A connection to at least one proxy server is always required.
+	MinServerFraction float64
+	// MaxSyncAge, if positive, makes the agent unready once its last
+	// successful sync, which either reached a proxy server or found them
+	// all connected, is older.
+	MaxSyncAge time.Duration
+}
+
+// ReadinessCheck is the result of one of the readiness checks of the agent.
+type ReadinessCheck struct {
+	Name    string `json:"name"`
+	Ready   bool   `json:"ready"`
+	Message string `json:"message,omitempty"`
+}
+
+// ReadinessStatus details the readiness of the agent.
+type ReadinessStatus struct {
+	Ready  bool             `json:"ready"`
+	Checks []ReadinessCheck `json:"checks"`
+}
+
+// Ready returns whether the agent is ready and, if not, why.
+func (cs *ClientSet) Ready() (bool, string) {
+	var msgs []string
+	for _, c := range cs.readinessChecks() {
+		if !c.Ready {
+			msgs = append(msgs, c.Message)
+		}
+	}
+	return len(msgs) == 0, strings.Join(msgs, "; ")
+}
+
+// ReadinessStatus returns the readiness of the agent, with the result of
+// every check.
+func (cs *ClientSet) ReadinessStatus() ReadinessStatus {
+	status := ReadinessStatus{Ready: true, Checks: cs.readinessChecks()}
+	for _, c := range status.Checks {
+		status.Ready = status.Ready && c.Ready
+	}
+	return status
+}
+
+func (cs *ClientSet) readinessChecks() []ReadinessCheck {
+	checks := []ReadinessCheck{cs.serversCheck()}
+	if cs.readinessPolicy.MaxSyncAge > 0 {
+		c := ReadinessCheck{Name: "sync", Ready: true}
+		cs.mu.Lock()
+		lastSync := cs.lastSync
+		cs.mu.Unlock()
+		if lastSync.IsZero() {
+			c.Ready, c.Message = false, "no successful sync with the proxy servers yet"
+		} else if age := time.Since(lastSync); age > cs.readinessPolicy.MaxSyncAge {
+			c.Ready, c.Message = false, fmt.Sprintf("last successful sync with the proxy servers %v ago, at most %v allowed", age.Round(time.Second), cs.readinessPolicy.MaxSyncAge)
+		}
+		checks = append(checks, c)
+	}
+	if cs.serviceAccountTokenPath != "" {
+		c := ReadinessCheck{Name: "token", Ready: true}
+		if token, err := os.ReadFile(cs.serviceAccountTokenPath); err != nil {
+			c.Ready, c.Message = false, fmt.Sprintf("failed to read the service account token: %v", err)
+		} else if len(strings.TrimSpace(string(token))) == 0 {
+			c.Ready, c.Message = false, fmt.Sprintf("the service account token file %s is empty", cs.serviceAccountTokenPath)
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+// serversCheck checks the healthy connections against the number of proxy
+// servers.
+func (cs *ClientSet) serversCheck() ReadinessCheck {
+	c := ReadinessCheck{Name: "servers", Ready: true}
+	healthy, serverCount := cs.HealthyClientsCount(), cs.ServerCount()
+	required := int(math.Ceil(cs.readinessPolicy.MinServerFraction * float64(serverCount)))
+	if required < 1 {
+		required = 1
+	}
+	if healthy == 0 {
+		c.Ready, c.Message = false, "no connection to any proxy server"
+	} else if healthy < required {
+		c.Ready, c.Message = false, fmt.Sprintf("%d of %d proxy servers connected, at least %d required", healthy, serverCount, required)
+	}
+	return c
+}
+
+// observeSync records a successful sync with the proxy servers.
+func (cs *ClientSet) observeSync() {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.lastSync = time.Now()
+}
+
+// ClientSetStatus details the connections of the agent to the proxy servers.
+type ClientSetStatus struct {
+	// ServerCount is the number of proxy servers last reported by a
+	// server, or zero if no server was connected yet.
+	ServerCount int `json:"serverCount"`
+	// LastSync is the time of the last successful sync with the proxy
+	// servers.
+	LastSync time.Time      `json:"lastSync"`
+	Servers  []ServerStatus `json:"servers"`
+}
+
+// ServerStatus details the connection of the agent to a proxy server.
+type ServerStatus struct {
+	ServerID       string    `json:"serverID"`
+	Address        string    `json:"address"`
+	State          string    `json:"state"` // The state of the gRPC connection.
+	ConnectedSince time.Time `json:"connectedSince"`
+	Draining       bool      `json:"draining"`
+	// Connections is the number of open connections to destinations, and
+	// PendingDials the number of dials in progress.
+	Connections  int   `json:"connections"`
+	PendingDials int64 `json:"pendingDials"`
+}
+
+// Status returns the connections of the agent to the proxy servers, sorted
+// by server ID.
+func (cs *ClientSet) Status() ClientSetStatus {
+	cs.mu.Lock()
+	status := ClientSetStatus{ServerCount: cs.serverCount, LastSync: cs.lastSync, Servers: []ServerStatus{}}
+	clients := make([]*Client, 0, len(cs.clients))
+	for _, c := range cs.clients {
+		clients = append(clients, c)
+	}
+	cs.mu.Unlock()
+
+	for _, c := range clients {
+		s := ServerStatus{
+			ServerID:       c.serverID,
+			Address:        c.address,
+			State:          connectivity.Shutdown.String(),
+			ConnectedSince: c.connectedSince,
+			Draining:       c.draining.Load(),
+			Connections:    len(c.connManager.List()),
+			PendingDials:   atomic.LoadInt64(&c.pendingDials),
+		}
+		if c.conn != nil {
+			s.State = c.conn.GetState().String()
+		}
+		status.Servers = append(status.Servers, s)
+	}
+	sort.Slice(status.Servers, func(i, j int) bool { return status.Servers[i].ServerID < status.Servers[j].ServerID })
+	return status
+}
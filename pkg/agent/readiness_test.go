@@ -0,0 +1,115 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// readyConn returns a gRPC connection in the Ready state.
+func readyConn(t *testing.T) *grpc.ClientConn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	go s.Serve(l)
+	t.Cleanup(s.Stop)
+	ctx, cancel := context.WithTimeout(context.Background(), wait.ForeverTestTimeout)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, l.Addr().String(), grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// notReadyConn returns a gRPC connection which can't become Ready.
+func notReadyConn(t *testing.T) *grpc.ClientConn {
+	conn, err := grpc.Dial("127.0.0.1:1", grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestReadiness(t *testing.T) {
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenPath, []byte("token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cs := &ClientSet{
+		clients:                 make(map[string]*Client),
+		serviceAccountTokenPath: tokenPath,
+		readinessPolicy:         ReadinessPolicy{MinServerFraction: 0.5, MaxSyncAge: time.Minute},
+	}
+	assertReady := func(want bool, wantMsg string) {
+		t.Helper()
+		ready, msg := cs.Ready()
+		if ready != want || !strings.Contains(msg, wantMsg) {
+			t.Errorf("expect ready=%v with %q; got ready=%v with %q", want, wantMsg, ready, msg)
+		}
+		if status := cs.ReadinessStatus(); status.Ready != ready {
+			t.Errorf("expect the status to be ready=%v; got %+v", ready, status)
+		}
+	}
+
+	assertReady(false, "no connection to any proxy server")
+	assertReady(false, "no successful sync with the proxy servers yet")
+
+	cs.observeSync()
+	cs.updateServers("server1", 4, nil)
+	cs.AddClient("server1", &Client{serverID: "server1", conn: readyConn(t), connManager: newConnectionManager()})
+	cs.AddClient("server2", &Client{serverID: "server2", conn: notReadyConn(t), connManager: newConnectionManager()})
+	assertReady(false, "1 of 4 proxy servers connected, at least 2 required")
+
+	cs.AddClient("server3", &Client{serverID: "server3", conn: readyConn(t), connManager: newConnectionManager()})
+	assertReady(true, "")
+
+	cs.mu.Lock()
+	cs.lastSync = time.Now().Add(-2 * time.Minute)
+	cs.mu.Unlock()
+	assertReady(false, "last successful sync with the proxy servers 2m0s ago, at most 1m0s allowed")
+	cs.observeSync()
+
+	if err := os.Remove(tokenPath); err != nil {
+		t.Fatal(err)
+	}
+	assertReady(false, "failed to read the service account token")
+
+	status := cs.Status()
+	if status.ServerCount != 4 || len(status.Servers) != 3 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if s := status.Servers[0]; s.ServerID != "server1" || s.State != "READY" {
+		t.Errorf("unexpected server status %+v", s)
+	}
+	if s := status.Servers[1]; s.ServerID != "server2" || s.State == "READY" {
+		t.Errorf("unexpected server status %+v", s)
+	}
+}
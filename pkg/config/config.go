@@ -0,0 +1,188 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package config implements the versioned configuration files of the
+// proxy-server and the proxy-agent.
+//
+// A configuration file is a YAML (or JSON) document identified by its
+// apiVersion and kind. Its "options" section sets command-line options by
+// flag name, so that every flag can be set from the file, and flags given
+// on the command line take precedence over the file. Components add their
+// own sections for the settings which flags cannot express well.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+)
+
+// APIVersion is the only supported version of the configuration files.
+const APIVersion = "konnectivity.k8s.io/v1alpha1"
+
+// TypeMeta identifies the version and the kind of a configuration file.
+type TypeMeta struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+}
+
+// Options sets command-line options by flag name. Scalar values are set as
+// if given on the command line; lists are accepted for the list flags.
+type Options map[string]interface{}
+
+// ReadFile decodes the configuration file at path into obj, which must embed
+// TypeMeta, after checking the file is of the given kind.
+func ReadFile(path, kind string, obj interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	if err := Decode(data, kind, obj); err != nil {
+		return fmt.Errorf("failed to decode config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// Decode decodes data into obj, which must embed TypeMeta, after checking
+// data is a configuration of the given kind. Unknown fields are rejected.
+func Decode(data []byte, kind string, obj interface{}) error {
+	var meta TypeMeta
+	if err := yaml.Unmarshal(data, &meta); err != nil {
+		return err
+	}
+	if meta.APIVersion != APIVersion {
+		return fmt.Errorf("unsupported apiVersion %q, expected %q", meta.APIVersion, APIVersion)
+	}
+	if meta.Kind != kind {
+		return fmt.Errorf("unsupported kind %q, expected %q", meta.Kind, kind)
+	}
+	return yaml.UnmarshalStrict(data, obj, func(d *json.Decoder) *json.Decoder {
+		// Keep numbers as written, rather than converting them to floats.
+		d.UseNumber()
+		return d
+	})
+}
+
+// Apply sets the flags of fs from opts, except for the flags which were set
+// on the command line and thus take precedence. reserved lists flags which
+// cannot be set from a configuration file.
+func (opts Options) Apply(fs *pflag.FlagSet, reserved ...string) error {
+	names := make([]string, 0, len(opts))
+	for name := range opts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		flag := fs.Lookup(name)
+		if flag == nil || contains(reserved, name) {
+			return fmt.Errorf("unknown option %q", name)
+		}
+		if flag.Changed {
+			continue
+		}
+		if err := setFlag(flag, opts[name]); err != nil {
+			return fmt.Errorf("invalid value for option %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func setFlag(flag *pflag.Flag, value interface{}) error {
+	if list, ok := value.([]interface{}); ok {
+		slice, ok := flag.Value.(pflag.SliceValue)
+		if !ok {
+			return fmt.Errorf("expected a single %s, got a list", flag.Value.Type())
+		}
+		values := make([]string, 0, len(list))
+		for _, v := range list {
+			s, err := scalar(v)
+			if err != nil {
+				return err
+			}
+			values = append(values, s)
+		}
+		return slice.Replace(values)
+	}
+	s, err := scalar(value)
+	if err != nil {
+		return err
+	}
+	return flag.Value.Set(s)
+}
+
+func scalar(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+// EffectiveOptions returns the current values of the flags of fs, except
+// for the deprecated and the reserved ones.
+func EffectiveOptions(fs *pflag.FlagSet, reserved ...string) Options {
+	opts := make(Options)
+	fs.VisitAll(func(flag *pflag.Flag) {
+		if flag.Deprecated != "" || contains(reserved, flag.Name) {
+			return
+		}
+		opts[flag.Name] = flagValue(flag)
+	})
+	return opts
+}
+
+func flagValue(flag *pflag.Flag) interface{} {
+	if slice, ok := flag.Value.(pflag.SliceValue); ok {
+		return slice.GetSlice()
+	}
+	s := flag.Value.String()
+	switch t := flag.Value.Type(); {
+	case t == "bool":
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	case strings.HasPrefix(t, "int"), strings.HasPrefix(t, "uint"), strings.HasPrefix(t, "float"):
+		return json.Number(s)
+	}
+	return s
+}
+
+// Marshal encodes obj as YAML.
+func Marshal(obj interface{}) ([]byte, error) {
+	return yaml.Marshal(obj)
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
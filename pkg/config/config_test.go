@@ -0,0 +1,158 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+type testConfiguration struct {
+	TypeMeta `json:",inline"`
+	Options  Options `json:"options,omitempty"`
+}
+
+type testOptions struct {
+	name     string
+	port     int
+	enabled  bool
+	interval time.Duration
+	protos   []string
+	config   string
+}
+
+func (o *testOptions) flags() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.StringVar(&o.name, "name", "default", "")
+	fs.IntVar(&o.port, "port", 8080, "")
+	fs.BoolVar(&o.enabled, "enabled", false, "")
+	fs.DurationVar(&o.interval, "interval", time.Second, "")
+	fs.StringSliceVar(&o.protos, "protos", nil, "")
+	fs.StringVar(&o.config, "config", "", "")
+	return fs
+}
+
+func TestDecode(t *testing.T) {
+	testcases := []struct {
+		desc string
+		data string
+		err  string
+	}{
+		{
+			desc: "valid",
+			data: "apiVersion: konnectivity.k8s.io/v1alpha1\nkind: Test\noptions:\n  port: 9090\n",
+		},
+		{
+			desc: "wrong apiVersion",
+			data: "apiVersion: konnectivity.k8s.io/v2\nkind: Test\n",
+			err:  "unsupported apiVersion",
+		},
+		{
+			desc: "wrong kind",
+			data: "apiVersion: konnectivity.k8s.io/v1alpha1\nkind: Other\n",
+			err:  "unsupported kind",
+		},
+		{
+			desc: "unknown field",
+			data: "apiVersion: konnectivity.k8s.io/v1alpha1\nkind: Test\nport: 9090\n",
+			err:  "unknown field",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := Decode([]byte(tc.data), "Test", &testConfiguration{})
+			if tc.err == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Errorf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	data := `
+apiVersion: konnectivity.k8s.io/v1alpha1
+kind: Test
+options:
+  name: from-file
+  port: 9090
+  enabled: true
+  interval: 1m
+  protos: [h2, konnectivity]
+`
+	cfg := &testConfiguration{}
+	if err := Decode([]byte(data), "Test", cfg); err != nil {
+		t.Fatal(err)
+	}
+	o := &testOptions{}
+	fs := o.flags()
+	if err := fs.Parse([]string{"--port=7070"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Options.Apply(fs, "config"); err != nil {
+		t.Fatal(err)
+	}
+	expected := testOptions{name: "from-file", port: 7070, enabled: true, interval: time.Minute, protos: []string{"h2", "konnectivity"}}
+	if !reflect.DeepEqual(*o, expected) {
+		t.Errorf("expected options %+v, got %+v", expected, *o)
+	}
+
+	effective := EffectiveOptions(fs, "config")
+	if _, ok := effective["config"]; ok {
+		t.Error("expected reserved flags to be omitted from the effective options")
+	}
+	data2, err := Marshal(&testConfiguration{TypeMeta: TypeMeta{APIVersion: APIVersion, Kind: "Test"}, Options: effective})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The effective options apply back to the same values.
+	cfg = &testConfiguration{}
+	if err := Decode(data2, "Test", cfg); err != nil {
+		t.Fatalf("failed to decode %s: %v", data2, err)
+	}
+	o = &testOptions{}
+	if err := cfg.Options.Apply(o.flags(), "config"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*o, expected) {
+		t.Errorf("expected round-tripped options %+v, got %+v", expected, *o)
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	testcases := map[string]Options{
+		"unknown option":  {"unknown": "value"},
+		"reserved option": {"config": "other.yaml"},
+		"invalid value":   {"port": "eighty"},
+		"unexpected list": {"port": []interface{}{"1", "2"}},
+		"map value":       {"name": map[string]interface{}{"a": "b"}},
+	}
+	for desc, opts := range testcases {
+		t.Run(desc, func(t *testing.T) {
+			o := &testOptions{}
+			if err := opts.Apply(o.flags(), "config"); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
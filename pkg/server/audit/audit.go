@@ -0,0 +1,287 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package audit provides structured records of the connections tunneled
+// through the proxy server, and sinks to persist them.
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// CloseReason describes why a tunneled connection ended.
+type CloseReason string
+
+const (
+	CloseReasonNoAgent          CloseReason = "no_agent"          // No agent was available to serve the dial.
+	CloseReasonDialError        CloseReason = "dial_error"        // The agent reported a dial failure.
+	CloseReasonDialCancelled    CloseReason = "dial_cancelled"    // The frontend cancelled the dial (DIAL_CLS) before it completed.
+	CloseReasonDialClosed       CloseReason = "dial_closed"       // The agent terminated the dial (DIAL_CLS) before it completed.
+	CloseReasonDialTimeout      CloseReason = "dial_timeout"      // The agent did not answer the dial within the dial timeout.
+	CloseReasonSendFailure      CloseReason = "send_failure"      // A packet required to set up the tunnel could not be sent.
+	CloseReasonClosed           CloseReason = "closed"            // The connection was closed (CLOSE_RSP) by the agent.
+	CloseReasonFrontendShutdown CloseReason = "frontend_shutdown" // The frontend stream went away.
+	CloseReasonBackendShutdown  CloseReason = "backend_shutdown"  // The agent stream went away.
+	CloseReasonIdleTimeout      CloseReason = "idle_timeout"      // The server closed the connection, idle for the idle timeout.
+	CloseReasonMaxLifetime      CloseReason = "max_lifetime"      // The server closed the connection, open for the maximum lifetime.
+	CloseReasonSendTimeout      CloseReason = "send_timeout"      // The server closed the connection, whose DATA the agent did not take within the send timeout.
+	CloseReasonReplaced         CloseReason = "replaced"          // The server closed the connection, whose connection ID the agent reused for another.
+)
+
+// Record describes a single tunneled connection, from the dial request until
+// the connection is torn down.
+type Record struct {
+	// StartTime is when the dial request was received from the frontend.
+	StartTime time.Time `json:"startTime"`
+	// EndTime is when the connection was torn down.
+	EndTime time.Time `json:"endTime"`
+	// Mode is the frontend mode, either "grpc" or "http-connect".
+	Mode string `json:"mode"`
+	// ClientIdentity is the identity of the frontend, i.e. the common name
+	// of its client certificate. Empty if the frontend is not authenticated
+	// with a certificate, e.g. when it connects through UDS.
+	ClientIdentity string `json:"clientIdentity,omitempty"`
+	// UserAgent is the user agent reported by the frontend.
+	UserAgent string `json:"userAgent,omitempty"`
+	// Destination is the address the frontend asked to dial.
+	Destination string `json:"destination"`
+	// ServerID is the ID of the proxy server handling the connection.
+	ServerID string `json:"serverID"`
+	// AgentID is the ID of the agent chosen to dial the destination.
+	AgentID string `json:"agentID,omitempty"`
+	// DialID is the random ID of the dial request.
+	DialID int64 `json:"dialID"`
+	// ConnectID is the agent assigned connection ID, zero if the dial
+	// never completed.
+	ConnectID int64 `json:"connectID,omitempty"`
+	// DialLatencySeconds is the time between the dial request and the dial
+	// response. Zero if the dial never completed.
+	DialLatencySeconds float64 `json:"dialLatencySeconds,omitempty"`
+	// DurationSeconds is the total lifetime of the connection.
+	DurationSeconds float64 `json:"durationSeconds"`
+	// BytesToAgent is the number of DATA payload bytes sent from the
+	// frontend towards the agent.
+	BytesToAgent int64 `json:"bytesToAgent"`
+	// BytesFromAgent is the number of DATA payload bytes sent from the
+	// agent towards the frontend.
+	BytesFromAgent int64 `json:"bytesFromAgent"`
+	// CloseReason is why the connection ended.
+	CloseReason CloseReason `json:"closeReason"`
+	// Error holds the error message associated with CloseReason, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Sink persists audit records. Implementations must be safe for concurrent
+// use.
+type Sink interface {
+	// Write persists a single record.
+	Write(r *Record) error
+	// Close flushes and releases the resources held by the sink.
+	Close() error
+}
+
+var _ Sink = &FileSink{}
+
+// FileSink writes records as JSON lines to a file. When the file would grow
+// over maxSize bytes it is rotated, keeping at most maxBackups old files named
+// <path>.1 (most recent) to <path>.<maxBackups>.
+type FileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex // protects the following
+	file *os.File
+	size int64
+}
+
+// NewFileSink returns a FileSink appending to the file at path. A maxSize of
+// zero disables rotation.
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	s := &FileSink{
+		path:       filepath.Clean(path),
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log %s: %v", s.path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close() /* #nosec G104 */
+		return fmt.Errorf("failed to stat audit log %s: %v", s.path, err)
+	}
+	s.file = f
+	s.size = info.Size()
+	return nil
+}
+
+// rotate must be called with s.mu held.
+func (s *FileSink) rotate() error {
+	err := s.file.Close()
+	s.file = nil
+	if err != nil {
+		return fmt.Errorf("failed to close audit log %s: %v", s.path, err)
+	}
+	if s.maxBackups > 0 {
+		for i := s.maxBackups - 1; i > 0; i-- {
+			from := fmt.Sprintf("%s.%d", s.path, i)
+			if err := os.Rename(from, fmt.Sprintf("%s.%d", s.path, i+1)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to rotate audit log %s: %v", from, err)
+			}
+		}
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			return fmt.Errorf("failed to rotate audit log %s: %v", s.path, err)
+		}
+	} else if err := os.Remove(s.path); err != nil {
+		return fmt.Errorf("failed to remove audit log %s: %v", s.path, err)
+	}
+	return s.open()
+}
+
+// Write appends the record to the file, rotating it first if needed.
+func (s *FileSink) Write(r *Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return fmt.Errorf("audit log %s is closed", s.path)
+	}
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+// Close closes the underlying file.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// DefaultBufferSize is the number of records an AsyncSink buffers before
+// its writers wait for room, or the records are dropped.
+const DefaultBufferSize = 4096
+
+// ErrBufferFull is returned by AsyncSink.Write when the record is dropped
+// because the buffer is full.
+var ErrBufferFull = errors.New("audit buffer is full")
+
+var _ Sink = &AsyncSink{}
+
+// AsyncSink queues records on a bounded buffer, and writes them to the
+// wrapped sink from a single goroutine, so that writers don't wait on the
+// wrapped sink, e.g. on a file rotation, as long as the buffer has room.
+// When it is full, the writers wait for room, unless the sink drops the
+// records.
+type AsyncSink struct {
+	sink    Sink
+	records chan *Record
+	done    chan struct{}
+	// drop makes Write drop the records instead of waiting for room.
+	drop bool
+
+	mu     sync.RWMutex // protects closed, and sending on records
+	closed bool
+}
+
+// NewAsyncSink returns an AsyncSink buffering up to size records in front
+// of sink, and starts its writer goroutine. If drop is true, the records
+// are dropped when the buffer is full.
+func NewAsyncSink(sink Sink, size int, drop bool) *AsyncSink {
+	s := &AsyncSink{
+		sink:    sink,
+		records: make(chan *Record, size),
+		done:    make(chan struct{}),
+		drop:    drop,
+	}
+	go s.run()
+	return s
+}
+
+func (s *AsyncSink) run() {
+	defer close(s.done)
+	for r := range s.records {
+		if err := s.sink.Write(r); err != nil {
+			klog.ErrorS(err, "Failed to write audit record", "dialID", r.DialID, "connectionID", r.ConnectID)
+		}
+	}
+}
+
+// Write queues the record, waiting for room in the buffer if it is full.
+// If the sink drops the records, it returns ErrBufferFull instead.
+func (s *AsyncSink) Write(r *Record) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return errors.New("audit sink is closed")
+	}
+	if !s.drop {
+		s.records <- r
+		return nil
+	}
+	select {
+	case s.records <- r:
+		return nil
+	default:
+		return ErrBufferFull
+	}
+}
+
+// Close writes the queued records, then closes the wrapped sink.
+func (s *AsyncSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.records)
+	s.mu.Unlock()
+	<-s.done
+	return s.sink.Close()
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func readRecords(t *testing.T, path string) []Record {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", path, err)
+	}
+	defer f.Close()
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("invalid record %q: %v", scanner.Text(), err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestFileSinkWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewFileSink(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(1); i <= 3; i++ {
+		if err := sink.Write(&Record{DialID: i, Destination: "127.0.0.1:80", CloseReason: CloseReasonClosed}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write(&Record{}); err == nil {
+		t.Error("expected error writing to closed sink")
+	}
+
+	records := readRecords(t, path)
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(records))
+	}
+	for i, r := range records {
+		if r.DialID != int64(i+1) || r.CloseReason != CloseReasonClosed {
+			t.Errorf("unexpected record %d: %+v", i, r)
+		}
+	}
+}
+
+func TestFileSinkRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	line, _ := json.Marshal(&Record{DialID: 1})
+	// Room for two records per file.
+	sink, err := NewFileSink(path, int64(2*(len(line)+1)), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	for i := int64(1); i <= 7; i++ {
+		if err := sink.Write(&Record{DialID: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for file, expected := range map[string][]int64{
+		path:        {7},
+		path + ".1": {5, 6},
+		path + ".2": {3, 4},
+	} {
+		records := readRecords(t, file)
+		if len(records) != len(expected) {
+			t.Fatalf("expected %d records in %s, got %d", len(expected), file, len(records))
+		}
+		for i, r := range records {
+			if r.DialID != expected[i] {
+				t.Errorf("expected dialID %d in %s, got %d", expected[i], file, r.DialID)
+			}
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected at most 2 backups, got %v", err)
+	}
+}
+
+// blockingSink records the written records once unblocked.
+type blockingSink struct {
+	unblock chan struct{}
+	written chan *Record
+	closed  bool
+}
+
+func (s *blockingSink) Write(r *Record) error {
+	<-s.unblock
+	s.written <- r
+	return nil
+}
+
+func (s *blockingSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestAsyncSink(t *testing.T) {
+	for _, drop := range []bool{false, true} {
+		t.Run(fmt.Sprintf("drop=%v", drop), func(t *testing.T) {
+			inner := &blockingSink{
+				unblock: make(chan struct{}),
+				written: make(chan *Record, 10),
+			}
+			sink := NewAsyncSink(inner, 2, drop)
+
+			// The writer goroutine takes the first record and blocks on
+			// it, the next two fill the buffer.
+			if err := sink.Write(&Record{DialID: 1}); err != nil {
+				t.Fatal(err)
+			}
+			if err := wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+				return len(sink.records) == 0, nil
+			}); err != nil {
+				t.Fatal("the writer did not pick up the first record")
+			}
+			for i := int64(2); i <= 3; i++ {
+				if err := sink.Write(&Record{DialID: i}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want := []int64{1, 2, 3}
+			if drop {
+				// The last one is dropped.
+				if err := sink.Write(&Record{DialID: 4}); err != ErrBufferFull {
+					t.Errorf("expected ErrBufferFull, got %v", err)
+				}
+				close(inner.unblock)
+			} else {
+				// The last one waits for room.
+				written := make(chan error)
+				go func() { written <- sink.Write(&Record{DialID: 4}) }()
+				select {
+				case err := <-written:
+					t.Fatalf("expected the write to wait for room, got %v", err)
+				case <-time.After(100 * time.Millisecond):
+				}
+				close(inner.unblock)
+				if err := <-written; err != nil {
+					t.Fatal(err)
+				}
+				want = append(want, 4)
+			}
+
+			if err := sink.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if !inner.closed {
+				t.Error("expected the wrapped sink to be closed")
+			}
+			close(inner.written)
+			var dialIDs []int64
+			for r := range inner.written {
+				dialIDs = append(dialIDs, r.DialID)
+			}
+			if !reflect.DeepEqual(dialIDs, want) {
+				t.Errorf("expected records %v to be written, got %v", want, dialIDs)
+			}
+			if err := sink.Write(&Record{}); err == nil {
+				t.Error("expected error writing to closed sink")
+			}
+		})
+	}
+}
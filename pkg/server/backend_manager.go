@@ -80,24 +80,27 @@ var _ Backend = &backend{}
 var _ Backend = agent.AgentService_ConnectServer(nil)
 
 type backend struct {
-	// TODO: this is a multi-writer single-reader pattern, it's tricky to
-	// write it using channel. Let's worry about performance later.
-	sendLock sync.Mutex
 	recvLock sync.Mutex
-	conn     agent.AgentService_ConnectServer
+	// conn is the agent stream, whose sends are serialized by its
+	// sendQueue, shared by the backends of the stream in the backend
+	// managers.
+	conn agent.AgentService_ConnectServer
+
+	// id is the agent ID of conn, resolved lazily for the per-agent metrics.
+	idOnce sync.Once
+	id     string
 }
 
+// Send queues p to be sent to the agent. The errors of the stream are
+// returned by the sends which follow them.
 func (b *backend) Send(p *client.Packet) error {
-	b.sendLock.Lock()
-	defer b.sendLock.Unlock()
-
 	const segment = commonmetrics.SegmentToAgent
 	metrics.Metrics.ObservePacket(segment, p.Type)
-	err := b.conn.Send(p)
-	if err != nil && err != io.EOF {
-		metrics.Metrics.ObserveStreamError(segment, err, p.Type)
+	metrics.Metrics.ObserveDataBytes(segment, p)
+	if metrics.Metrics.AgentMetricsEnabled() {
+		metrics.Metrics.ObserveAgentDataBytes(b.agentID(), segment, p)
 	}
-	return err
+	return b.conn.Send(p)
 }
 
 func (b *backend) Recv() (*client.Packet, error) {
@@ -113,9 +116,24 @@ func (b *backend) Recv() (*client.Packet, error) {
 		return nil, err
 	}
 	metrics.Metrics.ObservePacket(segment, pkt.Type)
+	metrics.Metrics.ObserveDataBytes(segment, pkt)
+	if metrics.Metrics.AgentMetricsEnabled() {
+		metrics.Metrics.ObserveAgentDataBytes(b.agentID(), segment, pkt)
+	}
 	return pkt, nil
 }
 
+func (b *backend) agentID() string {
+	b.idOnce.Do(func() {
+		id, err := agentID(b.conn)
+		if err != nil {
+			klog.V(2).InfoS("Failed to get the agent ID of backend", "error", err)
+		}
+		b.id = id
+	})
+	return b.id
+}
+
 func (b *backend) Context() context.Context {
 	// TODO: does Context require lock protection?
 	return b.conn.Context()
@@ -125,6 +143,12 @@ func newBackend(conn agent.AgentService_ConnectServer) *backend {
 	return &backend{conn: conn}
 }
 
+// BackendIdentifier is an identifier by which a backend is stored.
+type BackendIdentifier struct {
+	ID   string
+	Type pkgagent.IdentifierType
+}
+
 // BackendStorage is an interface to manage the storage of the backend
 // connections, i.e., get, add and remove
 type BackendStorage interface {
@@ -132,6 +156,9 @@ type BackendStorage interface {
 	AddBackend(identifier string, idType pkgagent.IdentifierType, conn agent.AgentService_ConnectServer) Backend
 	// RemoveBackend removes a backend.
 	RemoveBackend(identifier string, idType pkgagent.IdentifierType, conn agent.AgentService_ConnectServer)
+	// UpdateBackend replaces the removed identifiers of a backend with the
+	// added ones, at once.
+	UpdateBackend(conn agent.AgentService_ConnectServer, removed, added []BackendIdentifier)
 	// NumBackends returns the number of backends.
 	NumBackends() int
 }
@@ -166,8 +193,8 @@ type DefaultBackendStorage struct {
 	mu sync.RWMutex //protects the following
 	// A map between agentID and its grpc connections.
 	// For a given agent, ProxyServer prefers backends[agentID][0] to send
-	// traffic, because backends[agentID][1:] are more likely to be closed
-	// by the agent to deduplicate connections to the same server.
+	// traffic. The older connections of an agent are drained and removed
+	// once it connects again, so there is usually a single one.
 	backends map[string][]*backend
 	// agentID is tracked in this slice to enable randomly picking an
 	// agentID in the Backend() method. There is no reliable way to
@@ -222,6 +249,10 @@ func (s *DefaultBackendStorage) AddBackend(identifier string, idType pkgagent.Id
 	klog.V(5).InfoS("Register backend for agent", "connection", conn, "agentID", identifier)
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.addBackendLocked(identifier, idType, conn)
+}
+
+func (s *DefaultBackendStorage) addBackendLocked(identifier string, idType pkgagent.IdentifierType, conn agent.AgentService_ConnectServer) Backend {
 	_, ok := s.backends[identifier]
 	addedBackend := newBackend(conn)
 	if ok {
@@ -252,6 +283,10 @@ func (s *DefaultBackendStorage) RemoveBackend(identifier string, idType pkgagent
 	klog.V(5).InfoS("Remove connection for agent", "connection", conn, "identifier", identifier)
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.removeBackendLocked(identifier, idType, conn)
+}
+
+func (s *DefaultBackendStorage) removeBackendLocked(identifier string, idType pkgagent.IdentifierType, conn agent.AgentService_ConnectServer) {
 	backends, ok := s.backends[identifier]
 	if !ok {
 		klog.V(1).InfoS("Cannot find agent in backends", "identifier", identifier)
@@ -262,7 +297,7 @@ func (s *DefaultBackendStorage) RemoveBackend(identifier string, idType pkgagent
 		if c.conn == conn {
 			s.backends[identifier] = append(s.backends[identifier][:i], s.backends[identifier][i+1:]...)
 			if i == 0 && len(s.backends[identifier]) != 0 {
-				klog.V(1).InfoS("This should not happen. Removed connection that is not the first connection", "connection", conn, "remainingConnections", s.backends[identifier])
+				klog.V(2).InfoS("Removed the first connection of agent, a later connection takes over", "connection", conn, "remainingConnections", s.backends[identifier])
 			}
 			found = true
 		}
@@ -291,6 +326,29 @@ func (s *DefaultBackendStorage) RemoveBackend(identifier string, idType pkgagent
 	metrics.Metrics.SetBackendCount(len(s.backends))
 }
 
+// UpdateBackend replaces the removed identifiers of the backend of conn with
+// the added ones. Both are applied under a single lock, so that no dial sees
+// the backend stored by neither the old nor the new identifiers.
+func (s *DefaultBackendStorage) UpdateBackend(conn agent.AgentService_ConnectServer, removed, added []BackendIdentifier) {
+	klog.V(5).InfoS("Update identifiers of agent", "connection", conn, "removed", removed, "added", added)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range removed {
+		if !containIDType(s.idTypes, id.Type) {
+			klog.ErrorS(&ErrWrongIDType{id.Type, s.idTypes}, "fail to remove backend")
+			continue
+		}
+		s.removeBackendLocked(id.ID, id.Type, conn)
+	}
+	for _, id := range added {
+		if !containIDType(s.idTypes, id.Type) {
+			klog.V(4).InfoS("fail to add backend", "backend", id.ID, "error", &ErrWrongIDType{id.Type, s.idTypes})
+			continue
+		}
+		s.addBackendLocked(id.ID, id.Type, conn)
+	}
+}
+
 // NumBackends resturns the number of available backends
 func (s *DefaultBackendStorage) NumBackends() int {
 	s.mu.RLock()
@@ -332,6 +390,6 @@ func (s *DefaultBackendStorage) GetRandomBackend() (Backend, error) {
 	agentID := s.agentIDs[s.random.Intn(len(s.agentIDs))]
 	klog.V(5).InfoS("Pick agent as backend", "agentID", agentID)
 	// always return the first connection to an agent, because the agent
-	// will close later connections if there are multiple.
+	// is told to drain later connections if there are multiple.
 	return s.backends[agentID][0], nil
 }
@@ -124,3 +124,54 @@ func TestAddRemoveBackendsWithDefaultRoute(t *testing.T) {
 		t.Errorf("expected %v, got %v", e, a)
 	}
 }
+
+func TestUpdateBackend(t *testing.T) {
+	conn1 := new(fakeAgentServiceConnectServer)
+	conn2 := new(fakeAgentServiceConnectServer)
+
+	p := NewDestHostBackendManager()
+	p.AddBackend("node1", pkgagent.Host, conn1)
+	p.AddBackend("10.0.0.1", pkgagent.IPv4, conn1)
+	p.AddBackend("node2", pkgagent.Host, conn2)
+
+	p.UpdateBackend(conn1,
+		[]BackendIdentifier{{ID: "node1", Type: pkgagent.Host}},
+		[]BackendIdentifier{{ID: "node2", Type: pkgagent.Host}, {ID: "node3", Type: pkgagent.Host}, {ID: "agent1", Type: pkgagent.UID}})
+
+	// The identifier of the wrong type is ignored.
+	expectedBackends := map[string][]*backend{
+		"10.0.0.1": {newBackend(conn1)},
+		"node2":    {newBackend(conn2), newBackend(conn1)},
+		"node3":    {newBackend(conn1)},
+	}
+	if e, a := expectedBackends, p.backends; !reflect.DeepEqual(e, a) {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+	if e, a := 3, len(p.agentIDs); e != a {
+		t.Errorf("expected %v agent IDs, got %v", e, a)
+	}
+}
+
+func TestSetDestHostIdentifierTypes(t *testing.T) {
+	s := NewProxyServer("server-a", []ProxyStrategy{ProxyStrategyDefault}, 1, &AgentTokenAuthenticationOptions{})
+	if err := s.SetDestHostIdentifierTypes([]pkgagent.IdentifierType{pkgagent.Host}); err == nil {
+		t.Error("expected an error without the destHost strategy")
+	}
+
+	s = NewProxyServer("server-a", []ProxyStrategy{ProxyStrategyDestHost}, 1, &AgentTokenAuthenticationOptions{})
+	if err := s.SetDestHostIdentifierTypes([]pkgagent.IdentifierType{pkgagent.UID}); err == nil {
+		t.Error("expected an error for the uid identifiers")
+	}
+	if err := s.SetDestHostIdentifierTypes([]pkgagent.IdentifierType{pkgagent.Host}); err != nil {
+		t.Fatal(err)
+	}
+	bm := s.BackendManagers[0]
+	bm.AddBackend("10.0.0.1", pkgagent.IPv4, new(fakeAgentServiceConnectServer))
+	bm.AddBackend("node1.local", pkgagent.Host, new(fakeAgentServiceConnectServer))
+	if _, err := s.getBackend("10.0.0.1:10250"); err == nil {
+		t.Error("expected no backend for an IP when destHost only matches host names")
+	}
+	if _, err := s.getBackend("node1.local:10250"); err != nil {
+		t.Errorf("expected a backend for the host name, got %v", err)
+	}
+}
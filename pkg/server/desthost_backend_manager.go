@@ -18,11 +18,16 @@ package server
 
 import (
 	"context"
+	"fmt"
 
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/apiserver-network-proxy/pkg/agent"
 )
 
+// DestHostIdentifierTypes are the types of the agent identifiers which the
+// destHost strategy matches the destinations against by default.
+var DestHostIdentifierTypes = []agent.IdentifierType{agent.IPv4, agent.IPv6, agent.Host}
+
 type DestHostBackendManager struct {
 	*DefaultBackendStorage
 }
@@ -31,8 +36,33 @@ var _ BackendManager = &DestHostBackendManager{}
 
 func NewDestHostBackendManager() *DestHostBackendManager {
 	return &DestHostBackendManager{
-		DefaultBackendStorage: NewDefaultBackendStorage(
-			[]agent.IdentifierType{agent.IPv4, agent.IPv6, agent.Host})}
+		DefaultBackendStorage: NewDefaultBackendStorage(DestHostIdentifierTypes)}
+}
+
+// SetDestHostIdentifierTypes makes the destHost strategy match the
+// destinations against the agent identifiers of idTypes only, some of
+// DestHostIdentifierTypes. It must be called before any agent connects.
+func (s *ProxyServer) SetDestHostIdentifierTypes(idTypes []agent.IdentifierType) error {
+	bm, ok := s.backendManager(ProxyStrategyDestHost).(*DestHostBackendManager)
+	if !ok {
+		return fmt.Errorf("the destHost proxy strategy is not used")
+	}
+	for _, idType := range idTypes {
+		if !containIDType(DestHostIdentifierTypes, idType) {
+			return fmt.Errorf("the destHost proxy strategy can't match the %s identifiers", idType)
+		}
+	}
+	bm.idTypes = idTypes
+	return nil
+}
+
+// destHostIdentifierTypes returns the types of the agent identifiers which
+// the destHost strategy matches the destinations against.
+func (s *ProxyServer) destHostIdentifierTypes() []agent.IdentifierType {
+	if bm, ok := s.backendManager(ProxyStrategyDestHost).(*DestHostBackendManager); ok {
+		return bm.idTypes
+	}
+	return DestHostIdentifierTypes
 }
 
 // Backend tries to get a backend associating to the request destination host.
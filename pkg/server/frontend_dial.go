@@ -0,0 +1,351 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+	commonmetrics "sigs.k8s.io/apiserver-network-proxy/konnectivity-client/pkg/common/metrics"
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server/audit"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server/metrics"
+)
+
+// frontendDial is the dial of a frontend which tunnels one connection of
+// its client, as in the http-connect and socks5 modes. The client is
+// answered once the agent dialed the destination, after which the data of
+// the connection is forwarded between the client and the agent.
+type frontendDial struct {
+	server     *ProxyServer
+	connection *ProxyClientConnection
+	tunnel     *connectTunnel
+}
+
+func (s *ProxyServer) newFrontendDial(mode, address, clientIdentity, userAgent string) *frontendDial {
+	tunnel := newConnectTunnel()
+	return &frontendDial{
+		server: s,
+		tunnel: tunnel,
+		connection: &ProxyClientConnection{
+			Mode:           mode,
+			HTTP:           io.ReadWriter(tunnel), // pass as ReadWriter so the caller must close with CloseHTTP
+			CloseHTTP:      tunnel.Close,
+			connected:      make(chan struct{}),
+			dialID:         rand.Int63(), /* #nosec G404 */
+			start:          time.Now(),
+			dialAddress:    address,
+			clientIdentity: clientIdentity,
+			userAgent:      userAgent,
+		},
+	}
+}
+
+// dialErrorKind classifies the failures of a frontendDial, which the
+// frontends report to their clients in their own terms.
+type dialErrorKind int
+
+const (
+	dialNoAgent     dialErrorKind = iota // No agent serves the destination.
+	dialSendFailure                      // The dial request could not be sent to the agent.
+	dialFailed                           // The agent failed to dial the destination.
+	dialClosed                           // The agent terminated the dial (DIAL_CLS).
+	dialTimeout                          // The dial timed out, in the agent or in the server.
+	dialCancelled                        // The client went away before the dial completed.
+)
+
+type dialError struct {
+	kind dialErrorKind
+	msg  string
+	// code classifies the failure of a dialFailed or dialTimeout in the
+	// agent.
+	code client.DialErrorCode
+}
+
+func (e *dialError) Error() string {
+	return e.msg
+}
+
+// agentDialError is the error of a dial which failed in the agent with msg
+// and code.
+func agentDialError(msg string, code client.DialErrorCode) *dialError {
+	if code == client.DialErrorCode_DIAL_ERROR_UNSPECIFIED {
+		code = dialErrorCodeFromMessage(msg)
+	}
+	if code == client.DialErrorCode_DIAL_ERROR_TIMEOUT {
+		return &dialError{kind: dialTimeout, msg: msg, code: code}
+	}
+	return &dialError{kind: dialFailed, msg: msg, code: code}
+}
+
+// dialErrorCodeFromMessage classifies the error message of a dial which
+// failed in an agent that doesn't send the error code.
+func dialErrorCodeFromMessage(msg string) client.DialErrorCode {
+	switch lower := strings.ToLower(msg); {
+	case strings.Contains(lower, "timeout"), strings.Contains(lower, "timed out"), strings.Contains(lower, "deadline exceeded"):
+		return client.DialErrorCode_DIAL_ERROR_TIMEOUT
+	case strings.Contains(lower, "connection refused"):
+		return client.DialErrorCode_DIAL_ERROR_CONNECTION_REFUSED
+	case strings.Contains(lower, "no such host"):
+		return client.DialErrorCode_DIAL_ERROR_NO_SUCH_HOST
+	case strings.Contains(lower, "host is unreachable"):
+		return client.DialErrorCode_DIAL_ERROR_HOST_UNREACHABLE
+	case strings.Contains(lower, "network is unreachable"):
+		return client.DialErrorCode_DIAL_ERROR_NETWORK_UNREACHABLE
+	}
+	return client.DialErrorCode_DIAL_ERROR_UNSPECIFIED
+}
+
+// dial asks an agent to dial the destination, and waits for the result.
+// The dial is cancelled with a DIAL_CLS when timeout, if positive, expires or
+// when ctx is done. The returned error is a *dialError.
+func (d *frontendDial) dial(ctx context.Context, timeout time.Duration) error {
+	c := d.connection
+	dialRequest := &client.Packet{
+		Type: client.PacketType_DIAL_REQ,
+		Payload: &client.Packet_DialRequest{
+			DialRequest: &client.DialRequest{
+				Protocol: "tcp",
+				Address:  c.dialAddress,
+				Random:   c.dialID,
+			},
+		},
+	}
+	if timeout > 0 {
+		// The agent needn't dial for longer than the server waits.
+		dialRequest.GetDialRequest().TimeoutMillis = timeout.Milliseconds()
+	}
+
+	klog.V(4).Infof("Set pending(rand=%d) to %s connection", c.dialID, c.Mode)
+	ctx = d.server.startConnectionTrace(ctx, c)
+	backend, err := d.server.selectBackend(ctx, c.dialAddress, true)
+	if err != nil {
+		d.server.finishConnection(c, audit.CloseReasonNoAgent, err.Error())
+		return &dialError{kind: dialNoAgent, msg: fmt.Sprintf("currently no tunnels available: %v", err)}
+	}
+	c.backend = backend
+	d.server.startDialTrace(ctx, c, dialRequest.GetDialRequest())
+	d.server.PendingDial.Add(c.dialID, c)
+	if err := backend.Send(dialRequest); err != nil {
+		klog.ErrorS(err, "failed to tunnel dial request")
+		if d.server.PendingDial.Remove(c.dialID) != nil {
+			d.server.finishConnection(c, audit.CloseReasonSendFailure, err.Error())
+		}
+		return &dialError{kind: dialSendFailure, msg: fmt.Sprintf("failed to send the dial request to the agent: %v", err)}
+	}
+	return d.wait(ctx, timeout)
+}
+
+func (d *frontendDial) wait(ctx context.Context, timeout time.Duration) error {
+	c := d.connection
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	done := ctx.Done()
+	for {
+		select {
+		case <-c.connected:
+			return nil
+		case <-d.tunnel.closed:
+			select {
+			case <-c.connected: // Closed by the agent right after the dial.
+				return nil
+			default:
+			}
+			if c.dialError == "" {
+				return &dialError{kind: dialClosed, msg: "dial terminated by the agent"}
+			}
+			return agentDialError(c.dialError, c.dialErrorCode)
+		case <-expired:
+			if d.server.PendingDial.Remove(c.dialID) == nil {
+				// The response of the agent is being delivered.
+				expired = nil
+				continue
+			}
+			klog.V(2).InfoS("Dial timed out", "dialID", c.dialID, "dialAddress", c.dialAddress, "dialTimeout", timeout)
+			d.server.sendBackendDialClose(c.backend, c.dialID, "dial timeout")
+			metrics.Metrics.ObserveDialFailure(metrics.DialFailureTimeout)
+			d.server.observeDial(false)
+			d.server.finishConnection(c, audit.CloseReasonDialTimeout, "")
+			return &dialError{kind: dialTimeout, msg: fmt.Sprintf("dial to %s timed out after %v", c.dialAddress, timeout)}
+		case <-done:
+			if d.server.PendingDial.Remove(c.dialID) == nil {
+				done = nil
+				continue
+			}
+			klog.V(2).InfoS("Dial cancelled by frontend", "dialID", c.dialID, "dialAddress", c.dialAddress)
+			d.server.sendBackendDialClose(c.backend, c.dialID, "frontend went away")
+			metrics.Metrics.ObserveDialFailure(metrics.DialFailureFrontendClose)
+			d.server.finishConnection(c, audit.CloseReasonDialCancelled, "")
+			return &dialError{kind: dialCancelled, msg: "dial cancelled by the client"}
+		}
+	}
+}
+
+// serve forwards the data of the client, read from r, to the agent, and the
+// data of the agent to conn, until either side closes the connection.
+func (d *frontendDial) serve(conn io.ReadWriteCloser, r io.Reader) {
+	c := d.connection
+	if !d.tunnel.establish(conn) {
+		conn.Close()
+		return // The agent closed the connection already.
+	}
+
+	klog.V(3).InfoS("Starting proxy to host", "host", c.dialAddress, "clientIdentity", c.clientIdentity)
+	pkt := make([]byte, 1<<15) // Match GRPC Window size
+
+	connID := c.connectID
+	agentID := c.agentID
+	var acc int
+
+	for {
+		n, err := r.Read(pkt[:])
+		acc += n
+		if err == io.EOF {
+			klog.V(1).InfoS("EOF from host", "host", c.dialAddress)
+			break
+		}
+		if err != nil {
+			klog.ErrorS(err, "Received failure on connection")
+			break
+		}
+
+		packet := &client.Packet{
+			Type: client.PacketType_DATA,
+			Payload: &client.Packet_Data{
+				Data: &client.Data{
+					ConnectID: connID,
+					Data:      pkt[:n],
+				},
+			},
+		}
+		metrics.Metrics.ObserveDataBytes(commonmetrics.SegmentFromClient, packet)
+		err = c.backend.Send(packet)
+		if err != nil {
+			klog.ErrorS(err, "error sending packet")
+			if err == errSendQueueFull {
+				d.server.closeFrontend(c, audit.CloseReasonSendTimeout, "agent send timeout")
+			}
+			break
+		}
+		c.bytesToAgent.Add(int64(n))
+		c.expiry.Load().Touch()
+		klog.V(5).InfoS("Forwarding data on tunnel to agent",
+			"bytes", n,
+			"totalBytes", acc,
+			"agentID", agentID,
+			"connectionID", connID)
+	}
+
+	klog.V(5).InfoS("Stopping transfer to host", "host", c.dialAddress, "agentID", agentID, "connectionID", connID)
+}
+
+// close closes the connection to the client, and asks the agent to close
+// the connection to the destination if it was dialed.
+func (d *frontendDial) close() {
+	c := d.connection
+	select {
+	case <-c.connected:
+		packet := &client.Packet{
+			Type: client.PacketType_CLOSE_REQ,
+			Payload: &client.Packet_CloseRequest{
+				CloseRequest: &client.CloseRequest{
+					ConnectID: c.connectID,
+				},
+			},
+		}
+		if err := c.backend.Send(packet); err != nil {
+			klog.V(2).InfoS("failed to send close request packet", "host", c.dialAddress, "agentID", c.agentID, "connectionID", c.connectID)
+		}
+	default:
+	}
+	d.tunnel.Close()
+}
+
+// connectTunnel is the connection of a client to which the packets of the
+// agent are written. The client is answered, and its connection set, only
+// once the dial succeeded, so the writes wait until the tunnel is
+// established or closed.
+type connectTunnel struct {
+	ready  chan struct{} // closed once conn is set
+	closed chan struct{}
+
+	mu       sync.Mutex // protects the following
+	conn     io.ReadWriteCloser
+	isClosed bool
+}
+
+func newConnectTunnel() *connectTunnel {
+	return &connectTunnel{
+		ready:  make(chan struct{}),
+		closed: make(chan struct{}),
+	}
+}
+
+// establish sets the connection of the tunnel, unless it is already closed.
+func (t *connectTunnel) establish(conn io.ReadWriteCloser) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.isClosed {
+		return false
+	}
+	t.conn = conn
+	close(t.ready)
+	return true
+}
+
+func (t *connectTunnel) Read(p []byte) (int, error) {
+	select {
+	case <-t.ready:
+		return t.conn.Read(p)
+	case <-t.closed:
+		return 0, io.EOF
+	}
+}
+
+func (t *connectTunnel) Write(p []byte) (int, error) {
+	select {
+	case <-t.ready:
+		return t.conn.Write(p)
+	case <-t.closed:
+		return 0, io.ErrClosedPipe
+	}
+}
+
+// Close closes the tunnel, and its connection if established.
+func (t *connectTunnel) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.isClosed {
+		return nil
+	}
+	t.isClosed = true
+	close(t.closed)
+	if t.conn != nil {
+		return t.conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server/metrics"
+	"sigs.k8s.io/apiserver-network-proxy/proto/agent"
+)
+
+// agentHealth tracks whether the agent at the other end of a Connect stream
+// answers the PINGs sent over it. A stream which misses PINGs is removed
+// from the backend selection, so that no dial is sent to a stalled agent,
+// and added back once the agent answers again. A drained stream is removed
+// for good.
+type agentHealth struct {
+	server  *ProxyServer
+	agentID string
+	conn    agent.AgentService_ConnectServer
+	backend Backend
+
+	// now returns the current time; overridden in tests.
+	now func() time.Time
+
+	mu sync.Mutex // protects the following
+	// pending are the times at which the unanswered PINGs were sent.
+	pending map[int64]time.Time
+	lastID  int64
+	healthy bool
+	drained bool
+	stopped bool
+}
+
+func (s *ProxyServer) newAgentHealth(agentID string, conn agent.AgentService_ConnectServer, backend Backend) *agentHealth {
+	return &agentHealth{
+		server:  s,
+		agentID: agentID,
+		conn:    conn,
+		backend: backend,
+		now:     time.Now,
+		pending: make(map[int64]time.Time),
+		healthy: true,
+	}
+}
+
+// run pings the agent every interval, and marks the stream unhealthy when
+// a PING is not answered within timeout, until stopCh is closed.
+func (h *agentHealth) run(interval, timeout time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+		h.expire(timeout)
+		h.ping()
+	}
+}
+
+func (h *agentHealth) ping() {
+	h.mu.Lock()
+	h.lastID++
+	id := h.lastID
+	h.pending[id] = h.now()
+	h.mu.Unlock()
+
+	pkt := &client.Packet{
+		Type:    client.PacketType_PING,
+		Payload: &client.Packet_Ping{Ping: &client.Ping{Id: id}},
+	}
+	if err := h.backend.Send(pkt); err != nil {
+		klog.ErrorS(err, "PING to agent failed", "agentID", h.agentID)
+	}
+}
+
+// expire forgets the PINGs sent more than timeout ago, and marks the stream
+// unhealthy if there were any.
+func (h *agentHealth) expire(timeout time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	deadline := h.now().Add(-timeout)
+	missed := 0
+	for id, sent := range h.pending {
+		if sent.Before(deadline) {
+			delete(h.pending, id)
+			missed++
+		}
+	}
+	if missed == 0 || !h.healthy || h.drained || h.stopped {
+		return
+	}
+	klog.InfoS("Agent missed PING; removing it from the backend selection", "agentID", h.agentID, "missed", missed, "timeout", timeout)
+	h.healthy = false
+	h.server.removeBackend(h.agentID, h.conn)
+	metrics.Metrics.UnhealthyBackendInc()
+}
+
+// pong records the answer of the agent to the PING id.
+func (h *agentHealth) pong(id int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sent, ok := h.pending[id]
+	if !ok {
+		klog.V(2).InfoS("PONG not recognized or late; dropped", "pingID", id, "agentID", h.agentID)
+		return
+	}
+	delete(h.pending, id)
+	rtt := h.now().Sub(sent)
+	klog.V(5).InfoS("Received PONG", "pingID", id, "agentID", h.agentID, "rtt", rtt)
+	metrics.Metrics.ObservePing(h.agentID, rtt)
+	if h.healthy || h.drained || h.stopped {
+		return
+	}
+	klog.InfoS("Agent answered PING; adding it back to the backend selection", "agentID", h.agentID)
+	h.healthy = true
+	h.server.addBackend(h.agentID, h.conn)
+	metrics.Metrics.UnhealthyBackendDec()
+}
+
+// drain removes the stream from the backend selection for good, so that no
+// new dial is sent to it. It returns false if the stream was already
+// drained or closed.
+func (h *agentHealth) drain() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.drained || h.stopped {
+		return false
+	}
+	h.drained = true
+	if h.healthy {
+		h.server.removeBackend(h.agentID, h.conn)
+	}
+	return true
+}
+
+// isSelectable returns whether the stream is in the backend selection: it
+// answers the PINGs, and is neither drained nor closed.
+func (h *agentHealth) isSelectable() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.healthy && !h.drained && !h.stopped
+}
+
+// stop removes the stream from the backend selection, once it is closed.
+func (h *agentHealth) stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stopped = true
+	if !h.healthy {
+		metrics.Metrics.UnhealthyBackendDec()
+	} else if !h.drained {
+		h.server.removeBackend(h.agentID, h.conn)
+	}
+	metrics.Metrics.ForgetAgentPing(h.agentID)
+}
+
+func (s *ProxyServer) addAgentHealth(backend Backend, h *agentHealth) {
+	s.amu.Lock()
+	defer s.amu.Unlock()
+	s.agentHealths[backend] = h
+}
+
+func (s *ProxyServer) removeAgentHealth(backend Backend) {
+	s.amu.Lock()
+	defer s.amu.Unlock()
+	delete(s.agentHealths, backend)
+}
+
+// agentHealth returns the health of the agent stream of backend, or nil if
+// it is not registered.
+func (s *ProxyServer) agentHealth(backend Backend) *agentHealth {
+	s.amu.Lock()
+	defer s.amu.Unlock()
+	return s.agentHealths[backend]
+}
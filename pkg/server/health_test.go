@@ -0,0 +1,134 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"google.golang.org/grpc/metadata"
+
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server/metrics"
+	metricstest "sigs.k8s.io/apiserver-network-proxy/pkg/testing/metrics"
+	agentmock "sigs.k8s.io/apiserver-network-proxy/proto/agent/mocks"
+	"sigs.k8s.io/apiserver-network-proxy/proto/header"
+)
+
+func TestAgentHealth(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	metrics.Metrics.Reset()
+
+	s := NewProxyServer("server-a", []ProxyStrategy{ProxyStrategyDefault}, 1, &AgentTokenAuthenticationOptions{})
+	conn := agentmock.NewMockAgentService_ConnectServer(ctrl)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(header.AgentID, "agent1"))
+	conn.EXPECT().Context().Return(ctx).AnyTimes()
+	var pings []*client.Packet
+	conn.EXPECT().Send(gomock.Any()).DoAndReturn(func(pkt *client.Packet) error {
+		pings = append(pings, pkt)
+		return nil
+	}).AnyTimes()
+
+	backend := s.addBackend("agent1", conn)
+	s.addAgentStream(backend, "agent1", s.members())
+	h := s.newAgentHealth("agent1", conn, backend)
+	s.addAgentHealth(backend, h)
+	now := time.Unix(1000, 0)
+	h.now = func() time.Time { return now }
+
+	// An answered PING keeps the agent in the backend selection.
+	h.ping()
+	if len(pings) != 1 || pings[0].Type != client.PacketType_PING {
+		t.Fatalf("expected a PING to be sent, got %v", pings)
+	}
+	now = now.Add(20 * time.Millisecond)
+	h.pong(pings[0].GetPing().Id)
+	h.expire(time.Second)
+	if n := s.BackendManagers[0].NumBackends(); n != 1 {
+		t.Errorf("expected 1 backend, got %d", n)
+	}
+	expect := `
+# HELP konnectivity_network_proxy_server_agent_ping_rtt_seconds Round-trip time of the last PING answered by each connected agent in seconds
+# TYPE konnectivity_network_proxy_server_agent_ping_rtt_seconds gauge
+konnectivity_network_proxy_server_agent_ping_rtt_seconds{agent_id="agent1"} 0.02
+`
+	if err := metricstest.ExpectMetric(metrics.Namespace, metrics.Subsystem, "agent_ping_rtt_seconds", expect); err != nil {
+		t.Error(err)
+	}
+
+	// A missed PING removes the agent from the backend selection.
+	h.ping()
+	now = now.Add(2 * time.Second)
+	h.expire(time.Second)
+	if n := s.BackendManagers[0].NumBackends(); n != 0 {
+		t.Errorf("expected no backend, got %d", n)
+	}
+	if n := s.agentCount(); n != 0 {
+		t.Errorf("expected no healthy agent, got %d", n)
+	}
+	// The late PONG is ignored.
+	h.pong(pings[1].GetPing().Id)
+	if n := s.BackendManagers[0].NumBackends(); n != 0 {
+		t.Errorf("expected no backend, got %d", n)
+	}
+
+	// The agent is added back once it answers again.
+	h.ping()
+	h.pong(pings[2].GetPing().Id)
+	if n := s.BackendManagers[0].NumBackends(); n != 1 {
+		t.Errorf("expected 1 backend, got %d", n)
+	}
+	if n := s.agentCount(); n != 1 {
+		t.Errorf("expected 1 healthy agent, got %d", n)
+	}
+
+	h.stop()
+	if n := s.BackendManagers[0].NumBackends(); n != 0 {
+		t.Errorf("expected no backend once stopped, got %d", n)
+	}
+}
+
+func TestAgentHealth_Pong(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	s := NewProxyServer("server-a", []ProxyStrategy{ProxyStrategyDefault}, 1, &AgentTokenAuthenticationOptions{})
+	s.AgentPingInterval = 10 * time.Millisecond
+	s.AgentPingTimeout = time.Second
+	conn := agentmock.NewMockAgentService_ConnectServer(ctrl)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(header.AgentID, "agent1"))
+	conn.EXPECT().Context().Return(ctx).AnyTimes()
+	backend := newBackend(conn)
+	h := s.newAgentHealth("agent1", conn, backend)
+	h.pending[1] = time.Now()
+	s.addAgentHealth(backend, h)
+
+	// The PONG received on the stream is routed to its health.
+	recvCh := make(chan *client.Packet, 1)
+	recvCh <- &client.Packet{
+		Type:    client.PacketType_PONG,
+		Payload: &client.Packet_Pong{Pong: &client.Pong{Id: 1}},
+	}
+	close(recvCh)
+	s.serveRecvBackend(backend, "agent1", recvCh)
+	if len(h.pending) != 0 {
+		t.Errorf("expected the PING to be answered, got pending %v", h.pending)
+	}
+}
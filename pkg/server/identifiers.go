@@ -0,0 +1,154 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"k8s.io/klog/v2"
+
+	pkgagent "sigs.k8s.io/apiserver-network-proxy/pkg/agent"
+	"sigs.k8s.io/apiserver-network-proxy/proto/agent"
+)
+
+// streamIdentifiers are the identifiers by which an agent stream is stored
+// in the backend managers. They are read from the agentIdentifiers metadata
+// when the stream connects, and replaced by the AGENT_IDENTIFIERS packets
+// the agent sends when they change.
+type streamIdentifiers struct {
+	// raw are the url encoded identifiers, as sent by the agent.
+	raw string
+	ids pkgagent.Identifiers
+	// stored is whether the stream is in the backend managers; it is not
+	// while the agent misses PINGs.
+	stored bool
+}
+
+// backendIdentifiers returns the identifiers by which bm stores the stream
+// of the agent agentID, with the identifiers ids.
+func backendIdentifiers(bm BackendManager, agentID string, ids pkgagent.Identifiers) []BackendIdentifier {
+	var bids []BackendIdentifier
+	switch bm.(type) {
+	case *DestHostBackendManager:
+		for _, ipv4 := range ids.IPv4 {
+			bids = append(bids, BackendIdentifier{ID: ipv4, Type: pkgagent.IPv4})
+		}
+		for _, ipv6 := range ids.IPv6 {
+			bids = append(bids, BackendIdentifier{ID: ipv6, Type: pkgagent.IPv6})
+		}
+		for _, host := range ids.Host {
+			bids = append(bids, BackendIdentifier{ID: host, Type: pkgagent.Host})
+		}
+	case *DefaultRouteBackendManager:
+		if ids.DefaultRoute {
+			bids = append(bids, BackendIdentifier{ID: agentID, Type: pkgagent.DefaultRoute})
+		}
+	default:
+		bids = append(bids, BackendIdentifier{ID: agentID, Type: pkgagent.UID})
+	}
+	return bids
+}
+
+// diffIdentifiers returns the identifiers of old missing from new, and those
+// of new missing from old.
+func diffIdentifiers(old, new []BackendIdentifier) (removed, added []BackendIdentifier) {
+	contains := func(bids []BackendIdentifier, bid BackendIdentifier) bool {
+		for _, b := range bids {
+			if b == bid {
+				return true
+			}
+		}
+		return false
+	}
+	for _, bid := range old {
+		if !contains(new, bid) {
+			removed = append(removed, bid)
+		}
+	}
+	for _, bid := range new {
+		if !contains(old, bid) {
+			added = append(added, bid)
+		}
+	}
+	return removed, added
+}
+
+// streamIdentifiersLocked returns the identifiers of conn, reading them from
+// its metadata the first time.
+func (s *ProxyServer) streamIdentifiersLocked(agentID string, conn agent.AgentService_ConnectServer) *streamIdentifiers {
+	si, ok := s.identifiers[conn]
+	if !ok {
+		si = &streamIdentifiers{}
+		raw, ids, err := getAgentIdentifiers(conn)
+		if err != nil {
+			klog.ErrorS(err, "fail to get the agent identifiers", "agentID", agentID)
+		} else {
+			si.raw, si.ids = raw, ids
+		}
+		s.identifiers[conn] = si
+	}
+	return si
+}
+
+// agentIdentifiers returns the current url encoded identifiers of the agent
+// stream conn, or those of its metadata if it is not stored yet.
+func (s *ProxyServer) agentIdentifiers(conn agent.AgentService_ConnectServer) string {
+	s.imu.Lock()
+	si, ok := s.identifiers[conn]
+	var raw string
+	if ok {
+		raw = si.raw
+	}
+	s.imu.Unlock()
+	if !ok {
+		raw, _, _ = getAgentIdentifiers(conn)
+	}
+	return raw
+}
+
+// updateAgentIdentifiers replaces the identifiers of the agent stream conn
+// with raw, and updates the backend managers storing the stream.
+func (s *ProxyServer) updateAgentIdentifiers(agentID string, conn agent.AgentService_ConnectServer, raw string) {
+	ids, err := pkgagent.GenAgentIdentifiers(raw)
+	if err != nil {
+		klog.ErrorS(err, "Ignoring the invalid identifiers sent by the agent", "agentID", agentID)
+		return
+	}
+	s.imu.Lock()
+	defer s.imu.Unlock()
+	si, ok := s.identifiers[conn]
+	if !ok {
+		klog.V(2).InfoS("Identifiers of an unknown agent stream; dropped", "agentID", agentID)
+		return
+	}
+	if si.stored {
+		for _, bm := range s.BackendManagers {
+			removed, added := diffIdentifiers(backendIdentifiers(bm, agentID, si.ids), backendIdentifiers(bm, agentID, ids))
+			if len(removed) > 0 || len(added) > 0 {
+				bm.UpdateBackend(conn, removed, added)
+			}
+		}
+	}
+	si.raw, si.ids = raw, ids
+	klog.V(2).InfoS("Agent identifiers updated", "agentID", agentID, "identifiers", raw)
+}
+
+// forgetAgentIdentifiers drops the identifiers of the closed agent stream
+// conn.
+func (s *ProxyServer) forgetAgentIdentifiers(conn agent.AgentService_ConnectServer) {
+	s.imu.Lock()
+	defer s.imu.Unlock()
+	delete(s.identifiers, conn)
+}
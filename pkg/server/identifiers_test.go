@@ -0,0 +1,100 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"google.golang.org/grpc/metadata"
+
+	client "sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
+	agentmock "sigs.k8s.io/apiserver-network-proxy/proto/agent/mocks"
+	"sigs.k8s.io/apiserver-network-proxy/proto/header"
+)
+
+func TestUpdateAgentIdentifiers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	s := NewProxyServer("server1", []ProxyStrategy{ProxyStrategyDestHost, ProxyStrategyDefaultRoute, ProxyStrategyDefault}, 1, &AgentTokenAuthenticationOptions{})
+	destHostBM, defaultRouteBM, defaultBM := s.BackendManagers[0], s.BackendManagers[1], s.BackendManagers[2]
+	conn := agentmock.NewMockAgentService_ConnectServer(ctrl)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(header.AgentID, "agent1", header.AgentIdentifiers, "host=node1&ipv4=10.0.0.1"))
+	conn.EXPECT().Context().Return(ctx).AnyTimes()
+
+	assertDestHosts := func(want map[string]bool) {
+		t.Helper()
+		for host, found := range want {
+			_, err := destHostBM.Backend(context.WithValue(context.Background(), destHost, host))
+			if (err == nil) != found {
+				t.Errorf("expected %s to be found=%v, got error %v", host, found, err)
+			}
+		}
+	}
+	assertDefaultRoute := func(found bool) {
+		t.Helper()
+		if _, err := defaultRouteBM.Backend(context.Background()); (err == nil) != found {
+			t.Errorf("expected the default route to be found=%v, got error %v", found, err)
+		}
+	}
+
+	backend := s.addBackend("agent1", conn)
+	assertDestHosts(map[string]bool{"node1": true, "10.0.0.1": true, "node2": false})
+	assertDefaultRoute(false)
+
+	// The identifiers are updated through the stream of the agent.
+	recvCh := make(chan *client.Packet, 2)
+	recvCh <- &client.Packet{
+		Type:    client.PacketType_AGENT_IDENTIFIERS,
+		Payload: &client.Packet_AgentIdentifiers{AgentIdentifiers: &client.AgentIdentifiers{Identifiers: "host=node2&default-route=true"}},
+	}
+	// Invalid identifiers are ignored.
+	recvCh <- &client.Packet{
+		Type:    client.PacketType_AGENT_IDENTIFIERS,
+		Payload: &client.Packet_AgentIdentifiers{AgentIdentifiers: &client.AgentIdentifiers{Identifiers: "zone=a"}},
+	}
+	close(recvCh)
+	s.serveRecvBackend(backend, "agent1", recvCh)
+	assertDestHosts(map[string]bool{"node1": false, "10.0.0.1": false, "node2": true})
+	assertDefaultRoute(true)
+	if n := defaultBM.NumBackends(); n != 1 {
+		t.Errorf("expected the agent to stay in the default backend manager, got %d backends", n)
+	}
+	if got := s.agentIdentifiers(conn); got != "host=node2&default-route=true" {
+		t.Errorf("expected the updated identifiers, got %q", got)
+	}
+
+	// An update while the agent is out of the backend selection applies
+	// once it is added back.
+	s.removeBackend("agent1", conn)
+	s.updateAgentIdentifiers("agent1", conn, "host=node3")
+	assertDestHosts(map[string]bool{"node2": false, "node3": false})
+	s.addBackend("agent1", conn)
+	assertDestHosts(map[string]bool{"node2": false, "node3": true})
+	assertDefaultRoute(false)
+
+	s.removeBackend("agent1", conn)
+	s.forgetAgentIdentifiers(conn)
+	if n := destHostBM.NumBackends() + defaultRouteBM.NumBackends() + defaultBM.NumBackends(); n != 0 {
+		t.Errorf("expected no backends, got %d", n)
+	}
+	if got := s.agentIdentifiers(conn); got != "host=node1&ipv4=10.0.0.1" {
+		t.Errorf("expected the identifiers of the metadata once the stream is forgotten, got %q", got)
+	}
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server/membership"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server/metrics"
+)
+
+// SetMembership sets how the server learns the live proxy server instances,
+// replacing the static server count it was created with. Connecting agents
+// are told the live servers, and the connected agents are sent a SERVER_INFO
+// every time they change, so that they connect to new servers and drop the
+// stale ones.
+func (s *ProxyServer) SetMembership(m membership.Membership) {
+	s.mmu.Lock()
+	s.membership = m
+	s.mmu.Unlock()
+	m.Subscribe(s.broadcastMembers)
+	s.broadcastMembers(m.Members())
+}
+
+func (s *ProxyServer) members() membership.Members {
+	s.mmu.RLock()
+	defer s.mmu.RUnlock()
+	return s.membership.Members()
+}
+
+// DefaultDrainGrace is how long the new stream of an agent which already
+// has streams to the server must stay connected for the older ones to be
+// drained.
+const DefaultDrainGrace = 5 * time.Second
+
+// addAgentStream registers the stream of an agent which was told, on
+// connecting, that sent are the live servers. The current ones are sent to
+// the agent if they changed since. If the agent already has streams to the
+// server, they are drained once the new one stayed connected for the drain
+// grace: after an agent restart or a network flap, the older streams are
+// likely half-open, while the new one is live. The agent closes right away
+// the streams which duplicate a live one, e.g. when it keeps syncing, and
+// those never drain anything.
+func (s *ProxyServer) addAgentStream(backend Backend, agentID string, sent membership.Members) {
+	s.amu.Lock()
+	var older []Backend
+	for b, id := range s.agentStreams {
+		if id == agentID {
+			older = append(older, b)
+		}
+	}
+	s.agentStreams[backend] = agentID
+	metrics.Metrics.SetDuplicateStreamCount(s.duplicateStreamCountLocked())
+	s.amu.Unlock()
+	if len(older) > 0 {
+		time.AfterFunc(s.drainGrace, func() {
+			s.drainOlderStreams(backend, agentID, older)
+		})
+	}
+	if current := s.members(); !current.Equal(sent) {
+		s.sendMembers(backend, agentID, current)
+	}
+}
+
+func (s *ProxyServer) removeAgentStream(backend Backend) {
+	s.amu.Lock()
+	defer s.amu.Unlock()
+	delete(s.agentStreams, backend)
+	metrics.Metrics.SetDuplicateStreamCount(s.duplicateStreamCountLocked())
+}
+
+// duplicateStreamCountLocked returns the number of agent streams in excess
+// of one per agent.
+func (s *ProxyServer) duplicateStreamCountLocked() int {
+	agents := make(map[string]bool, len(s.agentStreams))
+	for _, agentID := range s.agentStreams {
+		agents[agentID] = true
+	}
+	return len(s.agentStreams) - len(agents)
+}
+
+// agentCount returns the number of distinct agents connected to the server
+// with at least one stream in the backend selection.
+func (s *ProxyServer) agentCount() int {
+	s.amu.Lock()
+	defer s.amu.Unlock()
+	seen := make(map[string]bool, len(s.agentStreams))
+	for backend, agentID := range s.agentStreams {
+		if h, ok := s.agentHealths[backend]; ok && !h.isSelectable() {
+			continue
+		}
+		seen[agentID] = true
+	}
+	return len(seen)
+}
+
+// broadcastMembers sends m to every connected agent stream.
+func (s *ProxyServer) broadcastMembers(m membership.Members) {
+	s.amu.Lock()
+	streams := make(map[Backend]string, len(s.agentStreams))
+	for backend, agentID := range s.agentStreams {
+		streams[backend] = agentID
+	}
+	s.amu.Unlock()
+
+	klog.V(2).InfoS("Live proxy servers changed", "serverCount", m.Count, "serverIDs", m.ServerIDs, "agentStreams", len(streams))
+	for backend, agentID := range streams {
+		s.sendMembers(backend, agentID, m)
+	}
+}
+
+func (s *ProxyServer) sendMembers(backend Backend, agentID string, m membership.Members) {
+	pkt := &client.Packet{
+		Type: client.PacketType_SERVER_INFO,
+		Payload: &client.Packet_ServerInfo{ServerInfo: &client.ServerInfo{
+			ServerCount: int32(m.Count),
+			ServerIDs:   m.ServerIDs,
+		}},
+	}
+	if err := backend.Send(pkt); err != nil {
+		klog.ErrorS(err, "SERVER_INFO to agent failed", "agentID", agentID)
+	}
+}
+
+// drainOlderStreams drains the older streams of the agent which are still
+// connected, if backend, its new stream, is still connected too.
+func (s *ProxyServer) drainOlderStreams(backend Backend, agentID string, older []Backend) {
+	s.amu.Lock()
+	if _, ok := s.agentStreams[backend]; !ok {
+		s.amu.Unlock()
+		return
+	}
+	healths := make(map[Backend]*agentHealth, len(older))
+	for _, b := range older {
+		if h, ok := s.agentHealths[b]; ok {
+			healths[b] = h
+		}
+	}
+	s.amu.Unlock()
+	for b, h := range healths {
+		s.drainAgentStream(b, agentID, h)
+	}
+}
+
+// drainAgentStream removes backend, an older stream of the agent, from the
+// backend selection, and tells the agent to close it once its connections
+// are closed. Until then, the stream keeps serving the connections already
+// established over it. A stream is only drained once.
+func (s *ProxyServer) drainAgentStream(backend Backend, agentID string, h *agentHealth) {
+	if !h.drain() {
+		return
+	}
+	klog.V(2).InfoS("Agent connected again, draining the older stream", "agentID", agentID)
+	metrics.Metrics.DrainedStreamInc()
+	pkt := &client.Packet{
+		Type: client.PacketType_DRAIN,
+		Payload: &client.Packet_Drain{Drain: &client.Drain{
+			Reason: "duplicate stream of agent " + agentID,
+		}},
+	}
+	if err := backend.Send(pkt); err != nil {
+		klog.ErrorS(err, "DRAIN to agent failed", "agentID", agentID)
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package membership
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// LeaseLabel is the label identifying the Leases of the proxy servers.
+const LeaseLabel = "konnectivity.k8s.io/proxy-server"
+
+var _ Membership = &Leases{}
+
+// Leases discovers the proxy servers through Kubernetes Leases. Every server
+// holds a Lease, labelled with LeaseLabel, which it renews periodically; the
+// live servers are the holders of the Leases which have not expired.
+type Leases struct {
+	tracker
+	client        kubernetes.Interface
+	namespace     string
+	leaseDuration time.Duration
+
+	// now returns the current time; overridden in tests.
+	now func() time.Time
+}
+
+// NewLeases returns the membership of the servers holding a Lease in
+// namespace. Leases expire if not renewed within leaseDuration.
+func NewLeases(client kubernetes.Interface, namespace, selfID string, leaseDuration time.Duration) *Leases {
+	return &Leases{
+		tracker:       newTracker(selfID),
+		client:        client,
+		namespace:     namespace,
+		leaseDuration: leaseDuration,
+		now:           time.Now,
+	}
+}
+
+// leaseName returns the name of the Lease held by serverID. Server IDs are
+// hashed, since they are not necessarily valid object names.
+func leaseName(serverID string) string {
+	sum := sha256.Sum256([]byte(serverID))
+	return "konnectivity-server-" + hex.EncodeToString(sum[:8])
+}
+
+// Run renews the Lease of this server and refreshes the live servers every
+// interval, until stopCh is closed. The Lease is then deleted, so that the
+// other servers and the agents forget this server immediately.
+func (l *Leases) Run(interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(l.sync, interval, stopCh)
+
+	ctx, cancel := context.WithTimeout(context.Background(), interval)
+	defer cancel()
+	err := l.client.CoordinationV1().Leases(l.namespace).Delete(ctx, leaseName(l.selfID), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		klog.ErrorS(err, "Failed to delete the proxy server lease", "namespace", l.namespace, "serverID", l.selfID)
+	}
+}
+
+func (l *Leases) sync() {
+	ctx, cancel := context.WithTimeout(context.Background(), l.leaseDuration)
+	defer cancel()
+	if err := l.renew(ctx); err != nil {
+		klog.ErrorS(err, "Failed to renew the proxy server lease", "namespace", l.namespace, "serverID", l.selfID)
+	}
+	if err := l.refresh(ctx); err != nil {
+		klog.ErrorS(err, "Failed to list the proxy server leases", "namespace", l.namespace)
+	}
+}
+
+// renew creates or renews the Lease of this server.
+func (l *Leases) renew(ctx context.Context) error {
+	leases := l.client.CoordinationV1().Leases(l.namespace)
+	now := metav1.NewMicroTime(l.now())
+	durationSeconds := int32(l.leaseDuration / time.Second)
+	lease, err := leases.Get(ctx, leaseName(l.selfID), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      leaseName(l.selfID),
+				Namespace: l.namespace,
+				Labels:    map[string]string{LeaseLabel: "true"},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &l.selfID,
+				LeaseDurationSeconds: &durationSeconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		_, err = leases.Create(ctx, lease, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	lease.Spec.HolderIdentity = &l.selfID
+	lease.Spec.LeaseDurationSeconds = &durationSeconds
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+// refresh records the holders of the unexpired Leases as the live servers.
+func (l *Leases) refresh(ctx context.Context) error {
+	list, err := l.client.CoordinationV1().Leases(l.namespace).List(ctx, metav1.ListOptions{LabelSelector: LeaseLabel})
+	if err != nil {
+		return err
+	}
+	now := l.now()
+	var ids []string
+	for _, lease := range list.Items {
+		spec := lease.Spec
+		if spec.HolderIdentity == nil || spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+			continue
+		}
+		expiry := spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second)
+		if now.After(expiry) {
+			continue
+		}
+		ids = append(ids, *spec.HolderIdentity)
+	}
+	if l.set(ids) {
+		klog.V(1).InfoS("Updated the proxy server peers", "namespace", l.namespace, "serverIDs", l.Members().ServerIDs)
+	}
+	return nil
+}
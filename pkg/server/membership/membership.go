@@ -0,0 +1,126 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package membership tracks the live instances of an HA proxy server, so
+// that agents can connect to every one of them as they come and go.
+package membership
+
+import (
+	"sort"
+	"sync"
+)
+
+// Members describes the live proxy server instances.
+type Members struct {
+	// Count is the number of live proxy servers.
+	Count int
+	// ServerIDs are the sorted IDs of the live proxy servers, or nil if
+	// only their count is known.
+	ServerIDs []string
+}
+
+// Equal reports whether m and o describe the same servers.
+func (m Members) Equal(o Members) bool {
+	if m.Count != o.Count || len(m.ServerIDs) != len(o.ServerIDs) {
+		return false
+	}
+	for i := range m.ServerIDs {
+		if m.ServerIDs[i] != o.ServerIDs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Membership reports the live proxy server instances.
+type Membership interface {
+	// Members returns the live proxy servers.
+	Members() Members
+	// Subscribe registers f to be called with the new members every time
+	// they change.
+	Subscribe(f func(Members))
+}
+
+var _ Membership = Static(1)
+
+// Static is a fixed number of proxy servers, of unknown IDs.
+type Static int
+
+// Members returns the fixed count of servers.
+func (s Static) Members() Members {
+	return Members{Count: int(s)}
+}
+
+// Subscribe is a no-op, as the members never change.
+func (s Static) Subscribe(func(Members)) {}
+
+// tracker implements the bookkeeping shared by the dynamic memberships.
+type tracker struct {
+	// selfID is the ID of this server, which is always a member.
+	selfID string
+
+	mu          sync.Mutex // protects the following
+	members     Members
+	subscribers []func(Members)
+}
+
+func newTracker(selfID string) tracker {
+	return tracker{
+		selfID:  selfID,
+		members: Members{Count: 1, ServerIDs: []string{selfID}},
+	}
+}
+
+func (t *tracker) Members() Members {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.members
+}
+
+func (t *tracker) Subscribe(f func(Members)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.subscribers = append(t.subscribers, f)
+}
+
+// set records serverIDs, plus this server, as the live servers, and notifies
+// the subscribers if they changed. It reports whether they changed.
+func (t *tracker) set(serverIDs []string) bool {
+	seen := map[string]bool{t.selfID: true}
+	ids := []string{t.selfID}
+	for _, id := range serverIDs {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	members := Members{Count: len(ids), ServerIDs: ids}
+
+	t.mu.Lock()
+	if members.Equal(t.members) {
+		t.mu.Unlock()
+		return false
+	}
+	t.members = members
+	subscribers := append([]func(Members){}, t.subscribers...)
+	t.mu.Unlock()
+
+	for _, f := range subscribers {
+		f(members)
+	}
+	return true
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package membership
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestTrackerSet(t *testing.T) {
+	tr := newTracker("b")
+	var notified []Members
+	tr.Subscribe(func(m Members) { notified = append(notified, m) })
+
+	if !tr.set([]string{"c", "a", "c", ""}) {
+		t.Fatal("expected the members to change")
+	}
+	want := Members{Count: 3, ServerIDs: []string{"a", "b", "c"}}
+	if got := tr.Members(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected members %+v, got %+v", want, got)
+	}
+	if tr.set([]string{"a", "c", "b"}) {
+		t.Error("expected the members not to change")
+	}
+	if !tr.set(nil) {
+		t.Fatal("expected the members to change")
+	}
+	wantNotified := []Members{want, {Count: 1, ServerIDs: []string{"b"}}}
+	if !reflect.DeepEqual(notified, wantNotified) {
+		t.Errorf("expected notifications %+v, got %+v", wantNotified, notified)
+	}
+}
+
+func TestPeerList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers")
+	if err := os.WriteFile(path, []byte("# servers\nserver-a\n\n  server-b  \n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPeerList(path, "server-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Members{Count: 2, ServerIDs: []string{"server-a", "server-b"}}
+	if got := p.Members(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected members %+v, got %+v", want, got)
+	}
+
+	// Make sure the modification time changes.
+	mtime := time.Now().Add(time.Minute)
+	if err := os.WriteFile(path, []byte("server-c\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	p.watcher.ReloadIfChanged()
+	want = Members{Count: 2, ServerIDs: []string{"server-a", "server-c"}}
+	if got := p.Members(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected members %+v, got %+v", want, got)
+	}
+
+	// A removed file keeps the previous members.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	p.watcher.ReloadIfChanged()
+	if got := p.Members(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected members %+v, got %+v", want, got)
+	}
+}
+
+func TestNewPeerListMissingFile(t *testing.T) {
+	if _, err := NewPeerList(filepath.Join(t.TempDir(), "missing"), "server-a"); err == nil {
+		t.Error("expected an error for a missing peer list")
+	}
+}
+
+func TestLeases(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	client := fake.NewSimpleClientset(
+		peerLease("server-b", now.Add(-10*time.Second), 30),
+		peerLease("server-c", now.Add(-time.Minute), 30),
+	)
+	l := NewLeases(client, "ns", "server-a", 30*time.Second)
+	l.now = func() time.Time { return now }
+
+	l.sync()
+	want := Members{Count: 2, ServerIDs: []string{"server-a", "server-b"}}
+	if got := l.Members(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected members %+v, got %+v", want, got)
+	}
+	lease, err := client.CoordinationV1().Leases("ns").Get(ctx, leaseName("server-a"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the lease of server-a to be created: %v", err)
+	}
+	if *lease.Spec.HolderIdentity != "server-a" || !lease.Spec.RenewTime.Time.Equal(now) {
+		t.Errorf("unexpected lease spec %+v", lease.Spec)
+	}
+
+	// server-b stops renewing its lease.
+	now = now.Add(time.Minute)
+	l.sync()
+	want = Members{Count: 1, ServerIDs: []string{"server-a"}}
+	if got := l.Members(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected members %+v, got %+v", want, got)
+	}
+	lease, err = client.CoordinationV1().Leases("ns").Get(ctx, leaseName("server-a"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lease.Spec.RenewTime.Time.Equal(now) {
+		t.Errorf("expected the lease to be renewed at %v, got %v", now, lease.Spec.RenewTime)
+	}
+
+	stopCh := make(chan struct{})
+	close(stopCh)
+	l.Run(time.Second, stopCh)
+	if _, err := client.CoordinationV1().Leases("ns").Get(ctx, leaseName("server-a"), metav1.GetOptions{}); err == nil {
+		t.Error("expected the lease of server-a to be deleted")
+	}
+}
+
+func peerLease(serverID string, renewTime time.Time, durationSeconds int32) *coordinationv1.Lease {
+	renew := metav1.NewMicroTime(renewTime)
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      leaseName(serverID),
+			Namespace: "ns",
+			Labels:    map[string]string{LeaseLabel: "true"},
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &serverID,
+			LeaseDurationSeconds: &durationSeconds,
+			RenewTime:            &renew,
+		},
+	}
+}
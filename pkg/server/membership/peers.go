@@ -0,0 +1,76 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package membership
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/apiserver-network-proxy/pkg/util"
+)
+
+var _ Membership = &PeerList{}
+
+// PeerList reads the IDs of the proxy servers from a file, with one ID per
+// line. Empty lines and lines starting with '#' are ignored. The file is
+// reread when it changes, so that servers can be added and removed by
+// updating it, e.g. through a ConfigMap.
+type PeerList struct {
+	tracker
+	path    string
+	watcher *util.FileWatcher
+}
+
+// NewPeerList returns the membership listed in the file at path, in which
+// the server selfID is always included. It fails if the file can't be read.
+func NewPeerList(path, selfID string) (*PeerList, error) {
+	p := &PeerList{tracker: newTracker(selfID), path: path}
+	w, err := util.NewFileWatcher("peer list", path, p.load)
+	if err != nil {
+		return nil, err
+	}
+	p.watcher = w
+	return p, nil
+}
+
+// Run rereads the file every interval if it changed, until stopCh is closed.
+func (p *PeerList) Run(interval time.Duration, stopCh <-chan struct{}) {
+	p.watcher.Run(interval, stopCh)
+}
+
+func (p *PeerList) load(data []byte) error {
+	var ids []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ids = append(ids, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if p.set(ids) {
+		klog.V(1).InfoS("Updated the proxy server peers", "path", p.path, "serverIDs", p.Members().ServerIDs)
+	}
+	return nil
+}
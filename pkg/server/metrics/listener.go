@@ -0,0 +1,54 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"net"
+	"sync"
+)
+
+// InstrumentFrontendListener returns lis, counting its open connections
+// in the frontend connections of the listener name serving mode.
+func InstrumentFrontendListener(lis net.Listener, name, mode string) net.Listener {
+	return &frontendListener{Listener: lis, name: name, mode: mode}
+}
+
+type frontendListener struct {
+	net.Listener
+	name string
+	mode string
+}
+
+func (l *frontendListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	Metrics.FrontendConnectionInc(l.name, l.mode)
+	return &frontendConn{Conn: conn, listener: l}, nil
+}
+
+type frontendConn struct {
+	net.Conn
+	listener  *frontendListener
+	closeOnce sync.Once
+}
+
+func (c *frontendConn) Close() error {
+	c.closeOnce.Do(func() { Metrics.FrontendConnectionDec(c.listener.name, c.listener.mode) })
+	return c.Conn.Close()
+}
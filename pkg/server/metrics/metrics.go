@@ -17,6 +17,7 @@ limitations under the License.
 package metrics
 
 import (
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -33,11 +34,24 @@ const (
 	Proxy = "Proxy"
 	// Connect is the AgentService method used to establish next hop.
 	Connect = "Connect"
+
+	// OtherAgents is the agent_id label value for the agents beyond the
+	// per-agent label limit.
+	OtherAgents = "other"
+
+	// FrontendListener is the listener label value of the frontend server.
+	FrontendListener = "frontend"
+	// AgentListener is the listener label value of the agent server.
+	AgentListener = "agent"
+	// PeerListener is the listener label value of the peer server.
+	PeerListener = "peer"
 )
 
 var (
 	// Use buckets ranging from 10 ns to 12.5 seconds.
 	latencyBuckets = []float64{0.000001, 0.00001, 0.0001, 0.005, 0.025, 0.1, 0.5, 2.5, 12.5}
+	// Use buckets ranging from 64 B to 1 GiB.
+	transferSizeBuckets = prometheus.ExponentialBuckets(64, 4, 13)
 
 	// Metrics provides access to all dial metrics.
 	Metrics = newServerMetrics()
@@ -49,13 +63,59 @@ type ServerMetrics struct {
 	frontendLatencies *prometheus.HistogramVec
 	grpcConnections   *prometheus.GaugeVec
 	httpConnections   prometheus.Gauge
+	socks5Connections prometheus.Gauge
+	frontendConns     *prometheus.GaugeVec
 	backend           *prometheus.GaugeVec
 	pendingDials      *prometheus.GaugeVec
 	establishedConns  *prometheus.GaugeVec
 	fullRecvChannels  *prometheus.GaugeVec
 	dialFailures      *prometheus.CounterVec
 	streamPackets     *prometheus.CounterVec
+	streamDataBytes   *prometheus.CounterVec
 	streamErrors      *prometheus.CounterVec
+	connectionBytes   *prometheus.HistogramVec
+	agentDataBytes    *prometheus.CounterVec
+	agentLabels       agentLabels
+	tlsReloads        *prometheus.CounterVec
+	forwardedDials    *prometheus.CounterVec
+	pingLatencies     *prometheus.HistogramVec
+	agentPingRTT      *prometheus.GaugeVec
+	unhealthyBackends *prometheus.GaugeVec
+	sendQueuePackets  *prometheus.GaugeVec
+	sendQueueFull     *prometheus.CounterVec
+	duplicateStreams  *prometheus.GaugeVec
+	drainedStreams    *prometheus.CounterVec
+	connectionCloses  *prometheus.CounterVec
+	proxyAuths        *prometheus.CounterVec
+	auditDrops        *prometheus.CounterVec
+}
+
+// agentLabels caps the number of distinct agent_id label values. Agents
+// beyond the limit are aggregated under OtherAgents. Agent IDs are never
+// released, so that counters of disconnected agents remain monotonic.
+type agentLabels struct {
+	mu    sync.Mutex
+	limit int
+	seen  map[string]struct{}
+}
+
+func (l *agentLabels) enabled() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit > 0
+}
+
+func (l *agentLabels) value(agentID string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.seen[agentID]; ok {
+		return agentID
+	}
+	if len(l.seen) >= l.limit {
+		return OtherAgents
+	}
+	l.seen[agentID] = struct{}{}
+	return agentID
 }
 
 // newServerMetrics create a new ServerMetrics, configured with default metric names.
@@ -99,6 +159,23 @@ func newServerMetrics() *ServerMetrics {
 			Help:      "Number of current HTTP CONNECT connections",
 		},
 	)
+	socks5Connections := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "socks5_connections",
+			Help:      "Number of current SOCKS5 connections",
+		},
+	)
+	frontendConns := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "frontend_connections",
+			Help:      "Number of current client connections to the frontend listeners, by listener and mode.",
+		},
+		[]string{"listener", "mode"},
+	)
 	backend := prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Namespace: Namespace,
@@ -149,30 +226,194 @@ func newServerMetrics() *ServerMetrics {
 		},
 	)
 	streamPackets := commonmetrics.MakeStreamPacketsTotalMetric(Namespace, Subsystem)
+	streamDataBytes := commonmetrics.MakeStreamDataBytesTotalMetric(Namespace, Subsystem)
 	streamErrors := commonmetrics.MakeStreamErrorsTotalMetric(Namespace, Subsystem)
+	connectionBytes := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "connection_transfer_bytes",
+			Help:      "DATA payload bytes transferred over the lifetime of an established connection, by segment (to_agent or from_agent)",
+			Buckets:   transferSizeBuckets,
+		},
+		[]string{"segment"},
+	)
+	agentDataBytes := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "agent_data_bytes_total",
+			Help:      "Count of DATA packet payload bytes exchanged with agents, by agent ID and segment. Only reported if per-agent metrics are enabled; agents beyond the configured limit are reported as \"other\".",
+		},
+		[]string{"agent_id", "segment"},
+	)
+	tlsReloads := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "tls_reloads_total",
+			Help:      "Count of reloads of the TLS certificate, key and CA files after they changed, by listener (a frontend listener, agent or peer) and result (success or failure).",
+		},
+		[]string{"listener", "result"},
+	)
+	forwardedDials := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "forwarded_dials_total",
+			Help:      "Count of dials forwarded to a peer proxy server for lack of a matching local agent, by result (success or failure to open the stream to the peer).",
+		},
+		[]string{"result"},
+	)
+	pingLatencies := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "ping_duration_seconds",
+			Help:      "Round-trip time of the PING sent to the agents in seconds",
+			Buckets:   latencyBuckets,
+		},
+		[]string{},
+	)
+	agentPingRTT := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "agent_ping_rtt_seconds",
+			Help:      "Round-trip time of the last PING answered by each connected agent in seconds",
+		},
+		[]string{"agent_id"},
+	)
+	unhealthyBackends := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "unhealthy_backend_connections",
+			Help:      "Number of agent connections removed from backend selection because they missed PINGs",
+		},
+		[]string{},
+	)
+	sendQueuePackets := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "agent_send_queue_packets",
+			Help:      "Number of packets queued to be sent to the agents, partitioned by queue (control or data).",
+		},
+		[]string{"queue"},
+	)
+	sendQueueFull := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "agent_send_queue_full_total",
+			Help:      "Count of packets whose sender waited for room in a full queue to an agent, partitioned by queue (control or data).",
+		},
+		[]string{"queue"},
+	)
+	duplicateStreams := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "duplicate_agent_streams",
+			Help:      "Number of agent streams in excess of one per agent, which the agents are told to drain",
+		},
+		[]string{},
+	)
+	drainedStreams := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "drained_agent_streams_total",
+			Help:      "Count of DRAIN packets sent to agents on duplicate streams",
+		},
+		[]string{},
+	)
+	connectionCloses := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "connections_closed_total",
+			Help:      "Count of tunneled connections torn down, including failed dials, by close reason (e.g. closed, idle_timeout or max_lifetime).",
+		},
+		[]string{"reason"},
+	)
+	proxyAuths := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "proxy_authentications_total",
+			Help:      "Count of HTTP CONNECT requests authenticated by their Proxy-Authorization header, by result (success, missing or invalid credentials, or error).",
+		},
+		[]string{"result"},
+	)
+	auditDrops := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "audit_records_dropped_total",
+			Help:      "Count of audit records dropped because the audit log buffer was full",
+		},
+		[]string{},
+	)
 	prometheus.MustRegister(endpointLatencies)
 	prometheus.MustRegister(frontendLatencies)
 	prometheus.MustRegister(grpcConnections)
 	prometheus.MustRegister(httpConnections)
+	prometheus.MustRegister(socks5Connections)
+	prometheus.MustRegister(frontendConns)
 	prometheus.MustRegister(backend)
 	prometheus.MustRegister(pendingDials)
 	prometheus.MustRegister(establishedConns)
 	prometheus.MustRegister(fullRecvChannels)
 	prometheus.MustRegister(dialFailures)
 	prometheus.MustRegister(streamPackets)
+	prometheus.MustRegister(streamDataBytes)
 	prometheus.MustRegister(streamErrors)
+	prometheus.MustRegister(connectionBytes)
+	prometheus.MustRegister(agentDataBytes)
+	prometheus.MustRegister(tlsReloads)
+	prometheus.MustRegister(forwardedDials)
+	prometheus.MustRegister(pingLatencies)
+	prometheus.MustRegister(agentPingRTT)
+	prometheus.MustRegister(unhealthyBackends)
+	prometheus.MustRegister(sendQueuePackets)
+	prometheus.MustRegister(sendQueueFull)
+	prometheus.MustRegister(duplicateStreams)
+	prometheus.MustRegister(drainedStreams)
+	prometheus.MustRegister(connectionCloses)
+	prometheus.MustRegister(proxyAuths)
+	prometheus.MustRegister(auditDrops)
 	return &ServerMetrics{
 		endpointLatencies: endpointLatencies,
 		frontendLatencies: frontendLatencies,
 		grpcConnections:   grpcConnections,
 		httpConnections:   httpConnections,
+		socks5Connections: socks5Connections,
+		frontendConns:     frontendConns,
 		backend:           backend,
 		pendingDials:      pendingDials,
 		establishedConns:  establishedConns,
 		fullRecvChannels:  fullRecvChannels,
 		dialFailures:      dialFailures,
 		streamPackets:     streamPackets,
+		streamDataBytes:   streamDataBytes,
 		streamErrors:      streamErrors,
+		connectionBytes:   connectionBytes,
+		agentDataBytes:    agentDataBytes,
+		agentLabels:       agentLabels{seen: make(map[string]struct{})},
+		tlsReloads:        tlsReloads,
+		forwardedDials:    forwardedDials,
+		pingLatencies:     pingLatencies,
+		agentPingRTT:      agentPingRTT,
+		unhealthyBackends: unhealthyBackends,
+		sendQueuePackets:  sendQueuePackets,
+		sendQueueFull:     sendQueueFull,
+		duplicateStreams:  duplicateStreams,
+		drainedStreams:    drainedStreams,
+		connectionCloses:  connectionCloses,
+		proxyAuths:        proxyAuths,
+		auditDrops:        auditDrops,
 	}
 }
 
@@ -181,13 +422,32 @@ func (s *ServerMetrics) Reset() {
 	s.endpointLatencies.Reset()
 	s.frontendLatencies.Reset()
 	s.grpcConnections.Reset()
+	s.frontendConns.Reset()
 	s.backend.Reset()
 	s.pendingDials.Reset()
 	s.establishedConns.Reset()
 	s.fullRecvChannels.Reset()
 	s.dialFailures.Reset()
 	s.streamPackets.Reset()
+	s.streamDataBytes.Reset()
 	s.streamErrors.Reset()
+	s.connectionBytes.Reset()
+	s.agentDataBytes.Reset()
+	s.tlsReloads.Reset()
+	s.forwardedDials.Reset()
+	s.pingLatencies.Reset()
+	s.agentPingRTT.Reset()
+	s.unhealthyBackends.Reset()
+	s.sendQueuePackets.Reset()
+	s.sendQueueFull.Reset()
+	s.duplicateStreams.Reset()
+	s.drainedStreams.Reset()
+	s.connectionCloses.Reset()
+	s.proxyAuths.Reset()
+	s.auditDrops.Reset()
+	s.agentLabels.mu.Lock()
+	s.agentLabels.seen = make(map[string]struct{})
+	s.agentLabels.mu.Unlock()
 }
 
 // ObserveDialLatency records the latency of dial to the remote endpoint.
@@ -216,6 +476,24 @@ func (s *ServerMetrics) HTTPConnectionInc() { s.httpConnections.Inc() }
 // HTTPConnectionDec decrements a finished HTTP CONNECTION connection.
 func (s *ServerMetrics) HTTPConnectionDec() { s.httpConnections.Dec() }
 
+// SOCKS5ConnectionInc increments a new SOCKS5 connection.
+func (s *ServerMetrics) SOCKS5ConnectionInc() { s.socks5Connections.Inc() }
+
+// SOCKS5ConnectionDec decrements a finished SOCKS5 connection.
+func (s *ServerMetrics) SOCKS5ConnectionDec() { s.socks5Connections.Dec() }
+
+// FrontendConnectionInc increments the client connections of a frontend
+// listener.
+func (s *ServerMetrics) FrontendConnectionInc(listener, mode string) {
+	s.frontendConns.WithLabelValues(listener, mode).Inc()
+}
+
+// FrontendConnectionDec decrements the client connections of a frontend
+// listener.
+func (s *ServerMetrics) FrontendConnectionDec(listener, mode string) {
+	s.frontendConns.WithLabelValues(listener, mode).Dec()
+}
+
 // SetBackendCount sets the number of backend connection.
 func (s *ServerMetrics) SetBackendCount(count int) {
 	s.backend.WithLabelValues().Set(float64(count))
@@ -236,6 +514,29 @@ func (s *ServerMetrics) FullRecvChannel(serviceMethod string) prometheus.Gauge {
 	return s.fullRecvChannels.With(prometheus.Labels{"service_method": serviceMethod})
 }
 
+// SendQueue is a queue of the packets sent to an agent.
+type SendQueue string
+
+const (
+	SendQueueControl SendQueue = "control" // The packets other than DATA, which are sent first.
+	SendQueueData    SendQueue = "data"    // The DATA packets, and the CLOSE_REQs which follow them.
+)
+
+// SendQueueInc increments the packets queued in queue.
+func (s *ServerMetrics) SendQueueInc(queue SendQueue) {
+	s.sendQueuePackets.WithLabelValues(string(queue)).Inc()
+}
+
+// SendQueueDec decrements the packets queued in queue.
+func (s *ServerMetrics) SendQueueDec(queue SendQueue) {
+	s.sendQueuePackets.WithLabelValues(string(queue)).Dec()
+}
+
+// SendQueueFull counts a packet whose sender waits for room in queue.
+func (s *ServerMetrics) SendQueueFull(queue SendQueue) {
+	s.sendQueueFull.WithLabelValues(string(queue)).Inc()
+}
+
 type DialFailureReason string
 
 const (
@@ -245,6 +546,7 @@ const (
 	DialFailureSendResponse         DialFailureReason = "send_rsp"              // Successful dial response from agent, but failed to send to frontend.
 	DialFailureBackendClose         DialFailureReason = "backend_close"         // Received a DIAL_CLS from the backend before the dial completed.
 	DialFailureFrontendClose        DialFailureReason = "frontend_close"        // Received a DIAL_CLS from the frontend before the dial completed.
+	DialFailureTimeout              DialFailureReason = "timeout"               // No dial response from the backend within the dial timeout.
 )
 
 func (s *ServerMetrics) ObserveDialFailure(reason DialFailureReason) {
@@ -255,6 +557,107 @@ func (s *ServerMetrics) ObservePacket(segment commonmetrics.Segment, packetType
 	commonmetrics.ObservePacket(s.streamPackets, segment, packetType)
 }
 
+func (s *ServerMetrics) ObserveDataBytes(segment commonmetrics.Segment, pkt *client.Packet) {
+	commonmetrics.ObserveDataBytes(s.streamDataBytes, segment, pkt)
+}
+
+// SetAgentLabelLimit sets the maximum number of distinct agents reported by
+// the per-agent metrics. Zero disables the per-agent metrics.
+func (s *ServerMetrics) SetAgentLabelLimit(limit int) {
+	s.agentLabels.mu.Lock()
+	defer s.agentLabels.mu.Unlock()
+	s.agentLabels.limit = limit
+}
+
+// AgentMetricsEnabled returns whether the per-agent metrics are enabled.
+func (s *ServerMetrics) AgentMetricsEnabled() bool {
+	return s.agentLabels.enabled()
+}
+
+// ObserveAgentDataBytes adds the payload size of DATA packets exchanged with
+// the given agent to the per-agent metrics, if enabled.
+func (s *ServerMetrics) ObserveAgentDataBytes(agentID string, segment commonmetrics.Segment, pkt *client.Packet) {
+	if pkt.Type != client.PacketType_DATA || !s.agentLabels.enabled() {
+		return
+	}
+	s.agentDataBytes.WithLabelValues(s.agentLabels.value(agentID), string(segment)).Add(float64(len(pkt.GetData().Data)))
+}
+
+// ObserveTLSReload records the result of reloading the TLS files of listener.
+func (s *ServerMetrics) ObserveTLSReload(listener string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	s.tlsReloads.WithLabelValues(listener, result).Inc()
+}
+
+// ObserveForwardedDial records the result of forwarding a dial to a peer
+// proxy server.
+func (s *ServerMetrics) ObserveForwardedDial(err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	s.forwardedDials.WithLabelValues(result).Inc()
+}
+
+// ObservePing records the round-trip time of a PING answered by an agent.
+func (s *ServerMetrics) ObservePing(agentID string, rtt time.Duration) {
+	s.pingLatencies.WithLabelValues().Observe(rtt.Seconds())
+	s.agentPingRTT.WithLabelValues(agentID).Set(rtt.Seconds())
+}
+
+// ForgetAgentPing removes the round-trip time of an agent which disconnected.
+func (s *ServerMetrics) ForgetAgentPing(agentID string) {
+	s.agentPingRTT.DeleteLabelValues(agentID)
+}
+
+// UnhealthyBackendInc increments the number of unhealthy agent connections.
+func (s *ServerMetrics) UnhealthyBackendInc() {
+	s.unhealthyBackends.WithLabelValues().Inc()
+}
+
+// UnhealthyBackendDec decrements the number of unhealthy agent connections.
+func (s *ServerMetrics) UnhealthyBackendDec() {
+	s.unhealthyBackends.WithLabelValues().Dec()
+}
+
+// SetDuplicateStreamCount sets the number of agent streams in excess of one
+// per agent.
+func (s *ServerMetrics) SetDuplicateStreamCount(count int) {
+	s.duplicateStreams.WithLabelValues().Set(float64(count))
+}
+
+// DrainedStreamInc increments the DRAIN packets sent to agents.
+func (s *ServerMetrics) DrainedStreamInc() {
+	s.drainedStreams.WithLabelValues().Inc()
+}
+
+// ObserveProxyAuth records the result of authenticating an HTTP CONNECT
+// request by its Proxy-Authorization header.
+func (s *ServerMetrics) ObserveProxyAuth(result string) {
+	s.proxyAuths.WithLabelValues(result).Inc()
+}
+
+// AuditRecordDroppedInc increments the audit records dropped for lack of
+// room in the audit log buffer.
+func (s *ServerMetrics) AuditRecordDroppedInc() {
+	s.auditDrops.WithLabelValues().Inc()
+}
+
+// ObserveConnectionClose records the close reason of a torn down connection.
+func (s *ServerMetrics) ObserveConnectionClose(reason string) {
+	s.connectionCloses.WithLabelValues(reason).Inc()
+}
+
+// ObserveConnectionTransfer records the bytes transferred in each direction
+// over the lifetime of an established connection.
+func (s *ServerMetrics) ObserveConnectionTransfer(toAgent, fromAgent int64) {
+	s.connectionBytes.WithLabelValues(string(commonmetrics.SegmentToAgent)).Observe(float64(toAgent))
+	s.connectionBytes.WithLabelValues(string(commonmetrics.SegmentFromAgent)).Observe(float64(fromAgent))
+}
+
 func (s *ServerMetrics) ObserveStreamErrorNoPacket(segment commonmetrics.Segment, err error) {
 	commonmetrics.ObserveStreamErrorNoPacket(s.streamErrors, segment, err)
 }
@@ -0,0 +1,399 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
+	pkgagent "sigs.k8s.io/apiserver-network-proxy/pkg/agent"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server/metrics"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/util"
+	"sigs.k8s.io/apiserver-network-proxy/proto/agent"
+	"sigs.k8s.io/apiserver-network-proxy/proto/header"
+	peerproto "sigs.k8s.io/apiserver-network-proxy/proto/peer"
+)
+
+// Peers tracks the agents connected to the peer proxy servers, i.e. the
+// other instances of an HA proxy server, so that a dial for which no agent
+// is connected to this server can be forwarded to a peer with a matching
+// agent.
+//
+// Peers are found by resolving a list of host:port addresses, e.g. of a
+// headless Service; every address a host resolves to is a peer. Each peer is
+// periodically asked for its agents through the PeerService, and dials are
+// forwarded through the ProxyService it serves on the same port.
+type Peers struct {
+	serverID  string
+	addresses []string
+	// tlsConfig authenticates the peer connections. If nil, the connections
+	// are insecure, which is only meant for tests.
+	tlsConfig *tls.Config
+
+	// lookupHost resolves the host of a peer address; overridden in tests.
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+
+	mu    sync.RWMutex // protects peers
+	peers map[string]*peerConn
+
+	randomMu sync.Mutex // protects random
+	random   *rand.Rand
+}
+
+// peerConn is the connection to a peer, by resolved address.
+type peerConn struct {
+	address string
+	conn    *grpc.ClientConn
+
+	// The following are updated on every sync, under Peers.mu.
+
+	// serverID is the ID of the peer, or empty if it never responded.
+	serverID string
+	// agents are the agents connected to the peer.
+	agents []pkgagent.Identifiers
+}
+
+// NewPeers returns the peers reachable at addresses, excluding the server
+// serverID itself. Peer connections are authenticated with tlsConfig, whose
+// ServerName is set to the host of each address.
+func NewPeers(serverID string, addresses []string, tlsConfig *tls.Config) *Peers {
+	return &Peers{
+		serverID:   serverID,
+		addresses:  addresses,
+		tlsConfig:  tlsConfig,
+		lookupHost: net.DefaultResolver.LookupHost,
+		peers:      make(map[string]*peerConn),
+		random:     rand.New(rand.NewSource(time.Now().UnixNano())), /* #nosec G404 */
+	}
+}
+
+// Run refreshes the peers and their agents every interval, until stopCh is
+// closed. The peer connections are then closed.
+func (p *Peers) Run(interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() { p.sync(interval) }, interval, stopCh)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for address, pc := range p.peers {
+		pc.conn.Close()
+		delete(p.peers, address)
+	}
+}
+
+// sync resolves the peer addresses and asks every peer for its agents,
+// within timeout.
+func (p *Peers) sync(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// resolved maps the address of every peer to the host it was resolved
+	// from, which its certificate must be valid for.
+	resolved := make(map[string]string)
+	for _, address := range p.addresses {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			klog.ErrorS(err, "Invalid peer address", "address", address)
+			continue
+		}
+		ips, err := p.lookupHost(ctx, host)
+		if err != nil {
+			klog.ErrorS(err, "Failed to resolve the peer address", "address", address)
+			continue
+		}
+		for _, ip := range ips {
+			resolved[net.JoinHostPort(ip, port)] = host
+		}
+	}
+
+	p.mu.Lock()
+	for address, pc := range p.peers {
+		if _, ok := resolved[address]; !ok {
+			klog.V(2).InfoS("Peer proxy server is gone", "address", address, "peerID", pc.serverID)
+			pc.conn.Close()
+			delete(p.peers, address)
+		}
+	}
+	var conns []*peerConn
+	for address, host := range resolved {
+		pc, ok := p.peers[address]
+		if !ok {
+			conn, err := grpc.Dial(address, p.dialOption(host))
+			if err != nil {
+				klog.ErrorS(err, "Failed to connect to the peer proxy server", "address", address)
+				continue
+			}
+			pc = &peerConn{address: address, conn: conn}
+			p.peers[address] = pc
+		}
+		conns = append(conns, pc)
+	}
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, pc := range conns {
+		wg.Add(1)
+		go func(pc *peerConn) {
+			defer wg.Done()
+			p.refresh(ctx, pc)
+		}(pc)
+	}
+	wg.Wait()
+}
+
+func (p *Peers) dialOption(host string) grpc.DialOption {
+	if p.tlsConfig == nil {
+		return grpc.WithInsecure()
+	}
+	tlsConfig := p.tlsConfig.Clone()
+	tlsConfig.ServerName = host
+	return grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
+}
+
+// refresh asks the peer pc for its agents.
+func (p *Peers) refresh(ctx context.Context, pc *peerConn) {
+	resp, err := peerproto.NewPeerServiceClient(pc.conn).Agents(ctx, &peerproto.AgentsRequest{ServerID: p.serverID})
+	if err != nil {
+		klog.V(2).InfoS("Failed to get the agents of the peer proxy server", "address", pc.address, "error", err)
+		p.mu.Lock()
+		pc.agents = nil
+		p.mu.Unlock()
+		return
+	}
+	var agents []pkgagent.Identifiers
+	for _, a := range resp.Agents {
+		ids, err := pkgagent.GenAgentIdentifiers(a.Identifiers)
+		if err != nil {
+			// Zero identifiers would match the default strategy.
+			klog.V(2).InfoS("Ignoring the peer agent with invalid identifiers", "peerID", resp.ServerID, "agentID", a.AgentID, "error", err)
+			continue
+		}
+		agents = append(agents, ids)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pc.serverID != resp.ServerID || len(pc.agents) != len(agents) {
+		klog.V(3).InfoS("Refreshed the agents of the peer proxy server", "address", pc.address, "peerID", resp.ServerID, "agents", len(agents))
+	}
+	pc.serverID = resp.ServerID
+	pc.agents = agents
+}
+
+// pick returns a random peer with an agent which the strategies would pick
+// to dial reqHost, trying the strategies in order; destHost matches the
+// identifiers of destHostTypes. This server itself is never picked.
+func (p *Peers) pick(strategies []ProxyStrategy, destHostTypes []pkgagent.IdentifierType, reqHost string) *peerConn {
+	host := util.RemovePortFromHost(reqHost)
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, ps := range strategies {
+		var candidates []*peerConn
+		for _, pc := range p.peers {
+			if pc.serverID == "" || pc.serverID == p.serverID {
+				continue
+			}
+			for _, ids := range pc.agents {
+				if matchesStrategy(ids, ps, destHostTypes, host) {
+					candidates = append(candidates, pc)
+					break
+				}
+			}
+		}
+		if len(candidates) > 0 {
+			// Sort for the random pick to be reproducible in tests.
+			sort.Slice(candidates, func(i, j int) bool { return candidates[i].address < candidates[j].address })
+			p.randomMu.Lock()
+			defer p.randomMu.Unlock()
+			return candidates[p.random.Intn(len(candidates))]
+		}
+	}
+	return nil
+}
+
+// matchesStrategy reports whether the backend manager of strategy ps would
+// pick an agent advertising ids to dial host.
+func matchesStrategy(ids pkgagent.Identifiers, ps ProxyStrategy, destHostTypes []pkgagent.IdentifierType, host string) bool {
+	switch ps {
+	case ProxyStrategyDefault:
+		return true
+	case ProxyStrategyDestHost:
+		lists := map[pkgagent.IdentifierType][]string{
+			pkgagent.IPv4: ids.IPv4,
+			pkgagent.IPv6: ids.IPv6,
+			pkgagent.Host: ids.Host,
+		}
+		for _, idType := range destHostTypes {
+			for _, id := range lists[idType] {
+				if id == host {
+					return true
+				}
+			}
+		}
+	case ProxyStrategyDefaultRoute:
+		return ids.DefaultRoute
+	}
+	return false
+}
+
+// open opens a stream to forward a dial of reqHost to a peer with a
+// matching agent.
+func (p *Peers) open(strategies []ProxyStrategy, destHostTypes []pkgagent.IdentifierType, reqHost string) (*peerBackend, error) {
+	pc := p.pick(strategies, destHostTypes, reqHost)
+	if pc == nil {
+		return nil, &ErrNotFound{}
+	}
+	p.mu.RLock()
+	peerID := pc.serverID
+	p.mu.RUnlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = metadata.AppendToOutgoingContext(ctx, header.ForwardedBy, p.serverID)
+	stream, err := client.NewProxyServiceClient(pc.conn).Proxy(ctx)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to forward to peer proxy server %s: %v", peerID, err)
+	}
+	return &peerBackend{serverID: peerID, stream: stream, cancel: cancel}, nil
+}
+
+// peerBackend forwards the packets of a single dial to a peer proxy server,
+// through a ProxyService stream, as if the peer was an agent.
+type peerBackend struct {
+	// serverID is the ID of the peer.
+	serverID string
+	stream   client.ProxyService_ProxyClient
+	// cancel aborts the stream; the peer then cleans up the dial and
+	// connection of the stream.
+	cancel context.CancelFunc
+
+	sendLock sync.Mutex
+}
+
+var _ Backend = &peerBackend{}
+
+func (b *peerBackend) Send(pkt *client.Packet) error {
+	b.sendLock.Lock()
+	defer b.sendLock.Unlock()
+	err := b.stream.Send(pkt)
+	if pkt.Type == client.PacketType_DIAL_CLS {
+		// The peer never responds to a cancelled dial.
+		b.cancel()
+	}
+	return err
+}
+
+func (b *peerBackend) Recv() (*client.Packet, error) {
+	return b.stream.Recv()
+}
+
+func (b *peerBackend) Context() context.Context {
+	return b.stream.Context()
+}
+
+// isLastPeerPacket reports whether pkt is the last packet a peer sends on a
+// forwarded stream.
+func isLastPeerPacket(pkt *client.Packet) bool {
+	switch pkt.Type {
+	case client.PacketType_DIAL_RSP:
+		return pkt.GetDialResponse().Error != ""
+	case client.PacketType_DIAL_CLS, client.PacketType_CLOSE_RSP:
+		return true
+	}
+	return false
+}
+
+// forwardToPeer opens a stream to forward a dial of reqHost to a peer with a
+// matching agent, and starts routing the packets of the peer back to the
+// frontend. It returns ErrNotFound if no peer has a matching agent.
+func (s *ProxyServer) forwardToPeer(reqHost string) (Backend, error) {
+	b, err := s.Peers.open(s.proxyStrategies, s.destHostIdentifierTypes(), reqHost)
+	if _, ok := err.(*ErrNotFound); ok {
+		return nil, err
+	}
+	metrics.Metrics.ObserveForwardedDial(err)
+	if err != nil {
+		return nil, err
+	}
+	klog.V(3).InfoS("Forwarding dial to peer proxy server", "dialAddress", reqHost, "peerID", b.serverID)
+	go s.servePeerBackend(b)
+	return b, nil
+}
+
+// servePeerBackend routes the packets received from the peer to the
+// frontend, until the peer is done with the stream.
+func (s *ProxyServer) servePeerBackend(b *peerBackend) {
+	// Connection IDs are only unique per agent; the pseudo agent ID of the
+	// stream keeps the frontends of every forwarded dial apart.
+	agentID := fmt.Sprintf("peer:%s:%s", b.serverID, uuid.New().String())
+	recvCh := make(chan *client.Packet, xfrChannelSize)
+	go s.serveRecvBackend(b, agentID, recvCh)
+	defer close(recvCh)
+	defer b.cancel()
+
+	for {
+		pkt, err := b.Recv()
+		if err != nil {
+			if err != io.EOF && status.Code(err) != codes.Canceled {
+				klog.ErrorS(err, "Receive stream from peer proxy server read failure", "peerID", b.serverID)
+			}
+			return
+		}
+		recvCh <- pkt
+		if isLastPeerPacket(pkt) {
+			return
+		}
+	}
+}
+
+// Agents returns the agents connected to this server, for the peers to
+// forward the dials they have no agent for.
+func (s *ProxyServer) Agents(_ context.Context, req *peerproto.AgentsRequest) (*peerproto.AgentsResponse, error) {
+	klog.V(5).InfoS("Agents request from peer proxy server", "peerID", req.ServerID)
+	resp := &peerproto.AgentsResponse{ServerID: s.serverID}
+	seen := make(map[string]bool)
+	s.amu.Lock()
+	for backend, agentID := range s.agentStreams {
+		if seen[agentID] {
+			continue
+		}
+		seen[agentID] = true
+		var identifiers string
+		if stream, ok := agentStream(backend).(agent.AgentService_ConnectServer); ok {
+			identifiers = s.agentIdentifiers(stream)
+		}
+		resp.Agents = append(resp.Agents, &peerproto.Agent{AgentID: agentID, Identifiers: identifiers})
+	}
+	s.amu.Unlock()
+	sort.Slice(resp.Agents, func(i, j int) bool { return resp.Agents[i].AgentID < resp.Agents[j].AgentID })
+	return resp, nil
+}
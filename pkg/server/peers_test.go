@@ -0,0 +1,224 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	client "sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
+	pkgagent "sigs.k8s.io/apiserver-network-proxy/pkg/agent"
+	agentmock "sigs.k8s.io/apiserver-network-proxy/proto/agent/mocks"
+	"sigs.k8s.io/apiserver-network-proxy/proto/header"
+	peerproto "sigs.k8s.io/apiserver-network-proxy/proto/peer"
+)
+
+func TestPeersPick(t *testing.T) {
+	p := NewPeers("server-a", nil, nil)
+	p.peers = map[string]*peerConn{
+		"10.0.0.1:8093": {address: "10.0.0.1:8093", serverID: "server-a", agents: []pkgagent.Identifiers{{Host: []string{"self.local"}}}},
+		"10.0.0.2:8093": {address: "10.0.0.2:8093", serverID: "server-b", agents: []pkgagent.Identifiers{{Host: []string{"node1.local"}, IPv4: []string{"10.1.0.1"}}}},
+		"10.0.0.3:8093": {address: "10.0.0.3:8093", serverID: "server-c", agents: []pkgagent.Identifiers{{}, {DefaultRoute: true}}},
+		"10.0.0.4:8093": {address: "10.0.0.4:8093", agents: []pkgagent.Identifiers{{Host: []string{"node2.local"}}}},
+	}
+	destHostDefaultRoute := []ProxyStrategy{ProxyStrategyDestHost, ProxyStrategyDefaultRoute}
+
+	for _, tc := range []struct {
+		desc       string
+		strategies []ProxyStrategy
+		reqHost    string
+		want       string
+	}{
+		{"dest host", destHostDefaultRoute, "node1.local:10250", "server-b"},
+		{"dest host IP", destHostDefaultRoute, "10.1.0.1:10250", "server-b"},
+		{"default route fallback", destHostDefaultRoute, "node3.local:10250", "server-c"},
+		{"no match", []ProxyStrategy{ProxyStrategyDestHost}, "node3.local:10250", ""},
+		{"self is never picked", []ProxyStrategy{ProxyStrategyDestHost}, "self.local:10250", ""},
+		{"unknown peer is never picked", []ProxyStrategy{ProxyStrategyDestHost}, "node2.local:10250", ""},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			var got string
+			if pc := p.pick(tc.strategies, DestHostIdentifierTypes, tc.reqHost); pc != nil {
+				got = pc.serverID
+			}
+			if got != tc.want {
+				t.Errorf("expected peer %q, got %q", tc.want, got)
+			}
+		})
+	}
+
+	// destHost only matches the identifiers of the configured types.
+	if pc := p.pick([]ProxyStrategy{ProxyStrategyDestHost}, []pkgagent.IdentifierType{pkgagent.Host}, "10.1.0.1:10250"); pc != nil {
+		t.Errorf("expected no peer for an IP when destHost only matches host names, got %q", pc.serverID)
+	}
+
+	// Any agent matches the default strategy.
+	for i := 0; i < 10; i++ {
+		pc := p.pick([]ProxyStrategy{ProxyStrategyDefault}, DestHostIdentifierTypes, "node3.local:10250")
+		if pc == nil || (pc.serverID != "server-b" && pc.serverID != "server-c") {
+			t.Fatalf("expected server-b or server-c, got %+v", pc)
+		}
+	}
+}
+
+func TestPeersAgents(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	s := NewProxyServer("server-a", []ProxyStrategy{ProxyStrategyDestHost}, 1, &AgentTokenAuthenticationOptions{})
+	for _, a := range []struct{ agentID, identifiers string }{
+		{"agent2", "host=node2.local"},
+		{"agent1", "host=node1.local&ipv4=10.1.0.1"},
+		{"agent1", "host=node1.local&ipv4=10.1.0.1"},
+	} {
+		conn := agentmock.NewMockAgentService_ConnectServer(ctrl)
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(header.AgentID, a.agentID, header.AgentIdentifiers, a.identifiers))
+		conn.EXPECT().Context().Return(ctx).AnyTimes()
+		s.addAgentStream(newBackend(conn), a.agentID, s.members())
+	}
+
+	resp, err := s.Agents(context.Background(), &peerproto.AgentsRequest{ServerID: "server-b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &peerproto.AgentsResponse{
+		ServerID: "server-a",
+		Agents: []*peerproto.Agent{
+			{AgentID: "agent1", Identifiers: "host=node1.local&ipv4=10.1.0.1"},
+			{AgentID: "agent2", Identifiers: "host=node2.local"},
+		},
+	}
+	if resp.ServerID != want.ServerID || len(resp.Agents) != len(want.Agents) {
+		t.Fatalf("expected %v, got %v", want, resp)
+	}
+	for i := range want.Agents {
+		if resp.Agents[i].AgentID != want.Agents[i].AgentID || resp.Agents[i].Identifiers != want.Agents[i].Identifiers {
+			t.Errorf("expected agent %v, got %v", want.Agents[i], resp.Agents[i])
+		}
+	}
+}
+
+func TestPeersSync(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	peerServer := NewProxyServer("server-b", []ProxyStrategy{ProxyStrategyDestHost}, 1, &AgentTokenAuthenticationOptions{})
+	conn := agentmock.NewMockAgentService_ConnectServer(ctrl)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(header.AgentID, "agent1", header.AgentIdentifiers, "host=node1.local"))
+	conn.EXPECT().Context().Return(ctx).AnyTimes()
+	peerServer.addAgentStream(newBackend(conn), "agent1", peerServer.members())
+
+	grpcServer := grpc.NewServer()
+	peerproto.RegisterPeerServiceServer(grpcServer, peerServer)
+	client.RegisterProxyServiceServer(grpcServer, peerServer)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+	_, port, _ := net.SplitHostPort(lis.Addr().String())
+
+	p := NewPeers("server-a", []string{net.JoinHostPort("peers.local", port)}, nil)
+	p.lookupHost = func(_ context.Context, host string) ([]string, error) {
+		if host != "peers.local" {
+			t.Errorf("unexpected lookup of %q", host)
+		}
+		return []string{"127.0.0.1"}, nil
+	}
+	p.sync(5 * time.Second)
+
+	pc := p.pick([]ProxyStrategy{ProxyStrategyDestHost}, DestHostIdentifierTypes, "node1.local:10250")
+	if pc == nil {
+		t.Fatal("expected the peer to be picked")
+	}
+	if pc.serverID != "server-b" {
+		t.Errorf("expected server-b, got %q", pc.serverID)
+	}
+	if want := []pkgagent.Identifiers{{Host: []string{"node1.local"}}}; !reflect.DeepEqual(pc.agents, want) {
+		t.Errorf("expected agents %+v, got %+v", want, pc.agents)
+	}
+
+	// The peer is forgotten once its address no longer resolves.
+	p.lookupHost = func(context.Context, string) ([]string, error) { return nil, nil }
+	p.sync(5 * time.Second)
+	if pc := p.pick([]ProxyStrategy{ProxyStrategyDestHost}, DestHostIdentifierTypes, "node1.local:10250"); pc != nil {
+		t.Errorf("expected no peer, got %+v", pc)
+	}
+}
+
+// stubPeerService advertises a fixed list of agents.
+type stubPeerService struct {
+	peerproto.UnimplementedPeerServiceServer
+	resp *peerproto.AgentsResponse
+}
+
+func (s *stubPeerService) Agents(context.Context, *peerproto.AgentsRequest) (*peerproto.AgentsResponse, error) {
+	return s.resp, nil
+}
+
+func TestPeersRefreshInvalidIdentifiers(t *testing.T) {
+	grpcServer := grpc.NewServer()
+	peerproto.RegisterPeerServiceServer(grpcServer, &stubPeerService{resp: &peerproto.AgentsResponse{
+		ServerID: "server-b",
+		Agents:   []*peerproto.Agent{{AgentID: "agent1", Identifiers: "bogus=node1.local"}},
+	}})
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	p := NewPeers("server-a", []string{lis.Addr().String()}, nil)
+	p.sync(5 * time.Second)
+
+	// Zero identifiers would match the default strategy.
+	if pc := p.pick([]ProxyStrategy{ProxyStrategyDefault}, DestHostIdentifierTypes, "node1.local:10250"); pc != nil {
+		t.Errorf("expected no peer for an agent with invalid identifiers, got %+v", pc)
+	}
+}
+
+func TestIsLastPeerPacket(t *testing.T) {
+	dialRsp := func(errMsg string) *client.Packet {
+		return &client.Packet{
+			Type:    client.PacketType_DIAL_RSP,
+			Payload: &client.Packet_DialResponse{DialResponse: &client.DialResponse{Error: errMsg}},
+		}
+	}
+	for _, tc := range []struct {
+		pkt  *client.Packet
+		want bool
+	}{
+		{dialRsp(""), false},
+		{dialRsp("connection refused"), true},
+		{dataPkt(1, []byte("hello")), false},
+		{closeRspPkt(1, ""), true},
+		{dialClosePkt(1), true},
+	} {
+		if got := isLastPeerPacket(tc.pkt); got != tc.want {
+			t.Errorf("isLastPeerPacket(%v) = %v, expected %v", tc.pkt.Type, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxyauth
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1" // #nosec G505 -- required by the {SHA} htpasswd format.
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Htpasswd accepts the Basic credentials of the users of an htpasswd file.
+// Only the bcrypt ($2y$, $2a$, $2b$) and {SHA} password hashes are
+// supported.
+type Htpasswd struct {
+	hashes map[string]string
+}
+
+var _ Authenticator = &Htpasswd{}
+
+// NewHtpasswd loads the users of the htpasswd file at path.
+func NewHtpasswd(path string) (*Htpasswd, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := &Htpasswd{hashes: make(map[string]string)}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("%s:%d: expected user:hash", path, n)
+		}
+		if !isBcrypt(hash) && !strings.HasPrefix(hash, "{SHA}") {
+			return nil, fmt.Errorf("%s:%d: unsupported password hash of user %q, expected bcrypt or {SHA}", path, n, user)
+		}
+		h.hashes[user] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2y$") || strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$")
+}
+
+// Scheme returns SchemeBasic.
+func (h *Htpasswd) Scheme() string {
+	return SchemeBasic
+}
+
+// Authenticate returns the user name of the base64 encoded user:password
+// credentials.
+func (h *Htpasswd) Authenticate(_ context.Context, credentials string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(credentials)
+	if err != nil {
+		return "", ErrInvalidCredentials
+	}
+	user, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return "", ErrInvalidCredentials
+	}
+	hash, ok := h.hashes[user]
+	if !ok {
+		return "", ErrInvalidCredentials
+	}
+	if isBcrypt(hash) {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+			return "", ErrInvalidCredentials
+		}
+		return user, nil
+	}
+	sum := sha1.Sum([]byte(password)) // #nosec G401
+	expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) != 1 {
+		return "", ErrInvalidCredentials
+	}
+	return user, nil
+}
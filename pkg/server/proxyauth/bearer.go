@@ -0,0 +1,127 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxyauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	authv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// StaticTokens accepts the Bearer tokens of a token file.
+type StaticTokens struct {
+	// users are the users by the SHA-256 of their tokens, so that tokens
+	// are compared in constant time.
+	users map[[sha256.Size]byte]string
+}
+
+var _ Authenticator = &StaticTokens{}
+
+// NewStaticTokens loads the tokens of the file at path. As in the token
+// file of kube-apiserver, each line is a token and a user name, separated
+// by a comma; further columns are ignored.
+func NewStaticTokens(path string) (*StaticTokens, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t := &StaticTokens{users: make(map[[sha256.Size]byte]string)}
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := r.FieldPos(0)
+		if len(record) < 2 || strings.TrimSpace(record[0]) == "" || strings.TrimSpace(record[1]) == "" {
+			return nil, fmt.Errorf("%s:%d: expected token,user", path, line)
+		}
+		t.users[sha256.Sum256([]byte(strings.TrimSpace(record[0])))] = strings.TrimSpace(record[1])
+	}
+	return t, nil
+}
+
+// Scheme returns SchemeBearer.
+func (t *StaticTokens) Scheme() string {
+	return SchemeBearer
+}
+
+// Authenticate returns the user of the token.
+func (t *StaticTokens) Authenticate(_ context.Context, token string) (string, error) {
+	user, ok := t.users[sha256.Sum256([]byte(token))]
+	if !ok {
+		return "", ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// TokenReview accepts the Bearer tokens authenticated by the Kubernetes
+// TokenReview API.
+type TokenReview struct {
+	client    kubernetes.Interface
+	audiences []string
+}
+
+var _ Authenticator = &TokenReview{}
+
+// NewTokenReview returns a TokenReview authenticator reviewing tokens for
+// audiences, or for the audiences of the API server if empty.
+func NewTokenReview(client kubernetes.Interface, audiences []string) *TokenReview {
+	return &TokenReview{client: client, audiences: audiences}
+}
+
+// Scheme returns SchemeBearer.
+func (t *TokenReview) Scheme() string {
+	return SchemeBearer
+}
+
+// Authenticate returns the user name of the token.
+func (t *TokenReview) Authenticate(ctx context.Context, token string) (string, error) {
+	review := &authv1.TokenReview{
+		Spec: authv1.TokenReviewSpec{
+			Token:     token,
+			Audiences: t.audiences,
+		},
+	}
+	r, err := t.client.AuthenticationV1().TokenReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to review token: %v", err)
+	}
+	if !r.Status.Authenticated {
+		if r.Status.Error != "" {
+			klog.V(2).InfoS("Token review rejected the proxy token", "error", r.Status.Error)
+		}
+		return "", ErrInvalidCredentials
+	}
+	return r.Status.User.Username, nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package proxyauth authenticates the HTTP CONNECT requests of the
+// frontends by their Proxy-Authorization header, for the frontends which
+// cannot present a client certificate.
+package proxyauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// SchemeBasic is the scheme of the user name and password credentials.
+	SchemeBasic = "Basic"
+	// SchemeBearer is the scheme of the token credentials.
+	SchemeBearer = "Bearer"
+)
+
+var (
+	// ErrNoCredentials is returned for the requests without a
+	// Proxy-Authorization header.
+	ErrNoCredentials = errors.New("no Proxy-Authorization credentials")
+	// ErrInvalidCredentials is returned for the credentials which are not
+	// accepted.
+	ErrInvalidCredentials = errors.New("invalid Proxy-Authorization credentials")
+)
+
+// Authenticator checks the credentials of one scheme.
+type Authenticator interface {
+	// Scheme is the authentication scheme of the credentials, e.g. "Basic".
+	Scheme() string
+	// Authenticate returns the identity of the client presenting
+	// credentials, or ErrInvalidCredentials if they are not accepted.
+	Authenticate(ctx context.Context, credentials string) (string, error)
+}
+
+// Chain authenticates requests with its Authenticators. Credentials are
+// accepted if any of the Authenticators of their scheme accepts them.
+type Chain struct {
+	// Realm is advertised in the challenges.
+	Realm          string
+	Authenticators []Authenticator
+}
+
+// Authenticate returns the identity of the client which sent r.
+func (c *Chain) Authenticate(r *http.Request) (string, error) {
+	value := r.Header.Get("Proxy-Authorization")
+	if value == "" {
+		return "", ErrNoCredentials
+	}
+	scheme, credentials, ok := strings.Cut(value, " ")
+	if !ok {
+		return "", ErrInvalidCredentials
+	}
+	return c.AuthenticateCredentials(r.Context(), scheme, strings.TrimSpace(credentials))
+}
+
+// AuthenticateCredentials returns the identity of the client presenting
+// credentials of scheme, e.g. the base64 encoded user:password of the
+// SchemeBasic.
+func (c *Chain) AuthenticateCredentials(ctx context.Context, scheme, credentials string) (string, error) {
+	var lastErr error = ErrInvalidCredentials
+	for _, a := range c.Authenticators {
+		if !strings.EqualFold(a.Scheme(), scheme) {
+			continue
+		}
+		identity, err := a.Authenticate(ctx, credentials)
+		if err == nil {
+			return identity, nil
+		}
+		lastErr = err
+	}
+	return "", lastErr
+}
+
+// Challenges returns the Proxy-Authenticate header values sent with the 407
+// responses, one per scheme.
+func (c *Chain) Challenges() []string {
+	var challenges []string
+	seen := make(map[string]bool)
+	for _, a := range c.Authenticators {
+		if seen[a.Scheme()] {
+			continue
+		}
+		seen[a.Scheme()] = true
+		challenges = append(challenges, fmt.Sprintf("%s realm=%q", a.Scheme(), c.Realm))
+	}
+	return challenges
+}
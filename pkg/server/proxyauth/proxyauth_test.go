@@ -0,0 +1,134 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxyauth
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+	authv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func request(authorization string) *http.Request {
+	r, _ := http.NewRequest(http.MethodConnect, "http://node1.local:10250", nil)
+	if authorization != "" {
+		r.Header.Set("Proxy-Authorization", authorization)
+	}
+	return r
+}
+
+func basic(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+func TestChain(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("alice-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The {SHA} hash of "bob-password".
+	htpasswd, err := NewHtpasswd(writeFile(t, "htpasswd", strings.Join([]string{
+		"# users",
+		"alice:" + strings.Replace(string(hash), "$2a$", "$2y$", 1),
+		"bob:{SHA}oHryCTyM4ObJvET53dSBiRe/fXQ=",
+	}, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := NewStaticTokens(writeFile(t, "tokens.csv", "s3cr3t,apiserver,uid1,\"group1,group2\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kcs := k8sfake.NewSimpleClientset()
+	kcs.Fake.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authv1.TokenReview)
+		review.Status.Authenticated = review.Spec.Token == "sa-token" && reflect.DeepEqual(review.Spec.Audiences, []string{"konnectivity"})
+		review.Status.User.Username = "system:serviceaccount:kube-system:apiserver"
+		return true, review, nil
+	})
+	chain := &Chain{
+		Realm:          "konnectivity",
+		Authenticators: []Authenticator{htpasswd, tokens, NewTokenReview(kcs, []string{"konnectivity"})},
+	}
+
+	for _, tc := range []struct {
+		authorization string
+		identity      string
+		err           error
+	}{
+		{"", "", ErrNoCredentials},
+		{"Basic", "", ErrInvalidCredentials},
+		{basic("alice", "alice-password"), "alice", nil},
+		{basic("alice", "wrong"), "", ErrInvalidCredentials},
+		{basic("bob", "bob-password"), "bob", nil},
+		{basic("carol", "bob-password"), "", ErrInvalidCredentials},
+		{"Basic !!!", "", ErrInvalidCredentials},
+		{"Bearer s3cr3t", "apiserver", nil},
+		{"bearer s3cr3t", "apiserver", nil},
+		{"Bearer sa-token", "system:serviceaccount:kube-system:apiserver", nil},
+		{"Bearer wrong", "", ErrInvalidCredentials},
+		{"Digest username=alice", "", ErrInvalidCredentials},
+	} {
+		identity, err := chain.Authenticate(request(tc.authorization))
+		if identity != tc.identity || !errors.Is(err, tc.err) {
+			t.Errorf("Authenticate(%q) = %q, %v; expected %q, %v", tc.authorization, identity, err, tc.identity, tc.err)
+		}
+	}
+
+	want := []string{`Basic realm="konnectivity"`, `Bearer realm="konnectivity"`}
+	if got := chain.Challenges(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected challenges %v, got %v", want, got)
+	}
+}
+
+func TestNewHtpasswd_Invalid(t *testing.T) {
+	for _, content := range []string{
+		"alice",
+		"alice:$apr1$salt$hash",
+		":{SHA}oHryCTyM4ObJvET53dSBiRe/fXQ=",
+	} {
+		if _, err := NewHtpasswd(writeFile(t, "htpasswd", content)); err == nil {
+			t.Errorf("expected an error for %q", content)
+		}
+	}
+}
+
+func TestNewStaticTokens_Invalid(t *testing.T) {
+	for _, content := range []string{"s3cr3t", ",apiserver", "s3cr3t,"} {
+		if _, err := NewStaticTokens(writeFile(t, "tokens.csv", content)); err == nil {
+			t.Errorf("expected an error for %q", content)
+		}
+	}
+}
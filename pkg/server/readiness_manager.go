@@ -16,6 +16,13 @@ limitations under the license.
 
 package server
 
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
 // ReadinessManager supports checking if the proxy server is ready.
 type ReadinessManager interface {
 	// Ready returns if the proxy server is ready. If not, also return an
@@ -31,3 +38,163 @@ func (s *DefaultBackendStorage) Ready() (bool, string) {
 	}
 	return true, ""
 }
+
+// ReadinessCheck is the result of one of the checks of a ReadinessReporter.
+type ReadinessCheck struct {
+	Name    string `json:"name"`
+	Ready   bool   `json:"ready"`
+	Message string `json:"message,omitempty"`
+}
+
+// ReadinessReporter is a ReadinessManager which details the result of each
+// of its checks.
+type ReadinessReporter interface {
+	ReadinessManager
+	// Checks returns the result of every check.
+	Checks() []ReadinessCheck
+}
+
+// ReadinessPolicy configures when the proxy server is ready.
+type ReadinessPolicy struct {
+	// MinAgents is the minimum number of connected agents. Zero disables
+	// the check.
+	MinAgents int
+	// Strategies are the proxy strategies which must each be able to pick
+	// an agent, e.g. defaultRoute requires a default-route agent.
+	Strategies []ProxyStrategy
+	// DialWindow, if positive, makes the server unready while the dials of
+	// the last DialWindow all failed. Servers without any recent dial are
+	// not affected.
+	DialWindow time.Duration
+}
+
+// DefaultReadinessPolicy requires a connection to any proxy agent.
+var DefaultReadinessPolicy = ReadinessPolicy{MinAgents: 1}
+
+var _ ReadinessReporter = &policyReadiness{}
+
+// policyReadiness reports the readiness of a ProxyServer according to a
+// ReadinessPolicy.
+type policyReadiness struct {
+	server *ProxyServer
+	policy ReadinessPolicy
+	// managers are the backend managers of policy.Strategies.
+	managers []BackendManager
+	// now returns the current time; overridden in tests.
+	now func() time.Time
+
+	mu              sync.Mutex // protects the following
+	lastDialSuccess time.Time
+	lastDialFailure time.Time
+}
+
+// SetReadinessPolicy makes the Readiness of the server follow policy. It
+// fails if the policy requires a strategy the server does not use.
+func (s *ProxyServer) SetReadinessPolicy(policy ReadinessPolicy) error {
+	r := &policyReadiness{server: s, policy: policy, now: time.Now}
+	for _, ps := range policy.Strategies {
+		bm := s.backendManager(ps)
+		if bm == nil {
+			return fmt.Errorf("readiness requires the %s proxy strategy, which is not used", ps)
+		}
+		r.managers = append(r.managers, bm)
+	}
+	s.readiness = r
+	s.Readiness = r
+	return nil
+}
+
+// backendManager returns the backend manager of strategy ps, or nil if the
+// server does not use it.
+func (s *ProxyServer) backendManager(ps ProxyStrategy) BackendManager {
+	for _, bm := range s.BackendManagers {
+		var strategy ProxyStrategy
+		switch bm.(type) {
+		case *DefaultBackendManager:
+			strategy = ProxyStrategyDefault
+		case *DestHostBackendManager:
+			strategy = ProxyStrategyDestHost
+		case *DefaultRouteBackendManager:
+			strategy = ProxyStrategyDefaultRoute
+		}
+		if strategy == ps {
+			return bm
+		}
+	}
+	return nil
+}
+
+// observeDial records the outcome of a dial sent to an agent.
+func (r *policyReadiness) observeDial(success bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if success {
+		r.lastDialSuccess = r.now()
+	} else {
+		r.lastDialFailure = r.now()
+	}
+}
+
+func (r *policyReadiness) Ready() (bool, string) {
+	var msgs []string
+	for _, c := range r.Checks() {
+		if !c.Ready {
+			msgs = append(msgs, c.Message)
+		}
+	}
+	return len(msgs) == 0, strings.Join(msgs, "; ")
+}
+
+func (r *policyReadiness) Checks() []ReadinessCheck {
+	var checks []ReadinessCheck
+	if r.policy.MinAgents > 0 {
+		c := ReadinessCheck{Name: "agents", Ready: true}
+		if n := r.server.agentCount(); n == 0 {
+			c.Ready, c.Message = false, "no connection to any proxy agent"
+		} else if n < r.policy.MinAgents {
+			c.Ready, c.Message = false, fmt.Sprintf("%d proxy agents connected, at least %d required", n, r.policy.MinAgents)
+		}
+		checks = append(checks, c)
+	}
+	for i, ps := range r.policy.Strategies {
+		c := ReadinessCheck{Name: "strategy-" + string(ps), Ready: true}
+		if r.managers[i].NumBackends() == 0 {
+			c.Ready, c.Message = false, fmt.Sprintf("no proxy agent for the %s proxy strategy", ps)
+		}
+		checks = append(checks, c)
+	}
+	if r.policy.DialWindow > 0 {
+		c := ReadinessCheck{Name: "dials", Ready: true}
+		r.mu.Lock()
+		since := r.now().Add(-r.policy.DialWindow)
+		if r.lastDialFailure.After(since) && !r.lastDialSuccess.After(since) {
+			c.Ready, c.Message = false, fmt.Sprintf("all the dials of the last %v failed", r.policy.DialWindow)
+		}
+		r.mu.Unlock()
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+// ReadinessStatus details the readiness of a proxy server.
+type ReadinessStatus struct {
+	Ready  bool             `json:"ready"`
+	Checks []ReadinessCheck `json:"checks"`
+}
+
+// ReadinessStatus returns the readiness of the server, with the result of
+// every check if its Readiness is a ReadinessReporter.
+func (s *ProxyServer) ReadinessStatus() ReadinessStatus {
+	if r, ok := s.Readiness.(ReadinessReporter); ok {
+		status := ReadinessStatus{Ready: true, Checks: r.Checks()}
+		for _, c := range status.Checks {
+			status.Ready = status.Ready && c.Ready
+		}
+		return status
+	}
+	ready, msg := s.Readiness.Ready()
+	return ReadinessStatus{
+		Ready:  ready,
+		Checks: []ReadinessCheck{{Name: "readiness", Ready: ready, Message: msg}},
+	}
+}
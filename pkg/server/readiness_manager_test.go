@@ -0,0 +1,180 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"google.golang.org/grpc/metadata"
+
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
+	pkgagent "sigs.k8s.io/apiserver-network-proxy/pkg/agent"
+	agentmock "sigs.k8s.io/apiserver-network-proxy/proto/agent/mocks"
+	"sigs.k8s.io/apiserver-network-proxy/proto/header"
+)
+
+func connectTestAgent(ctrl *gomock.Controller, s *ProxyServer, agentID string, idType pkgagent.IdentifierType) {
+	conn := agentmock.NewMockAgentService_ConnectServer(ctrl)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(header.AgentID, agentID))
+	conn.EXPECT().Context().Return(ctx).AnyTimes()
+	for _, bm := range s.BackendManagers {
+		bm.AddBackend(agentID, idType, conn)
+	}
+	s.addAgentStream(newBackend(conn), agentID, s.members())
+}
+
+func TestReadinessMinAgents(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	s := NewProxyServer("server-a", []ProxyStrategy{ProxyStrategyDefault}, 1, &AgentTokenAuthenticationOptions{})
+	if err := s.SetReadinessPolicy(ReadinessPolicy{MinAgents: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if ready, msg := s.Readiness.Ready(); ready || msg != "no connection to any proxy agent" {
+		t.Errorf("expected not ready without agent, got %v %q", ready, msg)
+	}
+	connectTestAgent(ctrl, s, "agent1", pkgagent.UID)
+	if ready, msg := s.Readiness.Ready(); ready || msg != "1 proxy agents connected, at least 2 required" {
+		t.Errorf("expected not ready with one agent, got %v %q", ready, msg)
+	}
+	connectTestAgent(ctrl, s, "agent2", pkgagent.UID)
+	if ready, msg := s.Readiness.Ready(); !ready {
+		t.Errorf("expected ready with two agents, got %q", msg)
+	}
+}
+
+func TestReadinessMissedPings(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	s := NewProxyServer("server-a", []ProxyStrategy{ProxyStrategyDefault}, 1, &AgentTokenAuthenticationOptions{})
+	conn := agentmock.NewMockAgentService_ConnectServer(ctrl)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(header.AgentID, "agent1"))
+	conn.EXPECT().Context().Return(ctx).AnyTimes()
+	var pings []*client.Packet
+	conn.EXPECT().Send(gomock.Any()).DoAndReturn(func(pkt *client.Packet) error {
+		pings = append(pings, pkt)
+		return nil
+	}).AnyTimes()
+	backend := s.addBackend("agent1", conn)
+	h := s.newAgentHealth("agent1", conn, backend)
+	s.addAgentHealth(backend, h)
+	s.addAgentStream(backend, "agent1", s.members())
+	now := time.Unix(1000, 0)
+	h.now = func() time.Time { return now }
+	if ready, msg := s.Readiness.Ready(); !ready {
+		t.Errorf("expected ready with an agent, got %q", msg)
+	}
+
+	// The agent which misses its PINGs can't be selected anymore.
+	h.ping()
+	now = now.Add(2 * time.Second)
+	h.expire(time.Second)
+	if ready, msg := s.Readiness.Ready(); ready || msg != "no connection to any proxy agent" {
+		t.Errorf("expected not ready once the agent missed its PINGs, got %v %q", ready, msg)
+	}
+
+	h.ping()
+	h.pong(pings[1].GetPing().Id)
+	if ready, msg := s.Readiness.Ready(); !ready {
+		t.Errorf("expected ready once the agent answered again, got %q", msg)
+	}
+	h.stop()
+	s.removeAgentHealth(backend)
+	s.removeAgentStream(backend)
+}
+
+func TestReadinessStrategies(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	s := NewProxyServer("server-a", []ProxyStrategy{ProxyStrategyDestHost, ProxyStrategyDefaultRoute}, 1, &AgentTokenAuthenticationOptions{})
+	if err := s.SetReadinessPolicy(ReadinessPolicy{Strategies: []ProxyStrategy{ProxyStrategyDefault}}); err == nil {
+		t.Error("expected an error for a strategy which is not used")
+	}
+	if err := s.SetReadinessPolicy(ReadinessPolicy{MinAgents: 1, Strategies: []ProxyStrategy{ProxyStrategyDefaultRoute}}); err != nil {
+		t.Fatal(err)
+	}
+	connectTestAgent(ctrl, s, "agent1", pkgagent.Host)
+	if ready, msg := s.Readiness.Ready(); ready || msg != "no proxy agent for the defaultRoute proxy strategy" {
+		t.Errorf("expected not ready without default route agent, got %v %q", ready, msg)
+	}
+	connectTestAgent(ctrl, s, "agent2", pkgagent.DefaultRoute)
+	if ready, msg := s.Readiness.Ready(); !ready {
+		t.Errorf("expected ready with a default route agent, got %q", msg)
+	}
+}
+
+func TestReadinessDialWindow(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	s := NewProxyServer("server-a", []ProxyStrategy{ProxyStrategyDefault}, 1, &AgentTokenAuthenticationOptions{})
+	if err := s.SetReadinessPolicy(ReadinessPolicy{DialWindow: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1000, 0)
+	s.readiness.now = func() time.Time { return now }
+
+	// Ready until a dial fails.
+	if ready, msg := s.Readiness.Ready(); !ready {
+		t.Errorf("expected ready before any dial, got %q", msg)
+	}
+	s.observeDial(false)
+	if ready, msg := s.Readiness.Ready(); ready || msg != "all the dials of the last 1m0s failed" {
+		t.Errorf("expected not ready after a failed dial, got %v %q", ready, msg)
+	}
+	now = now.Add(10 * time.Second)
+	s.observeDial(true)
+	s.observeDial(false)
+	if ready, msg := s.Readiness.Ready(); !ready {
+		t.Errorf("expected ready after a successful dial, got %q", msg)
+	}
+	now = now.Add(2 * time.Minute)
+	if ready, msg := s.Readiness.Ready(); !ready {
+		t.Errorf("expected ready once the failures left the window, got %q", msg)
+	}
+}
+
+func TestReadinessStatus(t *testing.T) {
+	s := NewProxyServer("server-a", []ProxyStrategy{ProxyStrategyDefault}, 1, &AgentTokenAuthenticationOptions{})
+	if err := s.SetReadinessPolicy(ReadinessPolicy{MinAgents: 1, Strategies: []ProxyStrategy{ProxyStrategyDefault}}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(s.ReadinessStatus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"ready":false,"checks":[` +
+		`{"name":"agents","ready":false,"message":"no connection to any proxy agent"},` +
+		`{"name":"strategy-default","ready":false,"message":"no proxy agent for the default proxy strategy"}]}`
+	if string(data) != want {
+		t.Errorf("expected %s, got %s", want, data)
+	}
+
+	// A plain ReadinessManager is reported as a single check.
+	s.Readiness = s.BackendManagers[0]
+	status := s.ReadinessStatus()
+	if status.Ready || len(status.Checks) != 1 || status.Checks[0].Name != "readiness" {
+		t.Errorf("expected a single failing readiness check, got %+v", status)
+	}
+}
@@ -0,0 +1,289 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	commonmetrics "sigs.k8s.io/apiserver-network-proxy/konnectivity-client/pkg/common/metrics"
+	client "sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server/metrics"
+	"sigs.k8s.io/apiserver-network-proxy/proto/agent"
+)
+
+// DefaultAgentSendQueueSize is the number of packets queued per agent
+// stream if ProxyServer.AgentSendQueueSize is not set.
+const DefaultAgentSendQueueSize = 128
+
+// DefaultAgentSendTimeout is the time a DATA packet waits for room in a full
+// queue if ProxyServer.AgentSendTimeout is not set.
+const DefaultAgentSendTimeout = 5 * time.Second
+
+// errSendQueueStopped is returned by the sends to a stream whose connection
+// ended.
+var errSendQueueStopped = errors.New("send queue to agent is stopped")
+
+// errSendQueueFull is returned by the sends of DATA packets which waited for
+// room in a full queue for longer than the send timeout, and by the later
+// sends of DATA for the same connection. The caller is expected to close
+// the connection.
+var errSendQueueFull = errors.New("send queue to agent is full")
+
+// sendQueue is an agent stream whose sends are queued, and written to the
+// stream by a single goroutine. Control packets, e.g. DIAL_REQ and DIAL_CLS,
+// are written before the queued DATA packets, so that slow tunnels do not
+// delay the dials of the others. A CLOSE_REQ is only written before the DATA
+// packets of other connections, as it would otherwise truncate its own.
+//
+// Sends block while the queue is full, which pushes back on the frontend
+// reading the data. A DATA packet waits for at most the send timeout, after
+// which its connection is aborted, so that a slow agent does not hold up
+// the frontend loops for longer: the DATA packets of an aborted connection
+// are refused, or discarded if already queued, and its CLOSE_REQ is written
+// before them.
+type sendQueue struct {
+	agent.AgentService_ConnectServer
+
+	agentID string
+	timeout time.Duration
+	control chan *client.Packet
+	data    chan *client.Packet
+
+	// mu protects err, queuedData and aborted.
+	mu sync.Mutex
+	// err is the error of the stream, once the writer stopped.
+	err error
+	// queuedData is the number of queued DATA packets, by connection.
+	queuedData map[int64]int
+	// aborted are the connections whose DATA timed out, and whether their
+	// CLOSE_REQ was queued. They are forgotten once both their CLOSE_REQ
+	// was queued and their DATA packets were dequeued.
+	aborted map[int64]bool
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	// doneCh is closed when the writer stopped.
+	doneCh chan struct{}
+}
+
+var _ agent.AgentService_ConnectServer = &sendQueue{}
+
+// newSendQueue returns the send queue of stream, holding up to size packets
+// of each priority, and starts its writer. DATA packets wait for at most
+// timeout for room in the queue, or indefinitely if it is not positive.
+func newSendQueue(stream agent.AgentService_ConnectServer, agentID string, size int, timeout time.Duration) *sendQueue {
+	if size <= 0 {
+		size = DefaultAgentSendQueueSize
+	}
+	q := &sendQueue{
+		AgentService_ConnectServer: stream,
+		agentID:                    agentID,
+		timeout:                    timeout,
+		control:                    make(chan *client.Packet, size),
+		data:                       make(chan *client.Packet, size),
+		queuedData:                 make(map[int64]int),
+		aborted:                    make(map[int64]bool),
+		stopCh:                     make(chan struct{}),
+		doneCh:                     make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// queueOf returns the queue of pkt, and its name.
+func (q *sendQueue) queueOf(pkt *client.Packet) (chan *client.Packet, metrics.SendQueue) {
+	switch pkt.Type {
+	case client.PacketType_DATA:
+		return q.data, metrics.SendQueueData
+	case client.PacketType_CLOSE_REQ:
+		connID := pkt.GetCloseRequest().ConnectID
+		if _, ok := q.aborted[connID]; ok {
+			// The queued DATA packets are discarded.
+			if q.queuedData[connID] > 0 {
+				q.aborted[connID] = true
+			} else {
+				delete(q.aborted, connID)
+			}
+		} else if q.queuedData[connID] > 0 {
+			return q.data, metrics.SendQueueData
+		}
+	}
+	return q.control, metrics.SendQueueControl
+}
+
+// Send queues pkt to be sent to the agent. It blocks while the queue is
+// full, for at most the send timeout for DATA packets, and fails once the
+// writer stopped.
+func (q *sendQueue) Send(pkt *client.Packet) error {
+	q.mu.Lock()
+	if q.err != nil {
+		q.mu.Unlock()
+		return q.err
+	}
+	isData := pkt.Type == client.PacketType_DATA
+	if isData {
+		if _, ok := q.aborted[pkt.GetData().ConnectID]; ok {
+			q.mu.Unlock()
+			return errSendQueueFull
+		}
+	}
+	ch, name := q.queueOf(pkt)
+	if isData {
+		q.queuedData[pkt.GetData().ConnectID]++
+	}
+	metrics.Metrics.SendQueueInc(name)
+	select {
+	case ch <- pkt:
+		q.mu.Unlock()
+		return nil
+	default:
+	}
+	q.mu.Unlock()
+
+	klog.V(4).InfoS("Send queue to agent is full", "agentID", q.agentID, "queue", name)
+	metrics.Metrics.SendQueueFull(name)
+	var timeout <-chan time.Time
+	if isData && q.timeout > 0 {
+		timer := time.NewTimer(q.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case ch <- pkt:
+		// The writer may have stopped in the meantime, and left pkt in the
+		// queue.
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if q.err != nil {
+			q.drainLocked()
+			return q.err
+		}
+		return nil
+	case <-q.doneCh:
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		metrics.Metrics.SendQueueDec(name)
+		if isData {
+			q.dequeueDataLocked(pkt)
+		}
+		return q.err
+	case <-timeout:
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		metrics.Metrics.SendQueueDec(name)
+		connID := pkt.GetData().ConnectID
+		q.dequeueDataLocked(pkt)
+		q.aborted[connID] = false
+		klog.V(2).InfoS("Timed out queueing DATA to agent, aborting the connection", "agentID", q.agentID, "connectionID", connID, "sendTimeout", q.timeout)
+		return errSendQueueFull
+	}
+}
+
+// stop stops the writer once its current write returns, which may only be
+// when the stream ends. The queued packets are discarded.
+func (q *sendQueue) stop() {
+	q.stopOnce.Do(func() { close(q.stopCh) })
+}
+
+func (q *sendQueue) run() {
+	err := q.write()
+	q.mu.Lock()
+	q.err = err
+	discarded := q.drainLocked()
+	q.mu.Unlock()
+	close(q.doneCh)
+	if discarded > 0 {
+		klog.V(2).InfoS("Discard packets queued to agent", "pktCount", discarded, "agentID", q.agentID, "err", err)
+	}
+}
+
+// write writes the queued packets to the stream until the queue is stopped
+// or a write fails.
+func (q *sendQueue) write() error {
+	for {
+		var pkt *client.Packet
+		name := metrics.SendQueueControl
+		select {
+		case pkt = <-q.control:
+		default:
+			select {
+			case pkt = <-q.control:
+			case pkt = <-q.data:
+				name = metrics.SendQueueData
+			case <-q.stopCh:
+				return errSendQueueStopped
+			}
+		}
+		if err := q.writePacket(pkt, name); err != nil {
+			return err
+		}
+	}
+}
+
+func (q *sendQueue) writePacket(pkt *client.Packet, name metrics.SendQueue) error {
+	metrics.Metrics.SendQueueDec(name)
+	if pkt.Type == client.PacketType_DATA {
+		q.mu.Lock()
+		_, aborted := q.aborted[pkt.GetData().ConnectID]
+		q.dequeueDataLocked(pkt)
+		q.mu.Unlock()
+		if aborted {
+			return nil
+		}
+	}
+
+	err := q.AgentService_ConnectServer.Send(pkt)
+	if err != nil && err != io.EOF {
+		metrics.Metrics.ObserveStreamError(commonmetrics.SegmentToAgent, err, pkt.Type)
+	}
+	return err
+}
+
+func (q *sendQueue) dequeueDataLocked(pkt *client.Packet) {
+	connID := pkt.GetData().ConnectID
+	if q.queuedData[connID]--; q.queuedData[connID] <= 0 {
+		delete(q.queuedData, connID)
+		if q.aborted[connID] {
+			delete(q.aborted, connID)
+		}
+	}
+}
+
+// drainLocked discards the queued packets, and returns their number.
+func (q *sendQueue) drainLocked() int {
+	discarded := 0
+	for {
+		select {
+		case <-q.control:
+			metrics.Metrics.SendQueueDec(metrics.SendQueueControl)
+			discarded++
+		case pkt := <-q.data:
+			if pkt.Type == client.PacketType_DATA {
+				q.dequeueDataLocked(pkt)
+			}
+			metrics.Metrics.SendQueueDec(metrics.SendQueueData)
+			discarded++
+		default:
+			return discarded
+		}
+	}
+}
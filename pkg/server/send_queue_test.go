@@ -0,0 +1,265 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server/metrics"
+	metricstest "sigs.k8s.io/apiserver-network-proxy/pkg/testing/metrics"
+	"sigs.k8s.io/apiserver-network-proxy/proto/agent"
+)
+
+// blockingStream records the packets sent to it. Each send waits for
+// release, if set, once it is signalled on entered.
+type blockingStream struct {
+	agent.AgentService_ConnectServer
+
+	entered chan struct{}
+	release chan struct{}
+	err     error
+
+	mu   sync.Mutex
+	sent []string
+}
+
+func newBlockingStream() *blockingStream {
+	return &blockingStream{entered: make(chan struct{}, 1), release: make(chan struct{})}
+}
+
+func (s *blockingStream) Send(pkt *client.Packet) error {
+	if s.release != nil {
+		select {
+		case s.entered <- struct{}{}:
+		default:
+		}
+		<-s.release
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, describePacket(pkt))
+	return s.err
+}
+
+func (s *blockingStream) packets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.sent...)
+}
+
+func describePacket(pkt *client.Packet) string {
+	switch pkt.Type {
+	case client.PacketType_DATA:
+		return "DATA " + string(pkt.GetData().Data)
+	case client.PacketType_CLOSE_REQ:
+		return fmt.Sprintf("CLOSE_REQ %d", pkt.GetCloseRequest().ConnectID)
+	}
+	return pkt.Type.String()
+}
+
+func dataPacket(connID int64, data string) *client.Packet {
+	return &client.Packet{
+		Type:    client.PacketType_DATA,
+		Payload: &client.Packet_Data{Data: &client.Data{ConnectID: connID, Data: []byte(data)}},
+	}
+}
+
+func closeRequestPacket(connID int64) *client.Packet {
+	return &client.Packet{
+		Type:    client.PacketType_CLOSE_REQ,
+		Payload: &client.Packet_CloseRequest{CloseRequest: &client.CloseRequest{ConnectID: connID}},
+	}
+}
+
+func waitForPackets(t *testing.T, stream *blockingStream, count int) []string {
+	t.Helper()
+	deadline := time.Now().Add(wait.ForeverTestTimeout)
+	for len(stream.packets()) < count {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d packets, got %v", count, stream.packets())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return stream.packets()
+}
+
+func expectSendQueuePackets(t *testing.T, queued map[metrics.SendQueue]int) {
+	t.Helper()
+	expected := `
+# HELP konnectivity_network_proxy_server_agent_send_queue_packets Number of packets queued to be sent to the agents, partitioned by queue (control or data).
+# TYPE konnectivity_network_proxy_server_agent_send_queue_packets gauge
+`
+	for _, queue := range []metrics.SendQueue{metrics.SendQueueControl, metrics.SendQueueData} {
+		if count, ok := queued[queue]; ok {
+			expected += fmt.Sprintf("konnectivity_network_proxy_server_agent_send_queue_packets{queue=%q} %d\n", queue, count)
+		}
+	}
+	if err := metricstest.ExpectMetric(metrics.Namespace, metrics.Subsystem, "agent_send_queue_packets", expected); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSendQueuePriority(t *testing.T) {
+	metrics.Metrics.Reset()
+	stream := newBlockingStream()
+	q := newSendQueue(stream, "agent1", 10, 0)
+	defer q.stop()
+
+	// The writer blocks on the first packet, while the others are queued.
+	if err := q.Send(dataPacket(1, "a")); err != nil {
+		t.Fatal(err)
+	}
+	<-stream.entered
+	for _, pkt := range []*client.Packet{
+		dataPacket(1, "b"),
+		dataPacket(2, "c"),
+		closeRequestPacket(1),
+		closeRequestPacket(3),
+		{Type: client.PacketType_DIAL_REQ},
+	} {
+		if err := q.Send(pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectSendQueuePackets(t, map[metrics.SendQueue]int{metrics.SendQueueControl: 2, metrics.SendQueueData: 3})
+	close(stream.release)
+
+	got := waitForPackets(t, stream, 6)
+	want := []string{
+		"DATA a",
+		// Control packets overtake the queued DATA packets...
+		"CLOSE_REQ 3",
+		"DIAL_REQ",
+		"DATA b",
+		"DATA c",
+		// ...but not those of their connection.
+		"CLOSE_REQ 1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	expectSendQueuePackets(t, map[metrics.SendQueue]int{metrics.SendQueueControl: 0, metrics.SendQueueData: 0})
+}
+
+func TestSendQueueFull(t *testing.T) {
+	metrics.Metrics.Reset()
+	stream := newBlockingStream()
+	q := newSendQueue(stream, "agent1", 1, 0)
+
+	// The writer blocks on the first packet, and the second fills the queue.
+	if err := q.Send(dataPacket(1, "a")); err != nil {
+		t.Fatal(err)
+	}
+	<-stream.entered
+	if err := q.Send(dataPacket(1, "b")); err != nil {
+		t.Fatal(err)
+	}
+	sent := make(chan error)
+	go func() { sent <- q.Send(dataPacket(1, "c")) }()
+	select {
+	case err := <-sent:
+		t.Fatalf("expected the send to wait for the full queue, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The waiting send fails once the queue is stopped.
+	q.stop()
+	close(stream.release)
+	if err := <-sent; err == nil {
+		t.Error("expected the send to fail after the queue stopped")
+	}
+	if err := q.Send(dataPacket(1, "d")); err == nil {
+		t.Error("expected the send to fail after the queue stopped")
+	}
+	expectSendQueuePackets(t, map[metrics.SendQueue]int{metrics.SendQueueData: 0})
+}
+
+func TestSendQueueTimeout(t *testing.T) {
+	metrics.Metrics.Reset()
+	stream := newBlockingStream()
+	q := newSendQueue(stream, "agent1", 1, 50*time.Millisecond)
+	defer q.stop()
+
+	// The writer blocks on the first packet, and the second fills the queue.
+	if err := q.Send(dataPacket(1, "a")); err != nil {
+		t.Fatal(err)
+	}
+	<-stream.entered
+	if err := q.Send(dataPacket(1, "b")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The DATA waiting for the full queue times out, and aborts its
+	// connection...
+	if err := q.Send(dataPacket(1, "c")); err != errSendQueueFull {
+		t.Fatalf("expected %v, got %v", errSendQueueFull, err)
+	}
+	// ...whose later DATA are refused at once...
+	sent := make(chan error)
+	go func() { sent <- q.Send(dataPacket(1, "d")) }()
+	select {
+	case err := <-sent:
+		if err != errSendQueueFull {
+			t.Errorf("expected %v, got %v", errSendQueueFull, err)
+		}
+	case <-time.After(25 * time.Millisecond):
+		t.Fatal("expected the DATA of the aborted connection to be refused at once")
+	}
+	// ...and whose CLOSE_REQ overtakes the DATA, which are discarded.
+	if err := q.Send(closeRequestPacket(1)); err != nil {
+		t.Fatal(err)
+	}
+	close(stream.release)
+
+	got := waitForPackets(t, stream, 2)
+	if want := []string{"DATA a", "CLOSE_REQ 1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	err := wait.PollImmediate(time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return len(q.aborted) == 0 && len(q.queuedData) == 0, nil
+	})
+	if err != nil {
+		t.Error("expected the aborted connection to be forgotten")
+	}
+	expectSendQueuePackets(t, map[metrics.SendQueue]int{metrics.SendQueueControl: 0, metrics.SendQueueData: 0})
+}
+
+func TestSendQueueStreamError(t *testing.T) {
+	metrics.Metrics.Reset()
+	streamErr := errors.New("stream reset")
+	stream := &blockingStream{err: streamErr}
+	q := newSendQueue(stream, "agent1", 10, 0)
+	defer q.stop()
+
+	if err := q.Send(&client.Packet{Type: client.PacketType_DIAL_REQ}); err != nil {
+		t.Fatal(err)
+	}
+	<-q.doneCh
+	if err := q.Send(&client.Packet{Type: client.PacketType_DIAL_REQ}); err != streamErr {
+		t.Errorf("expected %v, got %v", streamErr, err)
+	}
+}
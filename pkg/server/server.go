@@ -17,21 +17,22 @@ limitations under the License.
 package server
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	runpprof "runtime/pprof"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 	authv1 "k8s.io/api/authentication/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -39,12 +40,16 @@ import (
 	"k8s.io/klog/v2"
 
 	commonmetrics "sigs.k8s.io/apiserver-network-proxy/konnectivity-client/pkg/common/metrics"
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/pkg/common/tracing"
 	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
 	pkgagent "sigs.k8s.io/apiserver-network-proxy/pkg/agent"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server/audit"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server/membership"
 	"sigs.k8s.io/apiserver-network-proxy/pkg/server/metrics"
 	"sigs.k8s.io/apiserver-network-proxy/pkg/util"
 	"sigs.k8s.io/apiserver-network-proxy/proto/agent"
 	"sigs.k8s.io/apiserver-network-proxy/proto/header"
+	peerproto "sigs.k8s.io/apiserver-network-proxy/proto/peer"
 )
 
 const xfrChannelSize = 10
@@ -54,8 +59,14 @@ type key int
 type GrpcFrontend struct {
 	stream    client.ProxyService_ProxyServer
 	streamUID string
+	identity  string // common name of the client certificate, if any
+	userAgent string
 	sendLock  sync.Mutex
 	recvLock  sync.Mutex
+
+	// forwardedBy is the ID of the peer proxy server which forwarded the
+	// stream, if any. Forwarded streams are never forwarded again.
+	forwardedBy string
 }
 
 func (g *GrpcFrontend) Send(pkt *client.Packet) error {
@@ -64,6 +75,7 @@ func (g *GrpcFrontend) Send(pkt *client.Packet) error {
 
 	const segment = commonmetrics.SegmentToClient
 	metrics.Metrics.ObservePacket(segment, pkt.Type)
+	metrics.Metrics.ObserveDataBytes(segment, pkt)
 	err := g.stream.Send(pkt)
 	if err != nil {
 		metrics.Metrics.ObserveStreamError(segment, err, pkt.Type)
@@ -84,21 +96,46 @@ func (g *GrpcFrontend) Recv() (*client.Packet, error) {
 		return nil, err
 	}
 	metrics.Metrics.ObservePacket(segment, pkt.Type)
+	metrics.Metrics.ObserveDataBytes(segment, pkt)
 	return pkt, nil
 }
 
 type ProxyClientConnection struct {
-	Mode        string
-	HTTP        io.ReadWriter
-	frontend    *GrpcFrontend
-	CloseHTTP   func() error
-	connected   chan struct{}
-	dialID      int64
-	connectID   int64
+	Mode      string
+	HTTP      io.ReadWriter
+	frontend  *GrpcFrontend
+	CloseHTTP func() error
+	connected chan struct{}
+	dialID    int64
+	connectID int64
+	// id is issued by the server once the connection is established, and
+	// is unique among the connections of the server.
+	id          uint64
 	agentID     string
 	start       time.Time
 	backend     Backend
 	dialAddress string // cached for logging
+	// dialError is the error of a failed dial in the http-connect and
+	// socks5 modes, set before CloseHTTP is called.
+	dialError     string
+	dialErrorCode client.DialErrorCode
+
+	// The following are tracked for the metrics and audit record of the
+	// connection when it is torn down.
+	clientIdentity string
+	userAgent      string
+	dialDuration   time.Duration
+	bytesToAgent   atomic.Int64 // DATA payload bytes from the frontend
+	bytesFromAgent atomic.Int64 // DATA payload bytes to the frontend
+	finishOnce     sync.Once
+
+	// expiry closes the established connection once it is idle or too old,
+	// if the server limits them. It is set once the connection is
+	// established, while the frontend may already be sending DATA.
+	expiry atomic.Pointer[util.ExpiryTimer]
+
+	span     *tracing.Span // lifetime of the connection
+	dialSpan *tracing.Span // dial by the agent
 }
 
 const (
@@ -107,11 +144,16 @@ const (
 
 func (c *ProxyClientConnection) send(pkt *client.Packet) error {
 	start := time.Now()
-	defer metrics.Metrics.ObserveFrontendWriteLatency(time.Since(start))
+	defer func() { metrics.Metrics.ObserveFrontendWriteLatency(time.Since(start)) }()
+	if pkt.Type == client.PacketType_DATA {
+		c.bytesFromAgent.Add(int64(len(pkt.GetData().Data)))
+		c.expiry.Load().Touch()
+	}
 	if c.Mode == "grpc" {
 		return c.frontend.Send(pkt)
 	}
-	if c.Mode == "http-connect" {
+	if c.Mode == "http-connect" || c.Mode == "socks5" {
+		metrics.Metrics.ObserveDataBytes(commonmetrics.SegmentToClient, pkt)
 		if pkt.Type == client.PacketType_CLOSE_RSP {
 			return c.CloseHTTP()
 		} else if pkt.Type == client.PacketType_DIAL_CLS {
@@ -121,13 +163,10 @@ func (c *ProxyClientConnection) send(pkt *client.Packet) error {
 			return err
 		} else if pkt.Type == client.PacketType_DIAL_RSP {
 			if pkt.GetDialResponse().Error != "" {
-				body := bytes.NewBufferString(pkt.GetDialResponse().Error)
-				t := http.Response{
-					StatusCode: 503,
-					Body:       io.NopCloser(body),
-				}
-
-				t.Write(c.HTTP)
+				// The frontend answers its client with the error
+				// once the connection is closed.
+				c.dialError = pkt.GetDialResponse().Error
+				c.dialErrorCode = pkt.GetDialResponse().ErrorCode
 				return c.CloseHTTP()
 			}
 			return nil
@@ -194,25 +233,95 @@ type ProxyServer struct {
 
 	// Readiness reports if the proxy server is ready, i.e., if the proxy
 	// server has connections to proxy agents (backends). Note that the
-	// proxy server does not check the healthiness of the connections,
-	// though the proxy agents do, so this readiness check might report
-	// ready but there is no healthy connection.
+	// proxy server only checks the healthiness of the connections if
+	// AgentPingInterval is set, otherwise this readiness check might
+	// report ready but there is no healthy connection.
 	Readiness ReadinessManager
+	// readiness is the policy-based Readiness, which is told about the
+	// outcome of the dials.
+	readiness *policyReadiness
 
-	// fmu protects frontends.
+	// fmu protects frontends and their indexes.
 	fmu sync.RWMutex
-	// conn = Frontend[agentID][connID]
-	frontends map[string]map[int64]*ProxyClientConnection
+	// lastConnectionID is the last ID issued to an established connection.
+	lastConnectionID uint64
+	// frontends are the established connections, by server-issued ID.
+	frontends map[uint64]*ProxyClientConnection
+	// frontendsByBackend indexes the IDs of the connections by agent
+	// stream, see agentStream, and by the connectID assigned by the agent,
+	// which is only unique within its stream.
+	frontendsByBackend map[Backend]map[int64]uint64
+	// frontendsByStream indexes the IDs of the connections of the grpc mode
+	// by the UID of their frontend stream.
+	frontendsByStream map[string]map[uint64]struct{}
 
 	PendingDial *PendingDialManager
 
-	serverID    string // unique ID of this server
-	serverCount int    // Number of proxy server instances, should be 1 unless it is a HA server.
+	serverID string // unique ID of this server
+
+	// mmu protects membership.
+	mmu sync.RWMutex
+	// membership reports the live proxy server instances.
+	membership membership.Membership
+
+	// amu protects agentStreams and agentHealths.
+	amu sync.Mutex
+	// agentStreams are the backends of the connected agent streams, by
+	// which they are told about the live proxy servers.
+	agentStreams map[Backend]string
+	// agentHealths track whether the agent streams answer the PINGs, and
+	// drain them.
+	agentHealths map[Backend]*agentHealth
+	// drainGrace is how long a new stream of an agent must stay connected
+	// for its older streams to be drained.
+	drainGrace time.Duration
+
+	// imu protects identifiers.
+	imu sync.Mutex
+	// identifiers are the current identifiers of the agent streams, by
+	// which they are stored in the BackendManagers.
+	identifiers map[agent.AgentService_ConnectServer]*streamIdentifiers
+
+	// AgentPingInterval, if positive, is the interval at which PINGs are
+	// sent to the agents. An agent stream which does not answer a PING
+	// within AgentPingTimeout is removed from the backend selection until
+	// it answers again.
+	AgentPingInterval time.Duration
+	AgentPingTimeout  time.Duration
+
+	// AgentSendQueueSize is the number of packets of each priority queued
+	// to an agent stream, before the senders wait. Defaults to
+	// DefaultAgentSendQueueSize.
+	AgentSendQueueSize int
+	// AgentSendTimeout, if positive, is the time a DATA packet waits for
+	// room in the full send queue of an agent stream, after which its
+	// connection is closed. Otherwise DATA packets wait indefinitely.
+	AgentSendTimeout time.Duration
+
+	// ConnectionIdleTimeout and ConnectionMaxLifetime, if positive, limit
+	// the established connections: a connection with no DATA in either
+	// direction for ConnectionIdleTimeout, or open for
+	// ConnectionMaxLifetime, is closed on both sides.
+	ConnectionIdleTimeout time.Duration
+	ConnectionMaxLifetime time.Duration
 
 	// agent authentication
 	AgentAuthenticationOptions *AgentTokenAuthenticationOptions
 
 	proxyStrategies []ProxyStrategy
+
+	// AuditSink, if set, receives a record for every tunneled connection
+	// once it is torn down. It is written from the packet routing
+	// goroutines, so it should rarely block, see audit.AsyncSink.
+	AuditSink audit.Sink
+
+	// Tracer records the spans of the tunneled connections. Spans are not
+	// recorded if nil.
+	Tracer *tracing.Tracer
+
+	// Peers, if set, are the peer proxy servers to which the dials without
+	// a matching local agent are forwarded.
+	Peers *Peers
 }
 
 // AgentTokenAuthenticationOptions contains list of parameters required for agent token based authentication
@@ -228,6 +337,8 @@ var _ agent.AgentServiceServer = &ProxyServer{}
 
 var _ client.ProxyServiceServer = &ProxyServer{}
 
+var _ peerproto.PeerServiceServer = &ProxyServer{}
+
 func genContext(proxyStrategies []ProxyStrategy, reqHost string) context.Context {
 	ctx := context.Background()
 	for _, ps := range proxyStrategies {
@@ -257,154 +368,209 @@ func (s *ProxyServer) getBackend(reqHost string) (Backend, error) {
 }
 
 func (s *ProxyServer) addBackend(agentID string, conn agent.AgentService_ConnectServer) (backend Backend) {
-	for i := 0; i < len(s.BackendManagers); i++ {
-		switch s.BackendManagers[i].(type) {
-		case *DestHostBackendManager:
-			agentIdentifiers, err := getAgentIdentifiers(conn)
-			if err != nil {
-				klog.ErrorS(err, "fail to get the agent identifiers", "agentID", agentID)
-				break
-			}
-			for _, ipv4 := range agentIdentifiers.IPv4 {
-				klog.V(5).InfoS("Add the agent to DestHostBackendManager", "agent address", ipv4)
-				s.BackendManagers[i].AddBackend(ipv4, pkgagent.IPv4, conn)
-			}
-			for _, ipv6 := range agentIdentifiers.IPv6 {
-				klog.V(5).InfoS("Add the agent to DestHostBackendManager", "agent address", ipv6)
-				s.BackendManagers[i].AddBackend(ipv6, pkgagent.IPv6, conn)
-			}
-			for _, host := range agentIdentifiers.Host {
-				klog.V(5).InfoS("Add the agent to DestHostBackendManager", "agent address", host)
-				s.BackendManagers[i].AddBackend(host, pkgagent.Host, conn)
-			}
-		case *DefaultRouteBackendManager:
-			agentIdentifiers, err := getAgentIdentifiers(conn)
-			if err != nil {
-				klog.ErrorS(err, "fail to get the agent identifiers", "agentID", agentID)
-				break
-			}
-			if agentIdentifiers.DefaultRoute {
-				klog.V(5).InfoS("Add the agent to DefaultRouteBackendManager", "agentID", agentID)
-				backend = s.BackendManagers[i].AddBackend(agentID, pkgagent.DefaultRoute, conn)
+	s.imu.Lock()
+	defer s.imu.Unlock()
+	si := s.streamIdentifiersLocked(agentID, conn)
+	si.stored = true
+	for _, bm := range s.BackendManagers {
+		for _, bid := range backendIdentifiers(bm, agentID, si.ids) {
+			klog.V(5).InfoS("Add the agent to the backend manager", "agentID", agentID, "identifier", bid.ID, "identifierType", bid.Type)
+			be := bm.AddBackend(bid.ID, bid.Type, conn)
+			if bid.Type == pkgagent.UID || bid.Type == pkgagent.DefaultRoute {
+				backend = be
 			}
-		default:
-			klog.V(5).InfoS("Add the agent to DefaultBackendManager", "agentID", agentID)
-			backend = s.BackendManagers[i].AddBackend(agentID, pkgagent.UID, conn)
 		}
 	}
 	return
 }
 
 func (s *ProxyServer) removeBackend(agentID string, conn agent.AgentService_ConnectServer) {
+	s.imu.Lock()
+	defer s.imu.Unlock()
+	si := s.streamIdentifiersLocked(agentID, conn)
+	si.stored = false
 	for _, bm := range s.BackendManagers {
-		switch bm.(type) {
-		case *DestHostBackendManager:
-			agentIdentifiers, err := getAgentIdentifiers(conn)
-			if err != nil {
-				klog.ErrorS(err, "fail to get the agent identifiers", "agentID", agentID)
-				break
-			}
-			for _, ipv4 := range agentIdentifiers.IPv4 {
-				klog.V(5).InfoS("Remove the agent from the DestHostBackendManager", "agentHost", ipv4)
-				bm.RemoveBackend(ipv4, pkgagent.IPv4, conn)
-			}
-			for _, ipv6 := range agentIdentifiers.IPv6 {
-				klog.V(5).InfoS("Remove the agent from the DestHostBackendManager", "agentHost", ipv6)
-				bm.RemoveBackend(ipv6, pkgagent.IPv6, conn)
-			}
-			for _, host := range agentIdentifiers.Host {
-				klog.V(5).InfoS("Remove the agent from the DestHostBackendManager", "agentHost", host)
-				bm.RemoveBackend(host, pkgagent.Host, conn)
-			}
-		case *DefaultRouteBackendManager:
-			agentIdentifiers, err := getAgentIdentifiers(conn)
-			if err != nil {
-				klog.ErrorS(err, "fail to get the agent identifiers", "agentID", agentID)
-				break
-			}
-			if agentIdentifiers.DefaultRoute {
-				klog.V(5).InfoS("Remove the agent from the DefaultRouteBackendManager", "agentID", agentID)
-				bm.RemoveBackend(agentID, pkgagent.DefaultRoute, conn)
-			}
-		default:
-			klog.V(5).InfoS("Remove the agent from the DefaultBackendManager", "agentID", agentID)
-			bm.RemoveBackend(agentID, pkgagent.UID, conn)
+		for _, bid := range backendIdentifiers(bm, agentID, si.ids) {
+			klog.V(5).InfoS("Remove the agent from the backend manager", "agentID", agentID, "identifier", bid.ID, "identifierType", bid.Type)
+			bm.RemoveBackend(bid.ID, bid.Type, conn)
 		}
 	}
 }
 
-func (s *ProxyServer) addFrontend(agentID string, connID int64, p *ProxyClientConnection) {
+// agentStream returns the agent stream of backend, which identifies it
+// across the backend managers, each of which wraps the stream in a backend
+// of its own.
+func agentStream(b Backend) Backend {
+	if be, ok := b.(*backend); ok && be.conn != nil {
+		return be.conn
+	}
+	return b
+}
+
+// addFrontend adds the established connection c, and issues its ID.
+func (s *ProxyServer) addFrontend(c *ProxyClientConnection) {
+	if replaced := s.indexFrontend(c); replaced != nil {
+		// The agent closes nothing: the connection ID now belongs to c.
+		klog.V(2).InfoS("Closing connection", "reason", "connection ID reused",
+			"serverConnectionID", replaced.id, "agentID", replaced.agentID, "connectionID", replaced.connectID, "dialAddress", replaced.dialAddress)
+		s.closeFrontendSide(replaced, audit.CloseReasonReplaced, "connection ID reused by the agent")
+	}
+}
+
+// indexFrontend issues the ID of c and indexes it, and returns the connection
+// it replaced, if any.
+func (s *ProxyServer) indexFrontend(c *ProxyClientConnection) *ProxyClientConnection {
 	s.fmu.Lock()
 	defer s.fmu.Unlock()
-	if _, ok := s.frontends[agentID]; !ok {
-		s.frontends[agentID] = make(map[int64]*ProxyClientConnection)
+	s.lastConnectionID++
+	c.id = s.lastConnectionID
+	s.frontends[c.id] = c
+
+	stream := agentStream(c.backend)
+	conns, ok := s.frontendsByBackend[stream]
+	if !ok {
+		conns = make(map[int64]uint64)
+		s.frontendsByBackend[stream] = conns
 	}
-	s.frontends[agentID][connID] = p
+	var replaced *ProxyClientConnection
+	if id, ok := conns[c.connectID]; ok {
+		klog.V(1).InfoS("This should not happen. Replacing the connection of the same agent stream and connection ID", "agentID", c.agentID, "connectionID", c.connectID, "serverConnectionID", id)
+		replaced = s.frontends[id]
+		s.removeFrontendLocked(replaced)
+		s.frontendsByBackend[stream] = conns
+	}
+	conns[c.connectID] = c.id
+	c.expiry.Store(util.NewExpiryTimer(s.ConnectionIdleTimeout, s.ConnectionMaxLifetime, func(reason util.ExpiryReason) {
+		s.expireFrontend(c, reason)
+	}))
+
+	if c.frontend != nil && c.frontend.streamUID != "" {
+		ids, ok := s.frontendsByStream[c.frontend.streamUID]
+		if !ok {
+			ids = make(map[uint64]struct{})
+			s.frontendsByStream[c.frontend.streamUID] = ids
+		}
+		ids[c.id] = struct{}{}
+	}
+
+	metrics.Metrics.SetEstablishedConnCount(len(s.frontends))
+	return replaced
+}
 
-	metrics.Metrics.SetEstablishedConnCount(s.getCount(s.frontends))
+// removeFrontendLocked removes c and its indexes. s.fmu must be held.
+func (s *ProxyServer) removeFrontendLocked(c *ProxyClientConnection) {
+	delete(s.frontends, c.id)
+	c.expiry.Load().Stop()
+	stream := agentStream(c.backend)
+	if conns := s.frontendsByBackend[stream]; conns[c.connectID] == c.id {
+		delete(conns, c.connectID)
+		if len(conns) == 0 {
+			delete(s.frontendsByBackend, stream)
+		}
+	}
+	if c.frontend != nil {
+		if ids, ok := s.frontendsByStream[c.frontend.streamUID]; ok {
+			delete(ids, c.id)
+			if len(ids) == 0 {
+				delete(s.frontendsByStream, c.frontend.streamUID)
+			}
+		}
+	}
 }
 
-func (s *ProxyServer) removeFrontend(agentID string, connID int64) *ProxyClientConnection {
-	var ret *ProxyClientConnection
+// removeFrontend removes and returns the connection connectID of the agent
+// stream of backend, if any.
+func (s *ProxyServer) removeFrontend(backend Backend, connectID int64) *ProxyClientConnection {
 	s.fmu.Lock()
 	defer s.fmu.Unlock()
-	conns, ok := s.frontends[agentID]
+	id, ok := s.frontendsByBackend[agentStream(backend)][connectID]
 	if !ok {
 		return nil
 	}
-	if ret, ok = conns[connID]; !ok {
-		return nil
-	}
-	delete(s.frontends[agentID], connID)
-	if len(s.frontends[agentID]) == 0 {
-		delete(s.frontends, agentID)
-	}
-	metrics.Metrics.SetEstablishedConnCount(s.getCount(s.frontends))
-	return ret
+	c := s.frontends[id]
+	s.removeFrontendLocked(c)
+	metrics.Metrics.SetEstablishedConnCount(len(s.frontends))
+	return c
 }
 
-func (s *ProxyServer) getFrontend(agentID string, connID int64) (*ProxyClientConnection, error) {
+// getFrontend returns the connection connectID of the agent stream of
+// backend.
+func (s *ProxyServer) getFrontend(backend Backend, connectID int64) (*ProxyClientConnection, error) {
 	s.fmu.RLock()
 	defer s.fmu.RUnlock()
-	conns, ok := s.frontends[agentID]
+	id, ok := s.frontendsByBackend[agentStream(backend)][connectID]
 	if !ok {
-		return nil, fmt.Errorf("can't find agentID %s in the frontends", agentID)
+		return nil, fmt.Errorf("can't find connID %d in the frontends of the agent stream", connectID)
 	}
-	conn, ok := conns[connID]
-	if !ok {
-		return nil, fmt.Errorf("can't find connID %d in the frontends[%s]", connID, agentID)
+	return s.frontends[id], nil
+}
+
+// expireFrontend closes the connection c, which is idle or too old, on both
+// sides, unless it was already removed.
+func (s *ProxyServer) expireFrontend(c *ProxyClientConnection, reason util.ExpiryReason) {
+	closeReason := audit.CloseReasonIdleTimeout
+	if reason == util.ExpiryMaxLifetime {
+		closeReason = audit.CloseReasonMaxLifetime
+	}
+	s.closeFrontend(c, closeReason, string(reason))
+}
+
+// closeFrontend closes the established connection c on both sides for
+// reason, unless it was already removed.
+func (s *ProxyServer) closeFrontend(c *ProxyClientConnection, closeReason audit.CloseReason, reason string) {
+	s.fmu.Lock()
+	if s.frontends[c.id] != c {
+		s.fmu.Unlock()
+		return
 	}
-	return conn, nil
+	s.removeFrontendLocked(c)
+	metrics.Metrics.SetEstablishedConnCount(len(s.frontends))
+	s.fmu.Unlock()
+
+	klog.V(2).InfoS("Closing connection", "reason", reason,
+		"serverConnectionID", c.id, "agentID", c.agentID, "connectionID", c.connectID, "dialAddress", c.dialAddress)
+	s.sendBackendClose(c.backend, c.connectID, c.dialID, reason)
+	s.closeFrontendSide(c, closeReason, reason)
 }
 
-func (s *ProxyServer) removeFrontendsForBackendConn(agentID string, backend Backend) ([]*ProxyClientConnection, error) {
+// closeFrontendSide tells the frontend that the removed connection c is
+// closed for reason, and finishes it.
+func (s *ProxyServer) closeFrontendSide(c *ProxyClientConnection, closeReason audit.CloseReason, reason string) {
+	pkt := &client.Packet{
+		Type: client.PacketType_CLOSE_RSP,
+		Payload: &client.Packet_CloseResponse{
+			CloseResponse: &client.CloseResponse{
+				ConnectID: c.connectID,
+				Error:     fmt.Sprintf("connection closed by the proxy server: %s", reason),
+			},
+		},
+	}
+	if err := c.send(pkt); err != nil {
+		klog.V(5).ErrorS(err, "Failed to send close to frontend", "closeReason", reason, "connectionID", c.connectID)
+	}
+	s.finishConnection(c, closeReason, "")
+}
+
+// removeFrontendsForBackendConn removes and returns the connections of the
+// agent stream of backend. The connections of the other streams of the same
+// agent are kept.
+func (s *ProxyServer) removeFrontendsForBackendConn(backend Backend) []*ProxyClientConnection {
 	var ret []*ProxyClientConnection
 	if backend == nil {
-		return ret, nil
+		return ret
 	}
 	s.fmu.Lock()
 	defer s.fmu.Unlock()
-	frontends, ok := s.frontends[agentID]
-	if !ok {
-		return nil, fmt.Errorf("can't find agentID %s in the frontends", agentID)
+	for _, id := range s.frontendsByBackend[agentStream(backend)] {
+		ret = append(ret, s.frontends[id])
 	}
-	for _, frontend := range frontends {
-		if frontend.backend == backend {
-			delete(s.frontends, agentID)
-			ret = append(ret, frontend)
-		}
+	for _, c := range ret {
+		s.removeFrontendLocked(c)
 	}
-
-	metrics.Metrics.SetEstablishedConnCount(s.getCount(s.frontends))
-	return ret, nil
-}
-
-func (s *ProxyServer) getCount(frontends map[string](map[int64]*ProxyClientConnection)) int {
-	count := 0
-	for _, frontend := range frontends {
-		count = count + len(frontend)
+	if len(ret) > 0 {
+		metrics.Metrics.SetEstablishedConnCount(len(s.frontends))
 	}
-	return count
+	return ret
 }
 
 // removeForStream removes and returns all established ProxyClientConnection associated with a given
@@ -417,21 +583,70 @@ func (s *ProxyServer) removeFrontendsForStream(streamUID string) []*ProxyClientC
 	}
 	s.fmu.Lock()
 	defer s.fmu.Unlock()
-	for agentID, frontends := range s.frontends {
-		for connID, frontend := range frontends {
-			if frontend.frontend == nil {
-				continue
-			}
-			if frontend.frontend.streamUID == streamUID {
-				delete(frontends, connID)
-				ret = append(ret, frontend)
-			}
+	for id := range s.frontendsByStream[streamUID] {
+		ret = append(ret, s.frontends[id])
+	}
+	for _, c := range ret {
+		s.removeFrontendLocked(c)
+	}
+	if len(ret) > 0 {
+		metrics.Metrics.SetEstablishedConnCount(len(s.frontends))
+	}
+	return ret
+}
+
+// finishConnection records the transfer metrics of a torn down connection,
+// and writes its audit record to the AuditSink. Only the first call for a
+// given connection is recorded.
+func (s *ProxyServer) finishConnection(c *ProxyClientConnection, reason audit.CloseReason, errMsg string) {
+	c.finishOnce.Do(func() {
+		endConnectionTrace(c, reason, errMsg)
+		metrics.Metrics.ObserveConnectionClose(string(reason))
+		if c.connectID != 0 {
+			metrics.Metrics.ObserveConnectionTransfer(c.bytesToAgent.Load(), c.bytesFromAgent.Load())
 		}
-		if len(frontends) == 0 {
-			delete(s.frontends, agentID)
+		if s.AuditSink == nil {
+			return
+		}
+		end := time.Now()
+		record := &audit.Record{
+			StartTime:          c.start,
+			EndTime:            end,
+			Mode:               c.Mode,
+			ClientIdentity:     c.clientIdentity,
+			UserAgent:          c.userAgent,
+			Destination:        c.dialAddress,
+			ServerID:           s.serverID,
+			AgentID:            c.agentID,
+			DialID:             c.dialID,
+			ConnectID:          c.connectID,
+			DialLatencySeconds: c.dialDuration.Seconds(),
+			DurationSeconds:    end.Sub(c.start).Seconds(),
+			BytesToAgent:       c.bytesToAgent.Load(),
+			BytesFromAgent:     c.bytesFromAgent.Load(),
+			CloseReason:        reason,
+			Error:              errMsg,
 		}
+		if err := s.AuditSink.Write(record); errors.Is(err, audit.ErrBufferFull) {
+			metrics.Metrics.AuditRecordDroppedInc()
+		} else if err != nil {
+			klog.ErrorS(err, "Failed to write audit record", "dialID", c.dialID, "connectionID", c.connectID)
+		}
+	})
+}
+
+// peerIdentity returns the common name of the client certificate presented
+// on the stream, or an empty string if there is none.
+func peerIdentity(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
 	}
-	return ret
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		return ""
+	}
+	return tlsInfo.State.PeerCertificates[0].Subject.CommonName
 }
 
 // NewProxyServer creates a new ProxyServer instance
@@ -450,17 +665,24 @@ func NewProxyServer(serverID string, proxyStrategies []ProxyStrategy, serverCoun
 		}
 	}
 
-	return &ProxyServer{
-		frontends:                  make(map[string](map[int64]*ProxyClientConnection)),
+	s := &ProxyServer{
+		frontends:                  make(map[uint64]*ProxyClientConnection),
+		frontendsByBackend:         make(map[Backend]map[int64]uint64),
+		frontendsByStream:          make(map[string]map[uint64]struct{}),
 		PendingDial:                NewPendingDialManager(),
 		serverID:                   serverID,
-		serverCount:                serverCount,
+		membership:                 membership.Static(serverCount),
+		agentStreams:               make(map[Backend]string),
+		agentHealths:               make(map[Backend]*agentHealth),
+		drainGrace:                 DefaultDrainGrace,
+		identifiers:                make(map[agent.AgentService_ConnectServer]*streamIdentifiers),
 		BackendManagers:            bms,
 		AgentAuthenticationOptions: agentAuthenticationOptions,
-		// use the first backend-manager as the Readiness Manager
-		Readiness:       bms[0],
-		proxyStrategies: proxyStrategies,
+		proxyStrategies:            proxyStrategies,
 	}
+	// The default policy requires no strategy, so it can't fail.
+	_ = s.SetReadinessPolicy(DefaultReadinessPolicy)
+	return s
 }
 
 // Proxy handles incoming streams from gRPC frontend.
@@ -482,6 +704,12 @@ func (s *ProxyServer) Proxy(stream client.ProxyService_ProxyServer) error {
 	frontend := GrpcFrontend{
 		stream:    stream,
 		streamUID: streamUID,
+		identity:  peerIdentity(stream.Context()),
+		userAgent: strings.Join(userAgent, ", "),
+	}
+	if forwardedBy := md.Get(header.ForwardedBy); len(forwardedBy) > 0 {
+		frontend.forwardedBy = forwardedBy[0]
+		klog.V(5).InfoS("Proxy request forwarded by peer proxy server", "peerID", frontend.forwardedBy, "streamUID", streamUID)
 	}
 
 	defer func() {
@@ -492,15 +720,17 @@ func (s *ProxyServer) Proxy(stream client.ProxyService_ProxyServer) error {
 			klog.V(2).InfoS("frontend stream shutdown, cleaning dial", "dialID", p.dialID)
 			// TODO: add agent support to handle this
 			s.sendBackendDialClose(p.backend, p.dialID, "frontend stream shutdown")
+			s.finishConnection(p, audit.CloseReasonFrontendShutdown, "")
 		}
 		for _, f := range s.removeFrontendsForStream(streamUID) {
 			klog.V(2).InfoS("frontend stream shutdown, cleaning frontend", "connectionID", f.connectID, "dialID", f.dialID)
 			s.sendBackendClose(f.backend, f.connectID, f.dialID, "frontend stream shutdown")
+			s.finishConnection(f, audit.CloseReasonFrontendShutdown, "")
 		}
 	}()
 
 	labels := runpprof.Labels(
-		"serverCount", strconv.Itoa(s.serverCount),
+		"serverCount", strconv.Itoa(s.members().Count),
 		"userAgent", strings.Join(userAgent, ", "),
 	)
 	// Start goroutine to receive packets from frontend and push to recvCh
@@ -549,8 +779,6 @@ func (s *ProxyServer) serveRecvFrontend(frontend *GrpcFrontend, recvCh <-chan *c
 	var firstConnID int64
 	// The first packet should be a DIAL_REQ, we will randomly get a
 	// backend from the BackendManger then.
-	// TODO: either add agentID to protocol (DATA, CLOSE_RSP, etc) or replace {agentID,
-	// connectionID} with a simpler key (#462).
 	var backend Backend
 	var err error
 
@@ -584,10 +812,22 @@ func (s *ProxyServer) serveRecvFrontend(frontend *GrpcFrontend, recvCh <-chan *c
 			// the address, then we can send the Dial_REQ to the
 			// same agent. That way we save the agent from creating
 			// a new connection to the address.
-			backend, err = s.getBackend(address)
+			connection := &ProxyClientConnection{
+				Mode:           "grpc",
+				frontend:       frontend,
+				dialID:         random,
+				connected:      make(chan struct{}),
+				start:          time.Now(),
+				dialAddress:    address,
+				clientIdentity: frontend.identity,
+				userAgent:      frontend.userAgent,
+			}
+			ctx := s.startConnectionTrace(tracing.Extract(context.Background(), pkt.GetDialRequest()), connection)
+			backend, err = s.selectBackend(ctx, address, frontend.forwardedBy == "")
 			if err != nil {
 				klog.ErrorS(err, "Failed to get a backend", "dialID", random)
 				metrics.Metrics.ObserveDialFailure(metrics.DialFailureNoAgent)
+				s.finishConnection(connection, audit.CloseReasonNoAgent, err.Error())
 
 				resp := &client.Packet{
 					Type: client.PacketType_DIAL_RSP,
@@ -604,17 +844,9 @@ func (s *ProxyServer) serveRecvFrontend(frontend *GrpcFrontend, recvCh <-chan *c
 				// The Dial is failing; no reason to keep this goroutine.
 				return
 			}
-			s.PendingDial.Add(
-				random,
-				&ProxyClientConnection{
-					Mode:        "grpc",
-					frontend:    frontend,
-					dialID:      random,
-					connected:   make(chan struct{}),
-					start:       time.Now(),
-					backend:     backend,
-					dialAddress: address,
-				})
+			connection.backend = backend
+			s.startDialTrace(ctx, connection, pkt.GetDialRequest())
+			s.PendingDial.Add(random, connection)
 			if err := backend.Send(pkt); err != nil {
 				klog.ErrorS(err, "DIAL_REQ to Backend failed", "dialID", random)
 			} else {
@@ -650,9 +882,11 @@ func (s *ProxyServer) serveRecvFrontend(frontend *GrpcFrontend, recvCh <-chan *c
 					"dialDuration", time.Since(pd.start),
 				)
 				metrics.Metrics.ObserveDialFailure(metrics.DialFailureFrontendClose)
+				s.finishConnection(pd, audit.CloseReasonDialCancelled, "")
 			} else {
-				// TODO: Fix the leaked connection due to race (client cancels a pending dial but
-				// server has completed the dial).
+				// The dial may have completed meanwhile; its connection is
+				// then only closed with the frontend stream, or once idle
+				// for ConnectionIdleTimeout.
 				klog.ErrorS(nil, "Unrecognized dial cancelled (DIAL_CLS) by frontend", "dialID", random)
 			}
 
@@ -684,8 +918,22 @@ func (s *ProxyServer) serveRecvFrontend(frontend *GrpcFrontend, recvCh <-chan *c
 			if err := backend.Send(pkt); err != nil {
 				// TODO: retry with other backends connecting to this agent.
 				klog.ErrorS(err, "DATA to Backend failed", "connectionID", connID)
+				if err == errSendQueueFull {
+					// The agent is too slow to take the DATA of the
+					// connection, which is closed instead of holding up
+					// this loop.
+					if c, err := s.getFrontend(backend, connID); err == nil {
+						s.closeFrontend(c, audit.CloseReasonSendTimeout, "agent send timeout")
+					}
+				}
 				continue
 			}
+			// The connection is looked up by the ID of the DATA, as the
+			// stream may have dialed more than once.
+			if c, err := s.getFrontend(backend, connID); err == nil {
+				c.bytesToAgent.Add(int64(len(data)))
+				c.expiry.Load().Touch()
+			}
 			klog.V(5).Infoln("DATA sent to Backend")
 
 		default:
@@ -707,25 +955,27 @@ func agentID(stream agent.AgentService_ConnectServer) (string, error) {
 	return agentIDs[0], nil
 }
 
-func getAgentIdentifiers(stream agent.AgentService_ConnectServer) (pkgagent.Identifiers, error) {
+// getAgentIdentifiers returns the url encoded identifiers of the agent
+// stream, from its metadata, and the parsed identifiers.
+func getAgentIdentifiers(stream agent.AgentService_ConnectServer) (string, pkgagent.Identifiers, error) {
 	var agentIdentifiers pkgagent.Identifiers
 	md, ok := metadata.FromIncomingContext(stream.Context())
 	if !ok {
-		return agentIdentifiers, fmt.Errorf("failed to get context")
+		return "", agentIdentifiers, fmt.Errorf("failed to get context")
 	}
 	agentIDs := md.Get(header.AgentIdentifiers)
 	if len(agentIDs) > 1 {
-		return agentIdentifiers, fmt.Errorf("expected at most one agent IP in the context, got %v", agentIDs)
+		return "", agentIdentifiers, fmt.Errorf("expected at most one agent IP in the context, got %v", agentIDs)
 	}
 	if len(agentIDs) == 0 {
-		return agentIdentifiers, nil
+		return "", agentIdentifiers, nil
 	}
 
 	agentIdentifiers, err := pkgagent.GenAgentIdentifiers(agentIDs[0])
 	if err != nil {
-		return agentIdentifiers, err
+		return "", agentIdentifiers, err
 	}
-	return agentIdentifiers, nil
+	return agentIDs[0], agentIdentifiers, nil
 }
 
 func (s *ProxyServer) validateAuthToken(ctx context.Context, token string) (username string, err error) {
@@ -811,8 +1061,9 @@ func (s *ProxyServer) Connect(stream agent.AgentService_ConnectServer) error {
 	}
 
 	klog.V(5).InfoS("Connect request from agent", "agentID", agentID, "serverID", s.serverID)
+	members := s.members()
 	labels := runpprof.Labels(
-		"serverCount", strconv.Itoa(s.serverCount),
+		"serverCount", strconv.Itoa(members.Count),
 		"agentID", agentID,
 	)
 	ctx := runpprof.WithLabels(context.Background(), labels)
@@ -825,15 +1076,38 @@ func (s *ProxyServer) Connect(stream agent.AgentService_ConnectServer) error {
 		}
 	}
 
-	h := metadata.Pairs(header.ServerID, s.serverID, header.ServerCount, strconv.Itoa(s.serverCount))
+	h := metadata.Pairs(header.ServerID, s.serverID, header.ServerCount, strconv.Itoa(members.Count))
+	if len(members.ServerIDs) > 0 {
+		h.Append(header.ServerIDs, strings.Join(members.ServerIDs, ","))
+	}
 	if err := stream.SendHeader(h); err != nil {
 		klog.ErrorS(err, "Failed to send server count back to agent", "agentID", agentID)
 		return err
 	}
 
 	klog.V(2).InfoS("Agent connected", "agentID", agentID, "serverID", s.serverID)
+	// The backends of the stream share its send queue, which is stopped
+	// last, once the backends are removed.
+	queue := newSendQueue(stream, agentID, s.AgentSendQueueSize, s.AgentSendTimeout)
+	defer queue.stop()
+	stream = queue
 	backend := s.addBackend(agentID, stream)
-	defer s.removeBackend(agentID, stream)
+	defer s.forgetAgentIdentifiers(stream)
+	health := s.newAgentHealth(agentID, stream, backend)
+	defer health.stop()
+	if backend != nil {
+		// The health is registered first, for the stream to be drained
+		// through it by the later streams of the agent.
+		s.addAgentHealth(backend, health)
+		defer s.removeAgentHealth(backend)
+		s.addAgentStream(backend, agentID, members)
+		defer s.removeAgentStream(backend)
+		if s.AgentPingInterval > 0 {
+			pingStopCh := make(chan struct{})
+			defer close(pingStopCh)
+			go health.run(s.AgentPingInterval, s.AgentPingTimeout, pingStopCh)
+		}
+	}
 
 	recvCh := make(chan *client.Packet, xfrChannelSize)
 
@@ -894,10 +1168,7 @@ func (s *ProxyServer) serveRecvBackend(backend Backend, agentID string, recvCh <
 		// Close all connected frontends when the agent connection is closed
 		// TODO(#126): Frontends in PendingDial state that have not been added to the
 		//             list of frontends should also be closed.
-		frontends, err := s.removeFrontendsForBackendConn(agentID, backend)
-		if err != nil {
-			return
-		}
+		frontends := s.removeFrontendsForBackendConn(backend)
 		if len(frontends) > 0 {
 			klog.V(2).InfoS("Close frontends connected to agent",
 				"count", len(frontends), "agentID", agentID)
@@ -914,6 +1185,7 @@ func (s *ProxyServer) serveRecvBackend(backend Backend, agentID string, recvCh <
 			if err := frontend.send(pkt); err != nil {
 				klog.ErrorS(err, "CLOSE_RSP to frontend failed", "agentID", agentID)
 			}
+			s.finishConnection(frontend, audit.CloseReasonBackendShutdown, "")
 		}
 	}()
 
@@ -931,11 +1203,14 @@ func (s *ProxyServer) serveRecvBackend(backend Backend, agentID string, recvCh <
 					s.sendBackendClose(backend, resp.ConnectID, resp.Random, "unknown dial id")
 				}
 			} else {
+				frontend.agentID = agentID
 				dialErr := false
 				if resp.Error != "" {
 					// Dial response with error should not contain a valid ConnID.
 					klog.ErrorS(errors.New(resp.Error), "DIAL_RSP contains failure", "dialID", resp.Random, "agentID", agentID)
 					metrics.Metrics.ObserveDialFailure(metrics.DialFailureErrorResponse)
+					s.observeDial(false)
+					s.finishConnection(frontend, audit.CloseReasonDialError, resp.Error)
 					dialErr = true
 				}
 				err := frontend.send(pkt)
@@ -949,6 +1224,7 @@ func (s *ProxyServer) serveRecvBackend(backend Backend, agentID string, recvCh <
 					// Currently, the agent will no resend DIAL_RSP, so connection is dead.
 					// We already attempted to tell the frontend that. We should ensure we tell the backend.
 					s.sendBackendClose(backend, resp.ConnectID, resp.Random, "dial error")
+					s.finishConnection(frontend, audit.CloseReasonSendFailure, err.Error())
 					dialErr = true
 				}
 				// Avoid adding the frontend if there was an error dialing the destination
@@ -956,14 +1232,17 @@ func (s *ProxyServer) serveRecvBackend(backend Backend, agentID string, recvCh <
 					break
 				}
 				frontend.connectID = resp.ConnectID
-				frontend.agentID = agentID
+				frontend.dialDuration = time.Since(frontend.start)
+				endDialTrace(frontend)
 				// TODO: this connection may be cleaned on serveRecvFrontend exit, make it independent.
-				s.addFrontend(agentID, resp.ConnectID, frontend)
+				s.addFrontend(frontend)
 				close(frontend.connected)
-				metrics.Metrics.ObserveDialLatency(time.Since(frontend.start))
+				metrics.Metrics.ObserveDialLatency(frontend.dialDuration)
+				s.observeDial(true)
 				klog.V(3).InfoS("Proxy connection established",
 					"dialID", resp.Random,
 					"connectionID", resp.ConnectID,
+					"serverConnectionID", frontend.id,
 					"agentID", agentID,
 					"dialAddress", frontend.dialAddress,
 					"dialDuration", time.Since(frontend.start),
@@ -989,6 +1268,8 @@ func (s *ProxyServer) serveRecvBackend(backend Backend, agentID string, recvCh <
 					"dialDuration", time.Since(frontend.start),
 				)
 				metrics.Metrics.ObserveDialFailure(metrics.DialFailureBackendClose)
+				s.observeDial(false)
+				s.finishConnection(frontend, audit.CloseReasonDialClosed, "")
 			}
 
 		case client.PacketType_DATA:
@@ -999,7 +1280,7 @@ func (s *ProxyServer) serveRecvBackend(backend Backend, agentID string, recvCh <
 				continue
 			}
 
-			frontend, err := s.getFrontend(agentID, resp.ConnectID)
+			frontend, err := s.getFrontend(backend, resp.ConnectID)
 			if err != nil {
 				klog.V(2).InfoS("could not get frontend client; closing connection", "agentID", agentID, "connectionID", resp.ConnectID, "error", err)
 				s.sendBackendClose(backend, resp.ConnectID, 0, "missing frontend")
@@ -1014,7 +1295,7 @@ func (s *ProxyServer) serveRecvBackend(backend Backend, agentID string, recvCh <
 		case client.PacketType_CLOSE_RSP:
 			resp := pkt.GetCloseResponse()
 			klog.V(5).InfoS("Received CLOSE_RSP", "agentID", agentID, "connectionID", resp.ConnectID)
-			frontend := s.removeFrontend(agentID, resp.ConnectID)
+			frontend := s.removeFrontend(backend, resp.ConnectID)
 			if frontend == nil {
 				// assuming it is already closed, just log it
 				klog.V(2).InfoS("could not get frontend client for closing", "agentID", agentID, "connectionID", resp.ConnectID)
@@ -1026,6 +1307,17 @@ func (s *ProxyServer) serveRecvBackend(backend Backend, agentID string, recvCh <
 			} else {
 				klog.V(5).InfoS("CLOSE_RSP sent to frontend", "connectionID", resp.ConnectID)
 			}
+			s.finishConnection(frontend, audit.CloseReasonClosed, resp.Error)
+
+		case client.PacketType_PONG:
+			if health := s.agentHealth(backend); health != nil {
+				health.pong(pkt.GetPong().Id)
+			}
+
+		case client.PacketType_AGENT_IDENTIFIERS:
+			if stream, ok := agentStream(backend).(agent.AgentService_ConnectServer); ok {
+				s.updateAgentIdentifiers(agentID, stream, pkt.GetAgentIdentifiers().Identifiers)
+			}
 
 		default:
 			klog.V(5).InfoS("Ignoring unrecognized packet from backend", "packet", pkt, "agentID", agentID)
@@ -1034,6 +1326,14 @@ func (s *ProxyServer) serveRecvBackend(backend Backend, agentID string, recvCh <
 	klog.V(5).InfoS("Close backend of agent", "agentID", agentID)
 }
 
+// observeDial records the outcome of a dial sent to an agent, for the
+// readiness policy.
+func (s *ProxyServer) observeDial(success bool) {
+	if s.readiness != nil {
+		s.readiness.observeDial(success)
+	}
+}
+
 func (s *ProxyServer) sendBackendClose(backend Backend, connectID int64, random int64, reason string) {
 	pkt := &client.Packet{
 		Type: client.PacketType_CLOSE_REQ,
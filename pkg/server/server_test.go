@@ -37,7 +37,10 @@ import (
 	fakeauthenticationv1 "k8s.io/client-go/kubernetes/typed/authentication/v1/fake"
 	k8stesting "k8s.io/client-go/testing"
 
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/pkg/common/tracing"
 	client "sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server/audit"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server/membership"
 	"sigs.k8s.io/apiserver-network-proxy/pkg/server/metrics"
 	metricstest "sigs.k8s.io/apiserver-network-proxy/pkg/testing/metrics"
 	agentmock "sigs.k8s.io/apiserver-network-proxy/proto/agent/mocks"
@@ -216,72 +219,160 @@ func TestRemovePendingDialForStream(t *testing.T) {
 	}
 }
 
-func TestAddRemoveFrontends(t *testing.T) {
-	agent1ConnID1 := new(ProxyClientConnection)
-	agent1ConnID2 := new(ProxyClientConnection)
-	agent2ConnID1 := new(ProxyClientConnection)
-	agent2ConnID2 := new(ProxyClientConnection)
-	agent3ConnID1 := new(ProxyClientConnection)
+// newEstablishedConnection returns a connection established through
+// backend, as connectID of the agent.
+func newEstablishedConnection(backend Backend, connectID int64) *ProxyClientConnection {
+	return &ProxyClientConnection{backend: backend, connectID: connectID}
+}
 
-	p := NewProxyServer("", []ProxyStrategy{ProxyStrategyDefault}, 1, nil)
-	p.addFrontend("agent1", int64(1), agent1ConnID1)
-	p.removeFrontend("agent1", int64(1))
-	expectedFrontends := make(map[string]map[int64]*ProxyClientConnection)
+// expectFrontends checks the connections of p, and that its indexes only
+// refer to them.
+func expectFrontends(t *testing.T, p *ProxyServer, expected ...*ProxyClientConnection) {
+	t.Helper()
+	expectedFrontends := make(map[uint64]*ProxyClientConnection)
+	for _, c := range expected {
+		expectedFrontends[c.id] = c
+	}
 	if e, a := expectedFrontends, p.frontends; !reflect.DeepEqual(e, a) {
 		t.Errorf("expected %v, got %v", e, a)
 	}
+	indexed := 0
+	for stream, conns := range p.frontendsByBackend {
+		for connectID, id := range conns {
+			if c := p.frontends[id]; c == nil || c.connectID != connectID || agentStream(c.backend) != stream {
+				t.Errorf("unexpected index of connection %d of agent stream %v", connectID, stream)
+			}
+			indexed++
+		}
+	}
+	if indexed != len(expected) {
+		t.Errorf("expected %d indexed connections, got %d", len(expected), indexed)
+	}
+}
+
+func TestAddRemoveFrontends(t *testing.T) {
+	backend1 := &backend{}
+	backend2 := &backend{}
+	backend3 := &backend{}
+	agent1ConnID1 := newEstablishedConnection(backend1, 1)
+	agent1ConnID2 := newEstablishedConnection(backend1, 2)
+	agent2ConnID1 := newEstablishedConnection(backend2, 1)
+	agent2ConnID2 := newEstablishedConnection(backend2, 2)
+	agent3ConnID1 := newEstablishedConnection(backend3, 1)
+
+	p := NewProxyServer("", []ProxyStrategy{ProxyStrategyDefault}, 1, nil)
+	p.addFrontend(agent1ConnID1)
+	p.removeFrontend(backend1, int64(1))
+	expectFrontends(t, p)
 
 	p = NewProxyServer("", []ProxyStrategy{ProxyStrategyDefault}, 1, nil)
-	p.addFrontend("agent1", int64(1), agent1ConnID1)
-	p.addFrontend("agent1", int64(2), agent1ConnID2)
-	p.addFrontend("agent2", int64(1), agent2ConnID1)
-	p.addFrontend("agent2", int64(2), agent2ConnID2)
-	p.addFrontend("agent3", int64(1), agent3ConnID1)
-	p.removeFrontend("agent2", int64(1))
-	p.removeFrontend("agent2", int64(2))
-	p.removeFrontend("agent1", int64(1))
-	expectedFrontends = map[string]map[int64]*ProxyClientConnection{
-		"agent1": {
-			int64(2): agent1ConnID2,
-		},
-		"agent3": {
-			int64(1): agent3ConnID1,
-		},
+	p.addFrontend(agent1ConnID1)
+	p.addFrontend(agent1ConnID2)
+	p.addFrontend(agent2ConnID1)
+	p.addFrontend(agent2ConnID2)
+	p.addFrontend(agent3ConnID1)
+	p.removeFrontend(backend2, int64(1))
+	p.removeFrontend(backend2, int64(2))
+	p.removeFrontend(backend1, int64(1))
+	expectFrontends(t, p, agent1ConnID2, agent3ConnID1)
+}
+
+func TestFrontendIDs(t *testing.T) {
+	agentConn := new(agentmock.MockAgentService_ConnectServer)
+	otherAgentConn := new(agentmock.MockAgentService_ConnectServer)
+	// Two streams of the same agent assign the same connectIDs. The
+	// backend managers wrap each stream in backends of their own.
+	stream1, stream1Dup := newBackend(agentConn), newBackend(agentConn)
+	stream2 := newBackend(otherAgentConn)
+	conn1 := newEstablishedConnection(stream1, 1)
+	conn2 := newEstablishedConnection(stream2, 1)
+
+	p := NewProxyServer("", []ProxyStrategy{ProxyStrategyDefault}, 1, nil)
+	p.addFrontend(conn1)
+	p.addFrontend(conn2)
+	if conn1.id == 0 || conn1.id == conn2.id {
+		t.Fatalf("expected unique connection IDs, got %d and %d", conn1.id, conn2.id)
 	}
-	if e, a := expectedFrontends, p.frontends; !reflect.DeepEqual(e, a) {
-		t.Errorf("expected %v, got %v", e, a)
+	if c, err := p.getFrontend(stream1Dup, 1); err != nil || c != conn1 {
+		t.Errorf("expected connection %d of the first stream, got %v, %v", conn1.id, c, err)
+	}
+	if c, err := p.getFrontend(stream2, 1); err != nil || c != conn2 {
+		t.Errorf("expected connection %d of the second stream, got %v, %v", conn2.id, c, err)
+	}
+
+	// Cleaning up a stream keeps the connections of the other.
+	if removed := p.removeFrontendsForBackendConn(stream1Dup); !reflect.DeepEqual(removed, []*ProxyClientConnection{conn1}) {
+		t.Errorf("expected to remove connection %d, got %v", conn1.id, removed)
+	}
+	expectFrontends(t, p, conn2)
+	if _, err := p.getFrontend(stream1, 1); err == nil {
+		t.Error("expected the connection of the removed stream to be gone")
+	}
+}
+
+func TestFrontendIDReused(t *testing.T) {
+	metrics.Metrics.Reset()
+	sink := &fakeAuditSink{}
+	frontendClosed := make(chan struct{})
+	backend1 := &backend{}
+	old := newEstablishedConnection(backend1, 1)
+	old.Mode = "http-connect"
+	old.CloseHTTP = func() error {
+		close(frontendClosed)
+		return nil
+	}
+	// The backend has no stream, as it must not be sent a CLOSE_REQ for the
+	// connection ID, which is reused.
+	reused := newEstablishedConnection(backend1, 1)
+
+	p := NewProxyServer("", []ProxyStrategy{ProxyStrategyDefault}, 1, nil)
+	p.AuditSink = sink
+	p.addFrontend(old)
+	p.addFrontend(reused)
+	expectFrontends(t, p, reused)
+
+	select {
+	case <-frontendClosed:
+	default:
+		t.Error("expected the frontend of the replaced connection to be closed")
+	}
+	if len(sink.records) != 1 || sink.records[0].CloseReason != audit.CloseReasonReplaced {
+		t.Errorf("expected a replaced audit record, got %+v", sink.records)
 	}
 }
 
 func TestEstablishedConnsMetric(t *testing.T) {
 	metrics.Metrics.Reset()
 
-	agent1ConnID1 := new(ProxyClientConnection)
-	agent1ConnID2 := new(ProxyClientConnection)
-	agent2ConnID1 := new(ProxyClientConnection)
-	agent2ConnID2 := new(ProxyClientConnection)
-	agent3ConnID1 := new(ProxyClientConnection)
+	backend1 := &backend{}
+	backend2 := &backend{}
+	backend3 := &backend{}
+	agent1ConnID1 := newEstablishedConnection(backend1, 1)
+	agent1ConnID2 := newEstablishedConnection(backend1, 2)
+	agent2ConnID1 := newEstablishedConnection(backend2, 1)
+	agent2ConnID2 := newEstablishedConnection(backend2, 2)
+	agent3ConnID1 := newEstablishedConnection(backend3, 1)
 
 	p := NewProxyServer("", []ProxyStrategy{ProxyStrategyDefault}, 1, nil)
-	p.addFrontend("agent1", int64(1), agent1ConnID1)
+	p.addFrontend(agent1ConnID1)
 	assertEstablishedConnsMetric(t, 1)
-	p.addFrontend("agent1", int64(2), agent1ConnID2)
+	p.addFrontend(agent1ConnID2)
 	assertEstablishedConnsMetric(t, 2)
-	p.addFrontend("agent2", int64(1), agent2ConnID1)
+	p.addFrontend(agent2ConnID1)
 	assertEstablishedConnsMetric(t, 3)
-	p.addFrontend("agent2", int64(2), agent2ConnID2)
+	p.addFrontend(agent2ConnID2)
 	assertEstablishedConnsMetric(t, 4)
-	p.addFrontend("agent3", int64(1), agent3ConnID1)
+	p.addFrontend(agent3ConnID1)
 	assertEstablishedConnsMetric(t, 5)
-	p.removeFrontend("agent2", int64(1))
+	p.removeFrontend(backend2, int64(1))
 	assertEstablishedConnsMetric(t, 4)
-	p.removeFrontend("agent2", int64(2))
+	p.removeFrontend(backend2, int64(2))
 	assertEstablishedConnsMetric(t, 3)
-	p.removeFrontend("agent1", int64(1))
+	p.removeFrontend(backend1, int64(1))
 	assertEstablishedConnsMetric(t, 2)
-	p.removeFrontend("agent1", int64(2))
+	p.removeFrontend(backend1, int64(2))
 	assertEstablishedConnsMetric(t, 1)
-	p.removeFrontend("agent3", int64(1))
+	p.removeFrontend(backend3, int64(1))
 	assertEstablishedConnsMetric(t, 0)
 }
 
@@ -289,30 +380,19 @@ func TestRemoveFrontendsForBackendConn(t *testing.T) {
 	backend1 := &backend{}
 	backend2 := &backend{}
 	backend3 := &backend{}
-	agent1ConnID1 := &ProxyClientConnection{backend: backend1}
-	agent1ConnID2 := &ProxyClientConnection{backend: backend1}
-	agent2ConnID1 := &ProxyClientConnection{backend: backend2}
-	agent2ConnID2 := &ProxyClientConnection{backend: backend2}
-	agent3ConnID1 := &ProxyClientConnection{backend: backend3}
+	agent1ConnID1 := newEstablishedConnection(backend1, 1)
+	agent1ConnID2 := newEstablishedConnection(backend1, 2)
+	agent2ConnID1 := newEstablishedConnection(backend2, 1)
+	agent2ConnID2 := newEstablishedConnection(backend2, 2)
+	agent3ConnID1 := newEstablishedConnection(backend3, 1)
 	p := NewProxyServer("", []ProxyStrategy{ProxyStrategyDefault}, 1, nil)
-	p.addFrontend("agent1", int64(1), agent1ConnID1)
-	p.addFrontend("agent1", int64(2), agent1ConnID2)
-	p.addFrontend("agent2", int64(1), agent2ConnID1)
-	p.addFrontend("agent2", int64(2), agent2ConnID2)
-	p.addFrontend("agent3", int64(1), agent3ConnID1)
-	p.removeFrontendsForBackendConn("agent2", backend2)
-	expectedFrontends := map[string]map[int64]*ProxyClientConnection{
-		"agent1": {
-			int64(1): agent1ConnID1,
-			int64(2): agent1ConnID2,
-		},
-		"agent3": {
-			int64(1): agent3ConnID1,
-		},
-	}
-	if e, a := expectedFrontends, p.frontends; !reflect.DeepEqual(e, a) {
-		t.Errorf("expected %v, got %v", e, a)
-	}
+	p.addFrontend(agent1ConnID1)
+	p.addFrontend(agent1ConnID2)
+	p.addFrontend(agent2ConnID1)
+	p.addFrontend(agent2ConnID2)
+	p.addFrontend(agent3ConnID1)
+	p.removeFrontendsForBackendConn(backend2)
+	expectFrontends(t, p, agent1ConnID1, agent1ConnID2, agent3ConnID1)
 }
 
 func TestRemoveFrontendsForStream(t *testing.T) {
@@ -320,28 +400,21 @@ func TestRemoveFrontendsForStream(t *testing.T) {
 	backend1 := &backend{}
 	backend2 := &backend{}
 	backend3 := &backend{}
-	agent1ConnID1 := &ProxyClientConnection{backend: backend1, frontend: &GrpcFrontend{streamUID: streamUID}}
-	agent1ConnID2 := &ProxyClientConnection{backend: backend1}
-	agent2ConnID1 := &ProxyClientConnection{backend: backend2, frontend: &GrpcFrontend{streamUID: streamUID}}
-	agent2ConnID2 := &ProxyClientConnection{backend: backend2}
-	agent3ConnID1 := &ProxyClientConnection{backend: backend3, frontend: &GrpcFrontend{streamUID: streamUID}}
+	agent1ConnID1 := &ProxyClientConnection{backend: backend1, connectID: 1, frontend: &GrpcFrontend{streamUID: streamUID}}
+	agent1ConnID2 := &ProxyClientConnection{backend: backend1, connectID: 2}
+	agent2ConnID1 := &ProxyClientConnection{backend: backend2, connectID: 1, frontend: &GrpcFrontend{streamUID: streamUID}}
+	agent2ConnID2 := &ProxyClientConnection{backend: backend2, connectID: 2}
+	agent3ConnID1 := &ProxyClientConnection{backend: backend3, connectID: 1, frontend: &GrpcFrontend{streamUID: streamUID}}
 	p := NewProxyServer("", []ProxyStrategy{ProxyStrategyDefault}, 1, nil)
-	p.addFrontend("agent1", int64(1), agent1ConnID1)
-	p.addFrontend("agent1", int64(2), agent1ConnID2)
-	p.addFrontend("agent2", int64(1), agent2ConnID1)
-	p.addFrontend("agent2", int64(2), agent2ConnID2)
-	p.addFrontend("agent3", int64(1), agent3ConnID1)
+	p.addFrontend(agent1ConnID1)
+	p.addFrontend(agent1ConnID2)
+	p.addFrontend(agent2ConnID1)
+	p.addFrontend(agent2ConnID2)
+	p.addFrontend(agent3ConnID1)
 	p.removeFrontendsForStream(streamUID)
-	expectedFrontends := map[string]map[int64]*ProxyClientConnection{
-		"agent1": {
-			int64(2): agent1ConnID2,
-		},
-		"agent2": {
-			int64(2): agent2ConnID2,
-		},
-	}
-	if e, a := expectedFrontends, p.frontends; !reflect.DeepEqual(e, a) {
-		t.Errorf("expected %v, got %v", e, a)
+	expectFrontends(t, p, agent1ConnID2, agent2ConnID2)
+	if len(p.frontendsByStream) != 0 {
+		t.Errorf("expected no frontend streams, got %v", p.frontendsByStream)
 	}
 }
 
@@ -595,6 +668,146 @@ func TestServerProxyConnectionMismatch(t *testing.T) {
 	})
 }
 
+type fakeAuditSink struct {
+	mu      sync.Mutex
+	records []*audit.Record
+}
+
+func (f *fakeAuditSink) Write(r *audit.Record) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.records = append(f.records, r)
+	return nil
+}
+
+func (f *fakeAuditSink) Close() error { return nil }
+
+func TestServerProxyNoBackendAudit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sink := &fakeAuditSink{}
+	frontendConn := prepareFrontendConn(ctrl)
+	proxyServer := NewProxyServer("server1", []ProxyStrategy{ProxyStrategyDefault}, 1, &AgentTokenAuthenticationOptions{})
+	proxyServer.AuditSink = sink
+
+	gomock.InOrder(
+		frontendConn.EXPECT().Recv().Return(dialReqPkt(111), nil),
+		frontendConn.EXPECT().Recv().Return(nil, io.EOF),
+	)
+	frontendConn.EXPECT().Send(gomock.Any()).Return(nil)
+	proxyServer.Proxy(frontendConn)
+
+	if len(sink.records) != 1 {
+		t.Fatalf("expected 1 audit record, got %d", len(sink.records))
+	}
+	r := sink.records[0]
+	if r.CloseReason != audit.CloseReasonNoAgent || r.DialID != 111 || r.Destination != "127.0.0.1:8080" ||
+		r.ServerID != "server1" || r.Mode != "grpc" || r.UserAgent != "grpc-go/1.42.0" {
+		t.Errorf("unexpected audit record: %+v", r)
+	}
+}
+
+func TestServeRecvBackendAudit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	const dialID = 111
+	const connectID = 1
+	sink := &fakeAuditSink{}
+	proxyServer := NewProxyServer("server1", []ProxyStrategy{ProxyStrategyDefault}, 1, &AgentTokenAuthenticationOptions{})
+	proxyServer.AuditSink = sink
+
+	frontendConn := prepareFrontendConn(ctrl)
+	frontendConn.EXPECT().Send(gomock.Any()).Return(nil).AnyTimes()
+	connection := &ProxyClientConnection{
+		Mode:           "grpc",
+		frontend:       &GrpcFrontend{stream: frontendConn},
+		dialID:         dialID,
+		connected:      make(chan struct{}),
+		start:          time.Now(),
+		dialAddress:    "127.0.0.1:8080",
+		clientIdentity: "apiserver",
+	}
+	connection.bytesToAgent.Add(5)
+	proxyServer.PendingDial.Add(dialID, connection)
+
+	recvCh := make(chan *client.Packet, 3)
+	recvCh <- &client.Packet{
+		Type: client.PacketType_DIAL_RSP,
+		Payload: &client.Packet_DialResponse{
+			DialResponse: &client.DialResponse{Random: dialID, ConnectID: connectID},
+		},
+	}
+	recvCh <- dataPkt(connectID, []byte("hello world"))
+	recvCh <- closeRspPkt(connectID, "")
+	close(recvCh)
+	proxyServer.serveRecvBackend(nil, "agent1", recvCh)
+
+	if len(sink.records) != 1 {
+		t.Fatalf("expected 1 audit record, got %d", len(sink.records))
+	}
+	r := sink.records[0]
+	if r.CloseReason != audit.CloseReasonClosed || r.AgentID != "agent1" || r.ConnectID != connectID ||
+		r.ClientIdentity != "apiserver" || r.BytesToAgent != 5 || r.BytesFromAgent != 11 {
+		t.Errorf("unexpected audit record: %+v", r)
+	}
+}
+
+func TestServerProxyTracing(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	exporter := &tracing.InMemoryExporter{}
+	frontendConn := prepareFrontendConn(ctrl)
+	proxyServer := NewProxyServer("server1", []ProxyStrategy{ProxyStrategyDefault}, 1, &AgentTokenAuthenticationOptions{})
+	proxyServer.Tracer = tracing.NewTracer("proxy-server", exporter)
+	agentConn := prepareAgentConnMD(ctrl, proxyServer)
+
+	const traceparent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	parent := tracing.ParseTraceparent(traceparent, "")
+	dialReq := dialReqPkt(111)
+	dialReq.GetDialRequest().Traceparent = traceparent
+
+	var agentDialReq *client.DialRequest
+	agentConn.EXPECT().Send(gomock.Any()).DoAndReturn(func(pkt *client.Packet) error {
+		if pkt.Type == client.PacketType_DIAL_REQ {
+			agentDialReq = pkt.GetDialRequest()
+		}
+		return nil
+	}).AnyTimes()
+	gomock.InOrder(
+		frontendConn.EXPECT().Recv().Return(dialReq, nil),
+		frontendConn.EXPECT().Recv().Return(nil, io.EOF),
+	)
+	proxyServer.Proxy(frontendConn)
+
+	if agentDialReq == nil {
+		t.Fatal("expected DIAL_REQ to be sent to the agent")
+	}
+	spans := map[string]tracing.SpanData{}
+	for _, span := range exporter.Spans() {
+		spans[span.Name] = span
+	}
+	connection, ok := spans["proxy-server.Connection"]
+	if !ok || connection.Parent != parent.SpanID || connection.SpanContext.TraceID != parent.TraceID {
+		t.Errorf("unexpected connection span %+v", connection)
+	}
+	if connection.Attributes["closeReason"] != string(audit.CloseReasonFrontendShutdown) {
+		t.Errorf("expected connection span close reason %q, got %q", audit.CloseReasonFrontendShutdown, connection.Attributes["closeReason"])
+	}
+	if selection, ok := spans["proxy-server.SelectBackend"]; !ok || selection.Parent != connection.SpanContext.SpanID || selection.Attributes["agentID"] == "" {
+		t.Errorf("unexpected backend selection span %+v", selection)
+	}
+	dial, ok := spans["proxy-server.Dial"]
+	if !ok || dial.Parent != connection.SpanContext.SpanID || dial.Error == "" {
+		t.Errorf("unexpected dial span %+v", dial)
+	}
+	if got := tracing.ParseTraceparent(agentDialReq.Traceparent, ""); got.TraceID != parent.TraceID || got.SpanID != dial.SpanContext.SpanID {
+		t.Errorf("expected the dial span context to be propagated to the agent, got %q", agentDialReq.Traceparent)
+	}
+}
+
 func TestReadyBackendsMetric(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -615,6 +828,47 @@ func TestReadyBackendsMetric(t *testing.T) {
 	assertReadyBackendsMetric(t, 0)
 }
 
+func TestAgentDataBytesMetric(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	metrics.Metrics.Reset()
+	metrics.Metrics.SetAgentLabelLimit(1)
+	defer metrics.Metrics.SetAgentLabelLimit(0)
+
+	for _, agentID := range []string{"agent1", "agent2", "agent3"} {
+		agentConn := agentmock.NewMockAgentService_ConnectServer(ctrl)
+		agentConnCtx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(header.AgentID, agentID))
+		agentConn.EXPECT().Context().Return(agentConnCtx).AnyTimes()
+		agentConn.EXPECT().Send(gomock.Any()).Return(nil).Times(2)
+		b := newBackend(agentConn)
+		if err := b.Send(dataPkt(1, []byte("hello"))); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Send(closeReqPkt(1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expect := `
+# HELP konnectivity_network_proxy_server_agent_data_bytes_total Count of DATA packet payload bytes exchanged with agents, by agent ID and segment. Only reported if per-agent metrics are enabled; agents beyond the configured limit are reported as "other".
+# TYPE konnectivity_network_proxy_server_agent_data_bytes_total counter
+konnectivity_network_proxy_server_agent_data_bytes_total{agent_id="agent1",segment="to_agent"} 5
+konnectivity_network_proxy_server_agent_data_bytes_total{agent_id="other",segment="to_agent"} 10
+`
+	if err := metricstest.ExpectMetric(metrics.Namespace, metrics.Subsystem, "agent_data_bytes_total", expect); err != nil {
+		t.Error(err)
+	}
+	expect = `
+# HELP konnectivity_network_proxy_server_stream_data_bytes_total Count of DATA packet payload bytes processed, by segment (example: from_client)
+# TYPE konnectivity_network_proxy_server_stream_data_bytes_total counter
+konnectivity_network_proxy_server_stream_data_bytes_total{segment="to_agent"} 15
+`
+	if err := metricstest.ExpectMetric(metrics.Namespace, metrics.Subsystem, "stream_data_bytes_total", expect); err != nil {
+		t.Error(err)
+	}
+}
+
 func dialReqPkt(dialID int64) *client.Packet {
 	return &client.Packet{
 		Type: client.PacketType_DIAL_REQ,
@@ -686,3 +940,228 @@ func dialClosePkt(dialID int64) *client.Packet {
 		},
 	}
 }
+
+type fakeMembership struct {
+	members     membership.Members
+	subscribers []func(membership.Members)
+}
+
+func (f *fakeMembership) Members() membership.Members { return f.members }
+
+func (f *fakeMembership) Subscribe(s func(membership.Members)) {
+	f.subscribers = append(f.subscribers, s)
+}
+
+func (f *fakeMembership) set(m membership.Members) {
+	f.members = m
+	for _, s := range f.subscribers {
+		s(m)
+	}
+}
+
+func TestServerInfoBroadcast(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	p := NewProxyServer("server-a", []ProxyStrategy{ProxyStrategyDefault}, 1, &AgentTokenAuthenticationOptions{})
+	if got := p.members(); got.Count != 1 || got.ServerIDs != nil {
+		t.Errorf("expected the static count of 1, got %+v", got)
+	}
+	m := &fakeMembership{members: membership.Members{Count: 1, ServerIDs: []string{"server-a"}}}
+	p.SetMembership(m)
+
+	serverInfoPkt := func(ids ...string) *client.Packet {
+		return &client.Packet{
+			Type: client.PacketType_SERVER_INFO,
+			Payload: &client.Packet_ServerInfo{ServerInfo: &client.ServerInfo{
+				ServerCount: int32(len(ids)),
+				ServerIDs:   ids,
+			}},
+		}
+	}
+
+	agentConn := agentmock.NewMockAgentService_ConnectServer(ctrl)
+	agentConnCtx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(header.AgentID, "agent1"))
+	agentConn.EXPECT().Context().Return(agentConnCtx).AnyTimes()
+	b := newBackend(agentConn)
+
+	// The agent was told of server-a only, then server-b joined before its
+	// stream was registered.
+	sent := m.members
+	m.members = membership.Members{Count: 2, ServerIDs: []string{"server-a", "server-b"}}
+	agentConn.EXPECT().Send(serverInfoPkt("server-a", "server-b")).Return(nil)
+	p.addAgentStream(b, "agent1", sent)
+
+	agentConn.EXPECT().Send(serverInfoPkt("server-b")).Return(nil)
+	m.set(membership.Members{Count: 1, ServerIDs: []string{"server-b"}})
+
+	// Removed streams are not sent anything.
+	p.removeAgentStream(b)
+	m.set(membership.Members{Count: 1, ServerIDs: []string{"server-a"}})
+}
+
+func TestDuplicateAgentStreams(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	metrics.Metrics.Reset()
+
+	p := NewProxyServer("server-a", []ProxyStrategy{ProxyStrategyDefault}, 1, &AgentTokenAuthenticationOptions{})
+	newStream := func(agentID string) (*agentmock.MockAgentService_ConnectServer, Backend) {
+		conn := agentmock.NewMockAgentService_ConnectServer(ctrl)
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(header.AgentID, agentID))
+		conn.EXPECT().Context().Return(ctx).AnyTimes()
+		backend := p.addBackend(agentID, conn)
+		p.addAgentHealth(backend, p.newAgentHealth(agentID, conn, backend))
+		p.addAgentStream(backend, agentID, p.members())
+		return conn, backend
+	}
+	drainPkt := &client.Packet{
+		Type:    client.PacketType_DRAIN,
+		Payload: &client.Packet_Drain{Drain: &client.Drain{Reason: "duplicate stream of agent agent1"}},
+	}
+	// expectDrain expects conn to be drained, and returns a channel closed
+	// once it is.
+	expectDrain := func(conn *agentmock.MockAgentService_ConnectServer) <-chan struct{} {
+		drained := make(chan struct{})
+		conn.EXPECT().Send(drainPkt).Do(func(*client.Packet) { close(drained) }).Return(nil)
+		return drained
+	}
+	expectDuplicates := func(count int) {
+		t.Helper()
+		expected := fmt.Sprintf(`
+# HELP konnectivity_network_proxy_server_duplicate_agent_streams Number of agent streams in excess of one per agent, which the agents are told to drain
+# TYPE konnectivity_network_proxy_server_duplicate_agent_streams gauge
+konnectivity_network_proxy_server_duplicate_agent_streams %d
+`, count)
+		if err := metricstest.ExpectMetric(metrics.Namespace, metrics.Subsystem, "duplicate_agent_streams", expected); err != nil {
+			t.Error(err)
+		}
+	}
+	// selected returns whether the dials to agent1 go to conn only.
+	storage := p.BackendManagers[0].(*DefaultBackendManager).DefaultBackendStorage
+	selected := func(conn *agentmock.MockAgentService_ConnectServer) bool {
+		storage.mu.RLock()
+		defer storage.mu.RUnlock()
+		backends := storage.backends["agent1"]
+		return len(backends) == 1 && backends[0].conn == conn
+	}
+	expectSelected := func(conn *agentmock.MockAgentService_ConnectServer) {
+		t.Helper()
+		if err := wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+			return selected(conn), nil
+		}); err != nil {
+			t.Errorf("expected the dials to agent1 to go to stream %p only", conn)
+		}
+	}
+
+	// The first streams of the agents are kept.
+	firstConn, first := newStream("agent1")
+	_, other := newStream("agent2")
+	expectDuplicates(0)
+	expectSelected(firstConn)
+
+	// A stream which the agent closes right away, as it duplicates a live
+	// one, drains nothing.
+	p.drainGrace = 100 * time.Millisecond
+	probeConn, probe := newStream("agent1")
+	expectDuplicates(1)
+	p.removeAgentHealth(probe)
+	p.removeAgentStream(probe)
+	p.removeBackend("agent1", probeConn)
+	time.Sleep(2 * p.drainGrace)
+	expectDuplicates(0)
+	expectSelected(firstConn)
+
+	// After agent1 restarted, its first stream is likely half-open: it is
+	// drained, and no longer selected for new dials, while the stream of the
+	// restarted agent is kept.
+	p.drainGrace = 0
+	drained := expectDrain(firstConn)
+	restartedConn, restarted := newStream("agent1")
+	<-drained
+	expectDuplicates(1)
+	expectSelected(restartedConn)
+
+	// A later stream drains the previous one, and not the first again.
+	drained = expectDrain(restartedConn)
+	lastConn, last := newStream("agent1")
+	<-drained
+	expectDuplicates(2)
+	expectSelected(lastConn)
+
+	// Closing the drained streams keeps the last one selected.
+	for _, b := range []Backend{first, restarted} {
+		p.agentHealth(b).stop()
+		p.removeAgentHealth(b)
+		p.removeAgentStream(b)
+	}
+	expectDuplicates(0)
+	expectSelected(lastConn)
+	p.removeAgentStream(last)
+	p.removeAgentStream(other)
+	expectDuplicates(0)
+}
+
+func TestConnectionExpiry(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		idleTimeout time.Duration
+		maxLifetime time.Duration
+		wantReason  string
+	}{
+		{name: "idle", idleTimeout: 50 * time.Millisecond, wantReason: "idle_timeout"},
+		{name: "lifetime", maxLifetime: 50 * time.Millisecond, wantReason: "max_lifetime"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			metrics.Metrics.Reset()
+
+			agentConn := agentmock.NewMockAgentService_ConnectServer(ctrl)
+			sent := make(chan *client.Packet, 1)
+			agentConn.EXPECT().Send(gomock.Any()).DoAndReturn(func(pkt *client.Packet) error {
+				sent <- pkt
+				return nil
+			})
+			frontendClosed := make(chan struct{})
+			c := &ProxyClientConnection{
+				Mode:      "http-connect",
+				backend:   newBackend(agentConn),
+				connectID: 1,
+				CloseHTTP: func() error {
+					close(frontendClosed)
+					return nil
+				},
+			}
+			p := NewProxyServer("", []ProxyStrategy{ProxyStrategyDefault}, 1, nil)
+			p.ConnectionIdleTimeout = tc.idleTimeout
+			p.ConnectionMaxLifetime = tc.maxLifetime
+			p.addFrontend(c)
+
+			// The connection is closed on both sides.
+			select {
+			case pkt := <-sent:
+				if pkt.Type != client.PacketType_CLOSE_REQ || pkt.GetCloseRequest().ConnectID != 1 {
+					t.Errorf("expected CLOSE_REQ of connection 1 to the agent, got %v", pkt)
+				}
+			case <-time.After(wait.ForeverTestTimeout):
+				t.Fatal("timed out waiting for the CLOSE_REQ to the agent")
+			}
+			select {
+			case <-frontendClosed:
+			case <-time.After(wait.ForeverTestTimeout):
+				t.Fatal("timed out waiting for the frontend to be closed")
+			}
+			expectFrontends(t, p)
+
+			expected := fmt.Sprintf(`
+# HELP konnectivity_network_proxy_server_connections_closed_total Count of tunneled connections torn down, including failed dials, by close reason (e.g. closed, idle_timeout or max_lifetime).
+# TYPE konnectivity_network_proxy_server_connections_closed_total counter
+konnectivity_network_proxy_server_connections_closed_total{reason=%q} 1
+`, tc.wantReason)
+			if err := metricstest.ExpectMetric(metrics.Namespace, metrics.Subsystem, "connections_closed_total", expected); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server/metrics"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server/proxyauth"
+)
+
+// SOCKS5 protocol constants, from RFC 1928 and RFC 1929.
+const (
+	socks5Version = 0x05
+
+	socks5MethodNoAuth       = 0x00
+	socks5MethodUserPass     = 0x02
+	socks5MethodNoAcceptable = 0xff
+
+	socks5UserPassVersion = 0x01
+	socks5UserPassSuccess = 0x00
+	socks5UserPassFailure = 0x01
+
+	socks5CmdConnect = 0x01
+
+	socks5AddrIPv4   = 0x01
+	socks5AddrDomain = 0x03
+	socks5AddrIPv6   = 0x04
+
+	socks5ReplySucceeded           = 0x00
+	socks5ReplyGeneralFailure      = 0x01
+	socks5ReplyNotAllowed          = 0x02
+	socks5ReplyNetworkUnreachable  = 0x03
+	socks5ReplyHostUnreachable     = 0x04
+	socks5ReplyConnectionRefused   = 0x05
+	socks5ReplyTTLExpired          = 0x06
+	socks5ReplyCmdNotSupported     = 0x07
+	socks5ReplyAddrTypeUnsupported = 0x08
+)
+
+// defaultSOCKS5HandshakeTimeout bounds the negotiation of a SOCKS5
+// connection, if SOCKS5.HandshakeTimeout is not set.
+const defaultSOCKS5HandshakeTimeout = 10 * time.Second
+
+// SOCKS5 implements Proxy based on SOCKS5 (RFC 1928), which tunnels the
+// connections of the CONNECT commands to the agents registered in
+// ProxyServer. The IPv4, IPv6 and domain name address types are supported;
+// the BIND and UDP ASSOCIATE commands are not.
+type SOCKS5 struct {
+	Server *ProxyServer
+
+	// Auth, if set, requires the clients to authenticate with a username
+	// and password (RFC 1929), which are accepted if its Basic
+	// authenticators accept them.
+	Auth *proxyauth.Chain
+
+	// DialTimeout, if positive, bounds the wait for the dial response of
+	// the agent. When it expires, the dial is cancelled with a DIAL_CLS and
+	// the client is answered with the TTL expired reply.
+	DialTimeout time.Duration
+
+	// HandshakeTimeout bounds the negotiation of a connection, up to its
+	// CONNECT request. Defaults to 10s.
+	HandshakeTimeout time.Duration
+}
+
+// Serve serves the SOCKS5 connections accepted on lis, until it is closed.
+// The TLS handshake of the connections of a TLS listener is completed
+// before the negotiation, so that the clients are identified by their
+// certificates.
+func (s *SOCKS5) Serve(lis net.Listener) error {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves a SOCKS5 connection, and closes it.
+func (s *SOCKS5) ServeConn(conn net.Conn) {
+	metrics.Metrics.SOCKS5ConnectionInc()
+	defer metrics.Metrics.SOCKS5ConnectionDec()
+	defer conn.Close()
+
+	timeout := s.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = defaultSOCKS5HandshakeTimeout
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		klog.ErrorS(err, "failed to set the SOCKS5 handshake deadline")
+		return
+	}
+
+	var clientIdentity string
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			klog.V(2).InfoS("TLS handshake failed", "remoteAddr", conn.RemoteAddr(), "err", err)
+			return
+		}
+		if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			clientIdentity = certs[0].Subject.CommonName
+			klog.V(2).InfoS("TLS", "commonName", clientIdentity)
+		}
+	}
+
+	r := bufio.NewReader(conn)
+	identity, err := s.negotiate(r, conn)
+	if err != nil {
+		klog.V(2).InfoS("SOCKS5 negotiation failed", "remoteAddr", conn.RemoteAddr(), "err", err)
+		return
+	}
+	if identity != "" {
+		clientIdentity = identity
+	}
+	address, err := readSOCKS5Request(r, conn)
+	if err != nil {
+		klog.V(2).InfoS("Invalid SOCKS5 request", "remoteAddr", conn.RemoteAddr(), "err", err)
+		return
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		klog.ErrorS(err, "failed to clear the SOCKS5 handshake deadline")
+		return
+	}
+	klog.V(2).InfoS("Received SOCKS5 CONNECT", "host", address, "clientIdentity", clientIdentity)
+
+	dial := s.Server.newFrontendDial("socks5", address, clientIdentity, "")
+	defer dial.close()
+	// The reply depends on the result of the dial, so it is only written
+	// once the agent answered.
+	if err := dial.dial(context.Background(), s.DialTimeout); err != nil {
+		klog.V(2).InfoS("SOCKS5 dial failed", "host", address, "err", err)
+		writeSOCKS5Reply(conn, socks5ReplyCode(err))
+		return
+	}
+	if err := writeSOCKS5Reply(conn, socks5ReplySucceeded); err != nil {
+		klog.V(2).InfoS("failed to write the SOCKS5 reply", "host", address, "err", err)
+		return
+	}
+	dial.serve(conn, r)
+}
+
+// negotiate selects the authentication method of the client, and returns
+// the identity of the client if authenticated.
+func (s *SOCKS5) negotiate(r io.Reader, w io.Writer) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", err
+	}
+	if header[0] != socks5Version {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return "", err
+	}
+	method := byte(socks5MethodNoAuth)
+	if s.Auth != nil {
+		method = socks5MethodUserPass
+	}
+	if !bytes.Contains(methods, []byte{method}) {
+		w.Write([]byte{socks5Version, socks5MethodNoAcceptable})
+		return "", fmt.Errorf("no acceptable authentication method in %v", methods)
+	}
+	if _, err := w.Write([]byte{socks5Version, method}); err != nil {
+		return "", err
+	}
+	if s.Auth == nil {
+		return "", nil
+	}
+
+	user, password, err := readSOCKS5UserPass(r)
+	if err != nil {
+		return "", err
+	}
+	credentials := base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+	identity, err := s.Auth.AuthenticateCredentials(context.Background(), proxyauth.SchemeBasic, credentials)
+	metrics.Metrics.ObserveProxyAuth(proxyAuthResult(err))
+	if err != nil {
+		w.Write([]byte{socks5UserPassVersion, socks5UserPassFailure})
+		return "", fmt.Errorf("authentication of user %q failed: %v", user, err)
+	}
+	if _, err := w.Write([]byte{socks5UserPassVersion, socks5UserPassSuccess}); err != nil {
+		return "", err
+	}
+	klog.V(2).InfoS("Proxy authentication succeeded", "clientIdentity", identity)
+	return identity, nil
+}
+
+// readSOCKS5UserPass reads the username/password request of RFC 1929.
+func readSOCKS5UserPass(r io.Reader) (string, string, error) {
+	version := make([]byte, 1)
+	if _, err := io.ReadFull(r, version); err != nil {
+		return "", "", err
+	}
+	if version[0] != socks5UserPassVersion {
+		return "", "", fmt.Errorf("unsupported username/password authentication version %d", version[0])
+	}
+	user, err := readSOCKS5String(r)
+	if err != nil {
+		return "", "", err
+	}
+	password, err := readSOCKS5String(r)
+	if err != nil {
+		return "", "", err
+	}
+	return user, password, nil
+}
+
+// readSOCKS5String reads a string prefixed by its one byte length.
+func readSOCKS5String(r io.Reader) (string, error) {
+	length := make([]byte, 1)
+	if _, err := io.ReadFull(r, length); err != nil {
+		return "", err
+	}
+	s := make([]byte, length[0])
+	if _, err := io.ReadFull(r, s); err != nil {
+		return "", err
+	}
+	return string(s), nil
+}
+
+// readSOCKS5Request reads the request of the client, and returns its
+// destination address. Requests which are not supported are answered with
+// an error reply.
+func readSOCKS5Request(r io.Reader, w io.Writer) (string, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", err
+	}
+	if header[0] != socks5Version {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	var host string
+	switch header[3] {
+	case socks5AddrIPv4, socks5AddrIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if header[3] == socks5AddrIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socks5AddrDomain:
+		domain, err := readSOCKS5String(r)
+		if err != nil {
+			return "", err
+		}
+		host = domain
+	default:
+		writeSOCKS5Reply(w, socks5ReplyAddrTypeUnsupported)
+		return "", fmt.Errorf("unsupported address type %d", header[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", err
+	}
+	if header[1] != socks5CmdConnect {
+		writeSOCKS5Reply(w, socks5ReplyCmdNotSupported)
+		return "", fmt.Errorf("unsupported command %d", header[1])
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// writeSOCKS5Reply writes a reply to the request of the client. The address
+// bound by the agent is not known, so the reply carries the unspecified
+// IPv4 address.
+func writeSOCKS5Reply(w io.Writer, code byte) error {
+	_, err := w.Write([]byte{socks5Version, code, 0x00, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// socks5ReplyCode is the reply to a CONNECT request whose dial failed with
+// err.
+func socks5ReplyCode(err error) byte {
+	var dialErr *dialError
+	if !errors.As(err, &dialErr) {
+		return socks5ReplyGeneralFailure
+	}
+	switch dialErr.kind {
+	case dialNoAgent:
+		return socks5ReplyNetworkUnreachable
+	case dialTimeout:
+		return socks5ReplyTTLExpired
+	case dialFailed:
+		switch dialErr.code {
+		case client.DialErrorCode_DIAL_ERROR_CONNECTION_REFUSED:
+			return socks5ReplyConnectionRefused
+		case client.DialErrorCode_DIAL_ERROR_NO_SUCH_HOST, client.DialErrorCode_DIAL_ERROR_HOST_UNREACHABLE:
+			return socks5ReplyHostUnreachable
+		case client.DialErrorCode_DIAL_ERROR_NETWORK_UNREACHABLE:
+			return socks5ReplyNetworkUnreachable
+		case client.DialErrorCode_DIAL_ERROR_DENIED:
+			return socks5ReplyNotAllowed
+		}
+	}
+	return socks5ReplyGeneralFailure
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server/proxyauth"
+)
+
+func TestReadSOCKS5Request(t *testing.T) {
+	for _, tc := range []struct {
+		desc      string
+		request   []byte
+		want      string
+		wantReply byte
+		wantErr   bool
+	}{
+		{desc: "IPv4", request: []byte{5, 1, 0, 1, 10, 0, 0, 1, 0x28, 0x0a}, want: "10.0.0.1:10250"},
+		{desc: "IPv6", request: []byte{5, 1, 0, 4, 0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0x28, 0x0a}, want: "[fd00::1]:10250"},
+		{desc: "domain", request: append(append([]byte{5, 1, 0, 3, 11}, "node1.local"...), 0x28, 0x0a), want: "node1.local:10250"},
+		{desc: "UDP ASSOCIATE", request: []byte{5, 3, 0, 1, 0, 0, 0, 0, 0, 0}, wantReply: socks5ReplyCmdNotSupported, wantErr: true},
+		{desc: "unknown address type", request: []byte{5, 1, 0, 9}, wantReply: socks5ReplyAddrTypeUnsupported, wantErr: true},
+		{desc: "SOCKS4", request: []byte{4, 1, 0x28, 0x0a}, wantErr: true},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			var reply bytes.Buffer
+			got, err := readSOCKS5Request(bytes.NewReader(tc.request), &reply)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+			if tc.wantReply != 0 && (reply.Len() < 2 || reply.Bytes()[1] != tc.wantReply) {
+				t.Errorf("expected reply %d, got %v", tc.wantReply, reply.Bytes())
+			}
+		})
+	}
+}
+
+// staticBasic accepts the Basic credentials of a single user.
+type staticBasic struct {
+	user, password string
+}
+
+func (a *staticBasic) Scheme() string {
+	return proxyauth.SchemeBasic
+}
+
+func (a *staticBasic) Authenticate(ctx context.Context, credentials string) (string, error) {
+	if credentials != base64.StdEncoding.EncodeToString([]byte(a.user+":"+a.password)) {
+		return "", proxyauth.ErrInvalidCredentials
+	}
+	return a.user, nil
+}
+
+// userPass is the username/password request of RFC 1929.
+func userPass(version byte, user, password string) []byte {
+	b := []byte{version, byte(len(user))}
+	b = append(b, user...)
+	b = append(b, byte(len(password)))
+	return append(b, password...)
+}
+
+func TestSOCKS5Negotiate(t *testing.T) {
+	auth := &proxyauth.Chain{Authenticators: []proxyauth.Authenticator{&staticBasic{user: "alice", password: "secret"}}}
+	for _, tc := range []struct {
+		desc         string
+		auth         *proxyauth.Chain
+		request      []byte
+		wantIdentity string
+		wantReply    []byte
+		wantErr      bool
+	}{{
+		desc:      "no authentication",
+		request:   []byte{5, 1, socks5MethodNoAuth},
+		wantReply: []byte{5, socks5MethodNoAuth},
+	}, {
+		desc:      "no authentication not offered",
+		request:   []byte{5, 1, socks5MethodUserPass},
+		wantReply: []byte{5, socks5MethodNoAcceptable},
+		wantErr:   true,
+	}, {
+		desc:         "username/password",
+		auth:         auth,
+		request:      append([]byte{5, 2, socks5MethodNoAuth, socks5MethodUserPass}, userPass(1, "alice", "secret")...),
+		wantIdentity: "alice",
+		wantReply:    []byte{5, socks5MethodUserPass, socks5UserPassVersion, socks5UserPassSuccess},
+	}, {
+		desc:      "username/password not offered",
+		auth:      auth,
+		request:   []byte{5, 1, socks5MethodNoAuth},
+		wantReply: []byte{5, socks5MethodNoAcceptable},
+		wantErr:   true,
+	}, {
+		desc:      "wrong password",
+		auth:      auth,
+		request:   append([]byte{5, 1, socks5MethodUserPass}, userPass(1, "alice", "wrong")...),
+		wantReply: []byte{5, socks5MethodUserPass, socks5UserPassVersion, socks5UserPassFailure},
+		wantErr:   true,
+	}, {
+		desc:      "unknown user",
+		auth:      auth,
+		request:   append([]byte{5, 1, socks5MethodUserPass}, userPass(1, "bob", "secret")...),
+		wantReply: []byte{5, socks5MethodUserPass, socks5UserPassVersion, socks5UserPassFailure},
+		wantErr:   true,
+	}, {
+		desc:      "wrong username/password version",
+		auth:      auth,
+		request:   append([]byte{5, 1, socks5MethodUserPass}, userPass(5, "alice", "secret")...),
+		wantReply: []byte{5, socks5MethodUserPass},
+		wantErr:   true,
+	}, {
+		desc:    "SOCKS4",
+		request: []byte{4, 1, 0x28, 0x0a},
+		wantErr: true,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			s := &SOCKS5{Auth: tc.auth}
+			var reply bytes.Buffer
+			identity, err := s.negotiate(bytes.NewReader(tc.request), &reply)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if identity != tc.wantIdentity {
+				t.Errorf("expected identity %q, got %q", tc.wantIdentity, identity)
+			}
+			if !bytes.Equal(reply.Bytes(), tc.wantReply) {
+				t.Errorf("expected reply %v, got %v", tc.wantReply, reply.Bytes())
+			}
+		})
+	}
+}
+
+func TestSOCKS5ReplyCode(t *testing.T) {
+	for _, tc := range []struct {
+		desc string
+		err  error
+		want byte
+	}{
+		{desc: "no agent", err: &dialError{kind: dialNoAgent}, want: socks5ReplyNetworkUnreachable},
+		{desc: "server timeout", err: &dialError{kind: dialTimeout}, want: socks5ReplyTTLExpired},
+		{desc: "agent timeout", err: agentDialError("dial tcp 10.0.0.1:80: operation was canceled", client.DialErrorCode_DIAL_ERROR_TIMEOUT), want: socks5ReplyTTLExpired},
+		{desc: "refused", err: agentDialError("dial tcp 10.0.0.1:80: connect: connection refused", client.DialErrorCode_DIAL_ERROR_CONNECTION_REFUSED), want: socks5ReplyConnectionRefused},
+		{desc: "no such host", err: agentDialError("dial tcp: lookup timeout.example.com: no such host", client.DialErrorCode_DIAL_ERROR_NO_SUCH_HOST), want: socks5ReplyHostUnreachable},
+		{desc: "network unreachable", err: agentDialError("dial tcp 10.0.0.1:80: connect: network is unreachable", client.DialErrorCode_DIAL_ERROR_NETWORK_UNREACHABLE), want: socks5ReplyNetworkUnreachable},
+		{desc: "denied", err: agentDialError("destination 10.0.0.1:80 matches no allow rule: denied by the agent destination policy", client.DialErrorCode_DIAL_ERROR_DENIED), want: socks5ReplyNotAllowed},
+		// Older agents don't send the error code.
+		{desc: "refused without code", err: agentDialError("dial tcp 10.0.0.1:80: connect: connection refused", client.DialErrorCode_DIAL_ERROR_UNSPECIFIED), want: socks5ReplyConnectionRefused},
+		{desc: "timeout without code", err: agentDialError("dial tcp 10.0.0.1:80: i/o timeout", client.DialErrorCode_DIAL_ERROR_UNSPECIFIED), want: socks5ReplyTTLExpired},
+		{desc: "unknown", err: agentDialError("upstream proxy failed", client.DialErrorCode_DIAL_ERROR_UNSPECIFIED), want: socks5ReplyGeneralFailure},
+		{desc: "dial closed", err: &dialError{kind: dialClosed}, want: socks5ReplyGeneralFailure},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := socks5ReplyCode(tc.err); got != tc.want {
+				t.Errorf("expected reply %d, got %d", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"google.golang.org/grpc/metadata"
+
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/pkg/common/tracing"
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server/audit"
+	"sigs.k8s.io/apiserver-network-proxy/proto/header"
+)
+
+// The spans recorded by the server for each connection are:
+//
+//	proxy-server.Connection        from the DIAL_REQ until the connection is torn down
+//	├── proxy-server.SelectBackend the choice of the agent to dial through
+//	└── proxy-server.Dial          from the DIAL_REQ until the DIAL_RSP
+//
+// The Connection span continues the trace propagated by the frontend, and
+// the context of the Dial span is propagated to the agent in the DIAL_REQ.
+
+// startConnectionTrace starts the span covering the lifetime of c, as a child
+// of the span context carried by ctx. It returns a context carrying the new
+// span.
+func (s *ProxyServer) startConnectionTrace(ctx context.Context, c *ProxyClientConnection) context.Context {
+	ctx, c.span = s.Tracer.Start(ctx, "proxy-server.Connection")
+	c.span.SetAttribute("mode", c.Mode)
+	c.span.SetAttribute("address", c.dialAddress)
+	c.span.SetAttribute("dialID", strconv.FormatInt(c.dialID, 10))
+	c.span.SetAttribute("serverID", s.serverID)
+	return ctx
+}
+
+// selectBackend picks the backend to dial address through, recording the
+// choice as a span. If no local agent matches and forward is set, the dial
+// is forwarded to a peer proxy server with a matching agent, if any.
+func (s *ProxyServer) selectBackend(ctx context.Context, address string, forward bool) (Backend, error) {
+	_, span := s.Tracer.Start(ctx, "proxy-server.SelectBackend")
+	defer span.End()
+	backend, err := s.getBackend(address)
+	if _, ok := err.(*ErrNotFound); ok && forward && s.Peers != nil {
+		backend, err = s.forwardToPeer(address)
+		if err == nil && span != nil {
+			span.SetAttribute("peerID", backend.(*peerBackend).serverID)
+		}
+	}
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	if span != nil {
+		if md, ok := metadata.FromIncomingContext(backend.Context()); ok {
+			if ids := md.Get(header.AgentID); len(ids) == 1 {
+				span.SetAttribute("agentID", ids[0])
+			}
+		}
+	}
+	return backend, nil
+}
+
+// startDialTrace starts the span covering the dial of c by the agent, and
+// sets the trace context of req so that the agent continues the trace from it.
+func (s *ProxyServer) startDialTrace(ctx context.Context, c *ProxyClientConnection, req *client.DialRequest) {
+	ctx, c.dialSpan = s.Tracer.Start(ctx, "proxy-server.Dial")
+	tracing.Inject(ctx, req)
+}
+
+// endDialTrace ends the dial span of c after a successful DIAL_RSP.
+func endDialTrace(c *ProxyClientConnection) {
+	c.dialSpan.SetAttribute("agentID", c.agentID)
+	c.dialSpan.SetAttribute("connectionID", strconv.FormatInt(c.connectID, 10))
+	c.dialSpan.End()
+	c.span.SetAttribute("agentID", c.agentID)
+	c.span.SetAttribute("connectionID", strconv.FormatInt(c.connectID, 10))
+}
+
+// endConnectionTrace ends the spans of c which are still in progress. It is
+// a no-op for the dial span if the dial already completed.
+func endConnectionTrace(c *ProxyClientConnection, reason audit.CloseReason, errMsg string) {
+	var err error
+	if errMsg != "" {
+		err = errors.New(errMsg)
+	}
+	if c.connectID == 0 {
+		// The dial never completed.
+		dialErr := err
+		if dialErr == nil {
+			dialErr = errors.New(string(reason))
+		}
+		c.dialSpan.RecordError(dialErr)
+	}
+	c.dialSpan.End()
+	c.span.SetAttribute("closeReason", string(reason))
+	c.span.RecordError(err)
+	c.span.End()
+}
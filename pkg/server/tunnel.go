@@ -17,22 +17,36 @@ limitations under the License.
 package server
 
 import (
+	"errors"
 	"fmt"
 	"io"
-	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 
 	"k8s.io/klog/v2"
-	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/pkg/common/tracing"
 	"sigs.k8s.io/apiserver-network-proxy/pkg/server/metrics"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server/proxyauth"
 )
 
 // Tunnel implements Proxy based on HTTP Connect, which tunnels the traffic to
 // the agent registered in ProxyServer.
 type Tunnel struct {
 	Server *ProxyServer
+
+	// ProxyAuth, if set, authenticates the requests by their
+	// Proxy-Authorization header. Requests without accepted credentials
+	// are answered with 407 Proxy Authentication Required.
+	ProxyAuth *proxyauth.Chain
+
+	// DialTimeout, if positive, bounds the wait for the dial response of
+	// the agent. When it expires, the dial is cancelled with a DIAL_CLS and
+	// the request is answered with 504 Gateway Timeout.
+	DialTimeout time.Duration
 }
 
 func (t *Tunnel) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -40,137 +54,198 @@ func (t *Tunnel) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer metrics.Metrics.HTTPConnectionDec()
 
 	klog.V(2).InfoS("Received request for host", "method", r.Method, "host", r.Host, "userAgent", r.UserAgent())
-	if r.TLS != nil {
-		klog.V(2).InfoS("TLS", "commonName", r.TLS.PeerCertificates[0].Subject.CommonName)
+	var clientIdentity string
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		clientIdentity = r.TLS.PeerCertificates[0].Subject.CommonName
+		klog.V(2).InfoS("TLS", "commonName", clientIdentity)
 	}
 	if r.Method != http.MethodConnect {
 		http.Error(w, "this proxy only supports CONNECT passthrough", http.StatusMethodNotAllowed)
 		return
 	}
-
-	hijacker, ok := w.(http.Hijacker)
-	if !ok {
-		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
-		return
+	if t.ProxyAuth != nil {
+		identity, err := t.ProxyAuth.Authenticate(r)
+		metrics.Metrics.ObserveProxyAuth(proxyAuthResult(err))
+		if err != nil {
+			klog.V(2).InfoS("Proxy authentication failed", "host", r.Host, "userAgent", r.UserAgent(), "err", err)
+			for _, challenge := range t.ProxyAuth.Challenges() {
+				w.Header().Add("Proxy-Authenticate", challenge)
+			}
+			http.Error(w, "proxy authentication required", http.StatusProxyAuthRequired)
+			return
+		}
+		clientIdentity = identity
+		klog.V(2).InfoS("Proxy authentication succeeded", "host", r.Host, "clientIdentity", clientIdentity)
 	}
-	w.WriteHeader(http.StatusOK)
 
-	conn, bufrw, err := hijacker.Hijack()
+	address, err := connectAddress(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	var closeOnce sync.Once
-	defer closeOnce.Do(func() { conn.Close() })
 
-	random := rand.Int63() /* #nosec G404 */
-	dialRequest := &client.Packet{
-		Type: client.PacketType_DIAL_REQ,
-		Payload: &client.Packet_DialRequest{
-			DialRequest: &client.DialRequest{
-				Protocol: "tcp",
-				Address:  r.Host,
-				Random:   random,
-			},
-		},
+	dial := t.Server.newFrontendDial("http-connect", address, clientIdentity, r.UserAgent())
+	defer dial.close()
+	// Continue the trace propagated by the client in the W3C trace context
+	// headers, if any.
+	ctx := r.Context()
+	if sc := tracing.ParseTraceparent(r.Header.Get("traceparent"), r.Header.Get("tracestate")); sc.IsValid() {
+		ctx = tracing.ContextWithSpanContext(ctx, sc)
 	}
-
-	klog.V(4).Infof("Set pending(rand=%d) to %v", random, w)
-	backend, err := t.Server.getBackend(r.Host)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("currently no tunnels available: %v", err), http.StatusInternalServerError)
+	// The response status depends on the result of the dial, so it is only
+	// written once the agent answered.
+	if err := dial.dial(ctx, t.DialTimeout); err != nil {
+		var dialErr *dialError
+		if errors.As(err, &dialErr) && dialErr.kind == dialCancelled {
+			return // The frontend went away.
+		}
+		http.Error(w, err.Error(), dialErrorStatus(err))
 		return
 	}
-	closed := make(chan struct{})
-	connected := make(chan struct{})
-	connection := &ProxyClientConnection{
-		Mode: "http-connect",
-		HTTP: io.ReadWriter(conn), // pass as ReadWriter so the caller must close with CloseHTTP
-		CloseHTTP: func() error {
-			closeOnce.Do(func() { conn.Close() })
-			close(closed)
-			return nil
-		},
-		connected: connected,
-		start:     time.Now(),
-		backend:   backend,
-	}
-	t.Server.PendingDial.Add(random, connection)
-	if err := backend.Send(dialRequest); err != nil {
-		klog.ErrorS(err, "failed to tunnel dial request")
-		return
+
+	// conn is the tunnel to the frontend: the hijacked connection of an
+	// HTTP/1 request, or the request and response bodies of an HTTP/2
+	// stream, so that many tunnels can share one client connection.
+	var conn io.ReadWriteCloser
+	var bufrw io.Reader
+	if r.ProtoMajor == 2 {
+		stream, ok := newHTTP2Stream(w, r)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+		defer stream.finish()
+		w.WriteHeader(http.StatusOK)
+		stream.flush()
+		conn, bufrw = stream, stream
+	} else {
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+
+		hijacked, rw, err := hijacker.Hijack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		conn, bufrw = hijacked, rw
+	}
+	dial.serve(conn, bufrw)
+}
+
+// dialErrorStatus is the status of the response to a CONNECT request whose
+// dial failed with err.
+func dialErrorStatus(err error) int {
+	var dialErr *dialError
+	if !errors.As(err, &dialErr) {
+		return http.StatusBadGateway
 	}
-	ctxt := backend.Context()
-	if ctxt.Err() != nil {
-		klog.ErrorS(err, "context reports failure")
+	switch dialErr.kind {
+	case dialNoAgent:
+		return http.StatusServiceUnavailable
+	case dialTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
 	}
+}
 
-	select {
-	case <-ctxt.Done():
-		klog.V(5).Infoln("context reports done")
+// proxyAuthResult is the result label of the proxy authentication metric.
+func proxyAuthResult(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, proxyauth.ErrNoCredentials):
+		return "missing"
+	case errors.Is(err, proxyauth.ErrInvalidCredentials):
+		return "invalid"
 	default:
+		return "error"
 	}
+}
 
-	select {
-	case <-connection.connected: // Waiting for response before we begin full communication.
-	case <-closed: // Connection was closed before being established
+// connectTCPPath is the default URI template path of the connect-tcp
+// extended CONNECT protocol, /.well-known/masque/tcp/{host}/{port}/.
+const connectTCPPath = "/.well-known/masque/tcp/"
+
+// connectAddress returns the destination of a CONNECT request: its
+// authority, or the host and port of the path of an HTTP/2 extended CONNECT
+// (RFC 8441) request of the connect-tcp protocol.
+func connectAddress(r *http.Request) (string, error) {
+	protocol := r.Header.Get(":protocol")
+	if protocol == "" {
+		return r.Host, nil
+	}
+	if protocol != "connect-tcp" {
+		return "", fmt.Errorf("extended CONNECT protocol %q is not supported", protocol)
 	}
+	target := strings.TrimPrefix(r.URL.Path, connectTCPPath)
+	parts := strings.Split(strings.TrimSuffix(target, "/"), "/")
+	if target == r.URL.Path || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("expected a %s{host}/{port}/ path, got %q", connectTCPPath, r.URL.Path)
+	}
+	host, err := url.PathUnescape(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("invalid host %q: %v", parts[0], err)
+	}
+	return net.JoinHostPort(host, parts[1]), nil
+}
 
-	defer func() {
-		packet := &client.Packet{
-			Type: client.PacketType_CLOSE_REQ,
-			Payload: &client.Packet_CloseRequest{
-				CloseRequest: &client.CloseRequest{
-					ConnectID: connection.connectID,
-				},
-			},
-		}
+// http2Stream is the tunnel of a CONNECT request served over an HTTP/2
+// stream: the data of the frontend is read from the request body, and the
+// data of the agent is written to the response body.
+type http2Stream struct {
+	body    io.ReadCloser
+	w       http.ResponseWriter
+	flusher http.Flusher
+
+	// mu protects w, which must not be used once the handler returned.
+	mu       sync.Mutex
+	finished bool
+}
 
-		if err = backend.Send(packet); err != nil {
-			klog.V(2).InfoS("failed to send close request packet", "host", r.Host, "agentID", connection.agentID, "connectionID", connection.connectID)
-		}
-		conn.Close()
-	}()
-
-	klog.V(3).InfoS("Starting proxy to host", "host", r.Host)
-	pkt := make([]byte, 1<<15) // Match GRPC Window size
-
-	connID := connection.connectID
-	agentID := connection.agentID
-	var acc int
-
-	for {
-		n, err := bufrw.Read(pkt[:])
-		acc += n
-		if err == io.EOF {
-			klog.V(1).InfoS("EOF from host", "host", r.Host)
-			break
-		}
-		if err != nil {
-			klog.ErrorS(err, "Received failure on connection")
-			break
-		}
+func newHTTP2Stream(w http.ResponseWriter, r *http.Request) (*http2Stream, bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, false
+	}
+	return &http2Stream{body: r.Body, w: w, flusher: flusher}, true
+}
 
-		packet := &client.Packet{
-			Type: client.PacketType_DATA,
-			Payload: &client.Packet_Data{
-				Data: &client.Data{
-					ConnectID: connID,
-					Data:      pkt[:n],
-				},
-			},
-		}
-		err = backend.Send(packet)
-		if err != nil {
-			klog.ErrorS(err, "error sending packet")
-			break
-		}
-		klog.V(5).InfoS("Forwarding data on tunnel to agent",
-			"bytes", n,
-			"totalBytes", acc,
-			"agentID", connection.agentID,
-			"connectionID", connection.connectID)
+func (s *http2Stream) Read(p []byte) (int, error) {
+	return s.body.Read(p)
+}
+
+func (s *http2Stream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.finished {
+		return 0, io.ErrClosedPipe
 	}
+	n, err := s.w.Write(p)
+	if err == nil {
+		s.flusher.Flush()
+	}
+	return n, err
+}
+
+func (s *http2Stream) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flusher.Flush()
+}
+
+// Close closes the request body, which ends the transfer to the agent.
+func (s *http2Stream) Close() error {
+	return s.body.Close()
+}
 
-	klog.V(5).InfoS("Stopping transfer to host", "host", r.Host, "agentID", agentID, "connectionID", connID)
+// finish prevents further writes, once the handler returns.
+func (s *http2Stream) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finished = true
 }
@@ -0,0 +1,219 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"google.golang.org/grpc/metadata"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
+	pkgagent "sigs.k8s.io/apiserver-network-proxy/pkg/agent"
+	agentmock "sigs.k8s.io/apiserver-network-proxy/proto/agent/mocks"
+	"sigs.k8s.io/apiserver-network-proxy/proto/header"
+)
+
+// TestConnectAddress covers the parsing of the destinations; the :protocol
+// pseudo-header set by the HTTP/2 server is covered in tests/.
+func TestConnectAddress(t *testing.T) {
+	for _, tc := range []struct {
+		desc     string
+		host     string
+		protocol string
+		path     string
+		want     string
+		wantErr  bool
+	}{
+		{desc: "authority", host: "node1.local:10250", want: "node1.local:10250"},
+		{desc: "connect-tcp", host: "proxy.local", protocol: "connect-tcp", path: "/.well-known/masque/tcp/node1.local/10250/", want: "node1.local:10250"},
+		{desc: "connect-tcp IPv6", host: "proxy.local", protocol: "connect-tcp", path: "/.well-known/masque/tcp/fd00::1/10250/", want: "[fd00::1]:10250"},
+		{desc: "connect-tcp without port", host: "proxy.local", protocol: "connect-tcp", path: "/.well-known/masque/tcp/node1.local/", wantErr: true},
+		{desc: "connect-tcp other path", host: "proxy.local", protocol: "connect-tcp", path: "/node1.local/10250/", wantErr: true},
+		{desc: "websocket", host: "proxy.local", protocol: "websocket", path: "/chat", wantErr: true},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			r := &http.Request{Method: http.MethodConnect, Host: tc.host, Header: http.Header{}, URL: &url.URL{Path: tc.path}}
+			if tc.protocol != "" {
+				r.Header.Set(":protocol", tc.protocol)
+			}
+			got, err := connectAddress(r)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+// connectSilentAgent connects an agent to s whose stream records the packets
+// sent to it, and calls answer, if not nil, with the DIAL_REQs.
+func connectSilentAgent(ctrl *gomock.Controller, s *ProxyServer, answer func(*client.DialRequest)) func() []client.PacketType {
+	var mu sync.Mutex
+	var sent []client.PacketType
+	conn := agentmock.NewMockAgentService_ConnectServer(ctrl)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(header.AgentID, "agent1"))
+	conn.EXPECT().Context().Return(ctx).AnyTimes()
+	conn.EXPECT().Send(gomock.Any()).DoAndReturn(func(pkt *client.Packet) error {
+		mu.Lock()
+		sent = append(sent, pkt.Type)
+		mu.Unlock()
+		if pkt.Type == client.PacketType_DIAL_REQ && answer != nil {
+			go answer(pkt.GetDialRequest())
+		}
+		return nil
+	}).AnyTimes()
+	for _, bm := range s.BackendManagers {
+		bm.AddBackend("agent1", pkgagent.UID, conn)
+	}
+	return func() []client.PacketType {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]client.PacketType(nil), sent...)
+	}
+}
+
+func newConnectRequest(address string) *http.Request {
+	r := httptest.NewRequest(http.MethodConnect, "http://"+address, nil)
+	r.Host = address
+	r.URL = &url.URL{Host: address}
+	return r
+}
+
+func TestTunnelDialTimeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	s := NewProxyServer("server-a", []ProxyStrategy{ProxyStrategyDefault}, 1, &AgentTokenAuthenticationOptions{})
+	timeouts := make(chan int64, 1)
+	sent := connectSilentAgent(ctrl, s, func(req *client.DialRequest) { timeouts <- req.TimeoutMillis })
+	tunnel := &Tunnel{Server: s, DialTimeout: 50 * time.Millisecond}
+
+	w := httptest.NewRecorder()
+	tunnel.ServeHTTP(w, newConnectRequest("node1.local:10250"))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected %d, got %d: %s", http.StatusGatewayTimeout, w.Code, w.Body)
+	}
+	want := []client.PacketType{client.PacketType_DIAL_REQ, client.PacketType_DIAL_CLS}
+	if got := sent(); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("expected packets %v sent to the agent, got %v", want, got)
+	}
+	if pd := s.PendingDial.pendingDial; len(pd) != 0 {
+		t.Errorf("expected no pending dial, got %v", pd)
+	}
+	// The agent is asked to dial for no longer than the server waits.
+	if timeout := <-timeouts; timeout != 50 {
+		t.Errorf("expected the agent to be asked to dial for 50ms, got %dms", timeout)
+	}
+}
+
+func TestTunnelDialFailure(t *testing.T) {
+	for _, tc := range []struct {
+		desc       string
+		pkt        func(random int64) *client.Packet
+		wantStatus int
+	}{{
+		desc: "refused",
+		pkt: func(random int64) *client.Packet {
+			return &client.Packet{
+				Type:    client.PacketType_DIAL_RSP,
+				Payload: &client.Packet_DialResponse{DialResponse: &client.DialResponse{Random: random, Error: "dial tcp 10.0.0.1:10250: connect: connection refused"}},
+			}
+		},
+		wantStatus: http.StatusBadGateway,
+	}, {
+		desc: "agent timeout",
+		pkt: func(random int64) *client.Packet {
+			return &client.Packet{
+				Type:    client.PacketType_DIAL_RSP,
+				Payload: &client.Packet_DialResponse{DialResponse: &client.DialResponse{Random: random, Error: "dial tcp 10.0.0.1:10250: i/o timeout"}},
+			}
+		},
+		wantStatus: http.StatusGatewayTimeout,
+	}, {
+		desc: "agent timeout code",
+		pkt: func(random int64) *client.Packet {
+			return &client.Packet{
+				Type:    client.PacketType_DIAL_RSP,
+				Payload: &client.Packet_DialResponse{DialResponse: &client.DialResponse{Random: random, Error: "dial tcp 10.0.0.1:10250: operation was canceled", ErrorCode: client.DialErrorCode_DIAL_ERROR_TIMEOUT}},
+			}
+		},
+		wantStatus: http.StatusGatewayTimeout,
+	}, {
+		// The code wins over the message, which mentions a timeout.
+		desc: "no such host code",
+		pkt: func(random int64) *client.Packet {
+			return &client.Packet{
+				Type:    client.PacketType_DIAL_RSP,
+				Payload: &client.Packet_DialResponse{DialResponse: &client.DialResponse{Random: random, Error: "dial tcp: lookup timeout.example.com: no such host", ErrorCode: client.DialErrorCode_DIAL_ERROR_NO_SUCH_HOST}},
+			}
+		},
+		wantStatus: http.StatusBadGateway,
+	}, {
+		desc: "dial closed",
+		pkt: func(random int64) *client.Packet {
+			return &client.Packet{
+				Type:    client.PacketType_DIAL_CLS,
+				Payload: &client.Packet_CloseDial{CloseDial: &client.CloseDial{Random: random}},
+			}
+		},
+		wantStatus: http.StatusBadGateway,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			s := NewProxyServer("server-a", []ProxyStrategy{ProxyStrategyDefault}, 1, &AgentTokenAuthenticationOptions{})
+			connectSilentAgent(ctrl, s, func(req *client.DialRequest) {
+				if frontend := s.PendingDial.Remove(req.Random); frontend != nil {
+					frontend.send(tc.pkt(req.Random))
+				}
+			})
+			tunnel := &Tunnel{Server: s, DialTimeout: wait.ForeverTestTimeout}
+
+			w := httptest.NewRecorder()
+			tunnel.ServeHTTP(w, newConnectRequest("node1.local:10250"))
+			if w.Code != tc.wantStatus {
+				t.Errorf("expected %d, got %d: %s", tc.wantStatus, w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestTunnelNoAgent(t *testing.T) {
+	s := NewProxyServer("server-a", []ProxyStrategy{ProxyStrategyDefault}, 1, &AgentTokenAuthenticationOptions{})
+	tunnel := &Tunnel{Server: s}
+
+	w := httptest.NewRecorder()
+	tunnel.ServeHTTP(w, newConnectRequest("node1.local:10250"))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected %d, got %d: %s", http.StatusServiceUnavailable, w.Code, w.Body)
+	}
+	if !strings.Contains(w.Body.String(), "no tunnels available") {
+		t.Errorf("unexpected body %q", w.Body)
+	}
+}
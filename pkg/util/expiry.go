@@ -0,0 +1,127 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ExpiryReason is why a connection expired.
+type ExpiryReason string
+
+const (
+	ExpiryIdleTimeout ExpiryReason = "idle_timeout" // No data was transferred for the idle timeout.
+	ExpiryMaxLifetime ExpiryReason = "max_lifetime" // The connection was open for the maximum lifetime.
+)
+
+// ExpiryTimer expires a connection once no data was transferred for an idle
+// timeout, or once it is open for a maximum lifetime. A nil ExpiryTimer
+// never expires.
+type ExpiryTimer struct {
+	idleTimeout time.Duration
+	maxLifetime time.Duration
+	start       time.Time
+	// lastActive is the UnixNano time of the last transfer.
+	lastActive atomic.Int64
+	expire     func(ExpiryReason)
+
+	mu      sync.Mutex // protects timer and stopped
+	timer   *time.Timer
+	stopped bool
+}
+
+// NewExpiryTimer returns a timer which calls expire, once, when the
+// connection is idle for idleTimeout or open for maxLifetime. Either limit is
+// disabled if not positive; the timer is nil if both are.
+func NewExpiryTimer(idleTimeout, maxLifetime time.Duration, expire func(ExpiryReason)) *ExpiryTimer {
+	if idleTimeout <= 0 && maxLifetime <= 0 {
+		return nil
+	}
+	t := &ExpiryTimer{
+		idleTimeout: idleTimeout,
+		maxLifetime: maxLifetime,
+		start:       time.Now(),
+		expire:      expire,
+	}
+	t.lastActive.Store(t.start.UnixNano())
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.timer = time.AfterFunc(t.next(t.start), t.check)
+	return t
+}
+
+// Touch records a transfer on the connection.
+func (t *ExpiryTimer) Touch() {
+	if t == nil {
+		return
+	}
+	t.lastActive.Store(time.Now().UnixNano())
+}
+
+// Stop stops the timer of a connection which is closed.
+func (t *ExpiryTimer) Stop() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopped = true
+	t.timer.Stop()
+}
+
+// next returns the time until the connection may expire, as of now.
+func (t *ExpiryTimer) next(now time.Time) time.Duration {
+	var next time.Duration
+	if t.idleTimeout > 0 {
+		next = time.Unix(0, t.lastActive.Load()).Add(t.idleTimeout).Sub(now)
+	}
+	if t.maxLifetime > 0 {
+		if lifetime := t.start.Add(t.maxLifetime).Sub(now); t.idleTimeout <= 0 || lifetime < next {
+			next = lifetime
+		}
+	}
+	return next
+}
+
+// check expires the connection if one of the limits was reached, and
+// otherwise waits for the next one.
+func (t *ExpiryTimer) check() {
+	now := time.Now()
+	var reason ExpiryReason
+	switch {
+	case t.maxLifetime > 0 && now.Sub(t.start) >= t.maxLifetime:
+		reason = ExpiryMaxLifetime
+	case t.idleTimeout > 0 && now.Sub(time.Unix(0, t.lastActive.Load())) >= t.idleTimeout:
+		reason = ExpiryIdleTimeout
+	}
+
+	t.mu.Lock()
+	if t.stopped {
+		t.mu.Unlock()
+		return
+	}
+	if reason == "" {
+		t.timer.Reset(t.next(now))
+		t.mu.Unlock()
+		return
+	}
+	t.stopped = true
+	t.mu.Unlock()
+	t.expire(reason)
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpiryTimer(t *testing.T) {
+	if timer := NewExpiryTimer(0, 0, func(ExpiryReason) { t.Error("unexpected expiry") }); timer != nil {
+		t.Errorf("expected no timer without limits, got %v", timer)
+	}
+
+	for _, tc := range []struct {
+		name        string
+		idleTimeout time.Duration
+		maxLifetime time.Duration
+		want        ExpiryReason
+	}{
+		{name: "idle", idleTimeout: 50 * time.Millisecond, want: ExpiryIdleTimeout},
+		{name: "lifetime", maxLifetime: 200 * time.Millisecond, want: ExpiryMaxLifetime},
+		{name: "active until lifetime", idleTimeout: 50 * time.Millisecond, maxLifetime: 200 * time.Millisecond, want: ExpiryMaxLifetime},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			expired := make(chan ExpiryReason, 1)
+			start := time.Now()
+			timer := NewExpiryTimer(tc.idleTimeout, tc.maxLifetime, func(reason ExpiryReason) { expired <- reason })
+			defer timer.Stop()
+
+			// The transfers keep the connection from being idle.
+			touch := time.NewTicker(10 * time.Millisecond)
+			defer touch.Stop()
+			if tc.want == ExpiryIdleTimeout {
+				touch.Stop()
+			}
+			for {
+				select {
+				case <-touch.C:
+					timer.Touch()
+					continue
+				case reason := <-expired:
+					if reason != tc.want {
+						t.Errorf("expected %q, got %q", tc.want, reason)
+					}
+					limit := tc.idleTimeout
+					if tc.want == ExpiryMaxLifetime {
+						limit = tc.maxLifetime
+					}
+					if elapsed := time.Since(start); elapsed < limit {
+						t.Errorf("expected expiry after %v, got %v", limit, elapsed)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("timed out waiting for the expiry")
+				}
+				break
+			}
+		})
+	}
+}
+
+func TestExpiryTimerStop(t *testing.T) {
+	timer := NewExpiryTimer(10*time.Millisecond, 0, func(ExpiryReason) { t.Error("unexpected expiry of a stopped timer") })
+	timer.Stop()
+	time.Sleep(50 * time.Millisecond)
+
+	// A nil timer is a no-op.
+	var none *ExpiryTimer
+	none.Touch()
+	none.Stop()
+}
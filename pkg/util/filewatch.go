@@ -0,0 +1,87 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// FileWatcher reads a file, and reads it again whenever it changes on disk,
+// e.g. when a ConfigMap is updated. Each version of the file is passed to
+// a load function, which parses it and applies it; a version which fails to
+// load is not retried until the file changes again, and the previously
+// loaded one stays in use.
+type FileWatcher struct {
+	// name describes the file in errors and logs, e.g. "peer list".
+	name string
+	path string
+	load func(data []byte) error
+
+	stamp fileStamp
+}
+
+// NewFileWatcher reads the file at path and passes it to load. It fails if
+// the file can't be read or load fails. name describes the file in errors
+// and logs.
+func NewFileWatcher(name, path string, load func(data []byte) error) (*FileWatcher, error) {
+	w := &FileWatcher{name: name, path: path, load: load}
+	if err := w.read(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Run checks the file for changes every interval, until stopCh is closed.
+func (w *FileWatcher) Run(interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(w.ReloadIfChanged, interval, stopCh)
+}
+
+// ReloadIfChanged reads the file again if it changed since it was last
+// read. It is called by Run, and may be used instead of it by the callers
+// which act on the loaded file in the same loop.
+func (w *FileWatcher) ReloadIfChanged() {
+	stamp, err := statFile(w.path)
+	if err == nil && stamp == w.stamp {
+		return
+	}
+	if err := w.read(); err != nil {
+		klog.ErrorS(err, "Failed to reload file, keeping the previous version", "file", w.name, "path", w.path)
+	}
+}
+
+func (w *FileWatcher) read() error {
+	stamp, err := statFile(w.path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", w.name, err)
+	}
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", w.name, err)
+	}
+	// The version is recorded even if it fails to load, so that it is not
+	// retried until the file changes again.
+	w.stamp = stamp
+	if err := w.load(data); err != nil {
+		return fmt.Errorf("failed to parse %s %s: %v", w.name, w.path, err)
+	}
+	return nil
+}
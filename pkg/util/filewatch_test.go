@@ -0,0 +1,82 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileWatcher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	modTime := time.Now().Add(-time.Hour)
+	writeFile(t, path, []byte("v1"), modTime)
+
+	var loaded []string
+	w, err := NewFileWatcher("test file", path, func(data []byte) error {
+		loaded = append(loaded, string(data))
+		if string(data) == "invalid" {
+			return errors.New("invalid")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectLoaded := func(want ...string) {
+		t.Helper()
+		if len(loaded) != len(want) {
+			t.Fatalf("expected %q to be loaded, got %q", want, loaded)
+		}
+		for i := range want {
+			if loaded[i] != want[i] {
+				t.Fatalf("expected %q to be loaded, got %q", want, loaded)
+			}
+		}
+	}
+
+	// An unchanged file is not read again.
+	w.ReloadIfChanged()
+	expectLoaded("v1")
+
+	modTime = modTime.Add(time.Second)
+	writeFile(t, path, []byte("v2"), modTime)
+	w.ReloadIfChanged()
+	expectLoaded("v1", "v2")
+
+	// An invalid version is not retried until the file changes again.
+	modTime = modTime.Add(time.Second)
+	writeFile(t, path, []byte("invalid"), modTime)
+	w.ReloadIfChanged()
+	w.ReloadIfChanged()
+	expectLoaded("v1", "v2", "invalid")
+
+	modTime = modTime.Add(time.Second)
+	writeFile(t, path, []byte("v3"), modTime)
+	w.ReloadIfChanged()
+	expectLoaded("v1", "v2", "invalid", "v3")
+
+	if _, err := NewFileWatcher("test file", path, func([]byte) error { return errors.New("invalid") }); err == nil {
+		t.Error("expected the invalid file to be rejected")
+	}
+	if _, err := NewFileWatcher("test file", filepath.Join(t.TempDir(), "missing"), func([]byte) error { return nil }); err == nil {
+		t.Error("expected the missing file to be rejected")
+	}
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// ServerTLSReloader serves a server key pair, and optionally a client CA
+// pool, which are reloaded when their files change on disk. Handshakes
+// always use the most recently loaded files, so certificates can be rotated
+// without restarting the listeners or dropping established connections.
+type ServerTLSReloader struct {
+	caFile, certFile, keyFile string
+	cipherSuites              []uint16
+	// onReload, if set, is called with the result of every reload attempt.
+	onReload func(err error)
+
+	mu    sync.RWMutex // protects the following
+	cert  *tls.Certificate
+	pool  *x509.CertPool
+	files map[string]fileStamp
+}
+
+// fileStamp identifies a version of a file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewServerTLSReloader loads the key pair from certFile and keyFile, and the
+// client CA pool from caFile unless it is empty. onReload, if not nil, is
+// called with the result of every subsequent reload.
+func NewServerTLSReloader(caFile, certFile, keyFile string, cipherSuites []uint16, onReload func(err error)) (*ServerTLSReloader, error) {
+	r := &ServerTLSReloader{
+		caFile:       caFile,
+		certFile:     certFile,
+		keyFile:      keyFile,
+		cipherSuites: cipherSuites,
+		onReload:     onReload,
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns a server TLS config serving the current key pair and
+// verifying clients against the current CA pool, if any. nextProtos are
+// advertised through ALPN.
+func (r *ServerTLSReloader) TLSConfig(nextProtos ...string) *tls.Config {
+	base := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		CipherSuites: r.cipherSuites,
+		NextProtos:   nextProtos,
+	}
+	if r.caFile != "" {
+		base.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	config := base.Clone()
+	config.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return r.cert, nil
+	}
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		c := base.Clone()
+		c.Certificates = []tls.Certificate{*r.cert}
+		c.ClientCAs = r.pool
+		return c, nil
+	}
+	return config
+}
+
+// Run checks the files for changes every interval, until stopCh is closed.
+func (r *ServerTLSReloader) Run(interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(r.reloadIfChanged, interval, stopCh)
+}
+
+// reloadIfChanged reloads the files if any of them changed.
+func (r *ServerTLSReloader) reloadIfChanged() {
+	if !r.changed() {
+		return
+	}
+	err := r.load()
+	if err != nil {
+		klog.ErrorS(err, "Failed to reload TLS files, keeping the previous ones", "certFile", r.certFile, "keyFile", r.keyFile, "caFile", r.caFile)
+	} else {
+		klog.V(1).InfoS("Reloaded TLS files", "certFile", r.certFile, "keyFile", r.keyFile, "caFile", r.caFile)
+	}
+	if r.onReload != nil {
+		r.onReload(err)
+	}
+}
+
+func (r *ServerTLSReloader) paths() []string {
+	paths := []string{r.certFile, r.keyFile}
+	if r.caFile != "" {
+		paths = append(paths, r.caFile)
+	}
+	return paths
+}
+
+// changed reports whether any of the files differs from the last load.
+func (r *ServerTLSReloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, path := range r.paths() {
+		stamp, err := statFile(path)
+		if err != nil {
+			// Reloading reports the error.
+			return true
+		}
+		if stamp != r.files[path] {
+			return true
+		}
+	}
+	return false
+}
+
+// load reads all the files, and only replaces the served ones if they are all
+// valid. The versions of the files are recorded even if loading fails, so
+// that an invalid version is not retried until the files change again.
+func (r *ServerTLSReloader) load() error {
+	files := make(map[string]fileStamp)
+	for _, path := range r.paths() {
+		stamp, err := statFile(path)
+		if err != nil {
+			return err
+		}
+		files[path] = stamp
+	}
+	cert, pool, err := r.read()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files = files
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.pool = pool
+	return nil
+}
+
+func (r *ServerTLSReloader) read() (tls.Certificate, *x509.CertPool, error) {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return cert, nil, fmt.Errorf("failed to load X509 key pair %s and %s: %v", r.certFile, r.keyFile, err)
+	}
+	if r.caFile == "" {
+		return cert, nil, nil
+	}
+	pool, err := getCACertPool(r.caFile)
+	return cert, pool, err
+}
+
+func statFile(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert returns a certificate for commonName, signed by parent or
+// self-signed if parent is nil.
+func newTestCert(t *testing.T, commonName string, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              []string{commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// writeFile writes data to path, making sure the modification time changes.
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// handshake connects a client presenting clientCert to a server using
+// serverConfig.
+func handshake(t *testing.T, serverConfig *tls.Config, roots *x509.CertPool, clientCert *testCert) error {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	serverErr := make(chan error, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		server := tls.Server(conn, serverConfig)
+		serverErr <- server.Handshake()
+		server.Close()
+	}()
+
+	cert, err := tls.X509KeyPair(clientCert.certPEM, clientCert.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := tls.Dial("tcp", lis.Addr().String(), &tls.Config{
+		RootCAs:      roots,
+		ServerName:   "server",
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	})
+	if err == nil {
+		// With TLS 1.3 the server verifies the client certificate after the
+		// client completed the handshake; wait for the server to close the
+		// connection and read the outcome.
+		if _, rErr := client.Read(make([]byte, 1)); rErr != io.EOF {
+			err = rErr
+		}
+		client.Close()
+	}
+	if sErr := <-serverErr; sErr != nil {
+		err = sErr
+	}
+	return err
+}
+
+func TestServerTLSReloader(t *testing.T) {
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+
+	oldCA := newTestCert(t, "old-ca", nil)
+	newCA := newTestCert(t, "new-ca", nil)
+	roots := x509.NewCertPool()
+	roots.AddCert(oldCA.cert)
+	roots.AddCert(newCA.cert)
+	oldServer := newTestCert(t, "server", oldCA)
+	newServer := newTestCert(t, "server", newCA)
+	oldClient := newTestCert(t, "old-client", oldCA)
+	newClient := newTestCert(t, "new-client", newCA)
+
+	modTime := time.Now().Add(-time.Hour)
+	writeFile(t, caFile, oldCA.certPEM, modTime)
+	writeFile(t, certFile, oldServer.certPEM, modTime)
+	writeFile(t, keyFile, oldServer.keyPEM, modTime)
+
+	var reloadErrs []error
+	r, err := NewServerTLSReloader(caFile, certFile, keyFile, nil, func(err error) {
+		reloadErrs = append(reloadErrs, err)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := r.TLSConfig()
+
+	if err := handshake(t, config, roots, oldClient); err != nil {
+		t.Fatalf("expected handshake with the old client certificate to succeed: %v", err)
+	}
+	if err := handshake(t, config, roots, newClient); err == nil {
+		t.Fatal("expected handshake with a client certificate of the new CA to fail before reloading")
+	}
+	if r.changed() {
+		t.Error("expected the files to be unchanged")
+	}
+
+	// An invalid key pair is rejected, and the previous one is kept.
+	modTime = modTime.Add(time.Minute)
+	writeFile(t, certFile, newServer.certPEM, modTime)
+	r.reloadIfChanged()
+	if len(reloadErrs) != 1 || reloadErrs[0] == nil {
+		t.Fatalf("expected a failed reload, got %v", reloadErrs)
+	}
+	if err := handshake(t, config, roots, oldClient); err != nil {
+		t.Fatalf("expected the previous files to be served after a failed reload: %v", err)
+	}
+
+	// Completing the rotation reloads the key pair and the CA pool.
+	modTime = modTime.Add(time.Minute)
+	writeFile(t, keyFile, newServer.keyPEM, modTime)
+	writeFile(t, caFile, newCA.certPEM, modTime)
+	r.reloadIfChanged()
+	if len(reloadErrs) != 2 || reloadErrs[1] != nil {
+		t.Fatalf("expected a successful reload, got %v", reloadErrs)
+	}
+	if err := handshake(t, config, roots, newClient); err != nil {
+		t.Fatalf("expected handshake with the new client certificate to succeed: %v", err)
+	}
+	if err := handshake(t, config, roots, oldClient); err == nil {
+		t.Fatal("expected handshake with a client certificate of the old CA to fail after reloading")
+	}
+	if cert, _ := config.GetCertificate(nil); !bytes.Equal(cert.Certificate[0], newServer.cert.Raw) {
+		t.Error("expected GetCertificate to return the new server certificate")
+	}
+
+	// Nothing is reloaded while the files are unchanged.
+	r.reloadIfChanged()
+	if len(reloadErrs) != 2 {
+		t.Errorf("expected no reload of unchanged files, got %v", reloadErrs)
+	}
+}
@@ -19,6 +19,7 @@ package header
 const (
 	ServerCount      = "serverCount"
 	ServerID         = "serverID"
+	ServerIDs        = "serverIDs"
 	AgentID          = "agentID"
 	AgentIdentifiers = "agentIdentifiers"
 	// AuthenticationTokenContextKey will be used as a key to store authentication tokens in grpc call
@@ -31,4 +32,8 @@ const (
 
 	// UserAgent is used to provide the client information in a proxy request
 	UserAgent = "user-agent"
+
+	// ForwardedBy carries the ID of the proxy server which forwarded a proxy
+	// request to a peer. Forwarded requests are never forwarded again.
+	ForwardedBy = "forwardedBy"
 )
@@ -22,6 +22,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 
 	"google.golang.org/grpc"
@@ -155,3 +156,93 @@ func BenchmarkLargeRequest_GRPC(b *testing.B) {
 		b.StopTimer() // END CRITICAL SECTION
 	}
 }
+
+// BenchmarkDialDuringLargeRequest_GRPC measures the dials through an agent
+// whose stream is busy with the DATA packets of another tunnel. The dial
+// requests are sent ahead of the queued DATA packets.
+func BenchmarkDialDuringLargeRequest_GRPC(b *testing.B) {
+	b.StopTimer()
+
+	expectCleanShutdown(b)
+
+	const length = 10 << 20 // 10M
+
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	proxy, cleanup, err := runGRPCProxyServer()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer cleanup()
+
+	clientset := runAgent(proxy.agent, stopCh)
+	waitForConnectedServerCount(b, 1, clientset)
+
+	// Keep the agent stream busy with concurrent large requests.
+	const uploads = 64
+	uploadStopCh := make(chan struct{})
+	var uploadWG sync.WaitGroup
+	bodyBytes := make([]byte, length)
+	for i := 0; i < uploads; i++ {
+		uploadWG.Add(1)
+		go func() {
+			defer uploadWG.Done()
+			for {
+				select {
+				case <-uploadStopCh:
+					return
+				default:
+				}
+				tunnel, err := client.CreateSingleUseGrpcTunnel(ctx, proxy.front, grpc.WithInsecure())
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				c := &http.Client{
+					Transport: &http.Transport{
+						DialContext: tunnel.DialContext,
+					},
+				}
+				r, err := c.Post(server.URL, "application/octet-stream", bytes.NewReader(bodyBytes))
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				r.Body.Close()
+				c.CloseIdleConnections()
+			}
+		}()
+	}
+	defer func() {
+		close(uploadStopCh)
+		uploadWG.Wait()
+	}()
+
+	address := server.Listener.Addr().String()
+	for n := 0; n < b.N; n++ {
+		tunnel, err := client.CreateSingleUseGrpcTunnel(ctx, proxy.front, grpc.WithInsecure())
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.StartTimer() // BEGIN CRITICAL SECTION
+
+		conn, err := tunnel.DialContext(ctx, "tcp", address)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.StopTimer() // END CRITICAL SECTION
+
+		conn.Close()
+	}
+}
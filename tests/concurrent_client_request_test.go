@@ -98,6 +98,15 @@ func (s *singleTimeManager) RemoveBackend(agentID string, _ pkgagent.IdentifierT
 	delete(s.backends, agentID)
 }
 
+func (s *singleTimeManager) UpdateBackend(conn agent.AgentService_ConnectServer, removed, added []server.BackendIdentifier) {
+	for _, id := range removed {
+		s.RemoveBackend(id.ID, id.Type, conn)
+	}
+	for _, id := range added {
+		s.AddBackend(id.ID, id.Type, conn)
+	}
+}
+
 func (s *singleTimeManager) Backend(_ context.Context) (server.Backend, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
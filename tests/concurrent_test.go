@@ -193,9 +193,11 @@ func TestAgent_MultipleConn(t *testing.T) {
 
 			// Running an agent with the same ID simulates a second connection from the same agent.
 			// This simulates the scenario where a proxy agent established connections with HA proxy server
-			// and creates multiple connections with the same proxy server
-			cs2 := runAgentWithID("multipleAgentConn", proxy.agent, stopCh2)
-			waitForConnectedServerCount(t, 1, cs2)
+			// and creates multiple connections with the same proxy server. The server tells the agent to
+			// drain the second connection, which has no connections and is closed right away.
+			drained := drainedAgentStreams(t)
+			runAgentWithID("multipleAgentConn", proxy.agent, stopCh2)
+			waitForDrainedAgentStreams(t, drained+1)
 			close(stopCh2)
 			// Wait for the server to run cleanup routine
 			waitForConnectedAgentCount(t, 1, proxy.server)
@@ -0,0 +1,90 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	netproxy "golang.org/x/net/proxy"
+	"google.golang.org/grpc"
+
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/pkg/client"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server"
+)
+
+// TestMultipleFrontends serves a grpc and a socks5 frontend from the same
+// proxy server, and so the same agents.
+func TestMultipleFrontends(t *testing.T) {
+	target := httptest.NewServer(newEchoServer("hello"))
+	defer target.Close()
+	targetURL, _ := url.Parse(target.URL)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	proxy, s, cleanup, err := runGRPCProxyServerWithServerCount(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	front := listenSOCKS5(t)
+	socks5 := &server.SOCKS5{Server: s}
+	go socks5.Serve(front)
+	defer front.Close()
+
+	clientset := runAgent(proxy.agent, stopCh)
+	waitForConnectedServerCount(t, 1, clientset)
+
+	tunnel, err := client.CreateSingleUseGrpcTunnel(context.Background(), proxy.front, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &http.Client{
+		Transport: &http.Transport{
+			DialContext: tunnel.DialContext,
+		},
+	}
+	r, err := c.Get(target.URL)
+	if err != nil {
+		t.Fatalf("grpc frontend: %v", err)
+	}
+	data, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		t.Fatalf("grpc frontend: %v", err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("grpc frontend: expect %v; got %v", "hello", string(data))
+	}
+
+	dialer, err := netproxy.SOCKS5("tcp", front.Addr().String(), nil, netproxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	socksData, err := getThroughSOCKS5(dialer, targetURL.Host)
+	if err != nil {
+		t.Fatalf("socks5 frontend: %v", err)
+	}
+	if socksData != "hello" {
+		t.Errorf("socks5 frontend: expect %v; got %v", "hello", socksData)
+	}
+}
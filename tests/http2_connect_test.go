@@ -0,0 +1,174 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/uuid"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server"
+	agentproto "sigs.k8s.io/apiserver-network-proxy/proto/agent"
+)
+
+// runHTTP2ConnProxyServer runs an http-connect proxy server accepting
+// CONNECT requests over HTTP/2 without TLS, as over UDS.
+func runHTTP2ConnProxyServer(t *testing.T) proxy {
+	var proxy proxy
+	s := server.NewProxyServer(uuid.New().String(), []server.ProxyStrategy{server.ProxyStrategyDefault}, 0, &server.AgentTokenAuthenticationOptions{})
+	agentServer := grpc.NewServer()
+	agentproto.RegisterAgentServiceServer(agentServer, s)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go agentServer.Serve(lis)
+	t.Cleanup(agentServer.Stop)
+	proxy.agent = lis.Addr().String()
+
+	front := httptest.NewServer(h2c.NewHandler(&server.Tunnel{Server: s}, &http2.Server{}))
+	t.Cleanup(front.Close)
+	proxy.front = front.Listener.Addr().String()
+	return proxy
+}
+
+func TestBasicProxy_HTTP2CONN(t *testing.T) {
+	target := httptest.NewServer(newEchoServer("hello"))
+	defer target.Close()
+	targetURL, _ := url.Parse(target.URL)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	proxy := runHTTP2ConnProxyServer(t)
+	clientset := runAgent(proxy.agent, stopCh)
+	waitForConnectedServerCount(t, 1, clientset)
+
+	var dials int32
+	transport := &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			return net.Dial(network, addr)
+		},
+	}
+	defer transport.CloseIdleConnections()
+
+	// Both tunnels share the client connection to the proxy server.
+	for i := 0; i < 2; i++ {
+		pr, pw := io.Pipe()
+		req, err := http.NewRequest(http.MethodConnect, "http://"+proxy.front, pr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = targetURL.Host
+		res, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("expect 200; got %d", res.StatusCode)
+		}
+
+		if _, err := fmt.Fprintf(pw, "GET / HTTP/1.1\r\nHost: %s\r\n\r\n", targetURL.Host); err != nil {
+			t.Fatal(err)
+		}
+		r, err := http.ReadResponse(bufio.NewReader(res.Body), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(r.Body, r.ContentLength))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "hello" {
+			t.Errorf("expect %v; got %v", "hello", string(data))
+		}
+		pw.Close()
+		res.Body.Close()
+	}
+	if got := atomic.LoadInt32(&dials); got != 1 {
+		t.Errorf("expect one connection to the proxy server; got %d", got)
+	}
+}
+
+func TestExtendedConnect_HTTP2CONN(t *testing.T) {
+	target := httptest.NewServer(newEchoServer("hello"))
+	defer target.Close()
+	targetURL, _ := url.Parse(target.URL)
+	host, port, _ := net.SplitHostPort(targetURL.Host)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	proxy := runHTTP2ConnProxyServer(t)
+	clientset := runAgent(proxy.agent, stopCh)
+	waitForConnectedServerCount(t, 1, clientset)
+
+	transport := &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}
+	defer transport.CloseIdleConnections()
+
+	// The destination is in the path of the connect-tcp protocol.
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest(http.MethodConnect, fmt.Sprintf("http://%s/.well-known/masque/tcp/%s/%s/", proxy.front, host, port), pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(":protocol", "connect-tcp")
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expect 200; got %d", res.StatusCode)
+	}
+
+	if _, err := fmt.Fprintf(pw, "GET / HTTP/1.1\r\nHost: %s\r\n\r\n", targetURL.Host); err != nil {
+		t.Fatal(err)
+	}
+	r, err := http.ReadResponse(bufio.NewReader(res.Body), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, r.ContentLength))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("expect %v; got %v", "hello", string(data))
+	}
+	pw.Close()
+}
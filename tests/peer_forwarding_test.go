@@ -0,0 +1,130 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/apiserver-network-proxy/konnectivity-client/pkg/client"
+	clientproto "sigs.k8s.io/apiserver-network-proxy/konnectivity-client/proto/client"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server"
+	metricstest "sigs.k8s.io/apiserver-network-proxy/pkg/testing/metrics"
+	"sigs.k8s.io/apiserver-network-proxy/proto/header"
+	peerproto "sigs.k8s.io/apiserver-network-proxy/proto/peer"
+)
+
+// runPeerServer serves the PeerService and ProxyService of s to its peers,
+// without TLS.
+func runPeerServer(s *server.ProxyServer) (string, func(), error) {
+	grpcServer := grpc.NewServer()
+	peerproto.RegisterPeerServiceServer(grpcServer, s)
+	clientproto.RegisterProxyServiceServer(grpcServer, s)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	go grpcServer.Serve(lis)
+	return lis.Addr().String(), grpcServer.Stop, nil
+}
+
+func getThroughTunnel(ctx context.Context, front, target string) (string, error) {
+	tunnel, err := client.CreateSingleUseGrpcTunnel(ctx, front, grpc.WithInsecure())
+	if err != nil {
+		return "", err
+	}
+	c := &http.Client{
+		Transport: &http.Transport{
+			DialContext: tunnel.DialContext,
+		},
+		Timeout: 1 * time.Second,
+	}
+	defer c.CloseIdleConnections()
+	r, err := c.Get(target)
+	if err != nil {
+		return "", err
+	}
+	defer r.Body.Close()
+	data, err := ioutil.ReadAll(r.Body)
+	return string(data), err
+}
+
+func TestPeerForwarding_GRPC(t *testing.T) {
+	target := httptest.NewServer(newEchoServer("hello"))
+	defer target.Close()
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	// The agent only connects to proxy B.
+	proxyA, cleanupA, err := runGRPCProxyServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanupA()
+	proxyB, cleanupB, err := runGRPCProxyServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanupB()
+	peerB, stopPeerB, err := runPeerServer(proxyB.server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stopPeerB()
+
+	clientset := runAgent(proxyB.agent, stopCh)
+	waitForConnectedServerCount(t, 1, clientset)
+
+	proxyA.server.Peers = server.NewPeers("proxy-a", []string{peerB}, nil)
+	go proxyA.server.Peers.Run(100*time.Millisecond, stopCh)
+
+	// Proxy A forwards the dial to proxy B, once it learnt about its agent.
+	var got string
+	err = wait.PollImmediate(100*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		got, err = getThroughTunnel(context.Background(), proxyA.front, target.URL)
+		return err == nil, nil
+	})
+	if err != nil {
+		t.Fatalf("expected the dial to be forwarded to the peer: %v", err)
+	}
+	if got != "hello" {
+		t.Errorf("expect %v; got %v", "hello", got)
+	}
+	// Closing the tunnel through proxy A closes the connection of the agent.
+	err = wait.PollImmediate(100*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		return metricstest.ExpectAgentEndpointConnections(0) == nil, nil
+	})
+	if err != nil {
+		t.Errorf("expected the agent to close the forwarded connection: %v", metricstest.ExpectAgentEndpointConnections(0))
+	}
+
+	// A forwarded dial is never forwarded again.
+	ctx := metadata.AppendToOutgoingContext(context.Background(), header.ForwardedBy, "proxy-c")
+	if _, err := getThroughTunnel(ctx, proxyA.front, target.URL); err == nil || !strings.Contains(err.Error(), "No agent available") {
+		t.Errorf("expected the forwarded dial to fail for lack of agent, got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server/proxyauth"
+)
+
+func TestProxyAuth_HTTPCONN(t *testing.T) {
+	target := httptest.NewServer(newEchoServer("hello"))
+	defer target.Close()
+	targetURL, _ := url.Parse(target.URL)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	tokenFile := filepath.Join(t.TempDir(), "tokens.csv")
+	if err := os.WriteFile(tokenFile, []byte("s3cr3t,apiserver\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := proxyauth.NewStaticTokens(tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy, cleanup, err := runHTTPConnProxyServerWithAuth(&proxyauth.Chain{
+		Realm:          "konnectivity",
+		Authenticators: []proxyauth.Authenticator{tokens},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	clientset := runAgent(proxy.agent, stopCh)
+	waitForConnectedServerCount(t, 1, clientset)
+
+	connect := func(authorization string) (net.Conn, *http.Response) {
+		conn, err := net.Dial("tcp", proxy.front)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", targetURL.Host, "127.0.0.1")
+		if authorization != "" {
+			req += "Proxy-Authorization: " + authorization + "\r\n"
+		}
+		if _, err := fmt.Fprint(conn, req+"\r\n"); err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatalf("reading HTTP response from CONNECT: %v", err)
+		}
+		return conn, res
+	}
+
+	// Requests without valid credentials are challenged.
+	for _, authorization := range []string{"", "Bearer wrong", "Basic YWRtaW46YWRtaW4="} {
+		conn, res := connect(authorization)
+		conn.Close()
+		if res.StatusCode != http.StatusProxyAuthRequired {
+			t.Errorf("expect 407 for %q; got %d", authorization, res.StatusCode)
+		}
+		if got, want := res.Header.Values("Proxy-Authenticate"), []string{`Bearer realm="konnectivity"`}; !reflect.DeepEqual(got, want) {
+			t.Errorf("expect challenges %v; got %v", want, got)
+		}
+	}
+
+	conn, res := connect("Bearer s3cr3t")
+	defer conn.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expect 200; got %d", res.StatusCode)
+	}
+	c := &http.Client{
+		Transport: &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) { return conn, nil },
+		},
+	}
+	r, err := c.Get(target.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("expect %v; got %v", "hello", string(data))
+	}
+}
@@ -34,6 +34,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/goleak"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
@@ -47,6 +48,7 @@ import (
 	metricsagent "sigs.k8s.io/apiserver-network-proxy/pkg/agent/metrics"
 	"sigs.k8s.io/apiserver-network-proxy/pkg/server"
 	metricsserver "sigs.k8s.io/apiserver-network-proxy/pkg/server/metrics"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server/proxyauth"
 	metricstest "sigs.k8s.io/apiserver-network-proxy/pkg/testing/metrics"
 	agentproto "sigs.k8s.io/apiserver-network-proxy/proto/agent"
 	"sigs.k8s.io/apiserver-network-proxy/proto/header"
@@ -695,59 +697,12 @@ func TestFailedDNSLookupProxy_HTTPCONN(t *testing.T) {
 	clientset := runAgent(proxy.agent, stopCh)
 	waitForConnectedServerCount(t, 1, clientset)
 
-	conn, err := net.Dial("tcp", proxy.front)
-	if err != nil {
-		t.Error(err)
+	res, body := connectHTTPCONN(t, proxy.front, "thissssssxxxxx.com:80")
+	if res.StatusCode != http.StatusBadGateway {
+		t.Errorf("expect %d; got %d", http.StatusBadGateway, res.StatusCode)
 	}
-
-	urlString := "http://thissssssxxxxx.com:80"
-	serverURL, _ := url.Parse(urlString)
-
-	// Send HTTP-Connect request
-	_, err = fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", serverURL.Host, "127.0.0.1")
-	if err != nil {
-		t.Error(err)
-	}
-
-	// Parse the HTTP response for Connect
-	br := bufio.NewReader(conn)
-	res, err := http.ReadResponse(br, nil)
-	if err != nil {
-		t.Errorf("reading HTTP response from CONNECT: %v", err)
-	}
-
-	if res.StatusCode != 200 {
-		t.Errorf("expect 200; got %d", res.StatusCode)
-	}
-	if br.Buffered() > 0 {
-		t.Error("unexpected extra buffer")
-	}
-	dialer := func(network, addr string) (net.Conn, error) {
-		return conn, nil
-	}
-
-	c := &http.Client{
-		Transport: &http.Transport{
-			Dial: dialer,
-		},
-	}
-
-	resp, err := c.Get(urlString)
-	if err != nil {
-		t.Error(err)
-	}
-
-	if resp.StatusCode != 503 {
-		t.Errorf("expect 503; got %d", res.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if !strings.Contains(err.Error(), "connection reset by peer") {
-		t.Error(err)
-	}
-
-	if !strings.Contains(string(body), "no such host") {
-		t.Errorf("Unexpected error: %v", err)
+	if !strings.Contains(body, "no such host") {
+		t.Errorf("Unexpected error: %v", body)
 	}
 
 	err = wait.PollImmediate(100*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
@@ -785,51 +740,13 @@ func TestFailedDial_HTTPCONN(t *testing.T) {
 	clientset := runAgent(proxy.agent, stopCh)
 	waitForConnectedServerCount(t, 1, clientset)
 
-	conn, err := net.Dial("tcp", proxy.front)
-	if err != nil {
-		t.Error(err)
-	}
-
 	serverURL, _ := url.Parse(server.URL)
-
-	// Send HTTP-Connect request
-	_, err = fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", serverURL.Host, "127.0.0.1")
-	if err != nil {
-		t.Error(err)
+	res, body := connectHTTPCONN(t, proxy.front, serverURL.Host)
+	if res.StatusCode != http.StatusBadGateway {
+		t.Errorf("expect %d; got %d", http.StatusBadGateway, res.StatusCode)
 	}
-
-	// Parse the HTTP response for Connect
-	br := bufio.NewReader(conn)
-	res, err := http.ReadResponse(br, nil)
-	if err != nil {
-		t.Errorf("reading HTTP response from CONNECT: %v", err)
-	}
-	if res.StatusCode != 200 {
-		t.Errorf("expect 200; got %d", res.StatusCode)
-	}
-
-	dialer := func(network, addr string) (net.Conn, error) {
-		return conn, nil
-	}
-
-	c := &http.Client{
-		Transport: &http.Transport{
-			Dial: dialer,
-		},
-	}
-
-	resp, err := c.Get(server.URL)
-	if err != nil {
-		t.Error(err)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if !strings.Contains(err.Error(), "connection reset by peer") {
-		t.Error(err)
-	}
-
-	if !strings.Contains(string(body), "connection refused") {
-		t.Errorf("Unexpected error: %v", err)
+	if !strings.Contains(body, "connection refused") {
+		t.Errorf("Unexpected error: %v", body)
 	}
 
 	err = wait.PollImmediate(100*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
@@ -848,6 +765,32 @@ func TestFailedDial_HTTPCONN(t *testing.T) {
 	resetAllMetrics() // For clean shutdown.
 }
 
+// connectHTTPCONN sends a CONNECT request for address to the http-connect
+// frontend, and returns the response with its body.
+func connectHTTPCONN(t *testing.T, front, address string) (*http.Response, string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", front)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", address, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("reading HTTP response from CONNECT: %v", err)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Errorf("reading HTTP response body from CONNECT: %v", err)
+	}
+	return res, string(body)
+}
+
 func localAddr(addr net.Addr) string {
 	return addr.String()
 }
@@ -907,6 +850,12 @@ func runGRPCProxyServerWithServerCount(serverCount int) (proxy, *server.ProxySer
 }
 
 func runHTTPConnProxyServer() (proxy, func(), error) {
+	return runHTTPConnProxyServerWithAuth(nil)
+}
+
+// runHTTPConnProxyServerWithAuth runs an http-connect proxy server which
+// authenticates the requests with proxyAuth, if not nil.
+func runHTTPConnProxyServerWithAuth(proxyAuth *proxyauth.Chain) (proxy, func(), error) {
 	ctx := context.Background()
 	var proxy proxy
 	s := server.NewProxyServer(uuid.New().String(), []server.ProxyStrategy{server.ProxyStrategyDefault}, 0, &server.AgentTokenAuthenticationOptions{})
@@ -926,7 +875,8 @@ func runHTTPConnProxyServer() (proxy, func(), error) {
 	active := int32(0)
 	proxy.getActiveHTTPConnectConns = func() int { return int(atomic.LoadInt32(&active)) }
 	handler := &server.Tunnel{
-		Server: s,
+		Server:    s,
+		ProxyAuth: proxyAuth,
 	}
 	httpServer := &http.Server{
 		ReadHeaderTimeout: 60 * time.Second,
@@ -1039,6 +989,33 @@ func waitForConnectedAgentCount(t testing.TB, expectedAgentCount int, proxy *ser
 	}
 }
 
+// drainedAgentStreams returns the number of duplicate agent streams which
+// the proxy servers drained.
+func drainedAgentStreams(t testing.TB) int {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Error gathering the metrics: %v", err)
+	}
+	name := prometheus.BuildFQName(metricsserver.Namespace, metricsserver.Subsystem, "drained_agent_streams_total")
+	for _, f := range families {
+		if f.GetName() == name && len(f.GetMetric()) > 0 {
+			return int(f.GetMetric()[0].GetCounter().GetValue())
+		}
+	}
+	return 0
+}
+
+func waitForDrainedAgentStreams(t testing.TB, expectedCount int) {
+	t.Helper()
+	err := wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		return drainedAgentStreams(t) >= expectedCount, nil
+	})
+	if err != nil {
+		t.Fatalf("Error waiting for %d drained agent streams: %v", expectedCount, err)
+	}
+}
+
 func assertNoClientDialFailures(t testing.TB) {
 	t.Helper()
 	if err := clientmetricstest.ExpectClientDialFailures(nil); err != nil {
@@ -0,0 +1,343 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	netproxy "golang.org/x/net/proxy"
+	"google.golang.org/grpc"
+
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server"
+	"sigs.k8s.io/apiserver-network-proxy/pkg/server/proxyauth"
+	agentproto "sigs.k8s.io/apiserver-network-proxy/proto/agent"
+)
+
+// runSOCKS5ProxyServer runs a socks5 proxy server whose frontend serves
+// front, and returns the address of its agent server.
+func runSOCKS5ProxyServer(t *testing.T, front net.Listener, auth *proxyauth.Chain) string {
+	s := server.NewProxyServer(uuid.New().String(), []server.ProxyStrategy{server.ProxyStrategyDefault}, 0, &server.AgentTokenAuthenticationOptions{})
+	agentServer := grpc.NewServer()
+	agentproto.RegisterAgentServiceServer(agentServer, s)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go agentServer.Serve(lis)
+	t.Cleanup(agentServer.Stop)
+
+	socks5 := &server.SOCKS5{Server: s, Auth: auth}
+	go socks5.Serve(front)
+	t.Cleanup(func() { front.Close() })
+	return lis.Addr().String()
+}
+
+func listenSOCKS5(t *testing.T) net.Listener {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lis
+}
+
+// getThroughSOCKS5 gets the echo of the test server at address through the
+// SOCKS5 dialer.
+func getThroughSOCKS5(dialer netproxy.Dialer, address string) (string, error) {
+	conn, err := dialer.Dial("tcp", address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if _, err := fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\n\r\n", address); err != nil {
+		return "", err
+	}
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return "", err
+	}
+	data, err := io.ReadAll(io.LimitReader(res.Body, res.ContentLength))
+	return string(data), err
+}
+
+func TestBasicProxy_SOCKS5(t *testing.T) {
+	target := httptest.NewServer(newEchoServer("hello"))
+	defer target.Close()
+	targetURL, _ := url.Parse(target.URL)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	front := listenSOCKS5(t)
+	agentAddr := runSOCKS5ProxyServer(t, front, nil)
+	clientset := runAgent(agentAddr, stopCh)
+	waitForConnectedServerCount(t, 1, clientset)
+
+	dialer, err := netproxy.SOCKS5("tcp", front.Addr().String(), nil, netproxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(targetURL.Host)
+	for _, address := range []string{
+		targetURL.Host,                      // IPv4 address
+		net.JoinHostPort("localhost", port), // domain name
+	} {
+		data, err := getThroughSOCKS5(dialer, address)
+		if err != nil {
+			t.Errorf("%s: %v", address, err)
+		} else if data != "hello" {
+			t.Errorf("%s: expect %v; got %v", address, "hello", data)
+		}
+	}
+}
+
+func TestIPv6Proxy_SOCKS5(t *testing.T) {
+	lis, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 is not available: %v", err)
+	}
+	target := httptest.NewUnstartedServer(newEchoServer("hello"))
+	target.Listener.Close()
+	target.Listener = lis
+	target.Start()
+	defer target.Close()
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	front := listenSOCKS5(t)
+	agentAddr := runSOCKS5ProxyServer(t, front, nil)
+	clientset := runAgent(agentAddr, stopCh)
+	waitForConnectedServerCount(t, 1, clientset)
+
+	dialer, err := netproxy.SOCKS5("tcp", front.Addr().String(), nil, netproxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := getThroughSOCKS5(dialer, lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data != "hello" {
+		t.Errorf("expect %v; got %v", "hello", data)
+	}
+}
+
+func TestUDSProxy_SOCKS5(t *testing.T) {
+	target := httptest.NewServer(newEchoServer("hello"))
+	defer target.Close()
+	targetURL, _ := url.Parse(target.URL)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	udsName := filepath.Join(t.TempDir(), "socks5.sock")
+	front, err := net.Listen("unix", udsName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agentAddr := runSOCKS5ProxyServer(t, front, nil)
+	clientset := runAgent(agentAddr, stopCh)
+	waitForConnectedServerCount(t, 1, clientset)
+
+	dialer, err := netproxy.SOCKS5("unix", udsName, nil, netproxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := getThroughSOCKS5(dialer, targetURL.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data != "hello" {
+		t.Errorf("expect %v; got %v", "hello", data)
+	}
+}
+
+// tlsDialer dials TLS connections to the SOCKS5 proxy server.
+type tlsDialer struct {
+	config *tls.Config
+}
+
+func (d tlsDialer) Dial(network, addr string) (net.Conn, error) {
+	return tls.Dial(network, addr, d.config)
+}
+
+func TestTLSProxy_SOCKS5(t *testing.T) {
+	target := httptest.NewServer(newEchoServer("hello"))
+	defer target.Close()
+	targetURL, _ := url.Parse(target.URL)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	cert, pool := selfSignedCertificate(t)
+	front := tls.NewListener(listenSOCKS5(t), &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+	agentAddr := runSOCKS5ProxyServer(t, front, nil)
+	clientset := runAgent(agentAddr, stopCh)
+	waitForConnectedServerCount(t, 1, clientset)
+
+	dialer, err := netproxy.SOCKS5("tcp", front.Addr().String(), nil, tlsDialer{config: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := getThroughSOCKS5(dialer, targetURL.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data != "hello" {
+		t.Errorf("expect %v; got %v", "hello", data)
+	}
+}
+
+func TestProxyAuth_SOCKS5(t *testing.T) {
+	target := httptest.NewServer(newEchoServer("hello"))
+	defer target.Close()
+	targetURL, _ := url.Parse(target.URL)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	htpasswdFile := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(htpasswdFile, []byte("bob:{SHA}oHryCTyM4ObJvET53dSBiRe/fXQ=\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	htpasswd, err := proxyauth.NewHtpasswd(htpasswdFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	front := listenSOCKS5(t)
+	agentAddr := runSOCKS5ProxyServer(t, front, &proxyauth.Chain{Authenticators: []proxyauth.Authenticator{htpasswd}})
+	clientset := runAgent(agentAddr, stopCh)
+	waitForConnectedServerCount(t, 1, clientset)
+
+	for _, tc := range []struct {
+		desc    string
+		auth    *netproxy.Auth
+		wantErr bool
+	}{
+		{desc: "no credentials", wantErr: true},
+		{desc: "wrong password", auth: &netproxy.Auth{User: "bob", Password: "alice-password"}, wantErr: true},
+		{desc: "valid credentials", auth: &netproxy.Auth{User: "bob", Password: "bob-password"}},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			dialer, err := netproxy.SOCKS5("tcp", front.Addr().String(), tc.auth, netproxy.Direct)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := getThroughSOCKS5(dialer, targetURL.Host)
+			if tc.wantErr {
+				if err == nil {
+					t.Error("expected the connection to be rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data != "hello" {
+				t.Errorf("expect %v; got %v", "hello", data)
+			}
+		})
+	}
+}
+
+func TestFailedDial_SOCKS5(t *testing.T) {
+	target := httptest.NewServer(newEchoServer("hello"))
+	target.Close() // cleanup immediately so connections will fail
+	targetURL, _ := url.Parse(target.URL)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	front := listenSOCKS5(t)
+	agentAddr := runSOCKS5ProxyServer(t, front, nil)
+	clientset := runAgent(agentAddr, stopCh)
+	waitForConnectedServerCount(t, 1, clientset)
+
+	dialer, err := netproxy.SOCKS5("tcp", front.Addr().String(), nil, netproxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dialer.Dial("tcp", targetURL.Host)
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("expected connection refused, got %v", err)
+	}
+}
+
+func TestNoAgent_SOCKS5(t *testing.T) {
+	front := listenSOCKS5(t)
+	runSOCKS5ProxyServer(t, front, nil)
+
+	dialer, err := netproxy.SOCKS5("tcp", front.Addr().String(), nil, netproxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dialer.Dial("tcp", "127.0.0.1:80")
+	if err == nil || !strings.Contains(err.Error(), "network unreachable") {
+		t.Errorf("expected network unreachable, got %v", err)
+	}
+}
+
+// selfSignedCertificate returns a certificate for 127.0.0.1, and the pool
+// trusting it.
+func selfSignedCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "konnectivity-server"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}